@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// adminPrefix marks the start of the middleware's own endpoints in a path.
+	// Admin endpoints are matched anywhere in a path so that they continue to work
+	// when an application is mounted under a prefix, such as `/api/__/counters`
+	adminPrefix = "/__/"
+)
+
+// adminRequest is the subset of a request, from either net/http or fasthttp,
+// that admin endpoints need to do their jobs
+type adminRequest struct {
+	method   string
+	endpoint string
+	query    url.Values
+	header   func(string) string
+	body     []byte
+}
+
+// adminResponse is what an admin endpoint responds with
+type adminResponse struct {
+	status      int
+	contentType string
+	body        []byte
+
+	// etag is set for responses from cacheable endpoints
+	etag string
+}
+
+type adminHandler func(adminRequest) adminResponse
+
+// addAdminEndpoint registers an endpoint under adminPrefix, such as "counters"
+func (m *Middleware) addAdminEndpoint(endpoint string, h adminHandler) {
+	if m.admin == nil {
+		m.admin = make(map[string]adminHandler)
+	}
+
+	m.admin[endpoint] = h
+}
+
+// addPublicAdminEndpoint registers an endpoint which doesn't require the
+// admin token, such as readiness checks called by orchestrators
+func (m *Middleware) addPublicAdminEndpoint(endpoint string, h adminHandler) {
+	if m.publicAdmin == nil {
+		m.publicAdmin = make(map[string]bool)
+	}
+
+	m.publicAdmin[endpoint] = true
+
+	m.addAdminEndpoint(endpoint, h)
+}
+
+// addCachedAdminEndpoint registers an endpoint whose response only changes
+// along with the middleware's state, rather than with time, such as
+// counters. Its responses carry an ETag, and requests with a matching
+// If-None-Match receive a `304 Not Modified` without the endpoint being
+// called, so that frequent scrapers are cheap
+func (m *Middleware) addCachedAdminEndpoint(endpoint string, h adminHandler) {
+	if m.cachedAdmin == nil {
+		m.cachedAdmin = make(map[string]bool)
+	}
+
+	m.cachedAdmin[endpoint] = true
+
+	m.addAdminEndpoint(endpoint, h)
+}
+
+// changed marks the middleware's state as changed, invalidating the ETags
+// of cached admin endpoints
+func (m *Middleware) changed() {
+	atomic.AddUint64(&m.generation, 1)
+}
+
+// etag returns the ETag of the middleware's current state. Generations
+// restart with the process, so are qualified by when the middleware was
+// created
+func (m *Middleware) etag() string {
+	return `"` + m.epoch + "-" + strconv.FormatUint(atomic.LoadUint64(&m.generation), 36) + `"`
+}
+
+// etagMatches returns whether an If-None-Match header matches etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminEndpoint returns the admin endpoint a path refers to, if any.
+// Paths which merely look like admin endpoints are left for the wrapped handler
+func (m *Middleware) adminEndpoint(p string) (endpoint string, ok bool) {
+	i := strings.Index(p, adminPrefix)
+	if i < 0 {
+		return
+	}
+
+	endpoint = p[i+len(adminPrefix):]
+	_, ok = m.admin[endpoint]
+
+	return
+}
+
+// serveAdmin authorises and then dispatches an admin request
+func (m *Middleware) serveAdmin(r adminRequest) adminResponse {
+	if !m.publicAdmin[r.endpoint] && !m.adminAuthorised(r.header) {
+		return jsonResponse(http.StatusUnauthorized, []byte(`{"error":"unauthorised"}`))
+	}
+
+	switch r.method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		// Admin endpoints may change state, such as the blocklist, so
+		// aren't left open when there's no token to check
+		if m.AdminToken == "" {
+			return jsonResponse(http.StatusForbidden, []byte(`{"error":"admin token required"}`))
+		}
+
+		defer m.changed()
+
+		return m.admin[r.endpoint](r)
+	}
+
+	if !m.cachedAdmin[r.endpoint] || m.changesUnseen(r) {
+		return m.admin[r.endpoint](r)
+	}
+
+	// The ETag is taken first, so that changes made while the endpoint is
+	// being called can only cause a needless refresh, never a stale one.
+	// The counters' representations differ, so have ETags of their own
+	etag := m.etag()
+
+	switch {
+	case r.endpoint != "counters":
+	case acceptsOpenMetrics(r.header("Accept")):
+		etag = strings.TrimSuffix(etag, `"`) + `-om"`
+	case wantsSections(r.query):
+		etag = strings.TrimSuffix(etag, `"`) + `-sections"`
+	}
+
+	if etagMatches(r.header("If-None-Match"), etag) {
+		ar := jsonResponse(http.StatusNotModified, nil)
+		ar.etag = etag
+
+		return ar
+	}
+
+	ar := m.admin[r.endpoint](r)
+	if ar.status == http.StatusOK {
+		ar.etag = etag
+	}
+
+	return ar
+}
+
+// changesUnseen returns whether a request to a cached endpoint is for a
+// representation which changes without the middleware's state changing, and
+// so can't be given an ETag: counters with runtime stats, which are read
+// afresh each time
+func (m *Middleware) changesUnseen(r adminRequest) bool {
+	return r.endpoint == "counters" && m.runtimeStats && (acceptsOpenMetrics(r.header("Accept")) || wantsSections(r.query))
+}
+
+// adminAuthorised checks the admin token, when one is set, against either an
+// `Authorization: Bearer` header or an `X-Admin-Token` header
+func (m *Middleware) adminAuthorised(header func(string) string) bool {
+	return tokenAuthorised(m.AdminToken, header)
+}
+
+// tokenAuthorised is adminAuthorised for any admin token, such as a
+// Registry's
+func tokenAuthorised(adminToken string, header func(string) string) bool {
+	if adminToken == "" {
+		return true
+	}
+
+	token := header("X-Admin-Token")
+	if auth := header("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func jsonResponse(status int, body []byte) adminResponse {
+	return adminResponse{
+		status:      status,
+		contentType: "application/json",
+		body:        body,
+	}
+}
+
+// adminError responds with err as a JSON error message
+func adminError(status int, err error) adminResponse {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	return jsonResponse(status, b)
+}
+
+func (m *Middleware) serveCounters(r adminRequest) adminResponse {
+	if acceptsOpenMetrics(r.header("Accept")) {
+		return adminResponse{
+			status:      http.StatusOK,
+			contentType: openMetricsContentType,
+			body:        encodeOpenMetricsCounters(m.countersPayload()),
+		}
+	}
+
+	if wantsSections(r.query) {
+		return jsonResponse(http.StatusOK, m.sectionedCounters())
+	}
+
+	return jsonResponse(http.StatusOK, m.counters())
+}
+
+// wantsSections returns whether a request to the counters endpoint asks
+// for every section, rather than request counts alone
+func wantsSections(query url.Values) bool {
+	_, ok := query["sections"]
+
+	return ok
+}
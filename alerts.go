@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"time"
+)
+
+// Alert is raised when the middleware sees something a human should know
+// about, such as a client probing a honeypot
+type Alert struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Summary string    `json:"summary"`
+
+	Client    string `json:"client,omitempty"`
+	URL       string `json:"url,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Alerter receives alerts, forwarding them to a pager, chat room, or
+// incident tool
+type Alerter interface {
+	Alert(Alert)
+}
+
+// AlerterFunc allows a plain function to be used as an Alerter
+type AlerterFunc func(Alert)
+
+// Alert implements Alerter
+func (f AlerterFunc) Alert(a Alert) {
+	f(a)
+}
+
+// AddAlerter registers a to receive alerts. Alerts are sent by the log
+// queue's workers, so slow alerters don't hold up requests
+func (m *Middleware) AddAlerter(a Alerter) {
+	m.alerters = append(m.alerters, a)
+}
+
+// alert sends a to every alerter
+func (m *Middleware) alert(a Alert) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	for _, alerter := range m.alerters {
+		alerter := alerter
+		m.queue.dispatch(func() { alerter.Alert(a) })
+	}
+}
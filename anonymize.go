@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPAnonymization is a strategy for anonymising client IP addresses
+type IPAnonymization int
+
+const (
+	// IPTruncate zeroes the last octet of IPv4 addresses, and the last
+	// 80 bits of IPv6 addresses, keeping enough of an address for coarse
+	// geolocation
+	IPTruncate IPAnonymization = iota + 1
+
+	// IPHash replaces addresses with an HMAC keyed by a random key which
+	// is regenerated every rotation period. Requests from one address can
+	// be correlated within a period, but not across periods, and the key
+	// is never stored
+	IPHash
+)
+
+const (
+	// DefaultIPKeyRotation is how often IPHash keys are regenerated by default
+	DefaultIPKeyRotation = 24 * time.Hour
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+
+	// clientIPHeaders are headers set by proxies which carry client
+	// addresses, as comma separated lists
+	clientIPHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "True-Client-Ip", "Cf-Connecting-Ip"}
+)
+
+// ipAnonymizer rewrites IP addresses as per an IPAnonymization
+type ipAnonymizer struct {
+	mode     IPAnonymization
+	rotation time.Duration
+
+	lock    sync.Mutex
+	key     []byte
+	expires time.Time
+}
+
+// AnonymizeIPs anonymises client addresses before entries reach any logger,
+// as per mode. This covers each LogEntry's IPAddress, proxy headers which
+// carry client addresses, such as X-Forwarded-For, wherever headers are
+// logged, the subjects of audit events, such as bans, and the clients of
+// honeypot alerts. rotation sets how often IPHash keys are regenerated,
+// and defaults to DefaultIPKeyRotation. Anonymised addresses never include
+// a port. An unknown mode is programmer error, and panics.
+//
+// Rate limiting, and anything else which needs real addresses, is unaffected
+func (m *Middleware) AnonymizeIPs(mode IPAnonymization, rotation time.Duration) {
+	if mode != IPTruncate && mode != IPHash {
+		panic(fmt.Errorf("unknown IP anonymization %d", mode))
+	}
+
+	if rotation <= 0 {
+		rotation = DefaultIPKeyRotation
+	}
+
+	m.anonymizer = &ipAnonymizer{mode: mode, rotation: rotation}
+}
+
+// anonymize returns addr, which may include a port, anonymised as of now
+func (a *ipAnonymizer) anonymize(addr string, now time.Time) string {
+	if addr == "" {
+		return addr
+	}
+
+	host := clientIP(addr)
+
+	if a.mode == IPHash {
+		mac := hmac.New(sha256.New, a.keyAt(now))
+		mac.Write([]byte(host))
+
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// not an address we understand, so don't risk leaking it
+		return RedactedValue
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask).String()
+	}
+
+	return ip.Mask(ipv6Mask).String()
+}
+
+// anonymizeHeaders returns a copy of h with client addresses in proxy
+// headers anonymised. The Forwarded header's syntax is too loose to rewrite
+// safely, so is redacted outright
+func (a *ipAnonymizer) anonymizeHeaders(h map[string]string, now time.Time) map[string]string {
+	if len(h) == 0 {
+		return h
+	}
+
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = v
+	}
+
+	for _, k := range clientIPHeaders {
+		v, ok := out[k]
+		if !ok || v == RedactedValue || strings.HasPrefix(v, RedactedValue+":") {
+			continue
+		}
+
+		addrs := strings.Split(v, ",")
+		for i, addr := range addrs {
+			addrs[i] = a.anonymize(strings.TrimSpace(addr), now)
+		}
+
+		out[k] = strings.Join(addrs, ", ")
+	}
+
+	if _, ok := out["Forwarded"]; ok {
+		out["Forwarded"] = RedactedValue
+	}
+
+	return out
+}
+
+// anonymizeSubject anonymises s when it's an address, leaving other
+// subjects, such as API keys identifying banned clients, alone
+func (a *ipAnonymizer) anonymizeSubject(s string, now time.Time) string {
+	if net.ParseIP(clientIP(s)) == nil {
+		return s
+	}
+
+	return a.anonymize(s, now)
+}
+
+// keyAt returns the HMAC key for now, generating a fresh one once the
+// current key expires
+func (a *ipAnonymizer) keyAt(now time.Time) []byte {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.key == nil || !now.Before(a.expires) {
+		a.key = make([]byte, 32)
+		rand.Read(a.key)
+
+		a.expires = now.Truncate(a.rotation).Add(a.rotation)
+	}
+
+	return a.key
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPAnonymizer_Truncate(t *testing.T) {
+	a := &ipAnonymizer{mode: IPTruncate}
+
+	for addr, expect := range map[string]string{
+		"203.0.113.42:62405": "203.0.113.0",
+		"203.0.113.42":       "203.0.113.0",
+		"[2001:db8:85a3:8d3:1319:8a2e:370:7348]:443": "2001:db8:85a3::",
+		"not-an-ip": RedactedValue,
+		"":          "",
+	} {
+		if received := a.anonymize(addr, time.Now()); received != expect {
+			t.Errorf("%s: expected %q, received %q", addr, expect, received)
+		}
+	}
+}
+
+func TestIPAnonymizer_Hash(t *testing.T) {
+	a := &ipAnonymizer{mode: IPHash, rotation: time.Hour}
+	now := time.Date(2017, 5, 27, 14, 0, 0, 0, time.UTC)
+
+	first := a.anonymize("203.0.113.42:1234", now)
+	if len(first) != 32 {
+		t.Fatalf("expected a 32 character hash, received %q", first)
+	}
+
+	if same := a.anonymize("203.0.113.42:5678", now.Add(59*time.Minute)); same != first {
+		t.Errorf("expected addresses to correlate within a period, received %q and %q", first, same)
+	}
+
+	if other := a.anonymize("203.0.113.43:1234", now); other == first {
+		t.Errorf("expected different addresses to hash differently")
+	}
+
+	if rotated := a.anonymize("203.0.113.42:1234", now.Add(time.Hour)); rotated == first {
+		t.Errorf("expected key to rotate")
+	}
+}
+
+func TestAnonymizeIPs_PanicsOnUnknownMode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).AnonymizeIPs(IPAnonymization(99), 0)
+}
+
+func TestIPAnonymizer_Headers(t *testing.T) {
+	a := &ipAnonymizer{mode: IPTruncate}
+
+	h := map[string]string{
+		"X-Forwarded-For": "203.0.113.42, 198.51.100.7",
+		"X-Real-Ip":       "203.0.113.42",
+		"Forwarded":       "for=203.0.113.42;proto=https",
+		"Accept":          "text/html",
+	}
+
+	out := a.anonymizeHeaders(h, time.Now())
+
+	for k, expect := range map[string]string{
+		"X-Forwarded-For": "203.0.113.0, 198.51.100.0",
+		"X-Real-Ip":       "203.0.113.0",
+		"Forwarded":       RedactedValue,
+		"Accept":          "text/html",
+	} {
+		if out[k] != expect {
+			t.Errorf("%s: expected %q, received %q", k, expect, out[k])
+		}
+	}
+
+	if h["X-Real-Ip"] != "203.0.113.42" {
+		t.Errorf("expected the original headers to be left alone")
+	}
+}
+
+func TestIPAnonymizer_Subject(t *testing.T) {
+	a := &ipAnonymizer{mode: IPTruncate}
+
+	for subject, expect := range map[string]string{
+		"203.0.113.42":       "203.0.113.0",
+		"203.0.113.42:62405": "203.0.113.0",
+		"api-key-1234":       "api-key-1234",
+	} {
+		if received := a.anonymizeSubject(subject, time.Now()); received != expect {
+			t.Errorf("%s: expected %q, received %q", subject, expect, received)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"time"
+)
+
+// Apdex zones, as set on LogEntry.Apdex
+const (
+	ApdexSatisfied  = "satisfied"
+	ApdexTolerating = "tolerating"
+	ApdexFrustrated = "frustrated"
+)
+
+// ApdexScore holds a route's requests by Apdex zone, as shown under `apdex`
+// by the counters endpoint, along with the resulting score: the satisfied
+// requests plus half the tolerating ones, over the total. The score runs
+// from 0, every user frustrated, to 1, every user satisfied
+type ApdexScore struct {
+	Satisfied  int64   `json:"satisfied"`
+	Tolerating int64   `json:"tolerating"`
+	Frustrated int64   `json:"frustrated"`
+	Score      float64 `json:"score"`
+}
+
+// apdexZone returns the zone of a request which took d to receive status,
+// given its route's ApdexThreshold, t, or nothing when t is zero. Errors
+// frustrate users however quickly they're served
+func apdexZone(t time.Duration, l LogEntry, d time.Duration) string {
+	switch {
+	case t <= 0:
+		return ""
+	case isErrorEntry(l) || d > 4*t:
+		return ApdexFrustrated
+	case d > t:
+		return ApdexTolerating
+	default:
+		return ApdexSatisfied
+	}
+}
+
+// apdexScore returns the Apdex score of the given requests, or 0 when there
+// are none
+func apdexScore(satisfied, tolerating, frustrated int64) float64 {
+	total := satisfied + tolerating + frustrated
+	if total == 0 {
+		return 0
+	}
+
+	return (float64(satisfied) + float64(tolerating)/2) / float64(total)
+}
+
+// apdexScores returns the scores of routes' requests, counted by zone
+func apdexScores(zones map[string]map[string]int64) map[string]ApdexScore {
+	if len(zones) == 0 {
+		return nil
+	}
+
+	out := make(map[string]ApdexScore, len(zones))
+	for route, z := range zones {
+		out[route] = ApdexScore{
+			Satisfied:  z[ApdexSatisfied],
+			Tolerating: z[ApdexTolerating],
+			Frustrated: z[ApdexFrustrated],
+			Score:      apdexScore(z[ApdexSatisfied], z[ApdexTolerating], z[ApdexFrustrated]),
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApdexZone(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		threshold time.Duration
+		l         LogEntry
+		d         time.Duration
+		expect    string
+	}{
+		{"disabled", 0, LogEntry{Status: 200}, time.Second, ""},
+		{"satisfied", 100 * time.Millisecond, LogEntry{Status: 200}, 100 * time.Millisecond, ApdexSatisfied},
+		{"tolerating", 100 * time.Millisecond, LogEntry{Status: 200}, 400 * time.Millisecond, ApdexTolerating},
+		{"frustrated", 100 * time.Millisecond, LogEntry{Status: 200}, 401 * time.Millisecond, ApdexFrustrated},
+		{"client error", 100 * time.Millisecond, LogEntry{Status: 404}, time.Millisecond, ApdexSatisfied},
+		{"server error", 100 * time.Millisecond, LogEntry{Status: 500}, time.Millisecond, ApdexFrustrated},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if z := apdexZone(test.threshold, test.l, test.d); z != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, z)
+			}
+		})
+	}
+}
+
+func TestApdexScore(t *testing.T) {
+	if s := apdexScore(0, 0, 0); s != 0 {
+		t.Errorf("expected 0 without requests, received %v", s)
+	}
+
+	if s := apdexScore(60, 30, 10); s != 0.75 {
+		t.Errorf("expected 0.75, received %v", s)
+	}
+}
+
+func TestCounters_apdex(t *testing.T) {
+	m := NewMiddleware(TestSlowAPI{})
+	m.SetLoggers()
+	m.AddRoutePolicy("/slow/*", RoutePolicy{ApdexThreshold: time.Millisecond})
+	m.AddRoutePolicy("/fast/*", RoutePolicy{ApdexThreshold: time.Minute})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast/2", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+
+	expect := map[string]ApdexScore{
+		"/slow/*": {Frustrated: 1, Score: 0},
+		"/fast/*": {Satisfied: 2, Score: 1},
+	}
+
+	if len(c.Apdex) != len(expect) {
+		t.Fatalf("expected routes without thresholds to be left out, received %+v", c.Apdex)
+	}
+
+	for route, a := range expect {
+		if c.Apdex[route] != a {
+			t.Errorf("%s: expected %+v, received %+v", route, a, c.Apdex[route])
+		}
+	}
+
+	m.ResetCounters()
+
+	if c := getCounters(t, m); c.Apdex != nil {
+		t.Errorf("expected apdex to be reset, received %+v", c.Apdex)
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// defaultAppLogger backs Logger when a Middleware has no AppLogger of its own
+var defaultAppLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// Logger returns a structured logger for use within handlers, pre-populated
+// with the request_id, route, and (where configured) tenant of the request
+// ctx belongs to. This means application log lines automatically correlate
+// with access logs.
+//
+// ctx is either a net/http request's context, or a *fasthttp.RequestCtx.
+// Outside of the middleware, slog.Default() is returned.
+func Logger(ctx context.Context) *slog.Logger {
+	st := stateFrom(ctx)
+	if st == nil {
+		return slog.Default()
+	}
+
+	st.loggerOnce.Do(func() {
+		attrs := []interface{}{"request_id", st.id, "route", st.route}
+		if st.tenant != "" {
+			attrs = append(attrs, "tenant", st.tenant)
+		}
+
+		st.logger = st.appLogger.With(attrs...)
+	})
+
+	return st.logger
+}
+
+func (m *Middleware) appLogger() *slog.Logger {
+	if m.AppLogger != nil {
+		return m.AppLogger
+	}
+
+	return defaultAppLogger
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Logger(r.Context()).Info("charging card", "amount", 100)
+	}))
+	m.AddRoutePolicy("/payments/*", RoutePolicy{})
+	m.TenantHeader = "X-Tenant-ID"
+	m.AppLogger = slog.New(slog.NewJSONHandler(buf, nil))
+
+	r := httptest.NewRequest("POST", "/payments/123", nil)
+	r.Header.Set("X-Tenant-ID", "acme")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for k, v := range map[string]interface{}{
+		"msg":        "charging card",
+		"request_id": rec.Header().Get(RequestIDHeader),
+		"route":      "/payments/*",
+		"tenant":     "acme",
+		"amount":     float64(100),
+	} {
+		if line[k] != v {
+			t.Errorf("%s: expected %v, received %v", k, v, line[k])
+		}
+	}
+}
+
+func TestLogger_outsideMiddleware(t *testing.T) {
+	if Logger(httptest.NewRequest("GET", "/", nil).Context()) != slog.Default() {
+		t.Errorf("expected the default logger")
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent records a change made by, or to, the middleware itself, such
+// as a client being banned, rather than a request being served
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+
+	// Subject is what the action applies to, such as a client
+	Subject string `json:"subject,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+
+	// Until is set for actions which expire
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Auditable is implemented by loggers which also want audit events. Audit
+// events are sent to every logger which implements it, including the
+// default logger
+type Auditable interface {
+	Audit(AuditEvent)
+}
+
+// audit sends e to every Auditable logger
+func (m *Middleware) audit(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if m.anonymizer != nil {
+		e.Subject = m.anonymizer.anonymizeSubject(e.Subject, e.Time)
+	}
+
+	for _, logger := range m.loggers {
+		if a, ok := logger.(Auditable); ok {
+			m.queue.dispatch(func() { a.Audit(e) })
+		}
+	}
+}
+
+// Audit writes e as JSON under an `audit` key, so that audit events are
+// easily told apart from access log entries
+func (dl defaultLogger) Audit(e AuditEvent) {
+	b, err := json.Marshal(map[string]AuditEvent{"audit": e})
+	if err != nil {
+		dl.output.Printf("error marshaling audit event: %q", err)
+
+		return
+	}
+
+	dl.output.Print(string(b))
+}
@@ -0,0 +1,80 @@
+// Package autotls serves middleware over TLS with certificates obtained
+// automatically from Let's Encrypt, or another ACME provider. It is kept
+// apart from the middleware package so that it needn't depend on
+// golang.org/x/crypto
+package autotls
+
+import (
+	"fmt"
+
+	"github.com/zeebox/go-http-middleware"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultCacheDir is where certificates are cached between restarts, when
+// a Config doesn't say otherwise
+const DefaultCacheDir = "certs"
+
+// Config configures TLS certificates obtained automatically
+type Config struct {
+	// Hosts lists the host names certificates may be requested for, and is
+	// required; without it anybody could point a domain at the service and
+	// exhaust its rate limits
+	Hosts []string
+
+	// Email is passed to the ACME provider, to be notified of problems
+	Email string
+
+	// CacheDir defaults to DefaultCacheDir
+	CacheDir string
+
+	// Addr and HTTPAddr default to `:443` and `:80`. HTTP-01 challenges
+	// are answered on HTTPAddr; all other plain HTTP requests are
+	// redirected to HTTPS
+	Addr     string
+	HTTPAddr string
+
+	// DirectoryURL overrides the ACME directory, such as to use the Let's
+	// Encrypt staging environment
+	DirectoryURL string
+}
+
+// ListenAndServe serves m over TLS, as per m's ListenAndServeTLS, using
+// certificates obtained and renewed automatically for c.Hosts:
+//
+//	m := middleware.NewMiddleware(API{})
+//	panic(autotls.ListenAndServe(m, autotls.Config{Hosts: []string{"example.com"}}))
+func ListenAndServe(m *middleware.Middleware, c Config) error {
+	manager, err := c.manager()
+	if err != nil {
+		return err
+	}
+
+	return m.ListenAndServeTLS(manager, c.Addr, c.HTTPAddr)
+}
+
+// manager returns an autocert.Manager as per c
+func (c Config) manager() (*autocert.Manager, error) {
+	if len(c.Hosts) == 0 {
+		return nil, fmt.Errorf("autocert: at least one host is required")
+	}
+
+	dir := c.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Hosts...),
+		Cache:      autocert.DirCache(dir),
+		Email:      c.Email,
+	}
+
+	if c.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+
+	return manager, nil
+}
@@ -0,0 +1,36 @@
+package autotls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zeebox/go-http-middleware"
+)
+
+func TestConfig(t *testing.T) {
+	if _, err := (Config{}).manager(); err == nil {
+		t.Errorf("expected an error without hosts")
+	}
+
+	manager, err := Config{Hosts: []string{"example.com"}, CacheDir: t.TempDir()}.manager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cm middleware.CertManager = manager
+
+	w := httptest.NewRecorder()
+	cm.HTTPHandler(nil).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/path?q=1", nil))
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/path?q=1" {
+		t.Errorf("expected a redirect to https, received %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	cm.HTTPHandler(nil).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/unknown", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown challenges to 404, received %d", w.Code)
+	}
+}
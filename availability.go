@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a daily period, such as 02:00 to 04:00, given as offsets from
+// midnight. Windows where End is before Start span midnight. Times are UTC
+// unless Location is set
+type Window struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseWindow parses windows of the form `02:00-04:00`
+func ParseWindow(s string) (w Window, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", s)
+	}
+
+	if w.Start, err = parseTimeOfDay(parts[0]); err != nil {
+		return w, fmt.Errorf("invalid window %q: %v", s, err)
+	}
+
+	if w.End, err = parseTimeOfDay(parts[1]); err != nil {
+		return w, fmt.Errorf("invalid window %q: %v", s, err)
+	}
+
+	return
+}
+
+func parseTimeOfDay(s string) (d time.Duration, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// sinceMidnight returns how far through its day t is, in the window's
+// location
+func (w Window) sinceMidnight(t time.Time) time.Duration {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	t = t.In(loc)
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// contains returns true when t falls within the window
+func (w Window) contains(t time.Time) bool {
+	d := w.sinceMidnight(t)
+
+	if w.End < w.Start {
+		return d >= w.Start || d < w.End
+	}
+
+	return d >= w.Start && d < w.End
+}
+
+// opensIn returns how long after t the window next opens
+func (w Window) opensIn(t time.Time) time.Duration {
+	wait := w.Start - w.sinceMidnight(t)
+	if wait < 0 {
+		wait += 24 * time.Hour
+	}
+
+	return wait
+}
+
+// available returns true when a policy has no availability windows, or t
+// falls within one of them
+func (p RoutePolicy) available(t time.Time) bool {
+	if len(p.Availability) == 0 {
+		return true
+	}
+
+	for _, w := range p.Availability {
+		if w.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unavailable returns the status, and Retry-After header value if any, for
+// requests made outside of a policy's availability windows
+func (p RoutePolicy) unavailable(t time.Time) (status int, retryAfter string) {
+	status = p.UnavailableStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+
+	if status != http.StatusServiceUnavailable {
+		return
+	}
+
+	wait := p.Availability[0].opensIn(t)
+	for _, w := range p.Availability[1:] {
+		if d := w.opensIn(t); d < wait {
+			wait = d
+		}
+	}
+
+	return status, strconv.Itoa(int(wait / time.Second))
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("02:00-04:30")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if w.Start != 2*time.Hour || w.End != 4*time.Hour+30*time.Minute {
+		t.Errorf("unexpected window %+v", w)
+	}
+
+	for _, s := range []string{"", "02:00", "2am-4am", "02:00-25:00"} {
+		if _, err := ParseWindow(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestWindow_contains(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name   string
+		window Window
+		at     time.Duration
+		expect bool
+	}{
+		{"before", Window{Start: 2 * time.Hour, End: 4 * time.Hour}, time.Hour, false},
+		{"start", Window{Start: 2 * time.Hour, End: 4 * time.Hour}, 2 * time.Hour, true},
+		{"end", Window{Start: 2 * time.Hour, End: 4 * time.Hour}, 4 * time.Hour, false},
+		{"spanning midnight, late", Window{Start: 22 * time.Hour, End: 2 * time.Hour}, 23 * time.Hour, true},
+		{"spanning midnight, early", Window{Start: 22 * time.Hour, End: 2 * time.Hour}, time.Hour, true},
+		{"spanning midnight, midday", Window{Start: 22 * time.Hour, End: 2 * time.Hour}, 12 * time.Hour, false},
+		{"located", Window{Start: 2 * time.Hour, End: 4 * time.Hour, Location: time.FixedZone("UTC+2", 2*60*60)}, time.Hour, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.window.contains(day.Add(test.at)); got != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestRoutePolicy_Availability(t *testing.T) {
+	now := time.Now().UTC()
+	midnight := now.Truncate(24 * time.Hour)
+	elapsed := now.Sub(midnight)
+
+	m := NewMiddleware(TestAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	// Open from two hours from now, for an hour
+	m.AddRoutePolicy("/batch/closed", RoutePolicy{
+		Availability: []Window{{Start: (elapsed + 2*time.Hour) % (24 * time.Hour), End: (elapsed + 3*time.Hour) % (24 * time.Hour)}},
+	})
+
+	m.AddRoutePolicy("/batch/forbidden", RoutePolicy{
+		Availability:      []Window{{Start: (elapsed + 2*time.Hour) % (24 * time.Hour), End: (elapsed + 3*time.Hour) % (24 * time.Hour)}},
+		UnavailableStatus: http.StatusForbidden,
+	})
+
+	m.AddRoutePolicy("/batch/open", RoutePolicy{
+		Availability: []Window{{Start: (elapsed + 23*time.Hour) % (24 * time.Hour), End: (elapsed + time.Hour) % (24 * time.Hour)}},
+	})
+
+	for _, test := range []struct {
+		path       string
+		status     int
+		retryAfter bool
+	}{
+		{"/batch/closed", http.StatusServiceUnavailable, true},
+		{"/batch/forbidden", http.StatusForbidden, false},
+		{"/batch/open", http.StatusOK, false},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+			if rec.Code != test.status {
+				t.Errorf("expected %d, received %d", test.status, rec.Code)
+			}
+
+			if got := rec.Header().Get("Retry-After") != ""; got != test.retryAfter {
+				t.Errorf("expected Retry-After %v, received %q", test.retryAfter, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if strings.Count(string(logWriter.body), `"outside_window":true`) != 2 {
+		t.Errorf("expected refused requests to be logged as outside their window, received %q", logWriter.body)
+	}
+}
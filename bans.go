@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBanWindow is the period abuse thresholds are measured over
+	DefaultBanWindow = time.Minute
+
+	// DefaultBanCooldown is how long bans last
+	DefaultBanCooldown = 10 * time.Minute
+
+	// DefaultBanMinRequests is how many requests a client must make in a
+	// window before its error rate is considered
+	DefaultBanMinRequests = 20
+)
+
+// BanPolicy configures automatic banning of abusive clients. Banned clients
+// receive a `403 Forbidden` without the wrapped handler being called, until
+// Cooldown has passed or the ban is lifted via the `bans` admin endpoint,
+// which requires an AdminToken. Bans, and their lifting, are sent to the
+// audit log.
+//
+// A threshold of zero is disabled.
+type BanPolicy struct {
+	// KeyHeader, when set, identifies clients by the value of this header,
+	// such as an API key, rather than by IP address. Key values are hashed
+	// before being stored, logged, or listed. Requests without the header
+	// are identified by IP address
+	KeyHeader string
+
+	// ErrorRate bans clients for whom more than this fraction of responses
+	// in a Window were 4xx or 5xx, once they've made MinRequests requests
+	ErrorRate   float64
+	MinRequests int
+
+	// MaxRateLimited bans clients which are rate limited more than this
+	// many times in a Window
+	MaxRateLimited int
+
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// Ban describes a banned client
+type Ban struct {
+	Client string    `json:"client"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// banList tracks client behaviour per window, and current bans
+type banList struct {
+	sync.Mutex
+
+	policy  BanPolicy
+	windows map[string]*banWindow
+	bans    map[string]Ban
+
+	// swept is when windows and bans were last swept
+	swept time.Time
+}
+
+type banWindow struct {
+	start    time.Time
+	requests int
+	errors   int
+	limited  int
+}
+
+// EnableBans turns on automatic banning of abusive clients, as per p.
+// Policies without any thresholds are programmer error, and panic.
+func (m *Middleware) EnableBans(p BanPolicy) {
+	if p.ErrorRate <= 0 && p.MaxRateLimited <= 0 {
+		panic(fmt.Errorf("ban policy requires an error rate or rate limit threshold"))
+	}
+
+	if p.MinRequests <= 0 {
+		p.MinRequests = DefaultBanMinRequests
+	}
+
+	if p.Window <= 0 {
+		p.Window = DefaultBanWindow
+	}
+
+	if p.Cooldown <= 0 {
+		p.Cooldown = DefaultBanCooldown
+	}
+
+	m.bans = &banList{
+		policy:  p,
+		windows: make(map[string]*banWindow),
+		bans:    make(map[string]Ban),
+	}
+
+	m.addAdminEndpoint("bans", m.serveBans)
+}
+
+// client identifies the client making a request, as per the ban policy
+func (bl *banList) client(remoteAddr string, header func(string) string) string {
+	if bl.policy.KeyHeader != "" {
+		if key := header(bl.policy.KeyHeader); key != "" {
+			sum := sha256.Sum256([]byte(key))
+
+			return "key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+
+	return clientIP(remoteAddr)
+}
+
+// banned returns client's ban, if it has one which hasn't expired
+func (bl *banList) banned(client string, now time.Time) (b Ban, ok bool) {
+	bl.Lock()
+	defer bl.Unlock()
+
+	if b, ok = bl.bans[client]; ok && !now.Before(b.Until) {
+		delete(bl.bans, client)
+		ok = false
+	}
+
+	return
+}
+
+// record notes the outcome of a request, returning a new Ban when it tips
+// client over a threshold
+func (bl *banList) record(client string, status int, limited bool, now time.Time) (b Ban, banned bool) {
+	bl.Lock()
+	defer bl.Unlock()
+
+	// Sweeping is a walk of every client, so happens once a window at most
+	if now.Sub(bl.swept) >= bl.policy.Window {
+		bl.sweep(now)
+	}
+
+	w, ok := bl.windows[client]
+	if !ok || now.Sub(w.start) >= bl.policy.Window {
+		w = &banWindow{start: now}
+		bl.windows[client] = w
+	}
+
+	w.requests++
+
+	if status >= 400 {
+		w.errors++
+	}
+
+	if limited {
+		w.limited++
+	}
+
+	var reason string
+
+	switch {
+	case bl.policy.MaxRateLimited > 0 && w.limited > bl.policy.MaxRateLimited:
+		reason = fmt.Sprintf("rate limited %d times in %s", w.limited, bl.policy.Window)
+
+	case bl.policy.ErrorRate > 0 && w.requests >= bl.policy.MinRequests && float64(w.errors)/float64(w.requests) > bl.policy.ErrorRate:
+		reason = fmt.Sprintf("%d of %d requests errored in %s", w.errors, w.requests, bl.policy.Window)
+
+	default:
+		return
+	}
+
+	delete(bl.windows, client)
+
+	b = Ban{Client: client, Reason: reason, Until: now.Add(bl.policy.Cooldown)}
+	bl.bans[client] = b
+
+	return b, true
+}
+
+// ban bans client immediately, for the policy's cooldown
+func (bl *banList) ban(client, reason string, now time.Time) Ban {
+	bl.Lock()
+	defer bl.Unlock()
+
+	delete(bl.windows, client)
+
+	b := Ban{Client: client, Reason: reason, Until: now.Add(bl.policy.Cooldown)}
+	bl.bans[client] = b
+
+	return b
+}
+
+// sweep forgets windows which have ended, and bans which have expired. It
+// must be called with bl's lock held
+func (bl *banList) sweep(now time.Time) {
+	bl.swept = now
+
+	for k, w := range bl.windows {
+		if now.Sub(w.start) >= bl.policy.Window {
+			delete(bl.windows, k)
+		}
+	}
+
+	for k, b := range bl.bans {
+		if !now.Before(b.Until) {
+			delete(bl.bans, k)
+		}
+	}
+}
+
+func (bl *banList) list(now time.Time) []Ban {
+	bl.Lock()
+	defer bl.Unlock()
+
+	bans := make([]Ban, 0, len(bl.bans))
+	for _, b := range bl.bans {
+		if now.Before(b.Until) {
+			bans = append(bans, b)
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+
+	return bans
+}
+
+func (bl *banList) lift(client string) bool {
+	bl.Lock()
+	defer bl.Unlock()
+
+	_, ok := bl.bans[client]
+	delete(bl.bans, client)
+
+	return ok
+}
+
+// liftBan lifts the ban on client, which is either as banned or as listed
+// by serveBans, returning false when there's no such ban
+func (m *Middleware) liftBan(client string, now time.Time) bool {
+	if m.bans.lift(client) {
+		return true
+	}
+
+	if m.anonymizer == nil {
+		return false
+	}
+
+	for _, b := range m.bans.list(now) {
+		if m.anonymizer.anonymizeSubject(b.Client, now) == client {
+			return m.bans.lift(b.Client)
+		}
+	}
+
+	return false
+}
+
+// banClient identifies the client making a request, when bans are enabled
+func (m *Middleware) banClient(remoteAddr string, header func(string) string) string {
+	if m.bans == nil {
+		return ""
+	}
+
+	return m.bans.client(remoteAddr, header)
+}
+
+// checkBan returns true, and the value of a Retry-After header, when the
+// client making a request is banned
+func (m *Middleware) checkBan(client string, now time.Time) (retryAfter string, banned bool) {
+	if m.bans == nil {
+		return
+	}
+
+	b, banned := m.bans.banned(client, now)
+	if banned {
+		retryAfter = strconv.Itoa(int(b.Until.Sub(now)/time.Second) + 1)
+	}
+
+	return
+}
+
+// recordBan records the outcome of a request, banning its client should
+// it have crossed a threshold
+func (m *Middleware) recordBan(client string, status int, limited bool, now time.Time) {
+	if m.bans == nil {
+		return
+	}
+
+	if b, banned := m.bans.record(client, status, limited, now); banned {
+		m.audit(AuditEvent{
+			Time:    now,
+			Action:  "ban",
+			Subject: b.Client,
+			Reason:  b.Reason,
+			Until:   &b.Until,
+		})
+	}
+}
+
+// serveBans lists current bans on GET, and lifts the ban on the client
+// named by the `client` query parameter on DELETE. Listed clients are
+// anonymised as logged clients are, and may be lifted by either name.
+// Bans name clients, so the listing isn't served without an AdminToken,
+// unlike most admin endpoints
+func (m *Middleware) serveBans(r adminRequest) adminResponse {
+	switch r.method {
+	case http.MethodGet:
+		if m.AdminToken == "" {
+			return jsonResponse(http.StatusForbidden, []byte(`{"error":"admin token required"}`))
+		}
+
+		now := time.Now()
+
+		bans := m.bans.list(now)
+		if m.anonymizer != nil {
+			for i := range bans {
+				bans[i].Client = m.anonymizer.anonymizeSubject(bans[i].Client, now)
+			}
+		}
+
+		b, _ := json.Marshal(map[string][]Ban{"bans": bans})
+
+		return jsonResponse(http.StatusOK, b)
+
+	case http.MethodDelete:
+		client := r.query.Get("client")
+		if !m.liftBan(client, time.Now()) {
+			return adminError(http.StatusNotFound, fmt.Errorf("client %q is not banned", client))
+		}
+
+		m.audit(AuditEvent{Action: "unban", Subject: client, Reason: "lifted via admin endpoint"})
+
+		return jsonResponse(http.StatusOK, []byte(`{"status":"lifted"}`))
+	}
+
+	return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.method))
+}
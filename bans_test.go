@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBanList_record(t *testing.T) {
+	bl := &banList{
+		policy:  BanPolicy{ErrorRate: 0.5, MinRequests: 4, MaxRateLimited: 2, Window: time.Minute, Cooldown: time.Hour},
+		windows: make(map[string]*banWindow),
+		bans:    make(map[string]Ban),
+	}
+
+	now := time.Now()
+
+	t.Run("error rate", func(t *testing.T) {
+		for i, status := range []int{404, 404, 200, 404} {
+			_, banned := bl.record("203.0.113.1", status, false, now)
+			if banned != (i == 3) {
+				t.Errorf("request %d: unexpected ban state %v", i, banned)
+			}
+		}
+
+		if _, ok := bl.banned("203.0.113.1", now.Add(59*time.Minute)); !ok {
+			t.Errorf("expected ban to hold during cooldown")
+		}
+
+		if _, ok := bl.banned("203.0.113.1", now.Add(time.Hour)); ok {
+			t.Errorf("expected ban to expire after cooldown")
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if _, banned := bl.record("203.0.113.2", 429, true, now); banned != (i == 2) {
+				t.Errorf("request %d: unexpected ban state %v", i, banned)
+			}
+		}
+	})
+
+	t.Run("windows reset", func(t *testing.T) {
+		bl.record("203.0.113.3", 429, true, now)
+		bl.record("203.0.113.3", 429, true, now)
+
+		if _, banned := bl.record("203.0.113.3", 429, true, now.Add(time.Minute)); banned {
+			t.Errorf("expected a fresh window")
+		}
+	})
+}
+
+func TestBanList_sweep(t *testing.T) {
+	bl := &banList{
+		policy:  BanPolicy{ErrorRate: 0.5, MinRequests: 4, Window: time.Minute, Cooldown: time.Hour},
+		windows: make(map[string]*banWindow),
+		bans:    make(map[string]Ban),
+	}
+
+	now := time.Now()
+
+	bl.record("203.0.113.1", 200, false, now)
+	bl.record("203.0.113.2", 200, false, now.Add(30*time.Second))
+
+	if !bl.swept.Equal(now) {
+		t.Errorf("expected a single sweep within the window, last swept %s", bl.swept)
+	}
+
+	bl.record("203.0.113.3", 200, false, now.Add(time.Minute))
+
+	if _, ok := bl.windows["203.0.113.1"]; ok || len(bl.windows) != 2 {
+		t.Errorf("expected ended windows to be swept, received %+v", bl.windows)
+	}
+}
+
+func TestEnableBans(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetRateLimit(RateLimit{Rate: 0.001, Burst: 1})
+	m.EnableBans(BanPolicy{MaxRateLimited: 1, KeyHeader: "X-API-Key"})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Key", "abc123")
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	for _, expect := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusForbidden} {
+		if rec := request(); rec.Code != expect {
+			t.Errorf("expected %d, received %d", expect, rec.Code)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"audit":{`) || strings.Contains(string(logWriter.body), "abc123") {
+		t.Errorf("expected a ban audit event without the raw key, received %q", logWriter.body)
+	}
+
+	if list := m.serveBans(adminRequest{method: "GET"}); list.status != http.StatusForbidden {
+		t.Errorf("expected bans to be listed only with an admin token, received %d", list.status)
+	}
+
+	m.AdminToken = "secret"
+
+	list := m.serveBans(adminRequest{method: "GET"})
+
+	var bans struct {
+		Bans []Ban `json:"bans"`
+	}
+
+	if err := json.Unmarshal(list.body, &bans); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(bans.Bans) != 1 || !strings.HasPrefix(bans.Bans[0].Client, "key:") {
+		t.Fatalf("expected one ban, received %+v", bans.Bans)
+	}
+
+	lift := m.serveBans(adminRequest{method: "DELETE", query: map[string][]string{"client": {bans.Bans[0].Client}}})
+	if lift.status != http.StatusOK {
+		t.Errorf("expected ban to be lifted, received %d", lift.status)
+	}
+
+	if rec := request(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected client to be unbanned, received %d", rec.Code)
+	}
+}
+
+func TestServeBans_Anonymized(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.AdminToken = "secret"
+	m.AnonymizeIPs(IPTruncate, 0)
+	m.EnableBans(BanPolicy{ErrorRate: 0.9})
+
+	now := time.Now()
+	m.bans.bans["203.0.113.42"] = Ban{Client: "203.0.113.42", Reason: "test", Until: now.Add(time.Hour)}
+
+	list := m.serveBans(adminRequest{method: "GET"})
+	if strings.Contains(string(list.body), "203.0.113.42") || !strings.Contains(string(list.body), "203.0.113.0") {
+		t.Errorf("expected banned clients to be anonymised, received %s", list.body)
+	}
+
+	lift := m.serveBans(adminRequest{method: "DELETE", query: map[string][]string{"client": {"203.0.113.0"}}})
+	if lift.status != http.StatusOK {
+		t.Errorf("expected ban to be lifted by its anonymised client, received %d", lift.status)
+	}
+
+	if _, banned := m.checkBan("203.0.113.42", now); banned {
+		t.Errorf("expected client to be unbanned")
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchLoggable is implemented by loggers which can ship several entries
+// at once, such as those which make a network call per write
+type BatchLoggable interface {
+	LogBatch([]LogEntry)
+}
+
+// BatchLogger implements middleware.Loggable, collecting entries into
+// batches for another logger. A batch is sent once it holds maxSize entries,
+// or maxLatency after its first entry arrived, whichever comes first.
+//
+// Loggers implementing BatchLoggable receive each batch in one call;
+// anything else receives each entry of the batch in turn. Batches are
+// always sent in order.
+type BatchLogger struct {
+	target     Loggable
+	maxSize    int
+	maxLatency time.Duration
+
+	// sending serialises sends, so batches arrive in order
+	sending sync.Mutex
+
+	lock  sync.Mutex
+	batch []LogEntry
+	timer *time.Timer
+}
+
+// NewBatchLogger returns a BatchLogger for target. A maxSize of zero or less
+// means batches are only bounded by time, and a maxLatency of zero means
+// they're only bounded by size.
+func NewBatchLogger(target Loggable, maxSize int, maxLatency time.Duration) *BatchLogger {
+	return &BatchLogger{
+		target:     target,
+		maxSize:    maxSize,
+		maxLatency: maxLatency,
+	}
+}
+
+// Log implements middleware.Loggable
+func (bl *BatchLogger) Log(l LogEntry) {
+	bl.lock.Lock()
+
+	bl.batch = append(bl.batch, l)
+
+	if len(bl.batch) == 1 && bl.maxLatency > 0 {
+		bl.timer = time.AfterFunc(bl.maxLatency, bl.Flush)
+	}
+
+	full := bl.maxSize > 0 && len(bl.batch) >= bl.maxSize
+
+	bl.lock.Unlock()
+
+	if full {
+		bl.Flush()
+	}
+}
+
+// Flush sends any pending entries immediately
+func (bl *BatchLogger) Flush() {
+	bl.sending.Lock()
+	defer bl.sending.Unlock()
+
+	bl.lock.Lock()
+
+	batch := bl.batch
+	bl.batch = nil
+
+	if bl.timer != nil {
+		bl.timer.Stop()
+		bl.timer = nil
+	}
+
+	bl.lock.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if b, ok := bl.target.(BatchLoggable); ok {
+		b.LogBatch(batch)
+
+		return
+	}
+
+	for _, l := range batch {
+		bl.target.Log(l)
+	}
+}
+
+// Close flushes any pending entries. Entries logged after Close are
+// batched as normal
+func (bl *BatchLogger) Close() error {
+	bl.Flush()
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testBatchLogger struct {
+	sync.Mutex
+	batches [][]LogEntry
+}
+
+func (tb *testBatchLogger) Log(l LogEntry) {
+	tb.LogBatch([]LogEntry{l})
+}
+
+func (tb *testBatchLogger) LogBatch(ls []LogEntry) {
+	tb.Lock()
+	defer tb.Unlock()
+
+	tb.batches = append(tb.batches, ls)
+}
+
+func (tb *testBatchLogger) sizes() (s []int) {
+	tb.Lock()
+	defer tb.Unlock()
+
+	for _, b := range tb.batches {
+		s = append(s, len(b))
+	}
+
+	return
+}
+
+func TestBatchLogger_MaxSize(t *testing.T) {
+	target := &testBatchLogger{}
+	bl := NewBatchLogger(target, 2, 0)
+
+	for i := 0; i < 5; i++ {
+		bl.Log(LogEntry{Status: 200 + i})
+	}
+
+	if s := target.sizes(); len(s) != 2 || s[0] != 2 || s[1] != 2 {
+		t.Errorf("expected two full batches, received %v", s)
+	}
+
+	bl.Close()
+
+	if s := target.sizes(); len(s) != 3 || s[2] != 1 {
+		t.Errorf("expected remaining entry to be flushed on close, received %v", s)
+	}
+
+	if target.batches[2][0].Status != 204 {
+		t.Errorf("expected entries in order, received %+v", target.batches)
+	}
+}
+
+func TestBatchLogger_MaxLatency(t *testing.T) {
+	target := &testBatchLogger{}
+	bl := NewBatchLogger(target, 100, 10*time.Millisecond)
+
+	bl.Log(LogEntry{})
+	bl.Log(LogEntry{})
+
+	if s := target.sizes(); len(s) != 0 {
+		t.Fatalf("expected nothing sent yet, received %v", s)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if s := target.sizes(); len(s) != 1 || s[0] != 2 {
+		t.Errorf("expected one batch of two, received %v", s)
+	}
+}
+
+func TestBatchLogger_PlainLoggable(t *testing.T) {
+	var received []int
+
+	bl := NewBatchLogger(testLoggable(func(l LogEntry) {
+		received = append(received, l.Status)
+	}), 3, 0)
+
+	bl.Log(LogEntry{Status: 1})
+	bl.Log(LogEntry{Status: 2})
+	bl.Log(LogEntry{Status: 3})
+
+	if len(received) != 3 || received[2] != 3 {
+		t.Errorf("expected each entry logged in turn, received %v", received)
+	}
+}
+
+type testLoggable func(LogEntry)
+
+func (f testLoggable) Log(l LogEntry) { f(l) }
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// BlockRule describes paths which are refused outright, such as those
+// probed by vulnerability scanners. Pattern takes the same form as
+// those passed to AddRoutePolicy
+type BlockRule struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+
+	// Status is the 4xx status blocked requests receive, and defaults to
+	// 404. 410 tells well behaved crawlers not to come back
+	Status int `json:"status" yaml:"status"`
+}
+
+// blocklist is a set of BlockRules which can be changed while serving
+type blocklist struct {
+	sync.RWMutex
+
+	rules   []BlockRule
+	matcher routeMatcher
+	hits    counterSet
+}
+
+// Block refuses requests matching pattern with status, without passing them
+// to the wrapped handler. Blocked requests are neither logged nor counted
+// as requests; instead they're counted per pattern under `blocked` in the
+// counters endpoint, so that scanners don't pollute application metrics.
+//
+// Rules can also be managed at runtime via the `blocklist` admin endpoint.
+// Block panics on a malformed pattern, or a status outside of 4xx.
+func (m *Middleware) Block(pattern string, status int) {
+	if err := m.blocklist.add(BlockRule{Pattern: pattern, Status: status}); err != nil {
+		panic(err)
+	}
+
+	m.changed()
+}
+
+// Unblock removes the rule for pattern, returning false if there wasn't one
+func (m *Middleware) Unblock(pattern string) bool {
+	defer m.changed()
+
+	return m.blocklist.remove(pattern)
+}
+
+// blocked returns the rule matching p, if any, and counts the hit
+func (m *Middleware) blocked(p string) (rule BlockRule, ok bool) {
+	m.blocklist.RLock()
+	pattern, v, ok := m.blocklist.matcher.match(p)
+	m.blocklist.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	m.blocklist.hits.add(pattern, 1)
+
+	return v.(BlockRule), true
+}
+
+func (bl *blocklist) add(rule BlockRule) (err error) {
+	if rule.Status == 0 {
+		rule.Status = http.StatusNotFound
+	}
+
+	if rule.Status < 400 || rule.Status > 499 {
+		return fmt.Errorf("block rule %q: status must be 4xx, received %d", rule.Pattern, rule.Status)
+	}
+
+	bl.Lock()
+	defer bl.Unlock()
+
+	if err = bl.matcher.add(rule.Pattern, rule); err != nil {
+		return
+	}
+
+	for i, r := range bl.rules {
+		if r.Pattern == rule.Pattern {
+			bl.rules[i] = rule
+
+			return bl.rebuild()
+		}
+	}
+
+	bl.rules = append(bl.rules, rule)
+
+	return
+}
+
+func (bl *blocklist) remove(pattern string) bool {
+	bl.Lock()
+	defer bl.Unlock()
+
+	for i, r := range bl.rules {
+		if r.Pattern == pattern {
+			bl.rules = append(bl.rules[:i], bl.rules[i+1:]...)
+			bl.rebuild()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// rebuild recompiles the matcher from rules, and must be called with
+// bl's lock held. routeMatchers can't have patterns removed, nor have
+// prefixes and globs replaced
+func (bl *blocklist) rebuild() error {
+	bl.matcher = routeMatcher{}
+
+	for _, r := range bl.rules {
+		if err := bl.matcher.add(r.Pattern, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bl *blocklist) list() []BlockRule {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	return append([]BlockRule{}, bl.rules...)
+}
+
+// serveBlocklist lists rules on GET, adds the BlockRule in the body on POST,
+// and removes the rule named by the `pattern` query parameter on DELETE
+func (m *Middleware) serveBlocklist(r adminRequest) adminResponse {
+	switch r.method {
+	case http.MethodGet:
+		b, _ := json.Marshal(map[string][]BlockRule{"rules": m.blocklist.list()})
+
+		return jsonResponse(http.StatusOK, b)
+
+	case http.MethodPost:
+		var rule BlockRule
+		if err := json.Unmarshal(r.body, &rule); err != nil {
+			return adminError(http.StatusBadRequest, err)
+		}
+
+		if err := m.blocklist.add(rule); err != nil {
+			return adminError(http.StatusBadRequest, err)
+		}
+
+		return jsonResponse(http.StatusCreated, []byte(`{"status":"blocked"}`))
+
+	case http.MethodDelete:
+		if !m.Unblock(r.query.Get("pattern")) {
+			return adminError(http.StatusNotFound, fmt.Errorf("no rule for pattern %q", r.query.Get("pattern")))
+		}
+
+		return jsonResponse(http.StatusOK, []byte(`{"status":"unblocked"}`))
+	}
+
+	return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.method))
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlock(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.Block("/wp-login.php", http.StatusGone)
+	m.Block("/cgi-bin/*", 0)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/wp-login.php", http.StatusGone},
+		{"/cgi-bin/test.cgi", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, received %d", test.path, test.status, rec.Code)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if len(logWriter.body) != 0 {
+		t.Errorf("expected blocked requests not to be logged, received %q", logWriter.body)
+	}
+
+	c := getCounters(t, m)
+
+	if c.Blocked["/wp-login.php"] != 1 || c.Blocked["/cgi-bin/*"] != 1 {
+		t.Errorf("expected blocked requests to be counted, received %+v", c.Blocked)
+	}
+
+	if len(c.Requests) != 0 {
+		t.Errorf("expected blocked requests to be left out of request counters, received %+v", c.Requests)
+	}
+}
+
+func TestBlock_PanicsOnBadStatus(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).Block("/admin", http.StatusOK)
+}
+
+func TestServeBlocklist(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+
+	admin := func(method, target, body string) (int, string) {
+		ar := m.serveAdmin(adminRequest{
+			method:   method,
+			endpoint: "blocklist",
+			query:    httptest.NewRequest(method, target, nil).URL.Query(),
+			header:   func(string) string { return "sekrit" },
+			body:     []byte(body),
+		})
+
+		return ar.status, string(ar.body)
+	}
+
+	if status, _ := admin("POST", "/__/blocklist", `{"pattern":"/.env","status":410}`); status != http.StatusForbidden {
+		t.Errorf("expected changes to be refused without an admin token, received %d", status)
+	}
+
+	m.AdminToken = "sekrit"
+
+	if status, _ := admin("POST", "/__/blocklist", `{"pattern":"/.env","status":410}`); status != http.StatusCreated {
+		t.Fatalf("expected 201, received %d", status)
+	}
+
+	if status, _ := admin("POST", "/__/blocklist", `{"pattern":"/.git","status":200}`); status != http.StatusBadRequest {
+		t.Errorf("expected invalid rules to be refused, received %d", status)
+	}
+
+	_, body := admin("GET", "/__/blocklist", "")
+
+	var list struct {
+		Rules []BlockRule `json:"rules"`
+	}
+
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(list.Rules) != 1 || list.Rules[0].Pattern != "/.env" || list.Rules[0].Status != 410 {
+		t.Errorf("unexpected rules %+v", list.Rules)
+	}
+
+	if status, _ := admin("DELETE", "/__/blocklist?pattern=/.env", ""); status != http.StatusOK {
+		t.Errorf("expected 200, received %d", status)
+	}
+
+	if status, body := admin("DELETE", "/__/blocklist?pattern=/.env", ""); status != http.StatusNotFound || !strings.Contains(body, "no rule") {
+		t.Errorf("expected 404, received %d %s", status, body)
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultBufferSize is the number of entries a BufferedLogger holds
+	// before flushing
+	DefaultBufferSize = 100
+
+	// DefaultBufferFlushInterval is the longest a BufferedLogger holds an
+	// entry before flushing
+	DefaultBufferFlushInterval = time.Second
+)
+
+// BufferOption configures a BufferedLogger
+type BufferOption func(*bufferOptions)
+
+type bufferOptions struct {
+	size     int
+	interval time.Duration
+}
+
+// WithBufferSize flushes buffers once they hold n entries
+func WithBufferSize(n int) BufferOption {
+	return func(o *bufferOptions) {
+		o.size = n
+	}
+}
+
+// WithFlushInterval flushes buffers d after their first entry arrived, so
+// that entries aren't held indefinitely when traffic is quiet
+func WithFlushInterval(d time.Duration) BufferOption {
+	return func(o *bufferOptions) {
+		o.interval = d
+	}
+}
+
+// BufferedLogger wraps inner, accumulating entries in memory and flushing
+// them on size or interval, which defaults to DefaultBufferSize entries or
+// DefaultBufferFlushInterval. Inner loggers implementing BatchLoggable,
+// such as the network loggers and FileLogger, receive each buffer in one
+// call, which turns a write per request into a write per buffer:
+//
+//	m.AddLogger(middleware.BufferedLogger(fileLogger, middleware.WithBufferSize(500)))
+//
+// Call Close on shutdown to flush anything still buffered.
+func BufferedLogger(inner Loggable, opts ...BufferOption) *BatchLogger {
+	o := bufferOptions{
+		size:     DefaultBufferSize,
+		interval: DefaultBufferFlushInterval,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return NewBatchLogger(inner, o.size, o.interval)
+}
+
+// LogBatch implements middleware.BatchLoggable, writing every entry in one
+// write
+func (fl *FileLogger) LogBatch(entries []LogEntry) {
+	var buf bytes.Buffer
+
+	for _, l := range entries {
+		b, err := json.Marshal(l)
+		if err != nil {
+			b = []byte(fmt.Sprintf("error marshaling log data: %q", err))
+		}
+
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	fl.Write(buf.Bytes())
+}
+
+// LogBatch implements middleware.BatchLoggable, writing every entry bound
+// for the same output in one write
+func (dl defaultLogger) LogBatch(entries []LogEntry) {
+	var out, errs bytes.Buffer
+
+	for _, l := range entries {
+		b, err := dl.encode(l)
+		if err != nil {
+			b = []byte(fmt.Sprintf("error marshaling log data: %q", err))
+		}
+
+		buf := &out
+		if dl.errors != nil && isErrorEntry(l) {
+			buf = &errs
+		}
+
+		buf.Write(b)
+		if len(b) == 0 || b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	// Print doesn't add a newline to output which already ends in one
+	if out.Len() > 0 {
+		dl.output.Print(out.String())
+	}
+
+	if errs.Len() > 0 {
+		dl.errors.Print(errs.String())
+	}
+}
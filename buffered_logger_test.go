@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBufferedLogger(t *testing.T) {
+	target := &testBatchLogger{}
+	bl := BufferedLogger(target, WithBufferSize(3), WithFlushInterval(50*time.Millisecond))
+
+	for i := 0; i < 4; i++ {
+		bl.Log(LogEntry{Status: 200})
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	sizes := target.sizes()
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 1 {
+		t.Errorf("expected batches of 3 then 1, received %v", sizes)
+	}
+}
+
+func TestBufferedLogger_Defaults(t *testing.T) {
+	bl := BufferedLogger(&testBatchLogger{})
+
+	if bl.maxSize != DefaultBufferSize || bl.maxLatency != DefaultBufferFlushInterval {
+		t.Errorf("unexpected defaults %d, %s", bl.maxSize, bl.maxLatency)
+	}
+}
+
+func TestDefaultLogger_LogBatch(t *testing.T) {
+	out, errs := &countingWriter{}, &countingWriter{}
+	dl := defaultLogger{output: log.New(out, "", 0), errors: log.New(errs, "", 0)}
+
+	dl.LogBatch([]LogEntry{{Status: 200}, {Status: 500}, {Status: 404}})
+
+	if out.writes != 1 || strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("expected 2 entries in one write, received %d writes of %q", out.writes, out.String())
+	}
+
+	if errs.writes != 1 || !strings.Contains(errs.String(), `"status":500`) {
+		t.Errorf("expected the error entry in one write, received %d writes of %q", errs.writes, errs.String())
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.writes++
+
+	return cw.Buffer.Write(b)
+}
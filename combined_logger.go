@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// CombinedTimeFormat is the timestamp layout used by the NCSA Combined Log Format
+	CombinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// CombinedLogger implements middleware.Loggable, writing entries in the
+// NCSA Combined Log Format, as per:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"
+//
+// This allows existing log analysis tooling, such as GoAccess or awstats,
+// to consume access logs without a custom adapter. Referers are `-` unless
+// the Middleware records them, as per LogReferer; combined loggers built
+// from a Config turn this on.
+type CombinedLogger struct {
+	lock   sync.Mutex
+	output io.Writer
+}
+
+// NewCombinedLogger returns a CombinedLogger writing to w
+func NewCombinedLogger(w io.Writer) *CombinedLogger {
+	return &CombinedLogger{output: w}
+}
+
+// Log implements middleware.Loggable
+func (cl *CombinedLogger) Log(l LogEntry) {
+	line := FormatCombined(l) + "\n"
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	io.WriteString(cl.output, line)
+}
+
+// FormatCombined formats a LogEntry as a single Combined Log Format line,
+// without a trailing newline. Data the middleware doesn't have, such
+// as the identd user, is written as `-`
+func FormatCombined(l LogEntry) string {
+	user, requestURI := "-", l.URL
+	if u, err := url.Parse(l.URL); err == nil {
+		requestURI = u.RequestURI()
+
+		if u.User != nil && u.User.Username() != "" {
+			user = u.User.Username()
+		}
+	}
+
+	bytes := "-"
+	if l.ResponseBytes > 0 {
+		bytes = strconv.Itoa(l.ResponseBytes)
+	}
+
+	return strings.Join([]string{
+		orDash(clientIP(l.IPAddress)),
+		"-",
+		user,
+		"[" + l.Time.Format(CombinedTimeFormat) + "]",
+		quote(orDash(l.Method) + " " + requestURI + " " + orDash(l.Proto)),
+		strconv.Itoa(l.Status),
+		bytes,
+		quote(orDash(l.Referer)),
+		quote(orDash(l.UserAgent)),
+	}, " ")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// quote wraps s in double quotes, escaping anything which would
+// otherwise confuse a log parser
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s)
+
+	return `"` + s + `"`
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFormatCombined(t *testing.T) {
+	ts := time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+
+	for _, test := range []struct {
+		name   string
+		entry  LogEntry
+		expect string
+	}{
+		{
+			"full entry",
+			LogEntry{
+				IPAddress:     "127.0.0.1:52345",
+				Time:          ts,
+				URL:           "http://frank@example.com/apache_pb.gif?a=b",
+				Method:        "GET",
+				Proto:         "HTTP/1.0",
+				Status:        200,
+				ResponseBytes: 2326,
+				Referer:       "http://www.example.com/start.html",
+				UserAgent:     `Mozilla/4.08 "quoted"`,
+			},
+			`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=b HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 \"quoted\""`,
+		},
+		{
+			"sparse entry",
+			LogEntry{
+				IPAddress: "[::1]:8080",
+				Time:      ts,
+				URL:       "/",
+				Method:    "HEAD",
+				Proto:     "HTTP/1.1",
+				Status:    204,
+			},
+			`::1 - - [10/Oct/2000:13:55:36 -0700] "HEAD / HTTP/1.1" 204 - "-" "-"`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := FormatCombined(test.entry)
+			if out != test.expect {
+				t.Errorf("expected\n%s\nreceived\n%s", test.expect, out)
+			}
+		})
+	}
+
+	t.Run("logger", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		NewCombinedLogger(buf).Log(LogEntry{Time: ts, URL: "/", Status: 200})
+
+		if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+			t.Errorf("expected a newline terminated entry, received %q", buf.String())
+		}
+	})
+}
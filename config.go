@@ -0,0 +1,1207 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes a fully configured Middleware, and is usually loaded from
+// a YAML or JSON file by FromConfig so that behaviour can be tuned without
+// code changes. A sample config looks like:
+//
+//	loggers:
+//	  - type: stdout
+//	  - type: file
+//	    path: /var/log/app/access.log
+//	    format: combined
+//	    max_size_mb: 100
+//	    max_backups: 7
+//	    compress: true
+//	  - type: stderr
+//	    min_level: warn
+//	  - type: stdout
+//	    format: summary
+//	    summary_interval: 1m
+//	  - type: file
+//	    path: /var/log/app/slow.log
+//	    slow_threshold: 1s
+//	  - type: file
+//	    path: /var/log/app/requests.csv
+//	    format: csv
+//	    fields: [time, method, url, status, duration_ms]
+//	  - type: stderr
+//	    rename:
+//	      status: http_status
+//	log_referer: true
+//	log_partitions:
+//	  - paths: [/api/*]
+//	    loggers:
+//	      - type: file
+//	        path: /var/log/app/api-access.log
+//	  - paths: [/admin/*]
+//	    loggers:
+//	      - type: file
+//	        path: /var/log/app/admin-access.log
+//	routes:
+//	  - pattern: /healthcheck
+//	    sample_rate: 0.01
+//	    level: debug
+//	  - pattern: /payments/*
+//	    slow_threshold: 250ms
+//	    budget: 100ms
+//	    apdex_threshold: 200ms
+//	    capture: [request_body, response_body]
+//	  - pattern: /api/*
+//	    capture: [response_body]
+//	    capture_min_status: 500
+//	    capture_content_types: [application/json, text/]
+//	  - pattern: /batch/trigger
+//	    availability: ["02:00-04:00"]
+//	  - pattern: /v1/*
+//	    deprecated_fields: [user.nickname]
+//	    warning: v1 is deprecated, use v2
+//	  - pattern: /app/*
+//	    early_hints: ["</app.css>; rel=preload; as=style"]
+//	route_templates: [/users/:id, /users/:id/orders/{order}, /docs/*]
+//	counter_templates: [/static/*]
+//	max_counter_keys: 1000
+//	expvar: api
+//	skip_paths: [/favicon.ico]
+//	blocklist:
+//	  - pattern: /wp-login.php
+//	    status: 410
+//	honeypots: [/.env, /admin.php]
+//	synthetic:
+//	  header: X-Synthetic
+//	  baggage_key: synthetic
+//	dry_run: [blocklist, rate_limit]
+//	strip_query: false
+//	log_referer: true
+//	static_fields:
+//	  service: payments
+//	  env: ${ENVIRONMENT}
+//	  hostname: ${HOSTNAME}
+//	strict_schema: false
+//	tenant_header: X-Tenant-ID
+//	headers:
+//	  request: [Accept, X-Correlation-ID, X-Internal-Token]
+//	  response: [Content-Type, Cache-Control, X-Cache]
+//	  redact: [X-Internal-Token]
+//	  hash_key_env: MIDDLEWARE_HEADER_HASH_KEY
+//	redact:
+//	  query_params: [token, api_key, "*_secret"]
+//	  ip_addresses: truncate
+//	rate_limit:
+//	  rate: 10
+//	  burst: 20
+//	bans:
+//	  max_rate_limited: 100
+//	  cooldown: 15m
+//	quotas:
+//	  hourly: 1000
+//	  daily: 10000
+//	  weights:
+//	    acme: 10
+//	  path: /var/lib/app/quotas.json
+//	lanes:
+//	  max_concurrent: 200
+//	  default: normal
+//	  lanes:
+//	    - name: critical
+//	      paths: [/payments/*]
+//	    - name: normal
+//	    - name: bulk
+//	      header: X-Priority
+//	      header_values: [low]
+//	log_queue:
+//	  size: 50000
+//	  policy: sample
+//	warm_up:
+//	  duration: 2m
+//	  max_concurrency: 100
+//	  ready_at: 0.1
+//	admin:
+//	  token_env: MIDDLEWARE_ADMIN_TOKEN
+//	deadlines:
+//	  propagate: true
+//	  overhead: 5ms
+//	latency:
+//	  enabled: true
+//	  buckets: [10ms, 50ms, 100ms, 500ms, 1s, 5s]
+//	  percentiles: true
+//	prometheus:
+//	  enabled: true
+//	  namespace: payments
+//	  buckets: [0.01, 0.05, 0.1, 0.5, 1, 5]
+//	tail: true
+//	runtime_stats: true
+//	statsd:
+//	  address: localhost:8125
+//	  prefix: payments.
+//	  sample_rate: 0.1
+//	influx:
+//	  url: http://localhost:8086/api/v2/write?org=acme&bucket=metrics
+//	  token_env: INFLUX_TOKEN
+//	  tags: {host: "${HOSTNAME}"}
+//	  interval: 10s
+//	graphite:
+//	  address: localhost:2003
+//	  prefix: payments.${HOSTNAME}
+//	pushgateway:
+//	  url: http://pushgateway:9091
+//	  job: payments
+//	  grouping: {instance: "${HOSTNAME}"}
+//	request_ids:
+//	  honour: true
+//	  max_length: 64
+//	static:
+//	  - prefix: /assets/
+//	    dir: ./public
+//	profile_slow:
+//	  threshold: 2s
+//	  kind: trace
+//	favicon: none
+//	robots_txt: ./public/robots.txt
+//	retention:
+//	  2xx: short
+//	  5xx: long
+//	  admin: long
+type Config struct {
+	// Loggers, when set, replaces the default STDOUT logger
+	Loggers      []LoggerConfig  `json:"loggers" yaml:"loggers"`
+	Routes       []RouteConfig   `json:"routes" yaml:"routes"`
+	SkipPaths    []string        `json:"skip_paths" yaml:"skip_paths"`
+	StripQuery   bool            `json:"strip_query" yaml:"strip_query"`
+	LogReferer   bool            `json:"log_referer" yaml:"log_referer"`
+	StrictSchema bool            `json:"strict_schema" yaml:"strict_schema"`
+	TenantHeader string          `json:"tenant_header" yaml:"tenant_header"`
+	Headers      HeadersConfig   `json:"headers" yaml:"headers"`
+	Redact       RedactConfig    `json:"redact" yaml:"redact"`
+	RateLimit    RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	Admin        AdminConfig     `json:"admin" yaml:"admin"`
+	RequestIDs   RequestIDConfig `json:"request_ids" yaml:"request_ids"`
+	Bans         BansConfig      `json:"bans" yaml:"bans"`
+
+	// Quotas, when it has an Hourly or Daily quota, enforces quotas per
+	// tenant as per EnforceQuotas
+	Quotas QuotaPolicy `json:"quotas" yaml:"quotas"`
+
+	// Lanes, when it has any, enables priority lanes as per SetLanes
+	Lanes LanePolicy `json:"lanes" yaml:"lanes"`
+
+	LogQueue LogQueueConfig `json:"log_queue" yaml:"log_queue"`
+
+	// LogPartitions, when set, sends entries for each group of routes to
+	// loggers of their own, as per PartitionLogger, rather than to
+	// Loggers, which receive the rest
+	LogPartitions []LogPartitionConfig `json:"log_partitions" yaml:"log_partitions"`
+
+	// ObserveAPI, when set, is the title of the OpenAPI document served
+	// from observed traffic, as per ObserveAPI
+	ObserveAPI string `json:"observe_api" yaml:"observe_api"`
+
+	// WarmUp, when it has a Duration, starts warming up as per WarmUp
+	WarmUp WarmUpConfig `json:"warm_up" yaml:"warm_up"`
+
+	// Blocklist holds paths to refuse outright, as per Block
+	Blocklist []BlockRule `json:"blocklist" yaml:"blocklist"`
+
+	// Honeypots holds trap paths, as per AddHoneypots
+	Honeypots []string `json:"honeypots" yaml:"honeypots"`
+
+	// RouteTemplates, when set, resolves route templates as per
+	// RouteTemplates
+	RouteTemplates []string `json:"route_templates" yaml:"route_templates"`
+
+	// CounterTemplates, when set, groups counted requests as per
+	// CounterTemplates
+	CounterTemplates []string `json:"counter_templates" yaml:"counter_templates"`
+
+	// MaxCounterKeys, when set, bounds the keys counted as per
+	// LimitCounterKeys
+	MaxCounterKeys int `json:"max_counter_keys" yaml:"max_counter_keys"`
+
+	// Expvar, when set, publishes request counters to expvar under this
+	// name, as per PublishCounters
+	Expvar string `json:"expvar" yaml:"expvar"`
+
+	// Synthetic, when it has a Header or BaggageKey, identifies synthetic
+	// traffic as per MarkSynthetic
+	Synthetic SyntheticMarker `json:"synthetic" yaml:"synthetic"`
+
+	// Retention maps statuses to retention classes, as per SetRetentionClasses
+	Retention map[string]string `json:"retention" yaml:"retention"`
+
+	// Static serves directories of assets, as per Static
+	Static []StaticConfig `json:"static" yaml:"static"`
+
+	// AttributeResources is the fraction of requests to attribute
+	// allocations and goroutines to, as per AttributeResources
+	AttributeResources float64 `json:"attribute_resources" yaml:"attribute_resources"`
+
+	// ProfileSlow, when it has a Threshold, captures profiles as per
+	// ProfileSlowRequests
+	ProfileSlow ProfileConfig `json:"profile_slow" yaml:"profile_slow"`
+
+	// Deadlines, when Propagate is set, propagates deadlines as per
+	// PropagateDeadlines
+	Deadlines DeadlinesConfig `json:"deadlines" yaml:"deadlines"`
+
+	// Latency, when Enabled, keeps latency histograms as per TrackLatency.
+	// Its Percentiles, when set, keeps percentiles as per TrackPercentiles
+	Latency LatencyConfig `json:"latency" yaml:"latency"`
+
+	// Prometheus, when Enabled, exports metrics to Prometheus, as per the
+	// prom subpackage's Export, to a registry of the middleware's own.
+	// It requires importing the prom subpackage
+	Prometheus PrometheusConfig `json:"prometheus" yaml:"prometheus"`
+
+	// Tail turns on the tail admin endpoint, as per EnableTail, and
+	// requires an admin token
+	Tail bool `json:"tail" yaml:"tail"`
+
+	// RuntimeStats adds process health to the counters endpoint, as per
+	// IncludeRuntimeStats
+	RuntimeStats bool `json:"runtime_stats" yaml:"runtime_stats"`
+
+	// Statsd, when it has an Address, sends metrics as per Statsd
+	Statsd StatsdConfig `json:"statsd" yaml:"statsd"`
+
+	// Influx, when it has a URL or an Address, writes metrics as per Influx
+	Influx InfluxReporterConfig `json:"influx" yaml:"influx"`
+
+	// Graphite, when it has an Address, pushes metrics as per Graphite
+	Graphite GraphiteReporterConfig `json:"graphite" yaml:"graphite"`
+
+	// Pushgateway, when it has a URL, pushes metrics as per the prom
+	// subpackage's Pushgateway, and requires Prometheus to be Enabled
+	Pushgateway PushgatewayReporterConfig `json:"pushgateway" yaml:"pushgateway"`
+
+	// StaticFields are added to every entry, as per AddStaticField.
+	// Values may refer to environment variables, such as `${HOSTNAME}`
+	StaticFields map[string]string `json:"static_fields" yaml:"static_fields"`
+
+	// DryRun lists features to put into observe-only mode, as per DryRun,
+	// or `all` for every feature
+	DryRun []string `json:"dry_run" yaml:"dry_run"`
+
+	// Favicon and RobotsTxt are paths to files to serve as per Favicon and
+	// RobotsTxt, or `none` to respond 204 No Content
+	Favicon   string `json:"favicon" yaml:"favicon"`
+	RobotsTxt string `json:"robots_txt" yaml:"robots_txt"`
+}
+
+// LoggerConfig configures one of the built in loggers. Type is one of
+// `stdout`, `stderr`, or `file`; file loggers also require a Path, and
+// may be rotated as per FileLoggerConfig. Format is one of `json` (the
+// default), `logfmt`, `combined`, `pretty`, `summary`, or `csv`. Referers
+// are only recorded, in `combined` logs or any other, when the Config sets
+// log_referer
+type LoggerConfig struct {
+	Type   string `json:"type" yaml:"type"`
+	Path   string `json:"path" yaml:"path"`
+	Format string `json:"format" yaml:"format"`
+
+	MaxSizeMB  int      `json:"max_size_mb" yaml:"max_size_mb"`
+	MaxAge     Duration `json:"max_age" yaml:"max_age"`
+	MaxBackups int      `json:"max_backups" yaml:"max_backups"`
+	Compress   bool     `json:"compress" yaml:"compress"`
+
+	// SampleRate, when between 0 and 1, wraps the logger in a
+	// SamplingLogger
+	SampleRate float64 `json:"sample_rate" yaml:"sample_rate"`
+
+	// MinStatus and Paths, when set, wrap the logger in a FilterLogger
+	// forwarding only entries with at least this status, and matching
+	// these route patterns
+	MinStatus int      `json:"min_status" yaml:"min_status"`
+	Paths     []string `json:"paths" yaml:"paths"`
+
+	// MinLevel, when set, wraps the logger in a FilterLogger forwarding
+	// only entries of at least this level, such as `warn`
+	MinLevel string `json:"min_level" yaml:"min_level"`
+
+	// SummaryInterval is how often the `summary` format writes a summary,
+	// defaulting to DefaultSummaryInterval
+	SummaryInterval Duration `json:"summary_interval" yaml:"summary_interval"`
+
+	// Fields lists the keys written by the `csv` format, as per CSVEncoder
+	Fields []string `json:"fields" yaml:"fields"`
+
+	// Rename renames keys written by the `json` format, as per
+	// RenamedJSONEncoder
+	Rename map[string]string `json:"rename" yaml:"rename"`
+
+	// SlowThreshold, when set, wraps the logger in a SlowLogger, with
+	// diagnostics, forwarding only entries slower than this
+	SlowThreshold Duration `json:"slow_threshold" yaml:"slow_threshold"`
+}
+
+// LogPartitionConfig is a group of routes, matching any of Paths, whose
+// entries are sent to Loggers
+type LogPartitionConfig struct {
+	Paths   []string       `json:"paths" yaml:"paths"`
+	Loggers []LoggerConfig `json:"loggers" yaml:"loggers"`
+}
+
+// RouteConfig is the configuration form of a RoutePolicy. Capture may contain
+// `request_body`, `response_body`, `request_headers`, and `response_headers`
+type RouteConfig struct {
+	Pattern       string   `json:"pattern" yaml:"pattern"`
+	SampleRate    float64  `json:"sample_rate" yaml:"sample_rate"`
+	SlowThreshold Duration `json:"slow_threshold" yaml:"slow_threshold"`
+	Budget        Duration `json:"budget" yaml:"budget"`
+	Apdex         Duration `json:"apdex_threshold" yaml:"apdex_threshold"`
+	Capture       []string `json:"capture" yaml:"capture"`
+	MaxBodyBytes  int      `json:"max_body_bytes" yaml:"max_body_bytes"`
+	Level         string   `json:"level" yaml:"level"`
+
+	CaptureMinStatus    int      `json:"capture_min_status" yaml:"capture_min_status"`
+	CaptureContentTypes []string `json:"capture_content_types" yaml:"capture_content_types"`
+
+	// Availability holds windows of the form `02:00-04:00`, in the
+	// IANA time zone AvailabilityTZ, or UTC when unset
+	Availability      []string `json:"availability" yaml:"availability"`
+	AvailabilityTZ    string   `json:"availability_tz" yaml:"availability_tz"`
+	UnavailableStatus int      `json:"unavailable_status" yaml:"unavailable_status"`
+
+	// DeprecatedFields and Warning announce contract changes in the
+	// route's responses, as per Deprecation
+	DeprecatedFields []string `json:"deprecated_fields" yaml:"deprecated_fields"`
+	Warning          string   `json:"warning" yaml:"warning"`
+
+	// EarlyHints lists Link header values to send in a `103 Early Hints`
+	// response, as per RoutePolicy
+	EarlyHints []string `json:"early_hints" yaml:"early_hints"`
+
+	// Stream sends responses as they're written, as per RoutePolicy
+	Stream bool `json:"stream" yaml:"stream"`
+}
+
+// HeadersConfig lists headers to record in each LogEntry, and those whose
+// values must be masked
+type HeadersConfig struct {
+	Request  []string `json:"request" yaml:"request"`
+	Response []string `json:"response" yaml:"response"`
+	Redact   []string `json:"redact" yaml:"redact"`
+
+	// HashKeyEnv, when set, names an environment variable holding the key
+	// with which to hash redacted headers, as per HashRedactedHeaders
+	HashKeyEnv string `json:"hash_key_env" yaml:"hash_key_env"`
+}
+
+// RedactConfig lists data to be kept out of logs and counters
+type RedactConfig struct {
+	QueryParams []string `json:"query_params" yaml:"query_params"`
+
+	// IPAddresses is one of `truncate` or `hash`, as per AnonymizeIPs,
+	// with IPKeyRotation setting how often hash keys rotate
+	IPAddresses   string   `json:"ip_addresses" yaml:"ip_addresses"`
+	IPKeyRotation Duration `json:"ip_key_rotation" yaml:"ip_key_rotation"`
+}
+
+// RateLimitConfig is the configuration form of a RateLimit
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+// AdminConfig configures access to admin endpoints. TokenEnv names an
+// environment variable to read the token from, which avoids committing
+// secrets alongside config, and which must be set when named. A
+// Middleware's AdminToken is left as is when neither is set
+type AdminConfig struct {
+	Token    string `json:"token" yaml:"token"`
+	TokenEnv string `json:"token_env" yaml:"token_env"`
+}
+
+// BansConfig is the configuration form of a BanPolicy. Bans are enabled
+// when either threshold is set
+type BansConfig struct {
+	KeyHeader      string   `json:"key_header" yaml:"key_header"`
+	ErrorRate      float64  `json:"error_rate" yaml:"error_rate"`
+	MinRequests    int      `json:"min_requests" yaml:"min_requests"`
+	MaxRateLimited int      `json:"max_rate_limited" yaml:"max_rate_limited"`
+	Window         Duration `json:"window" yaml:"window"`
+	Cooldown       Duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// LogQueueConfig is the configuration form of a LogQueue. Policy is one of
+// `drop` (the default), `block`, or `sample`
+type LogQueueConfig struct {
+	Size    int    `json:"size" yaml:"size"`
+	Workers int    `json:"workers" yaml:"workers"`
+	Policy  string `json:"policy" yaml:"policy"`
+}
+
+// ProfileConfig is the configuration form of a ProfilePolicy. Kind is one
+// of `cpu` or `trace`
+type ProfileConfig struct {
+	Threshold   Duration `json:"threshold" yaml:"threshold"`
+	Kind        string   `json:"kind" yaml:"kind"`
+	Duration    Duration `json:"duration" yaml:"duration"`
+	Cooldown    Duration `json:"cooldown" yaml:"cooldown"`
+	MaxProfiles int      `json:"max_profiles" yaml:"max_profiles"`
+}
+
+// StaticConfig serves the assets in Dir under Prefix
+type StaticConfig struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Dir    string `json:"dir" yaml:"dir"`
+}
+
+// WarmUpConfig is the configuration form of a WarmUp
+type WarmUpConfig struct {
+	Duration           Duration `json:"duration" yaml:"duration"`
+	InitialConcurrency int      `json:"initial_concurrency" yaml:"initial_concurrency"`
+	MaxConcurrency     int      `json:"max_concurrency" yaml:"max_concurrency"`
+	ReadyAt            float64  `json:"ready_at" yaml:"ready_at"`
+}
+
+// DeadlinesConfig is the configuration form of PropagateDeadlines
+type DeadlinesConfig struct {
+	Propagate bool     `json:"propagate" yaml:"propagate"`
+	Overhead  Duration `json:"overhead" yaml:"overhead"`
+}
+
+// LatencyConfig is the configuration form of TrackLatency and
+// TrackPercentiles
+type LatencyConfig struct {
+	Enabled     bool       `json:"enabled" yaml:"enabled"`
+	Buckets     []Duration `json:"buckets" yaml:"buckets"`
+	Percentiles bool       `json:"percentiles" yaml:"percentiles"`
+}
+
+// InfluxReporterConfig is the configuration form of an InfluxConfig.
+// TokenEnv names an environment variable to read the token from, and tag
+// values may refer to environment variables, such as `${HOSTNAME}`
+type InfluxReporterConfig struct {
+	URL         string            `json:"url" yaml:"url"`
+	Token       string            `json:"token" yaml:"token"`
+	TokenEnv    string            `json:"token_env" yaml:"token_env"`
+	Address     string            `json:"address" yaml:"address"`
+	Measurement string            `json:"measurement" yaml:"measurement"`
+	Tags        map[string]string `json:"tags" yaml:"tags"`
+	Interval    Duration          `json:"interval" yaml:"interval"`
+}
+
+// GraphiteReporterConfig is the configuration form of a GraphiteConfig.
+// Prefix may refer to environment variables, such as `${HOSTNAME}`
+type GraphiteReporterConfig struct {
+	Address  string   `json:"address" yaml:"address"`
+	Prefix   string   `json:"prefix" yaml:"prefix"`
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+// PrometheusConfig is the configuration form of the prom subpackage's
+// Config. Buckets are in seconds
+type PrometheusConfig struct {
+	Enabled   bool      `json:"enabled" yaml:"enabled"`
+	Namespace string    `json:"namespace" yaml:"namespace"`
+	Buckets   []float64 `json:"buckets" yaml:"buckets"`
+}
+
+// PushgatewayReporterConfig is the configuration form of the prom
+// subpackage's PushgatewayConfig. Grouping label values may refer to
+// environment variables, such as `${HOSTNAME}`
+type PushgatewayReporterConfig struct {
+	URL      string            `json:"url" yaml:"url"`
+	Job      string            `json:"job" yaml:"job"`
+	Grouping map[string]string `json:"grouping" yaml:"grouping"`
+	Interval Duration          `json:"interval" yaml:"interval"`
+}
+
+// RequestIDConfig is the configuration form of a RequestIDPolicy. Client
+// supplied request IDs are only used when Honour is set
+type RequestIDConfig struct {
+	Honour         bool   `json:"honour" yaml:"honour"`
+	Pattern        string `json:"pattern" yaml:"pattern"`
+	MaxLength      int    `json:"max_length" yaml:"max_length"`
+	RecordClientID bool   `json:"record_client_id" yaml:"record_client_id"`
+}
+
+// Duration is a time.Duration which can be unmarshaled from strings
+// such as "250ms" or "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var s string
+	if err = unmarshal(&s); err != nil {
+		return
+	}
+
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+var captureNames = map[string]Capture{
+	"request_body":     CaptureRequestBody,
+	"response_body":    CaptureResponseBody,
+	"request_headers":  CaptureRequestHeaders,
+	"response_headers": CaptureResponseHeaders,
+}
+
+// LoadConfig reads a Config from a file. Files ending in `.yaml` or `.yml`
+// are parsed as YAML; anything else is parsed as JSON. Either way, unknown
+// fields are errors, lest typos go unnoticed
+func LoadConfig(path string) (c Config, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &c)
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+
+		err = d.Decode(&c)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	return
+}
+
+// FromConfig wraps h, as per NewMiddleware, and configures the resulting
+// Middleware from the config file at path
+func FromConfig(h interface{}, path string) (m *Middleware, err error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return
+	}
+
+	m = NewMiddleware(h)
+	if err = c.Apply(m); err != nil {
+		m = nil
+	}
+
+	return
+}
+
+// Apply configures m as per c. Should c be invalid, the files, connections,
+// and reporters Apply opened or started are closed again, and m's loggers
+// restored, though m may be left partly configured
+func (c Config) Apply(m *Middleware) (err error) {
+	var built closers
+
+	previous, reporters := m.loggers, m.reporters.count()
+	defer func() {
+		if err != nil {
+			m.loggers = previous
+			m.reporters.closeFrom(reporters)
+			built.close()
+		}
+	}()
+
+	if len(c.Loggers) > 0 {
+		loggers := make([]Loggable, 0, len(c.Loggers))
+		for _, lc := range c.Loggers {
+			var l Loggable
+			if l, err = lc.logger(m, &built); err != nil {
+				return
+			}
+
+			loggers = append(loggers, l)
+		}
+
+		m.SetLoggers(loggers...)
+	}
+
+	if len(c.LogPartitions) > 0 {
+		pl := NewPartitionLogger(m.loggers...)
+		for i, lp := range c.LogPartitions {
+			if len(lp.Paths) == 0 || len(lp.Loggers) == 0 {
+				return fmt.Errorf("log partition %d requires paths and loggers", i)
+			}
+
+			loggers := make([]Loggable, 0, len(lp.Loggers))
+			for _, lc := range lp.Loggers {
+				var l Loggable
+				if l, err = lc.logger(m, &built); err != nil {
+					return
+				}
+
+				loggers = append(loggers, l)
+			}
+
+			// Partition panics on bad patterns, so check them first
+			var rm routeMatcher
+			for _, p := range lp.Paths {
+				if err = rm.add(p, nil); err != nil {
+					return
+				}
+
+				pl.Partition(p, loggers...)
+			}
+		}
+
+		m.SetLoggers(pl)
+	}
+
+	for _, rc := range c.Routes {
+		var p RoutePolicy
+		if p, err = rc.policy(); err != nil {
+			return
+		}
+
+		if err = m.routes.add(rc.Pattern, p); err != nil {
+			return
+		}
+	}
+
+	for _, p := range c.SkipPaths {
+		if err = m.skip.add(p, nil); err != nil {
+			return
+		}
+	}
+
+	for _, rule := range c.Blocklist {
+		if err = m.blocklist.add(rule); err != nil {
+			return
+		}
+	}
+
+	for _, p := range c.Honeypots {
+		if err = m.honeypots.add(p, nil); err != nil {
+			return
+		}
+	}
+
+	if len(c.RouteTemplates) > 0 {
+		var rt routeTemplates
+		if rt, err = newRouteTemplates(c.RouteTemplates); err != nil {
+			return
+		}
+
+		m.SetRouteResolver(rt)
+	}
+
+	if len(c.CounterTemplates) > 0 {
+		if m.counterKeys, err = newRouteTemplates(c.CounterTemplates); err != nil {
+			return
+		}
+	}
+
+	if c.MaxCounterKeys > 0 {
+		m.LimitCounterKeys(c.MaxCounterKeys)
+	}
+
+	if c.Expvar != "" {
+		if expvar.Get(c.Expvar) != nil {
+			return fmt.Errorf("expvar: %q is already published", c.Expvar)
+		}
+
+		m.PublishCounters(c.Expvar)
+	}
+
+	if c.Synthetic.Header != "" || c.Synthetic.BaggageKey != "" {
+		m.MarkSynthetic(c.Synthetic)
+	}
+
+	if c.StrictSchema {
+		m.StrictSchema()
+	}
+
+	for name, v := range c.StaticFields {
+		if coreFields[name] {
+			return fmt.Errorf("static_fields: %q clashes with a LogEntry field", name)
+		}
+
+		m.AddStaticField(name, os.ExpandEnv(v))
+	}
+
+	if c.Deadlines.Propagate {
+		m.PropagateDeadlines(time.Duration(c.Deadlines.Overhead))
+	}
+
+	if c.Latency.Enabled {
+		buckets := make([]time.Duration, len(c.Latency.Buckets))
+		for i, b := range c.Latency.Buckets {
+			if b <= 0 || (i > 0 && b <= c.Latency.Buckets[i-1]) {
+				return fmt.Errorf("latency: buckets must be positive and ascending")
+			}
+
+			buckets[i] = time.Duration(b)
+		}
+
+		m.TrackLatency(buckets...)
+	}
+
+	if c.Latency.Percentiles {
+		m.TrackPercentiles()
+	}
+
+	if c.Prometheus.Enabled || c.Pushgateway.URL != "" {
+		if prometheusConfigurer == nil {
+			return fmt.Errorf("prometheus: requires importing the prom package")
+		}
+
+		if err = prometheusConfigurer(m, c); err != nil {
+			return
+		}
+	}
+
+	if c.RuntimeStats {
+		m.IncludeRuntimeStats()
+	}
+
+	if c.Statsd.Address != "" {
+		if m.statsd, err = newStatsdClient(c.Statsd); err != nil {
+			return
+		}
+
+		built = append(built, m.statsd.conn)
+	}
+
+	if c.Influx.URL != "" || c.Influx.Address != "" {
+		ic := InfluxConfig{
+			URL:         c.Influx.URL,
+			Token:       c.Influx.Token,
+			Address:     c.Influx.Address,
+			Measurement: c.Influx.Measurement,
+			Tags:        make(map[string]string, len(c.Influx.Tags)),
+			Interval:    time.Duration(c.Influx.Interval),
+		}
+
+		if c.Influx.TokenEnv != "" {
+			ic.Token = os.Getenv(c.Influx.TokenEnv)
+		}
+
+		for k, v := range c.Influx.Tags {
+			ic.Tags[k] = os.ExpandEnv(v)
+		}
+
+		var r *reporter
+		if r, err = newInfluxReporter(ic); err != nil {
+			return
+		}
+
+		m.addReporter(r)
+	}
+
+	if c.Graphite.Address != "" {
+		var r *reporter
+		if r, err = newGraphiteReporter(GraphiteConfig{
+			Address:  c.Graphite.Address,
+			Prefix:   os.ExpandEnv(c.Graphite.Prefix),
+			Interval: time.Duration(c.Graphite.Interval),
+		}); err != nil {
+			return
+		}
+
+		m.addReporter(r)
+	}
+
+	for _, f := range c.DryRun {
+		switch {
+		case f == "all":
+			m.DryRun()
+		case validDryRunFeature(f):
+			m.DryRun(f)
+		default:
+			return fmt.Errorf("dry_run: unknown feature %q", f)
+		}
+	}
+
+	m.StripQuery = c.StripQuery
+	if c.LogReferer {
+		m.LogReferer()
+	}
+	m.TenantHeader = c.TenantHeader
+	m.LogRequestHeaders(c.Headers.Request...)
+	m.LogResponseHeaders(c.Headers.Response...)
+	m.RedactHeaders(c.Headers.Redact...)
+
+	if c.Headers.HashKeyEnv != "" {
+		key := os.Getenv(c.Headers.HashKeyEnv)
+		if key == "" {
+			return fmt.Errorf("headers: %s is unset", c.Headers.HashKeyEnv)
+		}
+
+		m.HashRedactedHeaders([]byte(key))
+	}
+
+	for _, n := range c.Redact.QueryParams {
+		if _, err = path.Match(n, ""); err != nil {
+			return fmt.Errorf("redact: query param %q: %v", n, err)
+		}
+	}
+
+	m.RedactQueryParams(c.Redact.QueryParams...)
+
+	switch c.Redact.IPAddresses {
+	case "":
+	case "truncate":
+		m.AnonymizeIPs(IPTruncate, 0)
+	case "hash":
+		m.AnonymizeIPs(IPHash, time.Duration(c.Redact.IPKeyRotation))
+	default:
+		return fmt.Errorf("redact: unknown ip_addresses %q", c.Redact.IPAddresses)
+	}
+
+	m.SetRateLimit(RateLimit{Rate: c.RateLimit.Rate, Burst: c.RateLimit.Burst})
+
+	switch {
+	case c.Admin.TokenEnv != "":
+		token := os.Getenv(c.Admin.TokenEnv)
+		if token == "" {
+			return fmt.Errorf("admin: %s is unset", c.Admin.TokenEnv)
+		}
+
+		m.AdminToken = token
+	case c.Admin.Token != "":
+		m.AdminToken = c.Admin.Token
+	}
+
+	if c.Tail {
+		if m.AdminToken == "" {
+			return fmt.Errorf("tail: requires an admin token")
+		}
+
+		m.EnableTail()
+	}
+
+	if len(c.Retention) > 0 {
+		m.SetRetentionClasses(c.Retention)
+	}
+
+	if c.Bans.ErrorRate > 0 || c.Bans.MaxRateLimited > 0 {
+		m.EnableBans(BanPolicy{
+			KeyHeader:      c.Bans.KeyHeader,
+			ErrorRate:      c.Bans.ErrorRate,
+			MinRequests:    c.Bans.MinRequests,
+			MaxRateLimited: c.Bans.MaxRateLimited,
+			Window:         time.Duration(c.Bans.Window),
+			Cooldown:       time.Duration(c.Bans.Cooldown),
+		})
+	}
+
+	if c.Quotas.Hourly > 0 || c.Quotas.Daily > 0 {
+		if m.quotas, err = newQuotaSet(c.Quotas); err != nil {
+			return
+		}
+
+		m.addAdminEndpoint("quotas", m.serveQuotas)
+	}
+
+	if len(c.Lanes.Lanes) > 0 {
+		if m.lanes, err = newLaneLimiter(c.Lanes); err != nil {
+			return
+		}
+	}
+
+	if c.LogQueue != (LogQueueConfig{}) {
+		var p QueuePolicy
+		if p, err = ParseQueuePolicy(c.LogQueue.Policy); err != nil {
+			return
+		}
+
+		m.SetLogQueue(LogQueue{Size: c.LogQueue.Size, Workers: c.LogQueue.Workers, Policy: p})
+	}
+
+	if c.ObserveAPI != "" {
+		m.ObserveAPI(c.ObserveAPI)
+	}
+
+	m.AttributeResources(c.AttributeResources)
+
+	if c.ProfileSlow.Threshold > 0 {
+		switch kind := ProfileKind(c.ProfileSlow.Kind); kind {
+		case "", ProfileCPU, ProfileTrace:
+		default:
+			return fmt.Errorf("profile_slow: unknown kind %q", kind)
+		}
+
+		m.ProfileSlowRequests(ProfilePolicy{
+			Threshold:   time.Duration(c.ProfileSlow.Threshold),
+			Kind:        ProfileKind(c.ProfileSlow.Kind),
+			Duration:    time.Duration(c.ProfileSlow.Duration),
+			Cooldown:    time.Duration(c.ProfileSlow.Cooldown),
+			MaxProfiles: c.ProfileSlow.MaxProfiles,
+		})
+	}
+
+	for _, sc := range c.Static {
+		if !strings.HasPrefix(sc.Prefix, "/") || sc.Dir == "" {
+			return fmt.Errorf("static: prefix must begin with /, and dir is required")
+		}
+
+		m.Static(sc.Prefix, os.DirFS(sc.Dir))
+	}
+
+	if c.Favicon != "" {
+		var icon []byte
+		if icon, err = wellKnownConfigFile(c.Favicon); err != nil {
+			return
+		}
+
+		m.Favicon(icon)
+	}
+
+	if c.RobotsTxt != "" {
+		var robots []byte
+		if robots, err = wellKnownConfigFile(c.RobotsTxt); err != nil {
+			return
+		}
+
+		m.RobotsTxt(string(robots))
+	}
+
+	if c.WarmUp.Duration > 0 {
+		if c.WarmUp.MaxConcurrency <= 0 || c.WarmUp.InitialConcurrency > c.WarmUp.MaxConcurrency {
+			return fmt.Errorf("warm_up: max_concurrency is required, and must be at least initial_concurrency")
+		}
+
+		m.WarmUp(WarmUp{
+			Duration:           time.Duration(c.WarmUp.Duration),
+			InitialConcurrency: c.WarmUp.InitialConcurrency,
+			MaxConcurrency:     c.WarmUp.MaxConcurrency,
+			ReadyAt:            c.WarmUp.ReadyAt,
+		})
+	}
+
+	if c.RequestIDs.Honour {
+		var p RequestIDPolicy
+		if p, err = c.RequestIDs.policy(); err != nil {
+			return
+		}
+
+		m.HonourRequestIDs(p)
+	}
+
+	return
+}
+
+// logger builds the logger lc describes, adding files it opens, and loggers
+// needing to be stopped, to built
+func (lc LoggerConfig) logger(m *Middleware, built *closers) (l Loggable, err error) {
+	var w io.Writer
+
+	switch lc.Type {
+	case "", "stdout":
+		w = os.Stdout
+
+	case "stderr":
+		w = os.Stderr
+
+	case "file":
+		var fl *FileLogger
+		if fl, err = NewFileLogger(FileLoggerConfig{
+			Path:       lc.Path,
+			MaxSize:    int64(lc.MaxSizeMB) << 20,
+			MaxAge:     time.Duration(lc.MaxAge),
+			MaxBackups: lc.MaxBackups,
+			Compress:   lc.Compress,
+		}); err != nil {
+			return
+		}
+
+		w = fl
+		*built = append(*built, fl)
+
+	default:
+		return nil, fmt.Errorf("unknown logger type %q", lc.Type)
+	}
+
+	switch lc.Format {
+	case "", "json":
+		l = defaultLogger{output: log.New(w, "", 0)}
+		if len(lc.Rename) > 0 {
+			l = NewEncodingLogger(w, RenamedJSONEncoder(lc.Rename))
+		}
+
+	case "logfmt":
+		l = NewLogfmtLogger(w)
+
+	case "combined":
+		l = NewCombinedLogger(w)
+
+	case "pretty":
+		l = NewPrettyLogger(w)
+
+	case "summary":
+		sl := NewSummaryLogger(w, time.Duration(lc.SummaryInterval))
+		*built = append(*built, sl)
+		l = sl
+
+	case "csv":
+		if len(lc.Fields) == 0 {
+			return nil, fmt.Errorf("csv loggers require fields")
+		}
+
+		l = NewEncodingLogger(w, CSVEncoder(lc.Fields...))
+
+	default:
+		err = fmt.Errorf("unknown logger format %q", lc.Format)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if lc.SampleRate > 0 && lc.SampleRate < 1 {
+		l = NewSamplingLogger(l, lc.SampleRate)
+	}
+
+	var predicates []Predicate
+	if lc.MinStatus > 0 {
+		predicates = append(predicates, StatusAtLeast(lc.MinStatus))
+	}
+
+	if lc.MinLevel != "" {
+		var level Level
+		if level, err = ParseLevel(lc.MinLevel); err != nil {
+			return
+		}
+
+		predicates = append(predicates, LevelAtLeast(level))
+	}
+
+	if len(lc.Paths) > 0 {
+		// MatchingPaths panics on bad patterns, so check them first
+		var rm routeMatcher
+		for _, p := range lc.Paths {
+			if err = rm.add(p, nil); err != nil {
+				return
+			}
+		}
+
+		predicates = append(predicates, MatchingPaths(lc.Paths...))
+	}
+
+	if len(predicates) > 0 {
+		l = NewFilterLogger(l, predicates...)
+	}
+
+	if lc.SlowThreshold > 0 {
+		l = NewSlowLogger(l, time.Duration(lc.SlowThreshold)).Diagnose(m)
+	}
+
+	return
+}
+
+// closers are closed together, such as those built by a Config which
+// turned out to be invalid
+type closers []io.Closer
+
+func (cs closers) close() {
+	for _, c := range cs {
+		c.Close()
+	}
+}
+
+func (rc RequestIDConfig) policy() (p RequestIDPolicy, err error) {
+	if rc.MaxLength < 0 {
+		return p, fmt.Errorf("request_ids: max_length must not be negative")
+	}
+
+	p = RequestIDPolicy{MaxLength: rc.MaxLength, RecordClientID: rc.RecordClientID}
+
+	if rc.Pattern != "" {
+		if p.Pattern, err = regexp.Compile(rc.Pattern); err != nil {
+			err = fmt.Errorf("request_ids: %v", err)
+		}
+	}
+
+	return
+}
+
+func (rc RouteConfig) policy() (p RoutePolicy, err error) {
+	p = RoutePolicy{
+		SampleRate:     rc.SampleRate,
+		SlowThreshold:  time.Duration(rc.SlowThreshold),
+		Budget:         time.Duration(rc.Budget),
+		ApdexThreshold: time.Duration(rc.Apdex),
+		MaxBodyBytes:   rc.MaxBodyBytes,
+
+		CaptureMinStatus:    rc.CaptureMinStatus,
+		CaptureContentTypes: rc.CaptureContentTypes,
+		UnavailableStatus:   rc.UnavailableStatus,
+
+		Deprecation: Deprecation{Fields: rc.DeprecatedFields, Warning: rc.Warning},
+		EarlyHints:  rc.EarlyHints,
+		Stream:      rc.Stream,
+	}
+
+	if rc.Level != "" {
+		if p.Level, err = ParseLevel(rc.Level); err != nil {
+			return p, fmt.Errorf("route %q: %v", rc.Pattern, err)
+		}
+	}
+
+	var loc *time.Location
+	if rc.AvailabilityTZ != "" {
+		if loc, err = time.LoadLocation(rc.AvailabilityTZ); err != nil {
+			return p, fmt.Errorf("route %q: %v", rc.Pattern, err)
+		}
+	}
+
+	for _, s := range rc.Availability {
+		var w Window
+		if w, err = ParseWindow(s); err != nil {
+			return p, fmt.Errorf("route %q: %v", rc.Pattern, err)
+		}
+
+		w.Location = loc
+		p.Availability = append(p.Availability, w)
+	}
+
+	for _, name := range rc.Capture {
+		c, ok := captureNames[name]
+		if !ok {
+			return p, fmt.Errorf("route %q: unknown capture %q", rc.Pattern, name)
+		}
+
+		p.Capture |= c
+	}
+
+	return
+}
+
+// wellKnownConfigFile reads the file at p, unless p is `none`
+func wellKnownConfigFile(p string) ([]byte, error) {
+	if p == "none" {
+		return nil, nil
+	}
+
+	return ioutil.ReadFile(p)
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testYAMLConfig = `
+routes:
+  - pattern: /healthcheck
+    sample_rate: 0.01
+  - pattern: /payments/*
+    slow_threshold: 250ms
+    capture: [request_body, response_body]
+  - pattern: /v1/*
+    deprecated_fields: [nickname]
+    warning: v1 is deprecated
+skip_paths: [/favicon.ico]
+redact:
+  query_params: [token]
+rate_limit:
+  rate: 10
+  burst: 20
+admin:
+  token_env: TEST_MIDDLEWARE_ADMIN_TOKEN
+`
+
+const testJSONConfig = `{"loggers": [{"type": "stderr"}], "routes": [{"pattern": "/payments/*", "capture": ["everything"]}]}`
+
+func writeTestConfig(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "middleware")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	p := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(p, []byte(contents), 0600); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	return p
+}
+
+func TestFromConfig(t *testing.T) {
+	os.Setenv("TEST_MIDDLEWARE_ADMIN_TOKEN", "sekrit")
+	defer os.Unsetenv("TEST_MIDDLEWARE_ADMIN_TOKEN")
+
+	m, err := FromConfig(TestAPI{}, writeTestConfig(t, "config.yaml", testYAMLConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	t.Run("routes", func(t *testing.T) {
+		_, p := m.policy("/payments/123")
+
+		if p.SlowThreshold != 250*time.Millisecond {
+			t.Errorf("expected 250ms, received %s", p.SlowThreshold)
+		}
+
+		if !p.Capture.Has(CaptureRequestBody | CaptureResponseBody) {
+			t.Errorf("expected bodies to be captured")
+		}
+
+		if _, p = m.policy("/v1/users"); len(p.Deprecation.Fields) != 1 || p.Deprecation.Warning != "v1 is deprecated" {
+			t.Errorf("expected a deprecation, received %+v", p.Deprecation)
+		}
+	})
+
+	t.Run("skip paths", func(t *testing.T) {
+		if !m.skipped("/favicon.ico") {
+			t.Errorf("expected /favicon.ico to be skipped")
+		}
+	})
+
+	t.Run("redaction", func(t *testing.T) {
+		u := m.loggableRawURL("/?token=abc&page=2")
+		if u != "/?token=REDACTED&page=2" {
+			t.Errorf("expected token to be redacted, received %q", u)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		if m.limiter == nil || m.limiter.limit.Burst != 20 {
+			t.Errorf("expected rate limiting to be configured")
+		}
+	})
+
+	t.Run("admin token", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters", nil))
+
+		if rec.Code != 401 {
+			t.Errorf("expected 401, received %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/__/counters", nil)
+		r.Header.Set("Authorization", "Bearer sekrit")
+		m.ServeHTTP(rec, r)
+
+		if rec.Code != 200 {
+			t.Errorf("expected 200, received %d", rec.Code)
+		}
+	})
+}
+
+func TestFromConfig_errors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		path string
+	}{
+		{"missing file", "/nonsuch/config.json"},
+		{"bad capture", writeTestConfig(t, "config.json", testJSONConfig)},
+		{"bad yaml", writeTestConfig(t, "config.yml", "routes: {")},
+		{"tail without admin token", writeTestConfig(t, "config.yml", "tail: true")},
+		{"unknown json field", writeTestConfig(t, "config.json", `{"skip_path": ["/favicon.ico"]}`)},
+		{"unset admin token_env", writeTestConfig(t, "config.yml", "admin:\n  token_env: TEST_MIDDLEWARE_UNSET_TOKEN")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := FromConfig(TestAPI{}, test.path); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestConfig_KeepsAdminToken(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AdminToken = "sekrit"
+
+	if err := (Config{}).Apply(m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m.AdminToken != "sekrit" {
+		t.Errorf("expected the admin token to be kept, received %q", m.AdminToken)
+	}
+}
+
+func TestConfig_LogPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "middleware")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		Loggers: []LoggerConfig{{Type: "file", Path: filepath.Join(dir, "access.log")}},
+		LogPartitions: []LogPartitionConfig{
+			{Paths: []string{"/api/*"}, Loggers: []LoggerConfig{{Type: "file", Path: filepath.Join(dir, "api-access.log")}}},
+		},
+	}
+
+	m := NewMiddleware(TestAPI{})
+	if err = c.Apply(m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthcheck", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	for name, expect := range map[string]string{"api-access.log": "/api/users", "access.log": "/healthcheck"} {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if strings.Count(string(b), "\n") != 1 || !strings.Contains(string(b), expect) {
+			t.Errorf("expected %s to hold only %s, received %q", name, expect, b)
+		}
+	}
+
+	c.LogPartitions[0].Paths = []string{"/["}
+	if err = c.Apply(NewMiddleware(TestAPI{})); err == nil {
+		t.Errorf("expected an error for a bad partition pattern")
+	}
+}
+
+func TestConfig_ApplyCleansUpOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "middleware")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		Loggers:  []LoggerConfig{{Type: "file", Path: filepath.Join(dir, "access.log")}, {Format: "summary"}},
+		Graphite: GraphiteReporterConfig{Address: "127.0.0.1:2003"},
+		DryRun:   []string{"nonsuch"},
+	}
+
+	m := NewMiddleware(TestAPI{})
+	loggers := m.loggers
+
+	if err = c.Apply(m); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if len(m.loggers) != 1 || m.loggers[0] != loggers[0] {
+		t.Errorf("expected loggers to be restored, received %+v", m.loggers)
+	}
+
+	if n := m.reporters.count(); n != 0 {
+		t.Errorf("expected reporters to be stopped, received %d", n)
+	}
+}
+
+func TestConfig_CombinedLeavesReferers(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	if err := (Config{Loggers: []LoggerConfig{{Format: "combined"}}}).Apply(m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if m.logReferer {
+		t.Errorf("expected referers to be logged only when log_referer is set")
+	}
+}
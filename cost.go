@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMaxCostKeys is the number of routes, and of tenants, whose costs
+// are totalled apart; costs of any others are totalled under
+// OtherCounterKey
+const DefaultMaxCostKeys = 10000
+
+// AddCost reports that the request ctx belongs to incurred amount of kind
+// of cost, such as `db_queries`, `bytes_processed`, or `cents`. Costs of the
+// same kind are summed, logged under `cost`, and aggregated per route and
+// per tenant under `costs` in the counters endpoint, giving usage based
+// billing data without sampling. Tenants, which come from a header the
+// client sets, are totalled apart up to DefaultMaxCostKeys of them, as are
+// routes; the rest are totalled together under OtherCounterKey.
+//
+// AddCost is safe to call from goroutines spawned by a handler, but costs
+// reported after the handler has returned are ignored. ctx is either a
+// net/http request's context, or a *fasthttp.RequestCtx
+func AddCost(ctx context.Context, kind string, amount float64) {
+	st := stateFrom(ctx)
+	if st == nil {
+		return
+	}
+
+	st.costLock.Lock()
+	defer st.costLock.Unlock()
+
+	if st.costs == nil {
+		st.costs = make(map[string]float64)
+	}
+
+	st.costs[kind] += amount
+}
+
+// Costs returns the costs reported so far for the request ctx belongs to
+func Costs(ctx context.Context) map[string]float64 {
+	if st := stateFrom(ctx); st != nil {
+		return st.costSnapshot()
+	}
+
+	return nil
+}
+
+// costSnapshot returns a copy of the costs reported for a request, or nil
+// when there are none
+func (st *requestState) costSnapshot() map[string]float64 {
+	st.costLock.Lock()
+	defer st.costLock.Unlock()
+
+	if len(st.costs) == 0 {
+		return nil
+	}
+
+	out := make(map[string]float64, len(st.costs))
+	for k, v := range st.costs {
+		out[k] = v
+	}
+
+	return out
+}
+
+// costSummary is the `costs` section of the counters endpoint
+type costSummary struct {
+	Routes  map[string]map[string]float64 `json:"routes"`
+	Tenants map[string]map[string]float64 `json:"tenants,omitempty"`
+}
+
+// costLedger aggregates request costs per route and per tenant, for up to
+// maxKeys of each, or DefaultMaxCostKeys when zero
+type costLedger struct {
+	sync.Mutex
+
+	maxKeys int
+	routes  map[string]map[string]float64
+	tenants map[string]map[string]float64
+}
+
+func (cl *costLedger) add(route, tenant string, costs map[string]float64) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	if cl.routes == nil {
+		cl.routes = make(map[string]map[string]float64)
+		cl.tenants = make(map[string]map[string]float64)
+	}
+
+	max := cl.maxKeys
+	if max <= 0 {
+		max = DefaultMaxCostKeys
+	}
+
+	addCosts(cl.routes, route, costs, max)
+
+	if tenant != "" {
+		addCosts(cl.tenants, tenant, costs, max)
+	}
+}
+
+// addCosts adds costs to the totals of k, or of OtherCounterKey once max
+// keys have totals of their own
+func addCosts(totals map[string]map[string]float64, k string, costs map[string]float64, max int) {
+	if totals[k] == nil {
+		if len(totals) >= max {
+			k = OtherCounterKey
+		}
+
+		if totals[k] == nil {
+			totals[k] = make(map[string]float64)
+		}
+	}
+
+	for kind, amount := range costs {
+		totals[k][kind] += amount
+	}
+}
+
+// snapshot returns a copy of every total, or nil when no costs have been
+// reported
+func (cl *costLedger) snapshot() *costSummary {
+	cl.Lock()
+	defer cl.Unlock()
+
+	if len(cl.routes) == 0 {
+		return nil
+	}
+
+	return &costSummary{
+		Routes:  copyCosts(cl.routes),
+		Tenants: copyCosts(cl.tenants),
+	}
+}
+
+func copyCosts(totals map[string]map[string]float64) map[string]map[string]float64 {
+	if len(totals) == 0 {
+		return nil
+	}
+
+	out := make(map[string]map[string]float64, len(totals))
+	for k, costs := range totals {
+		out[k] = make(map[string]float64, len(costs))
+
+		for kind, amount := range costs {
+			out[k][kind] = amount
+		}
+	}
+
+	return out
+}
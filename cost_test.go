@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddCost(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddCost(r.Context(), "db_queries", 2)
+		AddCost(r.Context(), "db_queries", 1)
+		AddCost(r.Context(), "cents", 0.5)
+	}))
+	m.TenantHeader = "X-Tenant-ID"
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, tenant := range []string{"acme", "acme", ""} {
+		r := httptest.NewRequest("GET", "/search", nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"cost":{"cents":0.5,"db_queries":3}`) {
+		t.Errorf("expected costs to be logged, received %q", logWriter.body)
+	}
+
+	var counters countersPayload
+	if err := json.Unmarshal(m.sectionedCounters(), &counters); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if counters.Costs == nil {
+		t.Fatalf("expected costs in counters")
+	}
+
+	if got := counters.Costs.Routes["/search"]["db_queries"]; got != 9 {
+		t.Errorf("expected 9 db queries for /search, received %v", got)
+	}
+
+	if got := counters.Costs.Tenants["acme"]["cents"]; got != 1 {
+		t.Errorf("expected 1 cent for acme, received %v", got)
+	}
+
+	if len(counters.Costs.Tenants) != 1 {
+		t.Errorf("expected only tenanted requests to be totalled per tenant, received %+v", counters.Costs.Tenants)
+	}
+}
+
+func TestAddCost_OutsideMiddleware(t *testing.T) {
+	AddCost(context.Background(), "db_queries", 1)
+
+	if c := Costs(context.Background()); c != nil {
+		t.Errorf("expected no costs, received %+v", c)
+	}
+}
+
+func TestAddCost_bounded(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddCost(r.Context(), "cents", 1)
+	}))
+	m.SetLoggers()
+	m.TenantHeader = "X-Tenant-ID"
+	m.costs.maxKeys = 2
+
+	for _, tenant := range []string{"acme", "globex", "initech", "umbrella"} {
+		r := httptest.NewRequest("GET", "/users/"+tenant+"?q="+tenant, nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	m.queue.drain(ctx)
+
+	summary := m.costs.snapshot()
+	if summary == nil {
+		t.Fatalf("expected costs to be totalled")
+	}
+
+	expect := map[string]map[string]float64{
+		"acme":          {"cents": 1},
+		"globex":        {"cents": 1},
+		OtherCounterKey: {"cents": 2},
+	}
+
+	if !reflect.DeepEqual(summary.Tenants, expect) {
+		t.Errorf("expected %+v, received %+v", expect, summary.Tenants)
+	}
+
+	expect = map[string]map[string]float64{
+		"/users/acme":   {"cents": 1},
+		"/users/globex": {"cents": 1},
+		OtherCounterKey: {"cents": 2},
+	}
+
+	if !reflect.DeepEqual(summary.Routes, expect) {
+		t.Errorf("expected unrouted requests keyed without query strings, and capped, received %+v", summary.Routes)
+	}
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"container/list"
+	"expvar"
+	"strings"
+)
+
+// CounterTemplates sets templates, as per RouteTemplates, under which
+// requests without a route template are counted by the counters endpoint.
+// Unlike a RouteResolver, they don't change what is logged, so may be
+// used to group noisy paths, such as `/static/*`, for counting alone.
+//
+// Requests matching none are counted by their URL, normalized so that
+// the counters don't grow without bound under high cardinality traffic:
+// query strings and fragments are stripped, and numeric and UUID path
+// segments are collapsed into `:id` and `:uuid`, such that
+// `/users/42/orders?page=2` is counted as `/users/:id/orders`.
+//
+// CounterTemplates panics on a malformed template, as RouteTemplates does
+func (m *Middleware) CounterTemplates(templates ...string) {
+	rt, err := newRouteTemplates(templates)
+	if err != nil {
+		panic(err)
+	}
+
+	m.counterKeys = rt
+}
+
+// counterKey returns the key the counters endpoint counts l under: its
+// route template where known, or else its normalized URL
+func (m *Middleware) counterKey(l LogEntry) string {
+	if l.Route != "" {
+		return l.Route
+	}
+
+	return normalizeCounterKey(l.URL, m.counterKeys)
+}
+
+// normalizeCounterKey returns the template of rt which u's path matches,
+// if any, or else u without its query string or fragment, and with IDs
+// collapsed
+func normalizeCounterKey(u string, rt routeTemplates) string {
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		u = u[:i]
+	}
+
+	// Absolute URLs, as sent to proxies, keep their scheme and host
+	var origin string
+	if i := strings.Index(u, "://"); i >= 0 {
+		origin, u = u, ""
+		if j := strings.Index(origin[i+3:], "/"); j >= 0 {
+			origin, u = origin[:i+3+j], origin[i+3+j:]
+		}
+	}
+
+	if tmpl := rt.resolvePath(u); tmpl != "" {
+		return tmpl
+	}
+
+	segments := strings.Split(u, "/")
+	for i, s := range segments {
+		switch {
+		case isNumericSegment(s):
+			segments[i] = ":id"
+		case isUUIDSegment(s):
+			segments[i] = ":uuid"
+		}
+	}
+
+	return origin + strings.Join(segments, "/")
+}
+
+func isNumericSegment(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isUUIDSegment returns whether s is a UUID in its canonical, hyphenated,
+// form, in either case
+func isUUIDSegment(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+
+		default:
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// OtherCounterKey is the key under which requests to keys evicted by
+// LimitCounterKeys are counted
+const OtherCounterKey = "__other__"
+
+// LimitCounterKeys bounds the distinct keys counted by the counters
+// endpoint to max, protecting long running processes from URL scanning
+// bots which normalization alone can't contain. Once max keys are counted,
+// each new key evicts the least recently used one, whose counts are folded
+// into OtherCounterKey, and which is removed from the map published by
+// PublishCounters.
+//
+// LimitCounterKeys should be called before serving, and panics when max
+// is less than 1
+func (m *Middleware) LimitCounterKeys(max int) {
+	if max < 1 {
+		panic("middleware: LimitCounterKeys requires a max of at least 1")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.counterLRU = &counterLRU{
+		max:   max,
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+// counterLRU orders counter keys by last use, for eviction. It is guarded
+// by the package's lock, along with the counters themselves
+type counterLRU struct {
+	max   int
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+// touch marks k as just used, returning the key to evict to make room for
+// it, if any
+func (c *counterLRU) touch(k string) (evict string, ok bool) {
+	if c == nil || k == OtherCounterKey {
+		return
+	}
+
+	if e, found := c.keys[k]; found {
+		c.order.MoveToFront(e)
+
+		return
+	}
+
+	c.keys[k] = c.order.PushFront(k)
+	if c.order.Len() <= c.max {
+		return
+	}
+
+	e := c.order.Back()
+	c.order.Remove(e)
+
+	evict = e.Value.(string)
+	delete(c.keys, evict)
+
+	return evict, true
+}
+
+// newCounter returns a counter for a new key, k, published under the map
+// of PublishCounters, if any. The caller must hold lock
+func (m *Middleware) newCounter(k string) *expvar.Int {
+	c := new(expvar.Int)
+	if m.expvars != nil {
+		m.expvars.Set(k, c)
+	}
+
+	return c
+}
+
+// evictCounter folds k's counts into OtherCounterKey. The caller must
+// hold lock
+func (m *Middleware) evictCounter(k string) {
+	other, ok := m.Requests[OtherCounterKey]
+	if !ok {
+		other = m.newCounter(OtherCounterKey)
+		m.Requests[OtherCounterKey] = other
+	}
+
+	if c, ok := m.Requests[k]; ok {
+		other.Add(c.Value())
+		delete(m.Requests, k)
+	}
+
+	if m.expvars != nil {
+		m.expvars.Delete(k)
+	}
+
+	m.responseBytes.fold(k, OtherCounterKey)
+	m.requestBytes.fold(k, OtherCounterKey)
+}
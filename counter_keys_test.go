@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCounterKey(t *testing.T) {
+	rt, _ := newRouteTemplates([]string{"/static/*"})
+
+	for _, test := range []struct {
+		url    string
+		expect string
+	}{
+		{"/", "/"},
+		{"/users?page=2", "/users"},
+		{"/users/42/orders#top", "/users/:id/orders"},
+		{"/orders/3F2504E0-4F89-11D3-9A0C-0305E82C3301", "/orders/:uuid"},
+		{"/orders/3f2504e0-4f89-11d3-9a0c-0305e82c330", "/orders/3f2504e0-4f89-11d3-9a0c-0305e82c330"},
+		{"/v2/users", "/v2/users"},
+		{"/static/js/app.123.js?v=1", "/static/*"},
+		{"https://user@example.com/users/7?token=REDACTED", "https://user@example.com/users/:id"},
+		{"https://user@example.com", "https://user@example.com"},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			if k := normalizeCounterKey(test.url, rt); k != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, k)
+			}
+		})
+	}
+}
+
+func TestCounterTemplates(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.CounterTemplates("/static/*")
+
+	for _, p := range []string{"/static/app.js", "/static/app.css", "/users/1?page=2", "/users/2"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+	if len(c.Requests) != 2 || c.Requests["/static/*"] != 2 || c.Requests["/users/:id"] != 2 {
+		t.Errorf("expected requests to be counted by template and normalized URL, received %+v", c.Requests)
+	}
+
+	if c.ResponseBytes["/users/:id"] != int64(2*len(TestResponseBody)) {
+		t.Errorf("expected bytes to share keys with requests, received %+v", c.ResponseBytes)
+	}
+}
+
+func TestLimitCounterKeys(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.LimitCounterKeys(2)
+
+	for _, p := range []string{"/a", "/b", "/a", "/c", "/d"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+
+		// Requests are counted asynchronously, so pace them to keep
+		// their order
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+
+	// /b is evicted by /c, being least recently used, and then /a by /d
+	expect := map[string]int64{"/c": 1, "/d": 1, OtherCounterKey: 3}
+	if !reflect.DeepEqual(c.Requests, expect) {
+		t.Errorf("expected %+v, received %+v", expect, c.Requests)
+	}
+
+	if c.ResponseBytes[OtherCounterKey] != int64(3*len(TestResponseBody)) || len(c.ResponseBytes) != 3 {
+		t.Errorf("expected evicted bytes to be folded into %s, received %+v", OtherCounterKey, c.ResponseBytes)
+	}
+}
+
+func TestLimitCounterKeys_concurrent(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.LimitCounterKeys(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/p%d-%d", i, j), nil))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := m.queue.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c := getCounters(t, m)
+
+	for k := range c.ResponseBytes {
+		if _, ok := c.Requests[k]; !ok {
+			t.Errorf("expected bytes only for counted keys, received bytes for evicted %q", k)
+		}
+	}
+
+	var total int64
+	for _, n := range c.ResponseBytes {
+		total += n
+	}
+
+	if total != int64(1600*len(TestResponseBody)) {
+		t.Errorf("expected every byte to be counted, received %d", total)
+	}
+}
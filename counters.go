@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// countersPayload is the response body of the counters endpoint when its
+// sections are asked for, with `?sections`; otherwise, only Requests is
+// served, as a flat map of hits. Sections other than Requests are only
+// present when the features which populate them are in use
+type countersPayload struct {
+	// Requests holds hits per URL, or per route template where known; see
+	// RouteResolver
+	Requests map[string]int64 `json:"requests"`
+
+	// ResponseBytes holds the total size of response bodies per URL, or
+	// route template, for
+	// capacity planning and egress billing
+	ResponseBytes map[string]int64 `json:"response_bytes,omitempty"`
+
+	// RequestBytes, likewise, holds the total size of request bodies read
+	// per URL, or route template, for ingress bandwidth
+	RequestBytes map[string]int64 `json:"request_bytes,omitempty"`
+
+	// Statuses holds, per route as per Latency, the number of requests
+	// which received each status code, such as `503`, from which error
+	// rates can be derived
+	Statuses map[string]map[string]int64 `json:"statuses,omitempty"`
+
+	// Methods holds, per route as per Latency, the number of requests
+	// made with each method. Methods other than the standard ones are
+	// counted as `other`, as clients may send whatever they like
+	Methods map[string]map[string]int64 `json:"methods,omitempty"`
+
+	// Latency holds, per route, a histogram of request durations; see
+	// TrackLatency
+	Latency map[string]LatencyHistogram `json:"latency,omitempty"`
+
+	// Percentiles holds, per route, estimated request durations; see
+	// TrackPercentiles
+	Percentiles map[string]LatencyPercentiles `json:"percentiles,omitempty"`
+
+	// BudgetExceeded holds, per route pattern, the number of requests
+	// which took longer than their route's latency budget
+	BudgetExceeded map[string]int64 `json:"budget_exceeded,omitempty"`
+
+	// Apdex holds, per route as per Latency, requests by Apdex zone and
+	// the resulting score, for routes with an ApdexThreshold
+	Apdex map[string]ApdexScore `json:"apdex,omitempty"`
+
+	// Blocked holds, per BlockRule pattern, the number of requests refused
+	Blocked map[string]int64 `json:"blocked,omitempty"`
+
+	// Honeypots holds, per honeypot pattern, the number of requests trapped
+	Honeypots map[string]int64 `json:"honeypots,omitempty"`
+
+	// Failures holds, per route, the number of requests which failed, by
+	// kind of failure, such as FailurePanic
+	Failures map[string]map[string]int64 `json:"failures,omitempty"`
+
+	// Shed holds, per priority lane, the number of requests shed
+	Shed map[string]int64 `json:"shed,omitempty"`
+
+	// Shadow holds the number of shadow responses compared, and of those
+	// which mismatched, along with requests skipped as too large to mirror
+	Shadow map[string]int64 `json:"shadow,omitempty"`
+
+	// Costs holds the totals of costs reported via AddCost, per route
+	// and per tenant
+	Costs *costSummary `json:"costs,omitempty"`
+
+	// InFlight holds the number of requests being handled right now, as
+	// per InFlightStats. Requests starting and finishing don't change the
+	// counters' ETag, so this may be as of the last request to finish
+	InFlight *InFlightStats `json:"in_flight,omitempty"`
+
+	// LogQueue holds the depth of the log queue, and entries dropped from it.
+	// The queue draining doesn't change the counters' ETag, so its depth
+	// may be that of the last request
+	LogQueue *LogQueueStats `json:"log_queue,omitempty"`
+
+	// Runtime holds the process's health, as per IncludeRuntimeStats
+	Runtime *RuntimeStats `json:"runtime,omitempty"`
+
+	// Spools holds, per spool file, the state of each SpoolLogger
+	Spools map[string]SpoolStats `json:"spools,omitempty"`
+
+	// Synthetic holds the number of synthetic requests, which are left out
+	// of every other section; see MarkSynthetic
+	Synthetic int64 `json:"synthetic,omitempty"`
+
+	// UUIDFailures holds the number of IDs, across the process, minted
+	// from a fallback as UUIDs couldn't be generated
+	UUIDFailures int64 `json:"uuid_failures,omitempty"`
+}
+
+// counters returns the counters endpoint's default response body: hits
+// per URL, or route template, as a flat map
+func (m *Middleware) counters() (resp []byte) {
+	resp, _ = json.Marshal(m.requestCounts())
+
+	return
+}
+
+// sectionedCounters returns every section of the counters endpoint
+func (m *Middleware) sectionedCounters() (resp []byte) {
+	resp, _ = json.Marshal(m.countersPayload())
+
+	return
+}
+
+// requestCounts snapshots the Requests counters
+func (m *Middleware) requestCounts() map[string]int64 {
+	rData := make(map[string]int64)
+
+	lock.RLock()
+	for k, v := range m.Requests {
+		rData[k] = v.Value()
+	}
+	lock.RUnlock()
+
+	return rData
+}
+
+// countersPayload snapshots every counter
+func (m *Middleware) countersPayload() countersPayload {
+	return countersPayload{
+		Requests:       m.requestCounts(),
+		ResponseBytes:  m.responseBytes.snapshot(),
+		RequestBytes:   m.requestBytes.snapshot(),
+		Statuses:       m.statuses.snapshot(),
+		Methods:        m.methods.snapshot(),
+		Latency:        m.latency.snapshot(),
+		Percentiles:    m.percentiles.snapshot(),
+		BudgetExceeded: m.budgetExceeded.snapshot(),
+		Apdex:          apdexScores(m.apdex.snapshot()),
+		Blocked:        m.blocklist.hits.snapshot(),
+		Honeypots:      m.honeypotHits.snapshot(),
+		Failures:       m.failures.snapshot(),
+		Shed:           m.shedCounts(),
+		Shadow:         m.shadowCounts(),
+		Costs:          m.costs.snapshot(),
+		InFlight:       m.inFlightStats(),
+		LogQueue:       m.queueStats(),
+		Runtime:        m.readRuntimeStats(),
+		Spools:         m.spools(),
+		Synthetic:      atomic.LoadInt64(&m.synthetics),
+		UUIDFailures:   atomic.LoadInt64(&uuidFailures),
+	}
+}
+
+func (m *Middleware) inFlightStats() *InFlightStats {
+	stats := m.InFlightStats()
+
+	return &stats
+}
+
+// ResetCounters zeroes the counters served by the counters endpoint, and
+// those exported by ExportMetrics, such as after a deploy or between load
+// tests, without restarting the process. It is also available to POST
+// requests to the `counters/reset` admin endpoint.
+//
+// Requests counters are zeroed rather than removed, as they may be
+// published to expvar. Gauges, such as requests in flight, aren't reset,
+// nor are costs, which are billing data, nor the log queue's and spools'
+// stats
+func (m *Middleware) ResetCounters() {
+	lock.Lock()
+	for _, v := range m.Requests {
+		v.Set(0)
+	}
+	lock.Unlock()
+
+	for _, cs := range []*counterSet{&m.responseBytes, &m.requestBytes, &m.budgetExceeded, &m.blocklist.hits, &m.honeypotHits} {
+		cs.reset()
+	}
+
+	for _, rc := range []*routeCounters{&m.statuses, &m.methods, &m.apdex, &m.failures, &m.rejected, &m.wouldReject} {
+		rc.reset()
+	}
+
+	if m.lanes != nil {
+		m.lanes.shed.reset()
+	}
+
+	if m.warmUp != nil {
+		atomic.StoreInt64(&m.warmUp.shed, 0)
+	}
+
+	if m.shadow != nil {
+		m.shadow.counts.reset()
+	}
+
+	m.latency.reset()
+	m.percentiles.reset()
+
+	if m.metrics != nil {
+		m.metrics.Reset()
+	}
+
+	atomic.StoreInt64(&m.synthetics, 0)
+
+	m.changed()
+}
+
+// serveCountersReset resets counters, as per ResetCounters, on POST
+func (m *Middleware) serveCountersReset(r adminRequest) adminResponse {
+	if r.method != http.MethodPost {
+		return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.method))
+	}
+
+	m.ResetCounters()
+	m.audit(AuditEvent{Action: "reset_counters", Reason: "reset via admin endpoint"})
+
+	return jsonResponse(http.StatusOK, []byte(`{"status":"reset"}`))
+}
+
+func (m *Middleware) queueStats() *LogQueueStats {
+	stats := m.queue.stats()
+
+	return &stats
+}
+
+// spools collects stats from every spooling logger
+func (m *Middleware) spools() (out map[string]SpoolStats) {
+	for _, l := range m.loggers {
+		s, ok := l.(interface {
+			spoolStats() (string, SpoolStats)
+		})
+		if !ok {
+			continue
+		}
+
+		if out == nil {
+			out = make(map[string]SpoolStats)
+		}
+
+		path, stats := s.spoolStats()
+		out[path] = stats
+	}
+
+	return
+}
+
+// counterSet is a set of named counters, such as hits per route, safe for
+// concurrent use. Its zero value is ready to use
+type counterSet struct {
+	sync.RWMutex
+
+	counts map[string]int64
+}
+
+func (cs *counterSet) add(k string, n int64) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.counts == nil {
+		cs.counts = make(map[string]int64)
+	}
+
+	cs.counts[k] += n
+}
+
+// fold adds from's count to into's, and forgets from
+func (cs *counterSet) fold(from, into string) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if n, ok := cs.counts[from]; ok {
+		cs.counts[into] += n
+		delete(cs.counts, from)
+	}
+}
+
+func (cs *counterSet) reset() {
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.counts = nil
+}
+
+// snapshot returns a copy of every counter, or nil when there are none
+func (cs *counterSet) snapshot() map[string]int64 {
+	cs.RLock()
+	defer cs.RUnlock()
+
+	if len(cs.counts) == 0 {
+		return nil
+	}
+
+	out := make(map[string]int64, len(cs.counts))
+	for k, v := range cs.counts {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestSlowAPI struct{}
+
+func (ta TestSlowAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(10 * time.Millisecond)
+	fmt.Fprint(w, TestResponseBody)
+}
+
+func getCounters(t *testing.T, m *Middleware) (c countersPayload) {
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	return
+}
+
+func TestCounters_flat(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.TrackLatency()
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters", nil))
+
+	var c map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("expected a flat map of hits, received %s", rec.Body)
+	}
+
+	if len(c) != 1 || c["/users"] != 1 {
+		t.Errorf("expected hits to /users alone, received %+v", c)
+	}
+
+	sections := httptest.NewRecorder()
+	m.ServeHTTP(sections, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	if rec.Header().Get("ETag") == sections.Header().Get("ETag") {
+		t.Errorf("expected representations to have distinct ETags, both were %q", rec.Header().Get("ETag"))
+	}
+}
+
+func TestCounters_budgetExceeded(t *testing.T) {
+	m := NewMiddleware(TestSlowAPI{})
+	m.AddRoutePolicy("/slow/*", RoutePolicy{Budget: time.Millisecond})
+	m.AddRoutePolicy("/fast/*", RoutePolicy{Budget: time.Minute})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast/1", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+
+	if c.BudgetExceeded["/slow/*"] != 1 {
+		t.Errorf("expected one slow request, received %+v", c.BudgetExceeded)
+	}
+
+	if _, ok := c.BudgetExceeded["/fast/*"]; ok {
+		t.Errorf("unexpected budget breach %+v", c.BudgetExceeded)
+	}
+
+	if c.Requests["/slow/:id"] != 1 {
+		t.Errorf("expected request counts, received %+v", c.Requests)
+	}
+}
+
+func TestCounters_responseBytes(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for i := 0; i < 2; i++ {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := getCounters(t, m).ResponseBytes["/users"]; n != int64(2*len(TestResponseBody)) {
+		t.Errorf("expected %d bytes, received %d", 2*len(TestResponseBody), n)
+	}
+}
+
+func TestCounters_requestBytes(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+
+	for _, p := range []string{"/users/1", "/users/2"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", p, strings.NewReader(`{"name":"bob"}`)))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := getCounters(t, m).RequestBytes["/users/:id"]; n != 28 {
+		t.Errorf("expected 28 bytes, received %d", n)
+	}
+}
+
+func TestCounters_etag(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/__/counters", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag, received %d %v", first.Code, first.Header())
+	}
+
+	// Scrapes are logged too, but mustn't invalidate each other
+	time.Sleep(100 * time.Millisecond)
+
+	if rec := get(etag); rec.Code != http.StatusNotModified || rec.Body.Len() > 0 {
+		t.Errorf("expected 304 Not Modified, received %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := get(`W/"other", ` + etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected a match from a list of ETags, received %d", rec.Code)
+	}
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	rec := get(etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected fresh counters after a request, received %d %v", rec.Code, rec.Header())
+	}
+
+	etag = rec.Header().Get("ETag")
+	m.Block("/wp-login.php", http.StatusGone)
+
+	if rec := get(etag); rec.Code != http.StatusOK {
+		t.Errorf("expected blocking to change the ETag, received %d", rec.Code)
+	}
+}
+
+func TestCounters_statuses(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.AddRoutePolicy("/other/*", RoutePolicy{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, p := range []string{"/users/1", "/users/2", "/users/missing", "/other/1"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+
+	if c.Statuses["/users/:id"]["200"] != 2 || c.Statuses["/users/:id"]["404"] != 1 || c.Statuses["/other/*"]["200"] != 1 {
+		t.Errorf("expected statuses per route, received %+v", c.Statuses)
+	}
+
+	if _, ok := c.Statuses[defaultRouteKey]; ok {
+		t.Errorf("expected admin requests not to be counted, received %+v", c.Statuses)
+	}
+}
+
+func TestCounters_methods(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/users/*", RoutePolicy{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, method := range []string{"GET", "GET", "POST", "BREW"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/users/1", nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := getCounters(t, m)
+
+	expect := map[string]int64{"GET": 2, "POST": 1, "other": 1}
+	if !reflect.DeepEqual(expect, c.Methods["/users/*"]) {
+		t.Errorf("expected %v, received %+v", expect, c.Methods)
+	}
+}
+
+func TestResetCounters(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	m.SetLoggers()
+	m.AdminToken = "sekrit"
+	m.TrackLatency()
+
+	e := &testMetricsExporter{}
+	m.ExportMetrics(e)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	reset := func(method, token string) int {
+		r := httptest.NewRequest(method, "/__/counters/reset", nil)
+		r.Header.Set("X-Admin-Token", token)
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		return rec.Code
+	}
+
+	for _, test := range []struct {
+		method string
+		token  string
+		expect int
+	}{
+		{"POST", "", http.StatusUnauthorized},
+		{"GET", "sekrit", http.StatusMethodNotAllowed},
+		{"POST", "sekrit", http.StatusOK},
+	} {
+		if code := reset(test.method, test.token); code != test.expect {
+			t.Errorf("%s with %q: expected %d, received %d", test.method, test.token, test.expect, code)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/__/counters?sections", nil)
+	r.Header.Set("X-Admin-Token", "sekrit")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	var c countersPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if c.Requests["/users"] != 0 || c.Statuses != nil || c.Methods != nil || c.Latency["/users"].Count != 0 {
+		t.Errorf("expected counters to be reset, received %s", rec.Body)
+	}
+
+	if e.observed() != 0 {
+		t.Errorf("expected exported requests to be reset")
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// DeadlineHeader carries the time a caller will wait for a response, in
+	// the same form as gRPC's grpc-timeout header, such as `250m` for 250
+	// milliseconds
+	DeadlineHeader = "X-Deadline"
+
+	// grpcTimeoutHeader is read when DeadlineHeader isn't set
+	grpcTimeoutHeader = "Grpc-Timeout"
+)
+
+// grpcTimeoutUnits maps grpc-timeout unit suffixes to durations
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// PropagateDeadlines reads the time callers will wait for a response from
+// DeadlineHeader, or grpc-timeout, and takes overhead from it to allow for
+// time spent outside of the wrapped handler, such as in the network. The
+// remaining budget is then:
+//   - available to handlers from RemainingBudget;
+//   - set as the deadline of net/http request contexts; and
+//   - passed on by Transport in DeadlineHeader, so that budgets shrink
+//     across each hop rather than every service waiting the full timeout
+//
+// Requests without either header have no deadline.
+func (m *Middleware) PropagateDeadlines(overhead time.Duration) {
+	m.deadlines = &deadlinePolicy{overhead: overhead}
+}
+
+type deadlinePolicy struct {
+	overhead time.Duration
+}
+
+// deadline returns the deadline of a request arriving at now, or the zero
+// time when deadlines aren't propagated or the caller set none
+func (m *Middleware) deadline(header func(string) string, now time.Time) time.Time {
+	if m.deadlines == nil {
+		return time.Time{}
+	}
+
+	v := header(DeadlineHeader)
+	if v == "" {
+		v = header(grpcTimeoutHeader)
+	}
+
+	budget, ok := parseTimeout(v)
+	if !ok {
+		return time.Time{}
+	}
+
+	budget -= m.deadlines.overhead
+	if budget < 0 {
+		budget = 0
+	}
+
+	return now.Add(budget)
+}
+
+// RemainingBudget returns the time left before the caller of the request
+// ctx belongs to stops waiting, as per PropagateDeadlines. ok is false when
+// the request has no deadline. ctx is either a net/http request's context,
+// or a *fasthttp.RequestCtx
+func RemainingBudget(ctx context.Context) (remaining time.Duration, ok bool) {
+	st := stateFrom(ctx)
+	if st == nil || st.deadline.IsZero() {
+		return
+	}
+
+	if remaining = time.Until(st.deadline); remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, true
+}
+
+// parseTimeout parses a timeout in the form of grpc-timeout: up to eight
+// digits followed by a unit
+func parseTimeout(s string) (d time.Duration, ok bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return
+	}
+
+	unit, ok := grpcTimeoutUnits[s[len(s)-1]]
+	if !ok {
+		return
+	}
+
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	// Eight digits of hours don't fit in a Duration
+	if n > uint64(math.MaxInt64/unit) {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// maxTimeoutValue is the largest value grpc-timeout allows, of eight digits
+const maxTimeoutValue = 99999999
+
+// formatTimeoutUnits are the units formatTimeout steps up through, from
+// the most precise, until a budget fits in eight digits
+var formatTimeoutUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"m", time.Millisecond},
+	{"S", time.Second},
+	{"M", time.Minute},
+	{"H", time.Hour},
+}
+
+// formatTimeout formats d for DeadlineHeader, in whole milliseconds, or in
+// the smallest unit of seconds, minutes, or hours which keeps it within
+// eight digits. Rounding down, the budget passed on never grows
+func formatTimeout(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	for _, u := range formatTimeoutUnits {
+		if n := d / u.unit; n <= maxTimeoutValue {
+			return strconv.FormatInt(int64(n), 10) + u.suffix
+		}
+	}
+
+	return strconv.Itoa(maxTimeoutValue) + "H"
+}
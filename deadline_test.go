@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseTimeout(t *testing.T) {
+	for _, test := range []struct {
+		input  string
+		expect time.Duration
+		ok     bool
+	}{
+		{"250m", 250 * time.Millisecond, true},
+		{"2S", 2 * time.Second, true},
+		{"1H", time.Hour, true},
+		{"100u", 100 * time.Microsecond, true},
+		{"", 0, false},
+		{"m", 0, false},
+		{"250ms", 0, false},
+		{"-1S", 0, false},
+		{"123456789S", 0, false},
+		{"99999999S", 99999999 * time.Second, true},
+		{"99999999H", math.MaxInt64, true},
+		{"2562047H", 2562047 * time.Hour, true},
+		{"2562048H", math.MaxInt64, true},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			d, ok := parseTimeout(test.input)
+			if d != test.expect || ok != test.ok {
+				t.Errorf("expected %v %v, received %v %v", test.expect, test.ok, d, ok)
+			}
+		})
+	}
+}
+
+func TestFormatTimeout(t *testing.T) {
+	for _, test := range []struct {
+		input  time.Duration
+		expect string
+	}{
+		{-time.Second, "0m"},
+		{250 * time.Millisecond, "250m"},
+		{99999999 * time.Millisecond, "99999999m"},
+		{100000000 * time.Millisecond, "100000S"},
+		{99999999 * time.Second, "99999999S"},
+		{100000000 * time.Second, "1666666M"},
+		{99999999 * time.Minute, "99999999M"},
+		{100000000 * time.Minute, "1666666H"},
+		{math.MaxInt64, "2562047H"},
+	} {
+		t.Run(test.input.String(), func(t *testing.T) {
+			s := formatTimeout(test.input)
+			if s != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, s)
+			}
+
+			if _, ok := parseTimeout(s); !ok {
+				t.Errorf("expected %q to parse", s)
+			}
+		})
+	}
+}
+
+func TestPropagateDeadlines(t *testing.T) {
+	var (
+		received  http.Header
+		remaining time.Duration
+		deadline  time.Time
+		ok        bool
+	)
+
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer downstream.Close()
+
+	var m *Middleware
+
+	m = NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining, ok = RemainingBudget(r.Context())
+		deadline, _ = r.Context().Deadline()
+
+		req, _ := http.NewRequest("GET", downstream.URL, nil)
+		resp, err := m.NewTransport(nil).RoundTrip(req.WithContext(r.Context()))
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		resp.Body.Close()
+	}))
+	m.PropagateDeadlines(100 * time.Millisecond)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(DeadlineHeader, "1S")
+
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !ok || remaining > 900*time.Millisecond || remaining < 800*time.Millisecond {
+		t.Errorf("expected around 900ms remaining, received %v %v", remaining, ok)
+	}
+
+	if deadline.IsZero() {
+		t.Errorf("expected the request context to have a deadline")
+	}
+
+	v := received.Get(DeadlineHeader)
+	ms, err := strconv.Atoi(strings.TrimSuffix(v, "m"))
+	if err != nil || ms > 900 || ms < 800 {
+		t.Errorf("expected around 900m to be propagated, received %q", v)
+	}
+}
+
+func TestPropagateDeadlines_Fasthttp(t *testing.T) {
+	var (
+		remaining time.Duration
+		ok        bool
+	)
+
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {
+		remaining, ok = RemainingBudget(ctx)
+	}))
+	m.PropagateDeadlines(time.Second)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/")
+	c.Request.Header.Set("Grpc-Timeout", "500m")
+
+	m.ServeFastHTTP(c)
+
+	if !ok || remaining != 0 {
+		t.Errorf("expected an exhausted budget, received %v %v", remaining, ok)
+	}
+}
+
+func TestRemainingBudget_NoDeadline(t *testing.T) {
+	var ok bool
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok = RemainingBudget(r.Context())
+	}))
+	m.PropagateDeadlines(0)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if ok {
+		t.Errorf("expected no deadline")
+	}
+}
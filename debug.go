@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DebugHeader is the request header which, when carrying a valid
+	// debug token, forces verbose logging for that single request
+	DebugHeader = "X-Debug-Request"
+)
+
+// DebugConfig controls which requests may force verbose logging via
+// DebugHeader. Debug requests are always logged, regardless of sampling,
+// and have their headers and bodies captured.
+//
+// A request is treated as a debug request when its DebugHeader either:
+//   - exactly matches one of Tokens; or
+//   - is a token signed with Key, as produced by SignDebugToken, which
+//     has not yet expired
+type DebugConfig struct {
+	Key    []byte
+	Tokens []string
+}
+
+// EnableDebugRequests allows callers presenting a valid DebugHeader to turn
+// on full logging for their request. This allows production issues to be
+// debugged on demand without raising global verbosity.
+func (m *Middleware) EnableDebugRequests(dc DebugConfig) {
+	m.debug = &dc
+}
+
+// SignDebugToken returns a debug token, valid until expiry, for use in
+// DebugHeader with a Middleware configured with the same key
+func SignDebugToken(key []byte, expiry time.Time) string {
+	exp := strconv.FormatInt(expiry.Unix(), 10)
+
+	return exp + ":" + hex.EncodeToString(debugMAC(key, exp))
+}
+
+func debugMAC(key []byte, exp string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(exp))
+
+	return mac.Sum(nil)
+}
+
+// debugRequest returns true when token permits verbose logging
+func (m *Middleware) debugRequest(token string, now time.Time) bool {
+	if m.debug == nil || token == "" {
+		return false
+	}
+
+	for _, t := range m.debug.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	if len(m.debug.Key) == 0 {
+		return false
+	}
+
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+
+	sig, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(sig, debugMAC(m.debug.Key, parts[0]))
+}
+
+// debugPolicy turns p into one which logs everything about a request
+func debugPolicy(p RoutePolicy) RoutePolicy {
+	p.SampleRate = 1
+	p.Capture = CaptureAll
+	p.CaptureMinStatus = 0
+	p.CaptureContentTypes = nil
+
+	return p
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugRequest(t *testing.T) {
+	key := []byte("debug-key")
+	now := time.Now()
+
+	m := NewMiddleware(TestAPI{})
+	m.EnableDebugRequests(DebugConfig{Key: key, Tokens: []string{"letmein"}})
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		expect bool
+	}{
+		{"no token", "", false},
+		{"allowlisted token", "letmein", true},
+		{"signed token", SignDebugToken(key, now.Add(time.Minute)), true},
+		{"expired token", SignDebugToken(key, now.Add(-time.Minute)), false},
+		{"wrong key", SignDebugToken([]byte("nope"), now.Add(time.Minute)), false},
+		{"garbage", "12345:zzzz", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if m.debugRequest(test.token, now) != test.expect {
+				t.Errorf("expected %v", test.expect)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if NewMiddleware(TestAPI{}).debugRequest("letmein", now) {
+			t.Errorf("debug requests should be off by default")
+		}
+	})
+}
+
+func TestServeHTTP_debugRequest(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/", RoutePolicy{SampleRate: 0.0000001})
+	m.EnableDebugRequests(DebugConfig{Tokens: []string{"letmein"}})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(DebugHeader, "letmein")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("expected debug request to bypass sampling: %+v", err)
+	}
+
+	if !l.Debug {
+		t.Errorf("expected entry to be flagged as debug")
+	}
+
+	if l.RequestHeaders[DebugHeader] != RedactedValue {
+		t.Errorf("expected request headers to be captured, with the debug token redacted, received %+v", l.RequestHeaders)
+	}
+
+	if l.ResponseHeaders["X-Request-Id"] == "" {
+		t.Errorf("expected response headers to be captured, received %+v", l.ResponseHeaders)
+	}
+
+	if l.ResponseBody != TestResponseBody {
+		t.Errorf("expected %q, received %q", TestResponseBody, l.ResponseBody)
+	}
+}
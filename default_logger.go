@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"encoding/json"
+	"io"
 	"log"
 	"os"
 )
@@ -11,7 +11,12 @@ import (
 //
 // This is the simplest log there is.
 type defaultLogger struct {
-	output *log.Logger
+	output  *log.Logger
+	strict  bool
+	encoder Encoder
+
+	// errors, when set, receives error level entries instead of output
+	errors *log.Logger
 }
 
 func newDefaultLogger() defaultLogger {
@@ -21,13 +26,56 @@ func newDefaultLogger() defaultLogger {
 }
 
 // Log will spit out a LogEntry marshaled to json
-// to STDOUT
+// to STDOUT, or as per SetEncoder
 func (dl defaultLogger) Log(l LogEntry) {
-	lOut, err := json.Marshal(l)
+	lOut, err := dl.encode(l)
+
+	out := dl.output
+	if dl.errors != nil && isErrorEntry(l) {
+		out = dl.errors
+	}
 
 	if err == nil {
-		dl.output.Print(string(lOut))
+		out.Print(string(lOut))
 	} else {
-		dl.output.Printf("error marshaling log data: %q", err)
+		out.Printf("error marshaling log data: %q", err)
+	}
+}
+
+// isErrorEntry returns true for entries describing a server side failure:
+// 5xx responses, and requests which failed without a response at all
+func isErrorEntry(l LogEntry) bool {
+	return l.Status >= 500 || l.Error != ""
+}
+
+// SetLogOutput points the default logger at ws, which allows entries to be
+// written to both STDOUT and a file, say
+func (m *Middleware) SetLogOutput(ws ...io.Writer) {
+	m.defaultLoggers(func(dl *defaultLogger) {
+		dl.output = log.New(io.MultiWriter(ws...), "", 0)
+	})
+}
+
+// SetErrorLogOutput routes error level entries (5xx responses and failed
+// requests) from the default logger to ws, leaving everything else on its
+// usual output. Passing os.Stderr follows the container convention of
+// access logs on STDOUT and errors on STDERR. Calling it with no writers
+// stops the split.
+func (m *Middleware) SetErrorLogOutput(ws ...io.Writer) {
+	m.defaultLoggers(func(dl *defaultLogger) {
+		dl.errors = nil
+		if len(ws) > 0 {
+			dl.errors = log.New(io.MultiWriter(ws...), "", 0)
+		}
+	})
+}
+
+// defaultLoggers applies f to every default logger m has
+func (m *Middleware) defaultLoggers(f func(*defaultLogger)) {
+	for i, l := range m.loggers {
+		if dl, ok := l.(defaultLogger); ok {
+			f(&dl)
+			m.loggers[i] = dl
+		}
 	}
 }
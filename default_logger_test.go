@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetErrorLogOutput(t *testing.T) {
+	m := NewMiddleware(http.NotFoundHandler())
+
+	stdout, file, stderr := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+
+	m.SetLogOutput(stdout, file)
+	m.SetErrorLogOutput(stderr)
+
+	for _, l := range m.loggers {
+		l.Log(LogEntry{RequestID: "ok", Status: 200})
+		l.Log(LogEntry{RequestID: "client", Status: 404})
+		l.Log(LogEntry{RequestID: "server", Status: 502})
+		l.Log(LogEntry{RequestID: "failed", Error: "connection refused"})
+	}
+
+	for name, buf := range map[string]*bytes.Buffer{"stdout": stdout, "file": file} {
+		out := buf.String()
+		if !strings.Contains(out, `"ok"`) || !strings.Contains(out, `"client"`) {
+			t.Errorf("%s: expected access entries, received %q", name, out)
+		}
+
+		if strings.Contains(out, `"server"`) || strings.Contains(out, `"failed"`) {
+			t.Errorf("%s: expected no error entries, received %q", name, out)
+		}
+	}
+
+	if strings.Count(stderr.String(), "\n") != 2 || !strings.Contains(stderr.String(), `"server"`) || !strings.Contains(stderr.String(), `"failed"`) {
+		t.Errorf("expected error entries on stderr, received %q", stderr)
+	}
+
+	m.SetErrorLogOutput()
+	m.loggers[0].Log(LogEntry{RequestID: "again", Status: 500})
+
+	if !strings.Contains(stdout.String(), `"again"`) {
+		t.Errorf("expected errors back on stdout once split is removed")
+	}
+}
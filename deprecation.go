@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Deprecation describes contract changes to a route, announced in the
+// headers of responses from the wrapped handler, so that API owners can
+// warn clients without touching every handler. Responses the middleware
+// makes itself, such as rate limiting, aren't annotated.
+type Deprecation struct {
+	// Fields lists response fields which are deprecated, sent as a comma
+	// separated `X-Deprecated-Fields` header
+	Fields []string
+
+	// Warning, when set, is sent as a `Warning` header, with the
+	// miscellaneous persistent warning code 299, such as
+	// `299 - "v1 is deprecated, use v2"`
+	Warning string
+}
+
+// annotate adds d's headers, if any, via add
+func (d Deprecation) annotate(add func(k, v string)) {
+	if len(d.Fields) > 0 {
+		add("X-Deprecated-Fields", strings.Join(d.Fields, ", "))
+	}
+
+	if d.Warning != "" {
+		add("Warning", "299 - "+strconv.Quote(d.Warning))
+	}
+}
+
+// annotateResponse adds d's headers to a net/http response's headers
+func (d Deprecation) annotateResponse(h http.Header) {
+	d.annotate(h.Add)
+}
+
+// annotateFasthttpResponse adds d's headers to a fasthttp response
+func (d Deprecation) annotateFasthttpResponse(ctx *fasthttp.RequestCtx) {
+	d.annotate(ctx.Response.Header.Add)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var testDeprecation = Deprecation{Fields: []string{"nickname", "avatar_url"}, Warning: `v1 is "deprecated"`}
+
+func TestDeprecation(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Warning", `199 - "handler warning"`)
+	}))
+	m.AddRoutePolicy("/v1/*", RoutePolicy{Deprecation: testDeprecation})
+	m.SetRateLimit(RateLimit{Rate: 1, Burst: 1})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/users", nil))
+
+	if v := rec.Header().Get("X-Deprecated-Fields"); v != "nickname, avatar_url" {
+		t.Errorf("expected deprecated fields, received %q", v)
+	}
+
+	expect := []string{`199 - "handler warning"`, `299 - "v1 is \"deprecated\""`}
+	if !equalValues(rec.Header()["Warning"], expect) {
+		t.Errorf("expected warnings %q, received %q", expect, rec.Header()["Warning"])
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/users", nil))
+
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-Deprecated-Fields") != "" {
+		t.Errorf("expected a rate limited response without annotations, received %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestDeprecation_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.AddRoutePolicy("/v1/*", RoutePolicy{Deprecation: testDeprecation})
+
+	for _, test := range []struct {
+		path   string
+		fields string
+	}{
+		{"/v1/users", "nickname, avatar_url"},
+		{"/v2/users", ""},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(test.path)
+		m.ServeFastHTTP(ctx)
+
+		if v := string(ctx.Response.Header.Peek("X-Deprecated-Fields")); v != test.fields {
+			t.Errorf("%s: expected deprecated fields %q, received %q", test.path, test.fields, v)
+		}
+	}
+}
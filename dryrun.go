@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+)
+
+// Features which may be put into observe-only mode with DryRun
+const (
+	DryRunBlocklist = "blocklist"
+	DryRunBans      = "bans"
+	DryRunHoneypots = "honeypots"
+	DryRunRateLimit = "rate_limit"
+	DryRunQuotas    = "quotas"
+)
+
+// dryRunFeatures lists every feature DryRun accepts
+var dryRunFeatures = []string{DryRunBlocklist, DryRunBans, DryRunHoneypots, DryRunRateLimit, DryRunQuotas}
+
+// DryRun puts features into observe-only mode, or every feature when none
+// are given. Requests which would have been rejected by a feature in dry run
+// are served as normal, with the features which would have rejected them
+// logged as `would_reject`, and counted per route by the traffic endpoint.
+// This allows new rules to be rolled out safely, by watching what they'd do
+// before letting them do it.
+//
+// Features otherwise behave as normal: rate limiter buckets still drain,
+// quota headers are still sent, clients are still banned (and audited) for
+// crossing thresholds, and honeypots still raise alerts, though they no
+// longer ban. DryRun panics on an unknown feature, and should be called
+// before serving
+func (m *Middleware) DryRun(features ...string) {
+	if len(features) == 0 {
+		features = dryRunFeatures
+	}
+
+	if m.dryRun == nil {
+		m.dryRun = make(map[string]bool)
+	}
+
+	for _, f := range features {
+		if !validDryRunFeature(f) {
+			panic(fmt.Sprintf("middleware: unknown dry run feature %q", f))
+		}
+
+		m.dryRun[f] = true
+	}
+}
+
+func validDryRunFeature(f string) bool {
+	for _, known := range dryRunFeatures {
+		if f == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enforce returns whether a request matched by feature should be rejected.
+// When feature is in dry run, it instead adds feature to would, and returns
+// false so the request carries on
+func (m *Middleware) enforce(feature string, would *[]string) bool {
+	if !m.dryRun[feature] {
+		return true
+	}
+
+	*would = append(*would, feature)
+
+	return false
+}
+
+// recordDryRun counts a request which features in dry run would have
+// rejected
+func (m *Middleware) recordDryRun(route string, would []string) {
+	for _, f := range would {
+		m.wouldReject.add(route, f)
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDryRun(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetRateLimit(RateLimit{Rate: 0.5})
+	m.Block("/old/*", http.StatusGone)
+	m.DryRun(DryRunRateLimit, DryRunBlocklist)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/old/page", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected dry run not to reject, received %d", rec.Code)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"would_reject":["blocklist","rate_limit"]`) {
+		t.Errorf("expected would_reject to be logged, received %q", logWriter.body)
+	}
+
+	s := m.traffic(time.Now())
+	if s.WouldReject["default"]["blocklist"] != 2 || s.WouldReject["default"]["rate_limit"] != 1 {
+		t.Errorf("unexpected dry run counts %v", s.WouldReject)
+	}
+
+	if len(s.Rejected) > 0 {
+		t.Errorf("expected nothing rejected, received %v", s.Rejected)
+	}
+}
+
+func TestDryRun_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+	m.Block("/old/*", http.StatusGone)
+	m.DryRun()
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/old/page")
+
+	m.ServeFastHTTP(c)
+
+	if c.Response.StatusCode() == http.StatusGone {
+		t.Errorf("expected dry run not to reject")
+	}
+
+	if n := m.traffic(time.Now()).WouldReject["default"]["blocklist"]; n != 1 {
+		t.Errorf("expected a dry run count, received %d", n)
+	}
+}
+
+func TestDryRun_HoneypotsDontBan(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddHoneypots("/.env")
+	m.EnableBans(BanPolicy{ErrorRate: 0.9})
+	m.DryRun(DryRunHoneypots)
+
+	for _, p := range []string{"/.env", "/"} {
+		r := httptest.NewRequest("GET", p, nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected dry run not to reject, received %d", p, rec.Code)
+		}
+	}
+}
+
+func TestDryRun_PanicsOnUnknownFeature(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).DryRun("validation")
+}
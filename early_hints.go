@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// sendEarlyHints sends p's EarlyHints to w in a `103 Early Hints` response,
+// returning when they were sent, or the zero time when they weren't.
+// Headers set by the hints are removed again, so that they don't leak into
+// the final response
+func (p RoutePolicy) sendEarlyHints(w http.ResponseWriter, r *http.Request) (sent time.Time) {
+	if len(p.EarlyHints) == 0 || !r.ProtoAtLeast(1, 1) {
+		return
+	}
+
+	h := w.Header()
+	links, ok := h["Link"]
+
+	h["Link"] = p.EarlyHints
+	w.WriteHeader(http.StatusEarlyHints)
+
+	if ok {
+		h["Link"] = links
+	} else {
+		delete(h, "Link")
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEarlyHints(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("hello"))
+	}))
+	m.AddRoutePolicy("/app/*", RoutePolicy{EarlyHints: []string{"</app.css>; rel=preload; as=style"}})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	for _, test := range []struct {
+		path   string
+		expect []string
+	}{
+		{"/app/home", []string{"</app.css>; rel=preload; as=style"}},
+		{"/api/users", nil},
+	} {
+		var (
+			lock  sync.Mutex
+			hints []string
+		)
+
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+				lock.Lock()
+				defer lock.Unlock()
+
+				if code == http.StatusEarlyHints {
+					hints = append(hints, h["Link"]...)
+				}
+
+				return nil
+			},
+		}
+
+		r, _ := http.NewRequest("GET", s.URL+test.path, nil)
+		resp, err := http.DefaultClient.Do(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		resp.Body.Close()
+
+		lock.Lock()
+		if !equalValues(hints, test.expect) {
+			t.Errorf("%s: expected hints %q, received %q", test.path, test.expect, hints)
+		}
+		lock.Unlock()
+
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Link") != "" {
+			t.Errorf("%s: expected a 200 without hints, received %d %v", test.path, resp.StatusCode, resp.Header)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(string(logWriter.body)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"early_hints_ms":`) || strings.Contains(lines[1], "early_hints_ms") {
+		t.Errorf("expected the hint timing to be logged, received %q", logWriter.body)
+	}
+}
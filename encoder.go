@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Encoder serialises entries for the default logger. JSON encoding
+// dominates the default logger's CPU profile at high request rates, so
+// services may swap in a faster JSON library, or a binary format such as
+// msgpack or protobuf, with SetEncoder. Loggers with encodings of their
+// own, such as one writing CSV to a file alongside JSON on STDOUT, are
+// built with NewEncodingLogger.
+//
+// Each encoded entry is followed by a newline, unless it already ends in
+// one, so binary encoders should frame entries themselves, such as with a
+// length prefix or base64.
+type Encoder interface {
+	Encode(LogEntry) ([]byte, error)
+}
+
+// EncoderFunc allows a plain function, such as jsoniter's Marshal, to be
+// used as an Encoder
+type EncoderFunc func(LogEntry) ([]byte, error)
+
+// Encode implements Encoder
+func (f EncoderFunc) Encode(l LogEntry) ([]byte, error) {
+	return f(l)
+}
+
+var (
+	// JSONEncoder encodes entries with encoding/json, flattening custom
+	// fields as per LogEntry.MarshalJSON. It is the default
+	JSONEncoder Encoder = EncoderFunc(func(l LogEntry) ([]byte, error) { return json.Marshal(l) })
+
+	// StrictJSONEncoder encodes entries as per MarshalStrict
+	StrictJSONEncoder Encoder = EncoderFunc(MarshalStrict)
+)
+
+// SetEncoder replaces the default logger's encoder. It takes precedence over
+// StrictSchema, and passing nil restores the default
+func (m *Middleware) SetEncoder(e Encoder) {
+	m.defaultLoggers(func(dl *defaultLogger) {
+		dl.encoder = e
+	})
+}
+
+// encode serialises l with the logger's encoder
+func (dl defaultLogger) encode(l LogEntry) ([]byte, error) {
+	switch {
+	case dl.encoder != nil:
+		return dl.encoder.Encode(l)
+	case dl.strict:
+		return StrictJSONEncoder.Encode(l)
+	default:
+		return JSONEncoder.Encode(l)
+	}
+}
+
+// CSVEncoder returns an Encoder writing the values of keys as a CSV record.
+// Keys are those of JSON output, with nested data flattened into dotted
+// keys as per FormatLogfmt, such as `response_headers.Content-Type`.
+// Missing keys are left empty, and lists are comma separated.
+//
+// CSVEncoder panics when given no keys
+func CSVEncoder(keys ...string) Encoder {
+	if len(keys) == 0 {
+		panic("middleware: CSVEncoder requires at least one key")
+	}
+
+	return EncoderFunc(func(l LogEntry) ([]byte, error) {
+		fields, err := entryFields(l)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			values[f.key] = f.value
+		}
+
+		record := make([]string, len(keys))
+		for i, k := range keys {
+			record[i] = csvValue(values[k])
+		}
+
+		var buf bytes.Buffer
+
+		w := csv.NewWriter(&buf)
+		w.Write(record)
+		w.Flush()
+
+		return buf.Bytes(), w.Error()
+	})
+}
+
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []interface{}:
+		s := make([]string, len(t))
+		for i, e := range t {
+			s[i] = csvValue(e)
+		}
+
+		return strings.Join(s, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// RenamedJSONEncoder returns an Encoder writing JSON as JSONEncoder does,
+// but with top level keys renamed as per names, such as `status` to
+// `http_status`, to fit an existing schema. Keys are otherwise left in
+// place and in order
+func RenamedJSONEncoder(names map[string]string) Encoder {
+	return EncoderFunc(func(l LogEntry) ([]byte, error) {
+		b, err := JSONEncoder.Encode(l)
+		if err != nil || len(names) == 0 {
+			return b, err
+		}
+
+		return renameKeys(b, names)
+	})
+}
+
+// renameKeys renames the top level keys of the JSON object b
+func renameKeys(b []byte, names map[string]string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBufferString("{")
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token %v", t)
+		}
+
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		if name, ok := names[key]; ok {
+			key = name
+		}
+
+		kb, _ := json.Marshal(key)
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
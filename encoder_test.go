@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestSetEncoder(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.StrictSchema()
+	m.SetEncoder(EncoderFunc(func(l LogEntry) ([]byte, error) {
+		return []byte(fmt.Sprintf("%d %s", l.Status, l.URL)), nil
+	}))
+
+	var buf bytes.Buffer
+	m.SetLogOutput(&buf)
+
+	m.loggers[0].Log(LogEntry{Status: 200, URL: "/a"})
+	m.loggers[0].(defaultLogger).LogBatch([]LogEntry{{Status: 404, URL: "/b"}, {Status: 500, URL: "/c"}})
+
+	if buf.String() != "200 /a\n404 /b\n500 /c\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	buf.Reset()
+	m.SetEncoder(nil)
+	m.loggers[0].Log(LogEntry{Status: 200, Fields: map[string]interface{}{"user": "u1"}})
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"fields":{"user":"u1"}`)) {
+		t.Errorf("expected strict output once the encoder is removed, received %q", buf.String())
+	}
+}
+
+func TestSetEncoder_Error(t *testing.T) {
+	var buf bytes.Buffer
+
+	dl := defaultLogger{
+		output: log.New(&buf, "", 0),
+		encoder: EncoderFunc(func(LogEntry) ([]byte, error) {
+			return nil, fmt.Errorf("boom")
+		}),
+	}
+
+	dl.Log(LogEntry{})
+
+	if !bytes.Contains(buf.Bytes(), []byte("error marshaling log data")) {
+		t.Errorf("expected an error, received %q", buf.String())
+	}
+}
+
+func TestCSVEncoder(t *testing.T) {
+	e := CSVEncoder("status", "url", "response_headers.Content-Type", "would_reject", "missing")
+
+	b, err := e.Encode(LogEntry{
+		Status:          200,
+		URL:             "/search?q=a,b",
+		ResponseHeaders: map[string]string{"Content-Type": "text/html"},
+		WouldReject:     []string{DryRunBlocklist, DryRunRateLimit},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expect := `200,"/search?q=a,b",text/html,"blocklist,rate_limit",` + "\n"
+	if string(b) != expect {
+		t.Errorf("expected %q, received %q", expect, b)
+	}
+}
+
+func TestRenamedJSONEncoder(t *testing.T) {
+	b, err := RenamedJSONEncoder(map[string]string{"status": "http_status", "user": "user_id"}).Encode(LogEntry{
+		Status: 404,
+		URL:    "/a",
+		Fields: map[string]interface{}{"user": "u1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for _, expect := range []string{`"http_status":404,"time"`, `"url":"/a"`, `"user_id":"u1"}`} {
+		if !bytes.Contains(b, []byte(expect)) {
+			t.Errorf("expected %s, received %s", expect, b)
+		}
+	}
+
+	if bytes.Contains(b, []byte(`"status"`)) {
+		t.Errorf("expected status to be renamed, received %s", b)
+	}
+}
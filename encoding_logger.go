@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// EncodingLogger implements middleware.Loggable, writing entries to a
+// writer with an Encoder of its own, so that each logger can serialise
+// entries differently, such as:
+//
+//	m.SetLoggers(
+//		middleware.NewEncodingLogger(os.Stdout, middleware.JSONEncoder),
+//		middleware.NewEncodingLogger(f, middleware.CSVEncoder("time", "status", "url")),
+//	)
+//
+// Entries are framed as per Encoder
+type EncodingLogger struct {
+	lock    sync.Mutex
+	output  io.Writer
+	encoder Encoder
+}
+
+// NewEncodingLogger returns an EncodingLogger writing entries encoded by e
+// to w. A nil e encodes as JSONEncoder does
+func NewEncodingLogger(w io.Writer, e Encoder) *EncodingLogger {
+	if e == nil {
+		e = JSONEncoder
+	}
+
+	return &EncodingLogger{output: w, encoder: e}
+}
+
+// Log implements middleware.Loggable
+func (el *EncodingLogger) Log(l LogEntry) {
+	b, err := el.encoder.Encode(l)
+	if err != nil {
+		b = []byte("error marshaling log data: " + err.Error())
+	}
+
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+
+	el.lock.Lock()
+	defer el.lock.Unlock()
+
+	el.output.Write(b)
+}
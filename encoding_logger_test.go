@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestEncodingLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers(
+		NewEncodingLogger(&buf, CSVEncoder("status", "url")),
+		NewEncodingLogger(&buf, EncoderFunc(func(l LogEntry) ([]byte, error) {
+			return nil, fmt.Errorf("boom")
+		})),
+	)
+
+	for _, l := range m.loggers {
+		l.Log(LogEntry{Status: 200, URL: "/a"})
+	}
+
+	expect := "200,/a\nerror marshaling log data: boom\n"
+	if buf.String() != expect {
+		t.Errorf("expected %q, received %q", expect, buf.String())
+	}
+}
@@ -10,7 +10,12 @@ import (
 func main() {
 	m := middleware.NewMiddleware(API{})
 
-	fileLogger, err := NewFileLogger("./access.log")
+	fileLogger, err := middleware.NewFileLogger(middleware.FileLoggerConfig{
+		Path:       "./access.log",
+		MaxSize:    10 << 20,
+		MaxBackups: 5,
+		Compress:   true,
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"expvar"
+)
+
+// PublishCounters publishes the Middleware's request counters to expvar,
+// as a single map named name, so that they're readable from
+// `/debug/vars`, such as:
+//
+//	"api": {"/users/:id": 1204, "/healthcheck": 60}
+//
+// Counters are otherwise kept to the Middleware, so several may run in
+// one process, each publishing under a name of its own. As with
+// expvar.Publish, PublishCounters panics when name is already in use
+func (m *Middleware) PublishCounters(name string) {
+	mp := new(expvar.Map).Init()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for k, v := range m.Requests {
+		mp.Set(k, v)
+	}
+
+	expvar.Publish(name, mp)
+	m.expvars = mp
+}
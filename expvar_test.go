@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublishCounters(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/before", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	m.PublishCounters("test_publish_counters")
+	m.LimitCounterKeys(2)
+
+	for _, p := range []string{"/a", "/b", "/c"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	var published map[string]int64
+	if err := json.Unmarshal([]byte(expvar.Get("test_publish_counters").String()), &published); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Counters from before the limit aren't tracked by it, so /a is
+	// evicted by /c
+	expect := map[string]int64{"/before": 1, "/b": 1, "/c": 1, OtherCounterKey: 1}
+	if !reflect.DeepEqual(published, expect) {
+		t.Errorf("expected %+v, received %+v", expect, published)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected publishing under a taken name to panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).PublishCounters("test_publish_counters")
+}
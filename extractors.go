@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ResponseInfo describes the response to a request, for field extractors
+type ResponseInfo struct {
+	Status   int
+	Header   http.Header
+	Bytes    int
+	Duration time.Duration
+}
+
+// FieldExtractor computes the value of a custom field from a request and
+// its response. A nil value leaves the field out
+type FieldExtractor func(r *http.Request, resp ResponseInfo) interface{}
+
+type fieldExtractor struct {
+	name string
+	fn   FieldExtractor
+}
+
+// AddField enriches every LogEntry with a custom field, name, computed by
+// fn once the wrapped handler has responded, such as a shard or experiment
+// bucket. Fields are added to LogEntry.Fields, in the order they were
+// added.
+//
+// fasthttp requests are converted to net/http requests for fn, which is
+// only done when fields have been added. AddField panics when name clashes
+// with a LogEntry field
+func (m *Middleware) AddField(name string, fn FieldExtractor) {
+	if coreFields[name] {
+		panic(fmt.Errorf("field %q clashes with a LogEntry field", name))
+	}
+
+	m.extractors = append(m.extractors, fieldExtractor{name: name, fn: fn})
+}
+
+// AddStaticField adds a field with the same value to every LogEntry, for
+// inbound and outbound requests alike, such as the service name, environment,
+// or version, so that entries from many instances can be told apart without
+// enriching them downstream. Fields added by AddField take precedence.
+// AddStaticField panics when name clashes with a LogEntry field
+func (m *Middleware) AddStaticField(name string, value interface{}) {
+	if coreFields[name] {
+		panic(fmt.Errorf("field %q clashes with a LogEntry field", name))
+	}
+
+	if m.staticFields == nil {
+		m.staticFields = make(map[string]interface{})
+	}
+
+	m.staticFields[name] = value
+}
+
+// withStaticFields returns fields with static fields added, leaving fields
+// untouched as it may be shared
+func (m *Middleware) withStaticFields(fields map[string]interface{}) map[string]interface{} {
+	if len(m.staticFields) == 0 {
+		return fields
+	}
+
+	out := make(map[string]interface{}, len(fields)+len(m.staticFields))
+	for k, v := range m.staticFields {
+		out[k] = v
+	}
+
+	for k, v := range fields {
+		out[k] = v
+	}
+
+	return out
+}
+
+// extractFields runs every extractor, returning nil when none produce a value
+func (m *Middleware) extractFields(r *http.Request, resp ResponseInfo) (fields map[string]interface{}) {
+	for _, e := range m.extractors {
+		v := e.fn(r, resp)
+		if v == nil {
+			continue
+		}
+
+		if fields == nil {
+			fields = make(map[string]interface{}, len(m.extractors))
+		}
+
+		fields[e.name] = v
+	}
+
+	return
+}
+
+// extractFasthttpFields is extractFields for fasthttp requests
+func (m *Middleware) extractFasthttpFields(ctx *fasthttp.RequestCtx) map[string]interface{} {
+	if len(m.extractors) == 0 {
+		return nil
+	}
+
+	h := make(http.Header)
+	ctx.Response.Header.VisitAll(func(k, v []byte) {
+		h.Add(string(k), string(v))
+	})
+
+	return m.extractFields(httpRequest(ctx), ResponseInfo{
+		Status:   ctx.Response.StatusCode(),
+		Header:   h,
+		Bytes:    len(ctx.Response.Body()),
+		Duration: time.Since(ctx.Time()),
+	})
+}
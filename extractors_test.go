@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testExtractors(m *Middleware) {
+	m.AddField("shard", func(r *http.Request, _ ResponseInfo) interface{} {
+		return r.Header.Get("X-Shard")
+	})
+
+	m.AddField("cached", func(_ *http.Request, resp ResponseInfo) interface{} {
+		return resp.Header.Get("X-Cache") == "HIT"
+	})
+
+	m.AddField("big", func(_ *http.Request, resp ResponseInfo) interface{} {
+		if resp.Bytes < 1000 {
+			return nil
+		}
+
+		return true
+	})
+}
+
+func TestAddField(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	testExtractors(m)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Shard", "eu-2")
+
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	body := string(logWriter.body)
+	if !strings.Contains(body, `"shard":"eu-2"`) || !strings.Contains(body, `"cached":false`) {
+		t.Errorf("expected custom fields, received %q", body)
+	}
+
+	if strings.Contains(body, `"big"`) {
+		t.Errorf("expected nil fields to be left out, received %q", body)
+	}
+}
+
+func TestAddField_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+	testExtractors(m)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/")
+	c.Request.Header.Set("X-Shard", "us-1")
+
+	m.ServeFastHTTP(c)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if body := string(logWriter.body); !strings.Contains(body, `"shard":"us-1"`) {
+		t.Errorf("expected custom fields, received %q", body)
+	}
+}
+
+func TestAddField_CoreField(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).AddField("status", func(*http.Request, ResponseInfo) interface{} { return 1 })
+}
+
+func TestAddStaticField(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddStaticField("service", "payments")
+	m.AddStaticField("shard", "static")
+	m.AddField("shard", func(*http.Request, ResponseInfo) interface{} { return "extracted" })
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	m.logOutbound(httptest.NewRequest("GET", "http://users.internal/", nil), nil, nil, time.Now())
+
+	time.Sleep(100 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(string(logWriter.body)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries, received %q", lines)
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, `"service":"payments"`) {
+			t.Errorf("expected the static field, received %q", line)
+		}
+
+		if !strings.Contains(line, `"outbound":true`) && !strings.Contains(line, `"shard":"extracted"`) {
+			t.Errorf("expected extracted fields to take precedence, received %q", line)
+		}
+	}
+}
+
+func TestAddStaticField_PanicsOnCoreField(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).AddStaticField("status", 1)
+}
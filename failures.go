@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// Kinds of failure counted under `failures` in the counters endpoint, per
+// route, so that dashboards can break down what kind of bad is happening
+// without querying logs
+const (
+	// FailurePanic is a panic recovered from the wrapped handler
+	FailurePanic = "panic"
+
+	// FailureWriteError is a response which couldn't be written to the
+	// client, such as one which hung up. fasthttp writes responses after
+	// the middleware is done, so these are only counted for net/http
+	FailureWriteError = "write_error"
+
+	// FailureShed is a request shed by priority lanes or warm-up
+	FailureShed = "shed"
+
+	// FailureTimeout is a `408 Request Timeout` or `504 Gateway Timeout`,
+	// or a response which missed its caller's deadline, as per
+	// PropagateDeadlines
+	FailureTimeout = "timeout"
+
+	// FailureAuth is a `401 Unauthorized` or `403 Forbidden`, including
+	// those sent to banned clients
+	FailureAuth = "auth"
+
+	// FailureValidation is a response rejecting the request as malformed,
+	// such as `400 Bad Request` or `422 Unprocessable Entity`
+	FailureValidation = "validation"
+)
+
+// outcome is what the middleware saw of a request, for classifying failures
+type outcome struct {
+	status      int
+	crashed     *crash
+	writeFailed bool
+	shed        bool
+	deadline    time.Time
+	end         time.Time
+}
+
+// failure returns the kind of failure o describes, or an empty string when
+// the request didn't fail in a way the taxonomy covers. Only the first
+// matching kind is returned, so that kinds sum to failed requests
+func (o outcome) failure() string {
+	switch {
+	case o.crashed != nil:
+		return FailurePanic
+	case o.writeFailed:
+		return FailureWriteError
+	case o.shed:
+		return FailureShed
+	case o.status == http.StatusRequestTimeout || o.status == http.StatusGatewayTimeout,
+		!o.deadline.IsZero() && o.end.After(o.deadline):
+		return FailureTimeout
+	}
+
+	switch o.status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return FailureAuth
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType,
+		http.StatusUnprocessableEntity, http.StatusRequestHeaderFieldsTooLarge:
+		return FailureValidation
+	}
+
+	return ""
+}
+
+// recordFailure counts the failure o describes, if any, against route
+func (m *Middleware) recordFailure(route string, o outcome) {
+	kind := o.failure()
+	if kind == "" {
+		return
+	}
+
+	m.failures.add(route, kind)
+	m.changed()
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// brokenWriter is a ResponseWriter whose client has gone away
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (brokenWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestOutcome_Failure(t *testing.T) {
+	now := time.Now()
+
+	for _, test := range []struct {
+		name   string
+		o      outcome
+		expect string
+	}{
+		{"success", outcome{status: http.StatusOK}, ""},
+		{"not found", outcome{status: http.StatusNotFound}, ""},
+		{"server error", outcome{status: http.StatusInternalServerError}, ""},
+		{"panic", outcome{status: http.StatusInternalServerError, crashed: &crash{value: "boom"}}, FailurePanic},
+		{"panic over write error", outcome{crashed: &crash{}, writeFailed: true}, FailurePanic},
+		{"write error", outcome{status: http.StatusOK, writeFailed: true}, FailureWriteError},
+		{"shed", outcome{status: http.StatusServiceUnavailable, shed: true}, FailureShed},
+		{"request timeout", outcome{status: http.StatusRequestTimeout}, FailureTimeout},
+		{"gateway timeout", outcome{status: http.StatusGatewayTimeout}, FailureTimeout},
+		{"missed deadline", outcome{status: http.StatusOK, deadline: now, end: now.Add(time.Millisecond)}, FailureTimeout},
+		{"met deadline", outcome{status: http.StatusOK, deadline: now, end: now}, ""},
+		{"unauthorized", outcome{status: http.StatusUnauthorized}, FailureAuth},
+		{"forbidden", outcome{status: http.StatusForbidden}, FailureAuth},
+		{"bad request", outcome{status: http.StatusBadRequest}, FailureValidation},
+		{"unprocessable", outcome{status: http.StatusUnprocessableEntity}, FailureValidation},
+		{"too large", outcome{status: http.StatusRequestEntityTooLarge}, FailureValidation},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if received := test.o.failure(); received != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_Failures(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/login":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/form":
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	for _, p := range []string{"/login", "/form", "/panic", "/ok"} {
+		m.AddRoutePolicy(p, RoutePolicy{})
+	}
+
+	for _, p := range []string{"/panic", "/login", "/login", "/form", "/ok"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	m.ServeHTTP(brokenWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/ok", nil))
+
+	// Admin requests aren't counted, even when they're refused
+	m.AdminToken = "secret"
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+	m.AdminToken = ""
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	var c countersPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expect := map[string]map[string]int64{
+		"/panic": {FailurePanic: 1},
+		"/login": {FailureAuth: 2},
+		"/form":  {FailureValidation: 1},
+		"/ok":    {FailureWriteError: 1},
+	}
+
+	if !reflect.DeepEqual(expect, c.Failures) {
+		t.Errorf("expected %v, received %v", expect, c.Failures)
+	}
+}
+
+func TestServeFastHTTP_Failures(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(http.StatusForbidden)
+	}))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/secret")
+	m.ServeFastHTTP(ctx)
+
+	expect := map[string]map[string]int64{defaultRouteKey: {FailureAuth: 1}}
+	if received := m.failures.snapshot(); !reflect.DeepEqual(expect, received) {
+		t.Errorf("expected %v, received %v", expect, received)
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/valyala/fasthttp"
+)
+
+// httpRequest builds a read-only net/http view of a fasthttp request, so that
+// hooks (such as FlagProviders) only need implementing once. The body is a copy.
+func httpRequest(ctx *fasthttp.RequestCtx) *http.Request {
+	u, err := url.ParseRequestURI(string(ctx.RequestURI()))
+	if err != nil {
+		u = &url.URL{Path: string(ctx.Path())}
+	}
+
+	r := &http.Request{
+		Method:        string(ctx.Method()),
+		URL:           u,
+		Proto:         fasthttpProto(ctx),
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Host:          string(ctx.Host()),
+		RemoteAddr:    ctx.RemoteAddr().String(),
+		RequestURI:    string(ctx.RequestURI()),
+		ContentLength: int64(ctx.Request.Header.ContentLength()),
+		Body:          ioutil.NopCloser(bytes.NewReader(ctx.PostBody())),
+	}
+
+	if !ctx.Request.Header.IsHTTP11() {
+		r.ProtoMinor = 0
+	}
+
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		r.Header.Add(string(k), string(v))
+	})
+
+	return r.WithContext(ctx)
+}
+
+// fasthttpProto returns the protocol version of a request, as per http.Request.Proto.
+// fasthttp only speaks HTTP/1.x
+func fasthttpProto(ctx *fasthttp.RequestCtx) string {
+	if ctx.Request.Header.IsHTTP11() {
+		return "HTTP/1.1"
+	}
+
+	return "HTTP/1.0"
+}
+
+// fasthttpScheme returns the scheme a request was received over
+func fasthttpScheme(ctx *fasthttp.RequestCtx) string {
+	if ctx.IsTLS() {
+		return "https"
+	}
+
+	return "http"
+}
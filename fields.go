@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// field is a single, flattened, key/value pair from a LogEntry
+type field struct {
+	key   string
+	value interface{}
+}
+
+// entryFields flattens l into an ordered list of key/value pairs, as it
+// appears in JSON output. Nested objects, such as headers, are flattened with
+// dotted keys (`response_headers.Content-Type`). Values are strings, bools,
+// json.Numbers, nils, or (for arrays) []interface{}.
+//
+// Going via JSON keeps text formats, such as logfmt, in step with the
+// JSON schema without having to maintain a second list of fields.
+func entryFields(l LogEntry) (fields []field, err error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return
+	}
+
+	return objectFields(b, "", nil)
+}
+
+func objectFields(b []byte, prefix string, fields []field) ([]field, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token %v", t)
+		}
+
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		if len(raw) > 0 && raw[0] == '{' {
+			if fields, err = objectFields(raw, prefix+key+".", fields); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		var v interface{}
+
+		vd := json.NewDecoder(bytes.NewReader(raw))
+		vd.UseNumber()
+
+		if err = vd.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, field{key: prefix + key, value: v})
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,269 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rotatedTimeFormat names rotated files, sorting oldest first and
+	// avoiding characters which are awkward in file names
+	rotatedTimeFormat = "2006-01-02T15-04-05.000000000"
+)
+
+// renameFile renames files for rotation, and is swapped out in tests
+var renameFile = os.Rename
+
+// FileLoggerConfig configures a FileLogger. Thresholds of zero are disabled
+type FileLoggerConfig struct {
+	Path string
+
+	// MaxSize rotates the file before a write would take it past this many
+	// bytes
+	MaxSize int64
+
+	// MaxAge rotates the file once it has been open for this long
+	MaxAge time.Duration
+
+	// MaxBackups removes the oldest rotated files once there are more
+	// than this many
+	MaxBackups int
+
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// FileLogger implements middleware.Loggable, writing JSON lines to a file
+// which it rotates itself, removing the need for logrotate or similar.
+//
+// Rotated files are renamed to `<path>.<timestamp>` (with a `.gz` suffix
+// when compressed). Compression and removal of old backups happen in the
+// background, so don't hold up logging.
+//
+// FileLogger is also an io.Writer, so other loggers may write to a rotating
+// file too:
+//
+//	fl, _ := middleware.NewFileLogger(middleware.FileLoggerConfig{Path: "access.log", MaxSize: 100 << 20})
+//	m.AddLogger(middleware.NewLogfmtLogger(fl))
+type FileLogger struct {
+	lock   sync.Mutex
+	config FileLoggerConfig
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// maintenance serialises compression and pruning of backups
+	maintenance sync.Mutex
+	pending     sync.WaitGroup
+}
+
+// NewFileLogger opens, or creates, the file at c.Path for appending
+func NewFileLogger(c FileLoggerConfig) (fl *FileLogger, err error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("file logger requires a path")
+	}
+
+	fl = &FileLogger{config: c}
+
+	err = fl.open()
+
+	return
+}
+
+// Log implements middleware.Loggable
+func (fl *FileLogger) Log(l LogEntry) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		b = []byte(fmt.Sprintf("error marshaling log data: %q", err))
+	}
+
+	fl.Write(append(b, '\n'))
+}
+
+// Write implements io.Writer, rotating the file first when needed. Each
+// write is kept whole within a single file. Should rotation fail, such as
+// when the disk is full, writes carry on to the current file, and rotation
+// is retried on the next write
+func (fl *FileLogger) Write(p []byte) (n int, err error) {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	if fl.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if fl.due(int64(len(p)), time.Now()) {
+		fl.rotate()
+	}
+
+	n, err = fl.file.Write(p)
+	fl.size += int64(n)
+
+	return
+}
+
+// Rotate rotates the file immediately, such as on SIGHUP
+func (fl *FileLogger) Rotate() error {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	if fl.file == nil {
+		return os.ErrClosed
+	}
+
+	return fl.rotate()
+}
+
+// Close closes the file, waiting for any compression or pruning of backups
+// to finish
+func (fl *FileLogger) Close() (err error) {
+	fl.lock.Lock()
+
+	if fl.file != nil {
+		err = fl.file.Close()
+		fl.file = nil
+	}
+
+	fl.lock.Unlock()
+
+	fl.pending.Wait()
+
+	return
+}
+
+// due returns true when writing n bytes at now requires rotation first.
+// Empty files are never rotated, so oversized writes still succeed
+func (fl *FileLogger) due(n int64, now time.Time) bool {
+	if fl.size == 0 {
+		return false
+	}
+
+	return (fl.config.MaxSize > 0 && fl.size+n > fl.config.MaxSize) ||
+		(fl.config.MaxAge > 0 && now.Sub(fl.opened) >= fl.config.MaxAge)
+}
+
+// open opens the file at the configured path, and must be called with
+// fl's lock held
+func (fl *FileLogger) open() (err error) {
+	f, err := os.OpenFile(fl.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return
+	}
+
+	fl.file, fl.size, fl.opened = f, info.Size(), time.Now()
+
+	return
+}
+
+// rotate renames the current file aside and opens a fresh one. The current
+// file is kept open until then, so that failures leave it in place to be
+// written to. It must be called with fl's lock held
+func (fl *FileLogger) rotate() (err error) {
+	rotated := fl.config.Path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err = renameFile(fl.config.Path, rotated); err != nil {
+		return
+	}
+
+	current := fl.file
+	if err = fl.open(); err != nil {
+		// Move the file back, so that a retry starts from scratch
+		renameFile(rotated, fl.config.Path)
+
+		return
+	}
+
+	current.Close()
+
+	fl.pending.Add(1)
+
+	go fl.maintain(rotated)
+
+	return
+}
+
+// maintain compresses a freshly rotated file, if configured to, and prunes
+// old backups. Errors are ignored: the worst outcome is an uncompressed or
+// extra backup, which is better than losing entries
+func (fl *FileLogger) maintain(rotated string) {
+	defer fl.pending.Done()
+
+	fl.maintenance.Lock()
+	defer fl.maintenance.Unlock()
+
+	if fl.config.Compress {
+		if err := gzipFile(rotated); err == nil {
+			os.Remove(rotated)
+		}
+	}
+
+	if fl.config.MaxBackups > 0 {
+		backups := fl.backups()
+
+		for len(backups) > fl.config.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+}
+
+// backups returns rotated files, oldest first
+func (fl *FileLogger) backups() (backups []string) {
+	matches, _ := filepath.Glob(fl.config.Path + ".*")
+
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, fl.config.Path+"."), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+
+	sort.Strings(backups)
+
+	return
+}
+
+// gzipFile writes a gzipped copy of p to p.gz
+func gzipFile(p string) (err error) {
+	in, err := os.Open(p)
+	if err != nil {
+		return
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(p+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	gz := gzip.NewWriter(out)
+
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(p + ".gz")
+	}
+
+	return
+}
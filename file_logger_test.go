@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-logger")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "access.log")
+
+	fl, err := NewFileLogger(FileLoggerConfig{Path: p, MaxSize: 300, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		fl.Log(LogEntry{RequestID: "abc", Status: 200, URL: "/"})
+	}
+
+	if err = fl.Close(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	backups := fl.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, received %v", backups)
+	}
+
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".gz") {
+			t.Errorf("expected %s to be compressed", b)
+
+			continue
+		}
+
+		f, err := os.Open(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		body, _ := ioutil.ReadAll(gz)
+		f.Close()
+
+		if !strings.HasPrefix(string(body), `{"schema_version"`) || !strings.HasSuffix(string(body), "}\n") {
+			t.Errorf("expected whole entries in %s, received %q", b, body)
+		}
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if info.Size() > 300 {
+		t.Errorf("expected live file to respect MaxSize, received %d bytes", info.Size())
+	}
+
+	if _, err = fl.Write([]byte("late\n")); err == nil {
+		t.Errorf("expected writes after Close to fail")
+	}
+}
+
+func TestFileLogger_RotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-logger")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "access.log")
+
+	fl, err := NewFileLogger(FileLoggerConfig{Path: p, MaxSize: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	defer fl.Close()
+
+	renameFile = func(string, string) error { return os.ErrPermission }
+	defer func() { renameFile = os.Rename }()
+
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err = fl.Write([]byte(line)); err != nil {
+			t.Fatalf("expected writes to carry on, received %+v", err)
+		}
+	}
+
+	if b, _ := ioutil.ReadFile(p); string(b) != "first\nsecond\n" {
+		t.Errorf("expected both lines in the current file, received %q", b)
+	}
+
+	renameFile = os.Rename
+
+	if _, err = fl.Write([]byte("third\n")); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if backups := fl.backups(); len(backups) != 1 {
+		t.Errorf("expected rotation to be retried, received backups %v", backups)
+	}
+
+	if b, _ := ioutil.ReadFile(p); string(b) != "third\n" {
+		t.Errorf("expected a fresh file, received %q", b)
+	}
+}
+
+func TestFileLogger_due(t *testing.T) {
+	now := time.Now()
+
+	for _, test := range []struct {
+		name   string
+		config FileLoggerConfig
+		size   int64
+		opened time.Time
+		expect bool
+	}{
+		{"empty file", FileLoggerConfig{MaxSize: 1}, 0, now, false},
+		{"under size", FileLoggerConfig{MaxSize: 100}, 50, now, false},
+		{"over size", FileLoggerConfig{MaxSize: 100}, 90, now, true},
+		{"young", FileLoggerConfig{MaxAge: time.Hour}, 50, now.Add(-time.Minute), false},
+		{"old", FileLoggerConfig{MaxAge: time.Hour}, 50, now.Add(-time.Hour), true},
+		{"disabled", FileLoggerConfig{}, 1 << 30, now.Add(-time.Hour), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fl := &FileLogger{config: test.config, size: test.size, opened: test.opened}
+
+			if got := fl.due(20, now); got != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, got)
+			}
+		})
+	}
+}
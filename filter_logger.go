@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/url"
+)
+
+// Predicate decides whether a FilterLogger forwards an entry
+type Predicate func(LogEntry) bool
+
+// FilterLogger implements middleware.Loggable, forwarding only entries
+// matching every one of its predicates to another logger. This allows,
+// say, a dedicated error log alongside the usual access log:
+//
+//	m.AddLogger(middleware.NewFilterLogger(errorLog, middleware.StatusAtLeast(400)))
+type FilterLogger struct {
+	target     Loggable
+	predicates []Predicate
+}
+
+// NewFilterLogger returns a FilterLogger forwarding entries matching every
+// one of predicates to target. Without predicates, everything is forwarded
+func NewFilterLogger(target Loggable, predicates ...Predicate) *FilterLogger {
+	return &FilterLogger{target: target, predicates: predicates}
+}
+
+// Log implements middleware.Loggable
+func (fl *FilterLogger) Log(l LogEntry) {
+	for _, p := range fl.predicates {
+		if !p(l) {
+			return
+		}
+	}
+
+	fl.target.Log(l)
+}
+
+// StatusAtLeast matches entries with a status of at least status, and
+// requests which failed without a response at all
+func StatusAtLeast(status int) Predicate {
+	return func(l LogEntry) bool {
+		return l.Status >= status || l.Error != ""
+	}
+}
+
+// MatchingPaths matches entries whose URL's path, which may be relative or
+// absolute, matches any of patterns. Patterns take the same form as those
+// passed to AddRoutePolicy, and MatchingPaths panics on a malformed one
+func MatchingPaths(patterns ...string) Predicate {
+	var rm routeMatcher
+
+	for _, p := range patterns {
+		if err := rm.add(p, nil); err != nil {
+			panic(err)
+		}
+	}
+
+	return func(l LogEntry) bool {
+		p, ok := entryPath(l)
+		if !ok {
+			return false
+		}
+
+		_, _, ok = rm.match(p)
+
+		return ok
+	}
+}
+
+// entryPath returns the path of an entry's URL, which may be relative or
+// absolute
+func entryPath(l LogEntry) (string, bool) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return "", false
+	}
+
+	return u.Path, true
+}
+
+// MatchingRequestID matches entries for the request with ID id
+func MatchingRequestID(id string) Predicate {
+	return func(l LogEntry) bool {
+		return l.RequestID == id
+	}
+}
+
+// AnyOf matches entries matching at least one of predicates
+func AnyOf(predicates ...Predicate) Predicate {
+	return func(l LogEntry) bool {
+		for _, p := range predicates {
+			if p(l) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Not matches entries which p doesn't
+func Not(p Predicate) Predicate {
+	return func(l LogEntry) bool {
+		return !p(l)
+	}
+}
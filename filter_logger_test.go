@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"testing"
+)
+
+func TestFilterLogger(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		predicates []Predicate
+		entry      LogEntry
+		expect     bool
+	}{
+		{"no predicates", nil, LogEntry{Status: 200}, true},
+		{"status below", []Predicate{StatusAtLeast(400)}, LogEntry{Status: 200}, false},
+		{"status above", []Predicate{StatusAtLeast(400)}, LogEntry{Status: 404}, true},
+		{"failed request", []Predicate{StatusAtLeast(500)}, LogEntry{Error: "connection refused"}, true},
+		{"path", []Predicate{MatchingPaths("/payments/*")}, LogEntry{URL: "/payments/1?a=b"}, true},
+		{"absolute URL", []Predicate{MatchingPaths("/payments/*")}, LogEntry{URL: "https://example.com/payments/1"}, true},
+		{"other path", []Predicate{MatchingPaths("/payments/*")}, LogEntry{URL: "/search"}, false},
+		{"every predicate", []Predicate{StatusAtLeast(500), MatchingPaths("/payments/*")}, LogEntry{URL: "/payments/1", Status: 404}, false},
+		{"any of", []Predicate{AnyOf(StatusAtLeast(500), MatchingPaths("/payments/*"))}, LogEntry{URL: "/payments/1", Status: 404}, true},
+		{"not", []Predicate{Not(MatchingPaths("/healthcheck"))}, LogEntry{URL: "/healthcheck"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target := &collectingLogger{}
+			NewFilterLogger(target, test.predicates...).Log(test.entry)
+
+			if got := len(target.entries) == 1; got != test.expect {
+				t.Errorf("expected forwarded %v, received %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestMatchingPaths_PanicsOnBadPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	MatchingPaths("/[")
+}
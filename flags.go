@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// FlagProvider evaluates feature flags for a request, returning the
+// variant of each flag which applies to it (such as "on", "off", or
+// "blue-button").
+//
+// Variants are exposed to handlers via Flags and Flag, and are recorded
+// in LogEntry so that incidents can be correlated with flag rollouts.
+type FlagProvider interface {
+	Evaluate(*http.Request) map[string]string
+}
+
+// FlagProviderFunc allows ordinary functions to be used as FlagProviders
+type FlagProviderFunc func(*http.Request) map[string]string
+
+// Evaluate implements FlagProvider
+func (f FlagProviderFunc) Evaluate(r *http.Request) map[string]string {
+	return f(r)
+}
+
+// SetFlagProvider evaluates p on every request which reaches the wrapped handler
+func (m *Middleware) SetFlagProvider(p FlagProvider) {
+	m.flags = p
+}
+
+// Flags returns every flag variant evaluated for the request ctx belongs to.
+// ctx is either a net/http request's context, or a *fasthttp.RequestCtx
+func Flags(ctx context.Context) map[string]string {
+	if st := stateFrom(ctx); st != nil {
+		return st.flags
+	}
+
+	return nil
+}
+
+// Flag returns the variant of a single flag for the request ctx belongs to,
+// or an empty string when the flag wasn't evaluated
+func Flag(ctx context.Context, name string) string {
+	return Flags(ctx)[name]
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var testFlagProvider = FlagProviderFunc(func(r *http.Request) map[string]string {
+	if r.Header.Get("X-Beta") != "" {
+		return map[string]string{"new-checkout": "on"}
+	}
+
+	return map[string]string{"new-checkout": "off"}
+})
+
+type TestFlagAPI struct{}
+
+func (ta TestFlagAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, Flag(r.Context(), "new-checkout"))
+}
+
+func (ta TestFlagAPI) Handle(ctx *fasthttp.RequestCtx) {
+	fmt.Fprint(ctx, Flag(ctx, "new-checkout"))
+}
+
+func TestFlags(t *testing.T) {
+	m := NewMiddleware(TestFlagAPI{})
+	m.SetFlagProvider(testFlagProvider)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Beta", "1")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	if rec.Body.String() != "on" {
+		t.Errorf("expected handler to see flag, received %q", rec.Body.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	if l.Flags["new-checkout"] != "on" {
+		t.Errorf("expected flags to be logged, received %+v", l.Flags)
+	}
+}
+
+func TestFlags_fasthttp(t *testing.T) {
+	m := NewMiddleware(FasthttpHandler(TestFlagAPI{}))
+	m.SetFlagProvider(testFlagProvider)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/")
+
+	m.ServeFastHTTP(c)
+
+	if string(c.Response.Body()) != "off" {
+		t.Errorf("expected handler to see flag, received %q", c.Response.Body())
+	}
+}
+
+func TestFlags_noState(t *testing.T) {
+	if Flag(httptest.NewRequest("GET", "/", nil).Context(), "anything") != "" {
+		t.Errorf("expected no flags outside of the middleware")
+	}
+}
@@ -0,0 +1,349 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFluentTag is the tag entries are forwarded under
+	DefaultFluentTag = "http.access"
+
+	// DefaultFluentBufferSize is the most entries a FluentLogger holds onto
+	// while fluentd is unreachable
+	DefaultFluentBufferSize = 10000
+)
+
+// FluentConfig configures a FluentLogger
+type FluentConfig struct {
+	// Network is either `tcp` (the default) or `unix`
+	Network string
+	Address string
+
+	// Tag defaults to DefaultFluentTag
+	Tag string
+
+	// BatchSize and FlushInterval control batching, as per NewBatchLogger,
+	// and default to 100 entries and one second
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// BufferSize bounds how many unsent entries are held while fluentd is
+	// unreachable, defaulting to DefaultFluentBufferSize. The oldest entries
+	// are dropped first
+	BufferSize int
+
+	// Timeout bounds connecting and writing, and defaults to five seconds
+	Timeout time.Duration
+}
+
+// FluentLogger implements middleware.Loggable, shipping entries to fluentd or
+// fluent-bit using the Forward protocol, rather than relying on stdout being
+// scraped.
+//
+// Entries are batched into Forward mode messages. Should a batch fail to send
+// the connection is re-established and the batch retried once; failing that
+// the batch is buffered and sent ahead of the next.
+type FluentLogger struct {
+	config  FluentConfig
+	batcher *BatchLogger
+
+	lock    sync.Mutex
+	conn    net.Conn
+	pending []LogEntry
+	dropped int64
+}
+
+// NewFluentLogger returns a FluentLogger configured by c. Connections are made
+// lazily, so fluentd needn't be up when this is called
+func NewFluentLogger(c FluentConfig) *FluentLogger {
+	if c.Network == "" {
+		c.Network = "tcp"
+	}
+
+	if c.Tag == "" {
+		c.Tag = DefaultFluentTag
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultFluentBufferSize
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+
+	fl := &FluentLogger{config: c}
+	fl.batcher = NewBatchLogger(fl, c.BatchSize, c.FlushInterval)
+
+	return fl
+}
+
+// Log implements middleware.Loggable
+func (fl *FluentLogger) Log(l LogEntry) {
+	fl.batcher.Log(l)
+}
+
+// LogBatch implements middleware.BatchLoggable
+func (fl *FluentLogger) LogBatch(ls []LogEntry) {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	batch := append(fl.pending, ls...)
+	fl.pending = nil
+
+	if err := fl.send(batch); err == nil {
+		return
+	}
+
+	if over := len(batch) - fl.config.BufferSize; over > 0 {
+		batch = batch[over:]
+		fl.dropped += int64(over)
+	}
+
+	fl.pending = batch
+}
+
+// Flush sends any pending entries immediately
+func (fl *FluentLogger) Flush() {
+	fl.batcher.Flush()
+}
+
+// Close flushes any pending entries and closes the connection to fluentd
+func (fl *FluentLogger) Close() error {
+	fl.batcher.Flush()
+
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	if fl.conn == nil {
+		return nil
+	}
+
+	err := fl.conn.Close()
+	fl.conn = nil
+
+	return err
+}
+
+// Dropped returns the number of entries dropped because fluentd was
+// unreachable for long enough to fill the buffer
+func (fl *FluentLogger) Dropped() int64 {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	return fl.dropped
+}
+
+// send must be called with fl.lock held
+func (fl *FluentLogger) send(ls []LogEntry) (err error) {
+	msg, err := fluentForward(fl.config.Tag, ls)
+	if err != nil {
+		return
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if fl.conn == nil {
+			if fl.conn, err = net.DialTimeout(fl.config.Network, fl.config.Address, fl.config.Timeout); err != nil {
+				fl.conn = nil
+
+				continue
+			}
+		}
+
+		fl.conn.SetWriteDeadline(time.Now().Add(fl.config.Timeout))
+
+		if _, err = fl.conn.Write(msg); err == nil {
+			return
+		}
+
+		fl.conn.Close()
+		fl.conn = nil
+	}
+
+	return
+}
+
+// fluentForward encodes ls as a Forward mode message: [tag, [[time, record], ...]]
+func fluentForward(tag string, ls []LogEntry) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	msgpackArrayHeader(buf, 2)
+	msgpackEncode(buf, tag)
+	msgpackArrayHeader(buf, len(ls))
+
+	for _, l := range ls {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+
+		var record interface{}
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+
+		if err = dec.Decode(&record); err != nil {
+			return nil, err
+		}
+
+		t := l.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+
+		msgpackArrayHeader(buf, 2)
+		msgpackEventTime(buf, t)
+
+		if err = msgpackEncode(buf, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// msgpackEventTime writes t as a Fluent EventTime, which is a msgpack
+// extension (type 0) holding seconds and nanoseconds
+func msgpackEventTime(buf *bytes.Buffer, t time.Time) {
+	buf.Write([]byte{0xd7, 0x00})
+	binary.Write(buf, binary.BigEndian, uint32(t.Unix()))
+	binary.Write(buf, binary.BigEndian, uint32(t.Nanosecond()))
+}
+
+// msgpackEncode writes v, which must be JSON shaped data, as msgpack
+func msgpackEncode(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+
+	case bool:
+		if t {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case string:
+		msgpackString(buf, t)
+
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			msgpackInt(buf, i)
+
+			return nil
+		}
+
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+
+	case []interface{}:
+		msgpackArrayHeader(buf, len(t))
+
+		for _, e := range t {
+			if err := msgpackEncode(buf, e); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		msgpackMapHeader(buf, len(t))
+
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			msgpackString(buf, k)
+
+			if err := msgpackEncode(buf, t[k]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unable to msgpack encode %T", v)
+	}
+
+	return nil
+}
+
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+func msgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xd9, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+
+	buf.WriteString(s)
+}
+
+func msgpackArrayHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xdc)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdd)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackMapHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xde)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdf)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMsgpackEncode(t *testing.T) {
+	for _, test := range []struct {
+		value  interface{}
+		expect []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{json.Number("5"), []byte{0x05}},
+		{json.Number("-1"), []byte{0xff}},
+		{json.Number("200000"), []byte{0xd3, 0, 0, 0, 0, 0, 0x03, 0x0d, 0x40}},
+		{json.Number("1.5"), []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"ab", []byte{0xa2, 'a', 'b'}},
+		{[]interface{}{"a"}, []byte{0x91, 0xa1, 'a'}},
+		{map[string]interface{}{"b": nil, "a": true}, []byte{0x82, 0xa1, 'a', 0xc3, 0xa1, 'b', 0xc0}},
+	} {
+		buf := &bytes.Buffer{}
+		if err := msgpackEncode(buf, test.value); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), test.expect) {
+			t.Errorf("%v: expected %x, received %x", test.value, test.expect, buf.Bytes())
+		}
+	}
+}
+
+func TestFluentLogger_Reconnect(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "fluent.sock")
+
+	fl := NewFluentLogger(FluentConfig{Network: "unix", Address: addr, Tag: "web", BatchSize: 1})
+	defer fl.Close()
+
+	// nothing is listening, so this is buffered
+	fl.Log(LogEntry{RequestID: "first"})
+
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+		b, _ := ioutil.ReadAll(conn)
+		received <- b
+	}()
+
+	fl.Log(LogEntry{RequestID: "second"})
+
+	select {
+	case b := <-received:
+		// [tag, [entry, entry]]
+		if !bytes.HasPrefix(b, []byte{0x92, 0xa3, 'w', 'e', 'b', 0x92}) {
+			t.Errorf("unexpected forward message %x", b)
+		}
+
+		first, second := bytes.Index(b, []byte("first")), bytes.Index(b, []byte("second"))
+		if first < 0 || second < first {
+			t.Errorf("expected buffered entry ahead of the new one in %q", b)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	if fl.Dropped() != 0 {
+		t.Errorf("expected nothing dropped, received %d", fl.Dropped())
+	}
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGELFChunkSize is the largest UDP datagram a GELFLogger sends,
+	// and is small enough to avoid fragmentation on most networks
+	DefaultGELFChunkSize = 1420
+
+	// gelfMaxChunks is the most chunks Graylog will reassemble a message from
+	gelfMaxChunks = 128
+
+	// gelfChunkHeader is the size of each chunk's header: two magic bytes,
+	// an eight byte message ID, and a sequence number and count
+	gelfChunkHeader = 12
+)
+
+// GELFConfig configures where, and how, a GELFLogger ships entries
+type GELFConfig struct {
+	// Network is either `udp` (the default) or `tcp`
+	Network string
+	Address string
+
+	// Host is reported as the source of each message, and defaults to
+	// the machine's hostname
+	Host string
+
+	// ChunkSize bounds the size of UDP datagrams, and defaults to
+	// DefaultGELFChunkSize. Larger messages are chunked
+	ChunkSize int
+
+	// Compress gzips UDP messages. TCP messages are never compressed, as
+	// Graylog doesn't support compression over TCP
+	Compress bool
+}
+
+// GELFLogger implements middleware.Loggable, shipping entries to Graylog as
+// GELF 1.1 messages over UDP or TCP.
+//
+// Every field of the entry is sent as an additional field, with nested data
+// flattened as per LogfmtLogger, so that a request's ID, status and duration
+// are available as `_request_id`, `_status`, and `_duration_ms`
+type GELFLogger struct {
+	lock sync.Mutex
+	conn net.Conn
+
+	config GELFConfig
+}
+
+// NewGELFLogger connects to the Graylog input described by c
+func NewGELFLogger(c GELFConfig) (gl *GELFLogger, err error) {
+	if c.Network == "" {
+		c.Network = "udp"
+	}
+
+	if c.Network != "udp" && c.Network != "tcp" {
+		return nil, fmt.Errorf("unsupported GELF network %q", c.Network)
+	}
+
+	if c.Host == "" {
+		c.Host, _ = os.Hostname()
+	}
+
+	if c.ChunkSize <= gelfChunkHeader {
+		c.ChunkSize = DefaultGELFChunkSize
+	}
+
+	gl = &GELFLogger{config: c}
+
+	gl.conn, err = net.Dial(c.Network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Log implements middleware.Loggable
+func (gl *GELFLogger) Log(l LogEntry) {
+	gl.Ship(l)
+}
+
+// Ship implements middleware.Shipper
+func (gl *GELFLogger) Ship(l LogEntry) (err error) {
+	msg, err := FormatGELF(l, gl.config.Host)
+	if err != nil {
+		return
+	}
+
+	var packets [][]byte
+	if gl.config.Network == "tcp" {
+		packets = [][]byte{append(msg, 0)}
+	} else if packets, err = gl.chunk(msg); err != nil {
+		return
+	}
+
+	gl.lock.Lock()
+	defer gl.lock.Unlock()
+
+	if gl.conn == nil {
+		if gl.conn, err = net.Dial(gl.config.Network, gl.config.Address); err != nil {
+			return
+		}
+	}
+
+	for _, p := range packets {
+		if _, err = gl.conn.Write(p); err != nil {
+			// reconnect on the next attempt
+			gl.conn.Close()
+			gl.conn = nil
+
+			return
+		}
+	}
+
+	return
+}
+
+// Close closes the connection to Graylog
+func (gl *GELFLogger) Close() error {
+	gl.lock.Lock()
+	defer gl.lock.Unlock()
+
+	if gl.conn == nil {
+		return nil
+	}
+
+	return gl.conn.Close()
+}
+
+// chunk compresses msg, if configured to, and splits it into datagrams
+func (gl *GELFLogger) chunk(msg []byte) (packets [][]byte, err error) {
+	if gl.config.Compress {
+		buf := &bytes.Buffer{}
+
+		zw := gzip.NewWriter(buf)
+		zw.Write(msg)
+
+		if err = zw.Close(); err != nil {
+			return
+		}
+
+		msg = buf.Bytes()
+	}
+
+	if len(msg) <= gl.config.ChunkSize {
+		return [][]byte{msg}, nil
+	}
+
+	size := gl.config.ChunkSize - gelfChunkHeader
+	count := (len(msg) + size - 1) / size
+
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes needs %d chunks, more than the maximum of %d", len(msg), count, gelfMaxChunks)
+	}
+
+	id := make([]byte, 8)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		p := make([]byte, 0, gelfChunkHeader+end-i*size)
+		p = append(p, 0x1e, 0x0f)
+		p = append(p, id...)
+		p = append(p, byte(i), byte(count))
+		p = append(p, msg[i*size:end]...)
+
+		packets = append(packets, p)
+	}
+
+	return
+}
+
+// FormatGELF formats a LogEntry as a GELF 1.1 message from host
+func FormatGELF(l LogEntry, host string) ([]byte, error) {
+	fields, err := entryFields(l)
+	if err != nil {
+		return nil, err
+	}
+
+	t := l.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": strings.TrimSpace(fmt.Sprintf("%s %s %d", l.Method, l.URL, l.Status)),
+		"timestamp":     float64(t.UnixNano()) / float64(time.Second),
+		"level":         int(StatusSeverity(l.Status)),
+	}
+
+	for _, f := range fields {
+		key := "_" + gelfKey(f.key)
+		if key == "_id" {
+			// reserved by GELF
+			continue
+		}
+
+		switch v := f.value.(type) {
+		case nil:
+			continue
+
+		case json.Number, string:
+			msg[key] = v
+
+		case []interface{}:
+			// GELF only allows strings and numbers
+			b, _ := json.Marshal(v)
+			msg[key] = string(b)
+
+		default:
+			msg[key] = fmt.Sprint(v)
+		}
+	}
+
+	return json.Marshal(msg)
+}
+
+// gelfKey replaces characters which aren't allowed in GELF field names
+func gelfKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+
+		return '_'
+	}, k)
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatGELF(t *testing.T) {
+	b, err := FormatGELF(LogEntry{
+		RequestID:       "abc",
+		Status:          503,
+		DurationMS:      12,
+		Method:          "GET",
+		URL:             "/search",
+		Time:            time.Unix(1500000000, 500000000),
+		ResponseHeaders: map[string]string{"Content-Type": "text/plain"},
+		Fields:          map[string]interface{}{"id": "reserved", "cached": true},
+	}, "web-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var msg map[string]interface{}
+	if err = json.Unmarshal(b, &msg); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for k, expect := range map[string]interface{}{
+		"version":                        "1.1",
+		"host":                           "web-1",
+		"short_message":                  "GET /search 503",
+		"timestamp":                      1500000000.5,
+		"level":                          float64(SeverityError),
+		"_request_id":                    "abc",
+		"_status":                        float64(503),
+		"_duration_ms":                   float64(12),
+		"_response_headers.Content-Type": "text/plain",
+		"_cached":                        "true",
+	} {
+		if msg[k] != expect {
+			t.Errorf("%s: expected %v, received %v", k, expect, msg[k])
+		}
+	}
+
+	if _, ok := msg["_id"]; ok {
+		t.Errorf("expected reserved _id field to be dropped")
+	}
+}
+
+func TestGELFLogger_Chunked(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer pc.Close()
+
+	gl, err := NewGELFLogger(GELFConfig{Address: pc.LocalAddr().String(), ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer gl.Close()
+
+	if err = gl.Ship(LogEntry{RequestID: "abc", Status: 200, URL: strings.Repeat("/a", 100)}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var chunks [][]byte
+	for {
+		buf := make([]byte, 128)
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if buf[0] != 0x1e || buf[1] != 0x0f {
+			t.Fatalf("expected chunk magic bytes, received %x", buf[:2])
+		}
+
+		chunks = append(chunks, buf[:n])
+		if int(buf[10]) == int(buf[11])-1 {
+			break
+		}
+	}
+
+	msg := &bytes.Buffer{}
+	for _, c := range chunks {
+		if !bytes.Equal(c[2:10], chunks[0][2:10]) {
+			t.Errorf("expected chunks to share a message ID")
+		}
+
+		msg.Write(c[gelfChunkHeader:])
+	}
+
+	if !json.Valid(msg.Bytes()) {
+		t.Errorf("expected reassembled chunks to be JSON, received %q", msg)
+	}
+}
+
+func TestGELFLogger_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		msg, _ := bufio.NewReader(conn).ReadString(0)
+		received <- msg
+	}()
+
+	gl, err := NewGELFLogger(GELFConfig{Network: "tcp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer gl.Close()
+
+	gl.Log(LogEntry{RequestID: "abc", Status: 200})
+
+	select {
+	case msg := <-received:
+		if !strings.HasSuffix(msg, "\x00") || !strings.Contains(msg, `"_request_id":"abc"`) {
+			t.Errorf("unexpected message %q", msg)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultGraphiteInterval is how often request metrics are pushed to
+// Graphite, unless configured otherwise
+const DefaultGraphiteInterval = 10 * time.Second
+
+// GraphiteConfig configures the metrics pushed by Graphite
+type GraphiteConfig struct {
+	// Address is that of Carbon's plaintext listener, such as
+	// `localhost:2003`
+	Address string
+
+	// Prefix is the hierarchy metrics are pushed under, such as
+	// `payments.web-1`
+	Prefix string
+
+	// Interval defaults to DefaultGraphiteInterval, and should match the
+	// resolution of Carbon's storage schema
+	Interval time.Duration
+}
+
+// Graphite pushes request metrics to Graphite over its plaintext protocol,
+// as described by c, every interval. For each route, method, and status
+// class handled in an interval, the following are pushed beneath
+// `<prefix>.http.<route>.<method>.<status class>`:
+//   - requests, errors, request_bytes, and response_bytes, the
+//     interval's totals;
+//   - duration_ms.mean, .max, .p50, .p90, and .p99; and
+//   - apdex.satisfied, .tolerating, .frustrated, and .score, for routes
+//     with an ApdexThreshold
+//
+// The requests in flight at the end of each interval, as per InFlightStats,
+// are pushed as `<prefix>.http.in_flight`, and for busy routes as
+// `<prefix>.http.<route>.in_flight`.
+//
+// Routes are named as per Statsd, so that `/users/:id` becomes `users._id`.
+// Percentiles are estimated from a sample of each interval's durations.
+// Admin and synthetic requests aren't pushed. Metrics for the final
+// interval are pushed by Shutdown.
+//
+// Graphite panics when c has no Address
+func (m *Middleware) Graphite(c GraphiteConfig) {
+	r, err := newGraphiteReporter(c)
+	if err != nil {
+		panic(err)
+	}
+
+	m.addReporter(r)
+}
+
+// graphiteWriter pushes points over the plaintext protocol
+type graphiteWriter struct {
+	config GraphiteConfig
+}
+
+// newGraphiteReporter returns a reporter pushing to c's Address. A
+// connection is made for each push, so that Carbon needn't be up yet
+func newGraphiteReporter(c GraphiteConfig) (*reporter, error) {
+	if c.Address == "" {
+		return nil, fmt.Errorf("graphite requires an address")
+	}
+
+	c.Prefix = strings.Trim(c.Prefix, ".")
+
+	if c.Interval <= 0 {
+		c.Interval = DefaultGraphiteInterval
+	}
+
+	gw := &graphiteWriter{config: c}
+
+	return newReporter(c.Interval, gw.write), nil
+}
+
+// write pushes rep to Carbon
+func (gw *graphiteWriter) write(rep report) (err error) {
+	ts := strconv.FormatInt(rep.at.Unix(), 10)
+
+	var buf bytes.Buffer
+	for _, p := range rep.points {
+		gw.lines(&buf, p, ts)
+	}
+
+	fmt.Fprintf(&buf, "%s %d %s\n", gw.name("http.in_flight"), rep.inFlight.Total, ts)
+
+	routes := make([]string, 0, len(rep.inFlight.Routes))
+	for route := range rep.inFlight.Routes {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		fmt.Fprintf(&buf, "%s %d %s\n", gw.name("http."+statsdName(route)+".in_flight"), rep.inFlight.Routes[route], ts)
+	}
+
+	conn, err := net.DialTimeout("tcp", gw.config.Address, 10*time.Second)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(buf.Bytes())
+
+	return
+}
+
+// name returns the metric name beneath the configured prefix
+func (gw *graphiteWriter) name(name string) string {
+	if gw.config.Prefix == "" {
+		return name
+	}
+
+	return gw.config.Prefix + "." + name
+}
+
+// lines writes p's metrics to buf, one per line, timestamped ts
+func (gw *graphiteWriter) lines(buf *bytes.Buffer, p reportPoint, ts string) {
+	name := gw.name(strings.Join([]string{"http", statsdName(p.Route), strings.ToLower(p.Method), p.Class}, "."))
+
+	mean := 0.0
+	if p.Requests > 0 {
+		mean = p.DurationSumMS / float64(p.Requests)
+	}
+
+	for _, metric := range []struct {
+		name  string
+		value float64
+	}{
+		{"requests", float64(p.Requests)},
+		{"errors", float64(p.Errors)},
+		{"request_bytes", float64(p.RequestBytes)},
+		{"response_bytes", float64(p.ResponseBytes)},
+		{"duration_ms.mean", mean},
+		{"duration_ms.max", p.DurationMaxMS},
+		{"duration_ms.p50", p.percentile(0.5)},
+		{"duration_ms.p90", p.percentile(0.9)},
+		{"duration_ms.p99", p.percentile(0.99)},
+	} {
+		fmt.Fprintf(buf, "%s.%s %s %s\n", name, metric.name, strconv.FormatFloat(metric.value, 'f', -1, 64), ts)
+	}
+
+	score, ok := p.apdex()
+	if !ok {
+		return
+	}
+
+	for _, metric := range []struct {
+		name  string
+		value float64
+	}{
+		{"apdex.satisfied", float64(p.Satisfied)},
+		{"apdex.tolerating", float64(p.Tolerating)},
+		{"apdex.frustrated", float64(p.Frustrated)},
+		{"apdex.score", score},
+	} {
+		fmt.Fprintf(buf, "%s.%s %s %s\n", name, metric.name, strconv.FormatFloat(metric.value, 'f', -1, 64), ts)
+	}
+}
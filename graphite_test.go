@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		for s := bufio.NewScanner(conn); s.Scan(); {
+			lines = append(lines, s.Text())
+		}
+
+		received <- lines
+	}()
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.Graphite(GraphiteConfig{Address: ln.Addr().String(), Prefix: "payments.web-1.", Interval: time.Hour})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err = m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var lines []string
+	select {
+	case lines = <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("expected metrics to be pushed on shutdown")
+	}
+
+	if len(lines) != 10 {
+		t.Fatalf("expected 10 metrics, received %q", lines)
+	}
+
+	for i, prefix := range []string{
+		"payments.web-1.http.users._id.get.4xx.requests 2 ",
+		"payments.web-1.http.users._id.get.4xx.errors 0 ",
+		"payments.web-1.http.users._id.get.4xx.request_bytes 0 ",
+		"payments.web-1.http.users._id.get.4xx.response_bytes 0 ",
+		"payments.web-1.http.users._id.get.4xx.duration_ms.mean ",
+		"payments.web-1.http.users._id.get.4xx.duration_ms.max ",
+		"payments.web-1.http.users._id.get.4xx.duration_ms.p50 ",
+		"payments.web-1.http.users._id.get.4xx.duration_ms.p90 ",
+		"payments.web-1.http.users._id.get.4xx.duration_ms.p99 ",
+		"payments.web-1.http.in_flight 0 ",
+	} {
+		if !strings.HasPrefix(lines[i], prefix) || len(strings.Fields(lines[i])) != 3 {
+			t.Errorf("expected %q, received %q", prefix, lines[i])
+		}
+	}
+}
+
+func TestReportPoint_percentile(t *testing.T) {
+	r := newReporter(time.Hour, func(rep report) error {
+		if p := rep.points[0]; p.percentile(0.5) != 50 || p.percentile(0.99) != 99 || p.DurationMaxMS != 100 {
+			t.Errorf("expected percentiles of 1ms to 100ms, received %v %v", p.percentile(0.5), p.percentile(0.99))
+		}
+
+		return nil
+	})
+
+	for i := 100; i > 0; i-- {
+		r.observe("/", LogEntry{Method: "GET", Status: 200}, time.Duration(i)*time.Millisecond)
+	}
+
+	r.close()
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// HandoffEnv is the environment variable through which Handoff tells
+	// the new process which inherited file descriptors listen on which
+	// addresses, as a comma separated list of `addr=fd`
+	HandoffEnv = "MIDDLEWARE_LISTEN_FDS"
+)
+
+// serving tracks the listeners and servers started by the server helpers,
+// so that they may be handed off and shut down
+type serving struct {
+	sync.Mutex
+
+	listeners map[string]*net.TCPListener
+	stops     []func(context.Context) error
+
+	// inherited holds listeners passed by a previous process, by address,
+	// until they're claimed by listen
+	inherited map[string]net.Listener
+	parsed    bool
+}
+
+// listen returns a listener for addr, inheriting it from a previous
+// process where possible
+func (m *Middleware) listen(addr string) (ln net.Listener, err error) {
+	m.serving.Lock()
+	defer m.serving.Unlock()
+
+	if !m.serving.parsed {
+		m.serving.parsed = true
+		m.serving.inherited, err = inheritedListeners(os.Getenv(HandoffEnv))
+		if err != nil {
+			return
+		}
+	}
+
+	ln, ok := m.serving.inherited[addr]
+	if ok {
+		delete(m.serving.inherited, addr)
+	} else if ln, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+
+	if tl, ok := ln.(*net.TCPListener); ok {
+		if m.serving.listeners == nil {
+			m.serving.listeners = make(map[string]*net.TCPListener)
+		}
+
+		m.serving.listeners[addr] = tl
+	}
+
+	return
+}
+
+// inheritedListeners parses the value of HandoffEnv
+func inheritedListeners(env string) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	if env == "" {
+		return listeners, nil
+	}
+
+	for _, pair := range strings.Split(env, ",") {
+		idx := strings.LastIndex(pair, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("%s: invalid listener %q", HandoffEnv, pair)
+		}
+
+		fd, err := strconv.Atoi(pair[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid listener %q", HandoffEnv, pair)
+		}
+
+		f := os.NewFile(uintptr(fd), pair[:idx])
+
+		// FileListener duplicates the descriptor, so the original is closed
+		ln, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: listener %q: %v", HandoffEnv, pair, err)
+		}
+
+		listeners[pair[:idx]] = ln
+	}
+
+	return listeners, nil
+}
+
+// track registers a server's shutdown func with Shutdown
+func (m *Middleware) track(stop func(context.Context) error) {
+	m.serving.Lock()
+	defer m.serving.Unlock()
+
+	m.serving.stops = append(m.serving.stops, stop)
+}
+
+// Handoff starts a new copy of the running binary, with the same arguments
+// and environment, passing it the sockets of every listener opened by
+// ListenAndServe (and friends). The new process begins accepting
+// connections on them as soon as it calls ListenAndServe with the same
+// addresses, so that no connection is refused. Follow Handoff with Shutdown
+// to drain this process, for zero downtime upgrades:
+//
+//	go func() {
+//		signal.Notify(upgrade, syscall.SIGUSR2)
+//		<-upgrade
+//
+//		if _, err := m.Handoff(); err == nil {
+//			m.Shutdown(context.Background())
+//		}
+//	}()
+//
+// Handoff relies on file descriptor inheritance, and so is unsupported on
+// Windows.
+func (m *Middleware) Handoff() (*os.Process, error) {
+	bin, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	m.serving.Lock()
+	defer m.serving.Unlock()
+
+	addrs := make([]string, 0, len(m.serving.listeners))
+	for addr := range m.serving.listeners {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Strings(addrs)
+
+	files := make([]*os.File, 0, len(addrs))
+	pairs := make([]string, 0, len(addrs))
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i, addr := range addrs {
+		f, err := m.serving.listeners[addr].File()
+		if err != nil {
+			return nil, fmt.Errorf("handoff %s: %v", addr, err)
+		}
+
+		files = append(files, f)
+
+		// ExtraFiles start after stdin, stdout, and stderr
+		pairs = append(pairs, addr+"="+strconv.Itoa(3+i))
+	}
+
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(handoffEnviron(os.Environ()), HandoffEnv+"="+strings.Join(pairs, ","))
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd.Process, nil
+}
+
+// handoffEnviron removes any inherited HandoffEnv from env
+func handoffEnviron(env []string) []string {
+	out := env[:0:0]
+	for _, e := range env {
+		if !strings.HasPrefix(e, HandoffEnv+"=") {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+// Shutdown gracefully stops the servers started by ListenAndServe (and
+// friends): they stop accepting connections, and in-flight requests are
+// allowed to complete, before waiting for queued log entries to be
+// written, and final metrics to be sent by periodic sinks such as Influx
+// and those added by AddReporter. Shutdown returns early, with an error,
+// when ctx is done first.
+//
+// fasthttp servers also wait for idle keep-alive connections to be closed
+// by their clients, so are usually bounded by ctx
+func (m *Middleware) Shutdown(ctx context.Context) (err error) {
+	m.serving.Lock()
+	stops := m.serving.stops
+	m.serving.stops = nil
+	m.serving.listeners = nil
+	m.serving.Unlock()
+
+	for _, stop := range stops {
+		if e := stop(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if e := m.queue.drain(ctx); e != nil && err == nil {
+		err = e
+	}
+
+	if e := m.reporters.close(); e != nil && err == nil {
+		err = e
+	}
+
+	return
+}
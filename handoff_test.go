@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := inheritedListeners(":8080=" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherited, ok := listeners[":8080"]
+	if !ok {
+		t.Fatalf("expected a listener for :8080, received %+v", listeners)
+	}
+
+	defer inherited.Close()
+
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Errorf("expected %s, received %s", ln.Addr(), inherited.Addr())
+	}
+
+	for _, env := range []string{":8080", ":8080=x"} {
+		if _, err := inheritedListeners(env); err == nil {
+			t.Errorf("%q: expected an error", env)
+		}
+	}
+}
+
+func TestHandoffEnviron(t *testing.T) {
+	env := handoffEnviron([]string{"HOME=/root", HandoffEnv + "=:80=3", "PATH=/bin"})
+	if len(env) != 2 || env[0] != "HOME=/root" || env[1] != "PATH=/bin" {
+		t.Errorf("unexpected environment %+v", env)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		handler interface{}
+	}{
+		{"net/http", TestAPI{}},
+		{"fasthttp", FHAPI{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMiddleware(test.handler)
+
+			ln, err := m.listen("127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			served := make(chan error, 1)
+			go func() { served <- m.serve(ln) }()
+
+			// fasthttp waits for idle keep-alive connections on shutdown
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+			resp, err := client.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if err := m.Shutdown(ctx); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			select {
+			case err := <-served:
+				if err != nil {
+					t.Errorf("expected serving to stop cleanly, received %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("expected serving to stop")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultSensitiveHeaders are redacted from logged headers unless
+// configured otherwise, as they carry credentials
+var DefaultSensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// adminHeaders carry the middleware's own credentials, so are redacted
+// whether or not they're among those registered with RedactHeaders
+var adminHeaders = map[string]bool{DebugHeader: true, "X-Admin-Token": true}
+
+// LogResponseHeaders records the named response headers, when present, in
+// each LogEntry's ResponseHeaders. This is useful for verifying caching and
+// content negotiation behaviour in production, for instance by logging
+// `Content-Type`, `Cache-Control` and `X-Cache`.
+//
+// Names are case insensitive.
+func (m *Middleware) LogResponseHeaders(names ...string) {
+	m.responseHeaders = appendHeaderNames(m.responseHeaders, names)
+}
+
+// LogRequestHeaders records the named request headers, when present, in
+// each LogEntry's RequestHeaders, such as `Accept` or a correlation header
+// set by an upstream proxy. Names are case insensitive.
+func (m *Middleware) LogRequestHeaders(names ...string) {
+	m.requestHeaders = appendHeaderNames(m.requestHeaders, names)
+}
+
+// RedactHeaders registers header names whose values are replaced with
+// RedactedValue wherever headers are logged, whether they're allowlisted
+// or captured by a RoutePolicy, in addition to DefaultSensitiveHeaders.
+// DebugHeader and X-Admin-Token are always redacted. Names are case
+// insensitive.
+func (m *Middleware) RedactHeaders(names ...string) {
+	if m.redactHeaders == nil {
+		m.redactHeaders = make(map[string]bool)
+	}
+
+	for _, n := range names {
+		m.redactHeaders[http.CanonicalHeaderKey(n)] = true
+	}
+}
+
+// HashRedactedHeaders replaces the values of redacted headers with an HMAC
+// of their value, keyed by key, rather than dropping them, so that
+// requests bearing the same credentials can be correlated without logging
+// them. Masked values take the form `REDACTED:<hex>`. A nil key generates
+// a random one, which correlates requests to this process only; share a
+// key between instances to correlate across them
+func (m *Middleware) HashRedactedHeaders(key []byte) {
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+
+	m.headerHashKey = key
+}
+
+// redactHeaderValues masks the values of redacted headers in h, which is
+// changed in place and returned
+func (m *Middleware) redactHeaderValues(h map[string]string) map[string]string {
+	for k, v := range h {
+		if !m.redactHeaders[k] && !adminHeaders[k] {
+			continue
+		}
+
+		if m.headerHashKey == nil {
+			h[k] = RedactedValue
+
+			continue
+		}
+
+		mac := hmac.New(sha256.New, m.headerHashKey)
+		mac.Write([]byte(v))
+
+		h[k] = RedactedValue + ":" + hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+
+	return h
+}
+
+func appendHeaderNames(existing, names []string) []string {
+	for _, n := range names {
+		existing = append(existing, http.CanonicalHeaderKey(n))
+	}
+
+	return existing
+}
+
+// pickHeader returns the allowlisted headers present in h
+func pickHeader(h http.Header, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string)
+	for _, n := range names {
+		if v, ok := h[n]; ok {
+			out[n] = strings.Join(v, ", ")
+		}
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
+
+// pickFasthttpHeader is pickHeader for fasthttp response headers
+func pickFasthttpHeader(h *fasthttp.ResponseHeader, names []string) map[string]string {
+	return pickVisitedHeader(h.VisitAll, names)
+}
+
+// pickFasthttpRequestHeader is pickHeader for fasthttp request headers
+func pickFasthttpRequestHeader(h *fasthttp.RequestHeader, names []string) map[string]string {
+	return pickVisitedHeader(h.VisitAll, names)
+}
+
+// pickVisitedHeader is pickHeader for headers walked by a fasthttp VisitAll
+// method
+func pickVisitedHeader(visitAll func(func(k, v []byte)), names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	all := make(map[string]string)
+	visitAll(visitHeader(all))
+
+	out := make(map[string]string)
+	for _, n := range names {
+		if v, ok := all[n]; ok {
+			out[n] = v
+		}
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
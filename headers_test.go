@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type TestCachedAPI struct{}
+
+func (ta TestCachedAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Header().Set("X-Internal", "secret")
+
+	fmt.Fprint(w, TestResponseBody)
+}
+
+func (ta TestCachedAPI) Handle(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set("Cache-Control", "max-age=60")
+	ctx.Response.Header.Set("X-Internal", "secret")
+
+	fmt.Fprint(ctx, TestResponseBody)
+}
+
+func TestLogResponseHeaders(t *testing.T) {
+	m := NewMiddleware(TestCachedAPI{})
+	m.LogResponseHeaders("content-type", "CACHE-CONTROL", "X-Cache")
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	expect := map[string]string{"Content-Type": "text/plain", "Cache-Control": "max-age=60"}
+	if len(l.ResponseHeaders) != len(expect) {
+		t.Errorf("expected %+v, received %+v", expect, l.ResponseHeaders)
+	}
+
+	for k, v := range expect {
+		if l.ResponseHeaders[k] != v {
+			t.Errorf("%s: expected %q, received %q", k, v, l.ResponseHeaders[k])
+		}
+	}
+}
+
+func TestPickFasthttpHeader(t *testing.T) {
+	c := &fasthttp.RequestCtx{}
+	TestCachedAPI{}.Handle(c)
+
+	h := pickFasthttpHeader(&c.Response.Header, appendHeaderNames(nil, []string{"cache-control"}))
+	if len(h) != 1 || h["Cache-Control"] != "max-age=60" {
+		t.Errorf("unexpected headers %+v", h)
+	}
+}
+
+func TestLogRequestHeaders(t *testing.T) {
+	m := NewMiddleware(TestCachedAPI{})
+	m.LogRequestHeaders("accept", "X-Correlation-ID", "x-api-token")
+	m.LogResponseHeaders("X-Internal")
+	m.RedactHeaders("X-API-Token", "x-internal")
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("X-Correlation-ID", "abc123")
+	r.Header.Set("X-Api-Token", "secret")
+	r.Header.Set("X-Unlisted", "ignored")
+
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	expect := map[string]string{"Accept": "application/json", "X-Correlation-Id": "abc123", "X-Api-Token": RedactedValue}
+	if len(l.RequestHeaders) != len(expect) {
+		t.Errorf("expected %+v, received %+v", expect, l.RequestHeaders)
+	}
+
+	for k, v := range expect {
+		if l.RequestHeaders[k] != v {
+			t.Errorf("%s: expected %q, received %q", k, v, l.RequestHeaders[k])
+		}
+	}
+
+	if l.ResponseHeaders["X-Internal"] != RedactedValue {
+		t.Errorf("expected a redacted response header, received %+v", l.ResponseHeaders)
+	}
+}
+
+func TestPickFasthttpRequestHeader(t *testing.T) {
+	c := &fasthttp.RequestCtx{}
+	c.Request.Header.Set("accept", "text/html")
+	c.Request.Header.Set("X-Other", "ignored")
+
+	h := pickFasthttpRequestHeader(&c.Request.Header, appendHeaderNames(nil, []string{"ACCEPT"}))
+	if len(h) != 1 || h["Accept"] != "text/html" {
+		t.Errorf("unexpected headers %+v", h)
+	}
+}
+
+func TestSensitiveHeaders(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/*", RoutePolicy{Capture: CaptureRequestHeaders})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Cookie", "session=secret")
+	r.Header.Set("X-Api-Key", "secret")
+	r.Header.Set("Accept", "text/html")
+
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	for _, h := range []string{"Authorization", "Cookie", "X-Api-Key"} {
+		if l.RequestHeaders[h] != RedactedValue {
+			t.Errorf("%s: expected %q, received %q", h, RedactedValue, l.RequestHeaders[h])
+		}
+	}
+
+	if l.RequestHeaders["Accept"] != "text/html" {
+		t.Errorf("expected other headers to be logged, received %+v", l.RequestHeaders)
+	}
+}
+
+func TestSensitiveHeaders_AdminHeaders(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.LogRequestHeaders(DebugHeader, "X-Admin-Token")
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(DebugHeader, "letmein")
+	r.Header.Set("X-Admin-Token", "sekrit")
+
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	for _, h := range []string{DebugHeader, "X-Admin-Token"} {
+		if l.RequestHeaders[h] != RedactedValue {
+			t.Errorf("%s: expected %q, received %q", h, RedactedValue, l.RequestHeaders[h])
+		}
+	}
+}
+
+func TestHashRedactedHeaders(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.HashRedactedHeaders([]byte("key"))
+
+	a := m.redactHeaderValues(map[string]string{"Authorization": "Bearer a"})
+	b := m.redactHeaderValues(map[string]string{"Authorization": "Bearer a"})
+	c := m.redactHeaderValues(map[string]string{"Authorization": "Bearer c"})
+
+	if !strings.HasPrefix(a["Authorization"], RedactedValue+":") || strings.Contains(a["Authorization"], "Bearer") {
+		t.Errorf("expected a hashed value, received %q", a["Authorization"])
+	}
+
+	if a["Authorization"] != b["Authorization"] {
+		t.Errorf("expected equal values to hash equally, received %q and %q", a["Authorization"], b["Authorization"])
+	}
+
+	if a["Authorization"] == c["Authorization"] {
+		t.Errorf("expected differing values to hash differently")
+	}
+
+	m.HashRedactedHeaders(nil)
+	if len(m.headerHashKey) != 32 {
+		t.Errorf("expected a random key, received %d bytes", len(m.headerHashKey))
+	}
+}
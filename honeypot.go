@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// AlertHoneypot is the Kind of alerts raised when a honeypot is hit
+	AlertHoneypot = "honeypot"
+)
+
+// AddHoneypots declares trap paths, such as `/.env` or `/admin.php`, which
+// no legitimate client has reason to request. Requests matching any of
+// patterns receive a `404 Not Found` without the wrapped handler being
+// called, and:
+//   - raise an AlertHoneypot alert with every Alerter;
+//   - ban the client for the ban cooldown, when bans are enabled; and
+//   - are counted per pattern under `honeypots` in the counters endpoint
+//
+// Like blocked requests, they're neither logged nor counted as requests.
+//
+// Patterns take the same form as those passed to AddRoutePolicy, and
+// AddHoneypots panics on a malformed pattern.
+func (m *Middleware) AddHoneypots(patterns ...string) {
+	for _, p := range patterns {
+		if err := m.honeypots.add(p, nil); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// honeypot springs the trap for a request, returning false when the path
+// isn't a honeypot
+func (m *Middleware) honeypot(path, url, remoteAddr, client, requestID string, now time.Time) bool {
+	pattern, _, ok := m.honeypots.match(path)
+	if !ok {
+		return false
+	}
+
+	m.honeypotHits.add(pattern, 1)
+
+	ip := clientIP(remoteAddr)
+	if m.anonymizer != nil {
+		ip = m.anonymizer.anonymizeSubject(ip, now)
+	}
+
+	m.alert(Alert{
+		Time:      now,
+		Kind:      AlertHoneypot,
+		Summary:   fmt.Sprintf("%s requested honeypot %s", ip, pattern),
+		Client:    ip,
+		URL:       url,
+		RequestID: requestID,
+	})
+
+	if m.bans != nil && !m.dryRun[DryRunHoneypots] {
+		b := m.bans.ban(client, "requested honeypot "+pattern, now)
+
+		m.audit(AuditEvent{
+			Time:    now,
+			Action:  "ban",
+			Subject: b.Client,
+			Reason:  b.Reason,
+			Until:   &b.Until,
+		})
+	}
+
+	return true
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddHoneypots(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddHoneypots("/.env", "/wp-admin/*")
+	m.EnableBans(BanPolicy{ErrorRate: 0.9})
+
+	alerts := make(chan Alert, 1)
+	m.AddAlerter(AlerterFunc(func(a Alert) { alerts <- a }))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	request := func(p string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", p, nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	if rec := request("/.env"); rec.Code != http.StatusNotFound || rec.Body.String() == TestResponseBody {
+		t.Errorf("expected honeypot to 404 without calling handler, received %d %q", rec.Code, rec.Body.String())
+	}
+
+	select {
+	case a := <-alerts:
+		if a.Kind != AlertHoneypot || a.Client != "203.0.113.7" || a.URL != "/.env" {
+			t.Errorf("unexpected alert %+v", a)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("expected an alert")
+	}
+
+	if rec := request("/"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected client to be banned, received %d", rec.Code)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if strings.Contains(string(logWriter.body), `"url":"/.env"`) {
+		t.Errorf("expected honeypot hit not to be logged, received %q", logWriter.body)
+	}
+
+	if !strings.Contains(string(logWriter.body), `requested honeypot /.env`) {
+		t.Errorf("expected a ban audit event, received %q", logWriter.body)
+	}
+
+	if !strings.Contains(string(m.sectionedCounters()), `"honeypots":{"/.env":1}`) {
+		t.Errorf("expected honeypot hit to be counted, received %s", m.sectionedCounters())
+	}
+}
+
+func TestAddHoneypots_WithoutBans(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddHoneypots("/admin.php")
+
+	for _, p := range []string{"/admin.php", "/"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+
+		if p == "/" && rec.Code != http.StatusOK {
+			t.Errorf("expected client not to be banned, received %d", rec.Code)
+		}
+	}
+}
+
+func TestAddHoneypots_AnonymizeIPs(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddHoneypots("/.env")
+	m.AnonymizeIPs(IPTruncate, 0)
+
+	alerts := make(chan Alert, 1)
+	m.AddAlerter(AlerterFunc(func(a Alert) { alerts <- a }))
+
+	r := httptest.NewRequest("GET", "/.env", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	select {
+	case a := <-alerts:
+		if a.Client != "203.0.113.0" || strings.Contains(a.Summary, "203.0.113.7") {
+			t.Errorf("expected an anonymised client, received %+v", a)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("expected an alert")
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// InFlightStats holds the number of requests being handled by the wrapped
+// handler right now, in total and per route pattern. Routes with nothing
+// in flight are left out
+type InFlightStats struct {
+	Total  int64            `json:"total"`
+	Routes map[string]int64 `json:"routes,omitempty"`
+}
+
+// InFlightStats returns the requests currently being handled by the
+// wrapped handler, per route pattern as per AddRoutePolicy, or `default`
+// for requests matching none. Route templates aren't known until a
+// request has been handled, so aren't used here.
+//
+// These are also served by the counters endpoint, and exported by
+// ExportMetrics, Influx, and Graphite, for saturation alerts and load
+// shedding decisions
+func (m *Middleware) InFlightStats() InFlightStats {
+	return InFlightStats{
+		Total:  atomic.LoadInt64(&m.inFlight),
+		Routes: m.inFlightRoutes.snapshot(),
+	}
+}
+
+// beginInFlight marks a request to route as in flight, returning a func to
+// call once it has been handled
+func (m *Middleware) beginInFlight(route, method string) func() {
+	key := routeKey("", route)
+
+	atomic.AddInt64(&m.inFlight, 1)
+	m.inFlightRoutes.add(key, 1)
+	done := m.beginMetrics(route, method)
+
+	return func() {
+		m.inFlightRoutes.add(key, -1)
+		atomic.AddInt64(&m.inFlight, -1)
+		done()
+	}
+}
+
+// inFlightRoutes gauges requests in flight per route. Its zero value is
+// ready to use
+type inFlightRoutes struct {
+	sync.Mutex
+
+	routes map[string]int64
+}
+
+func (ir *inFlightRoutes) add(route string, delta int64) {
+	ir.Lock()
+	defer ir.Unlock()
+
+	if ir.routes == nil {
+		ir.routes = make(map[string]int64)
+	}
+
+	// Idle routes are dropped, so that snapshots only hold busy ones
+	if ir.routes[route] += delta; ir.routes[route] == 0 {
+		delete(ir.routes, route)
+	}
+}
+
+func (ir *inFlightRoutes) snapshot() map[string]int64 {
+	ir.Lock()
+	defer ir.Unlock()
+
+	if len(ir.routes) == 0 {
+		return nil
+	}
+
+	out := make(map[string]int64, len(ir.routes))
+	for route, n := range ir.routes {
+		out[route] = n
+	}
+
+	return out
+}
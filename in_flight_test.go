@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInFlightStats(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	}))
+	m.SetLoggers()
+	m.AddRoutePolicy("/slow/*", RoutePolicy{})
+
+	for _, p := range []string{"/slow/1", "/slow/2", "/other"} {
+		go m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+		<-started
+	}
+
+	expect := InFlightStats{Total: 3, Routes: map[string]int64{"/slow/*": 2, defaultRouteKey: 1}}
+
+	if s := m.InFlightStats(); !reflect.DeepEqual(s, expect) {
+		t.Errorf("expected %+v, received %+v", expect, s)
+	}
+
+	if c := getCounters(t, m); c.InFlight == nil || !reflect.DeepEqual(*c.InFlight, expect) {
+		t.Errorf("expected %+v from the counters endpoint, received %+v", expect, c.InFlight)
+	}
+
+	for i := 0; i < 3; i++ {
+		release <- true
+	}
+
+	for deadline := time.Now().Add(time.Second); m.InFlight() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	if s := m.InFlightStats(); s.Total != 0 || s.Routes != nil {
+		t.Errorf("expected idle routes to be dropped, received %+v", s)
+	}
+}
@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInfluxMeasurement is the measurement request metrics are
+	// written to, unless configured otherwise
+	DefaultInfluxMeasurement = "http_requests"
+
+	// DefaultInfluxInterval is how often request metrics are written to
+	// InfluxDB, unless configured otherwise
+	DefaultInfluxInterval = 10 * time.Second
+
+	// influxDatagramSize keeps UDP writes within a typical MTU, so that
+	// they aren't fragmented
+	influxDatagramSize = 1400
+)
+
+// InfluxConfig configures the metrics written by Influx
+type InfluxConfig struct {
+	// URL is a write endpoint, such as `http://localhost:8086/write?db=metrics`
+	// for InfluxDB 1.x, or `http://localhost:8086/api/v2/write?org=acme&bucket=metrics`
+	// for 2.x
+	URL string
+
+	// Token, when set, is sent with writes to URL as `Authorization: Token ...`
+	Token string
+
+	// Address is that of a UDP listener, such as `localhost:8089`, to write
+	// to in place of URL
+	Address string
+
+	// Measurement defaults to DefaultInfluxMeasurement
+	Measurement string
+
+	// Tags are added to every point, for instance `{"host": "web-1"}`
+	Tags map[string]string
+
+	// Interval defaults to DefaultInfluxInterval
+	Interval time.Duration
+
+	// Client defaults to an http.Client with a ten second timeout
+	Client *http.Client
+}
+
+// Influx writes request metrics to InfluxDB, as described by c, every
+// interval. Each route, method, and status class handled in an interval
+// is a point in line protocol, such as:
+//
+//	http_requests,host=web-1,method=GET,route=/users/:id,status=2xx requests=12i,errors=0i,duration_ms_sum=140.2,duration_ms_max=31.9,request_bytes=0i,response_bytes=5120i 1495897080000000000
+//
+// Points for routes with an ApdexThreshold also have apdex_satisfied,
+// apdex_tolerating, and apdex_frustrated counts, and an apdex_score.
+//
+// The requests in flight at the end of each interval, as per InFlightStats,
+// are written to the measurement suffixed `_in_flight`: their total
+// without a route tag, and busy routes with one.
+//
+// Routes are as per MetricsExporter, so that series stay bounded. Admin and
+// synthetic requests aren't written. Metrics for the final interval are
+// written by Shutdown.
+//
+// Influx panics when c has neither a URL nor an Address, or has an
+// Address which can't be resolved
+func (m *Middleware) Influx(c InfluxConfig) {
+	r, err := newInfluxReporter(c)
+	if err != nil {
+		panic(err)
+	}
+
+	m.addReporter(r)
+}
+
+// influxWriter writes points in line protocol
+type influxWriter struct {
+	config InfluxConfig
+	conn   net.Conn
+}
+
+// newInfluxReporter returns a reporter writing to c's URL or, failing
+// that, Address
+func newInfluxReporter(c InfluxConfig) (r *reporter, err error) {
+	if c.URL == "" && c.Address == "" {
+		return nil, fmt.Errorf("influx requires a url or an address")
+	}
+
+	if c.Measurement == "" {
+		c.Measurement = DefaultInfluxMeasurement
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = DefaultInfluxInterval
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	iw := &influxWriter{config: c}
+
+	// As UDP is connectionless, the listener needn't be up yet
+	if c.URL == "" {
+		if iw.conn, err = net.Dial("udp", c.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	return newReporter(c.Interval, iw.write), nil
+}
+
+// write sends rep to InfluxDB
+func (iw *influxWriter) write(rep report) error {
+	var lines [][]byte
+	for _, p := range rep.points {
+		tags := map[string]string{"route": p.Route, "method": p.Method, "status": p.Class}
+		fields := fmt.Sprintf("requests=%di,errors=%di,duration_ms_sum=%s,duration_ms_max=%s,request_bytes=%di,response_bytes=%di",
+			p.Requests,
+			p.Errors,
+			strconv.FormatFloat(p.DurationSumMS, 'f', -1, 64),
+			strconv.FormatFloat(p.DurationMaxMS, 'f', -1, 64),
+			p.RequestBytes,
+			p.ResponseBytes,
+		)
+
+		if score, ok := p.apdex(); ok {
+			fields += fmt.Sprintf(",apdex_satisfied=%di,apdex_tolerating=%di,apdex_frustrated=%di,apdex_score=%s",
+				p.Satisfied,
+				p.Tolerating,
+				p.Frustrated,
+				strconv.FormatFloat(score, 'f', -1, 64),
+			)
+		}
+
+		lines = append(lines, iw.line(iw.config.Measurement, tags, fields, rep.at))
+	}
+
+	inFlight := iw.config.Measurement + "_in_flight"
+	lines = append(lines, iw.line(inFlight, nil, fmt.Sprintf("requests=%di", rep.inFlight.Total), rep.at))
+
+	routes := make([]string, 0, len(rep.inFlight.Routes))
+	for route := range rep.inFlight.Routes {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		fields := fmt.Sprintf("requests=%di", rep.inFlight.Routes[route])
+		lines = append(lines, iw.line(inFlight, map[string]string{"route": route}, fields, rep.at))
+	}
+
+	if iw.conn != nil {
+		return iw.writeUDP(lines)
+	}
+
+	return iw.writeHTTP(bytes.Join(lines, nil))
+}
+
+func (iw *influxWriter) writeHTTP(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, iw.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if iw.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+iw.config.Token)
+	}
+
+	resp, err := iw.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+		return fmt.Errorf("influx: unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// writeUDP sends lines in as few datagrams as will fit them, without
+// splitting any line
+func (iw *influxWriter) writeUDP(lines [][]byte) (err error) {
+	var datagram []byte
+
+	for _, line := range lines {
+		if len(datagram) > 0 && len(datagram)+len(line) > influxDatagramSize {
+			if _, err = iw.conn.Write(datagram); err != nil {
+				return
+			}
+
+			datagram = nil
+		}
+
+		datagram = append(datagram, line...)
+	}
+
+	_, err = iw.conn.Write(datagram)
+
+	return
+}
+
+// line returns a point in line protocol, with a trailing newline. The
+// configured tags are added to tags, which take precedence
+func (iw *influxWriter) line(measurement string, tags map[string]string, fields string, at time.Time) []byte {
+	all := make(map[string]string, len(iw.config.Tags)+len(tags))
+	for k, v := range iw.config.Tags {
+		all[k] = v
+	}
+
+	for k, v := range tags {
+		all[k] = v
+	}
+
+	tags = all
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+
+	b.WriteString(influxMeasurementEscaper.Replace(measurement))
+
+	for _, k := range keys {
+		// Line protocol has no way to write an empty tag value
+		if k == "" || tags[k] == "" {
+			continue
+		}
+
+		fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(k), influxTagEscaper.Replace(tags[k]))
+	}
+
+	fmt.Fprintf(&b, " %s %d\n", fields, at.UnixNano())
+
+	return b.Bytes()
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
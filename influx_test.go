@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInflux(t *testing.T) {
+	received := make(chan string, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token sekrit" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		received <- string(b)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte("hello"))
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.Influx(InfluxConfig{
+		URL:         s.URL + "/api/v2/write?org=acme&bucket=metrics",
+		Token:       "sekrit",
+		Measurement: "api requests",
+		Tags:        map[string]string{"host": "web-1", "region": "eu west"},
+		Interval:    time.Hour,
+	})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users/3", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var body string
+	select {
+	case body = <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("expected metrics to be written on shutdown")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 points, received %q", body)
+	}
+
+	for i, prefix := range []string{
+		`api\ requests,host=web-1,method=GET,region=eu\ west,route=/users/:id,status=2xx requests=2i,errors=0i,duration_ms_sum=`,
+		`api\ requests,host=web-1,method=POST,region=eu\ west,route=/users/:id,status=5xx requests=1i,errors=1i,duration_ms_sum=`,
+		`api\ requests_in_flight,host=web-1,region=eu\ west requests=0i `,
+	} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected %q, received %q", prefix, lines[i])
+		}
+	}
+
+	if !strings.Contains(lines[0], ",response_bytes=10i ") {
+		t.Errorf("expected response bytes to be summed, received %q", lines[0])
+	}
+}
+
+func TestInflux_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer pc.Close()
+
+	r, err := newInfluxReporter(InfluxConfig{Address: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Enough routes to need more than one datagram
+	for i := 0; i < 50; i++ {
+		r.observe("/route/"+strings.Repeat("x", i), LogEntry{Method: "GET", Status: 200}, time.Millisecond)
+	}
+
+	if err = r.close(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+
+	var points, datagrams int
+
+	buf := make([]byte, 2048)
+	for points < 51 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error, having read %d points: %+v", points, err)
+		}
+
+		if n > influxDatagramSize {
+			t.Errorf("expected datagrams of at most %d bytes, received %d", influxDatagramSize, n)
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n") {
+			if !strings.HasPrefix(line, DefaultInfluxMeasurement+",method=GET,route=/route/") && !strings.HasPrefix(line, DefaultInfluxMeasurement+"_in_flight ") {
+				t.Errorf("unexpected line %q", line)
+			}
+
+			points++
+		}
+
+		datagrams++
+	}
+
+	if datagrams < 2 {
+		t.Errorf("expected points to be split across datagrams")
+	}
+}
+
+func TestInflux_errors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer s.Close()
+
+	r, err := newInfluxReporter(InfluxConfig{URL: s.URL, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err = r.flush(); err != nil {
+		t.Errorf("expected an idle interval to write nothing, received %+v", err)
+	}
+
+	r.observe("/", LogEntry{Method: "GET", Status: 200}, time.Millisecond)
+
+	if err = r.close(); err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("expected the failed write to be returned, received %+v", err)
+	}
+
+	if _, err = newInfluxReporter(InfluxConfig{}); err == nil {
+		t.Errorf("expected an error without a url or address")
+	}
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// KafkaMessage is a single record to be published
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer publishes messages to Kafka. It is deliberately small, so
+// that any client library (sarama, segmentio/kafka-go, confluent-kafka-go)
+// can be adapted in a few lines without this package depending on one.
+//
+// Produce should return once every message is acknowledged, or with an
+// error describing why some weren't.
+type KafkaProducer interface {
+	Produce([]KafkaMessage) error
+}
+
+// KafkaProducerFunc allows a plain function to be used as a KafkaProducer
+type KafkaProducerFunc func([]KafkaMessage) error
+
+// Produce implements KafkaProducer
+func (f KafkaProducerFunc) Produce(msgs []KafkaMessage) error {
+	return f(msgs)
+}
+
+// KafkaConfig configures a KafkaLogger. Producer and Topic are required
+type KafkaConfig struct {
+	Producer KafkaProducer
+	Topic    string
+
+	// Encoding is either `json` (the default) or `avro`, as per EncodeAvro
+	Encoding string
+
+	// SchemaID, when set, frames Avro messages in the Confluent wire format
+	// (a zero byte, then the big endian schema ID) for use with a schema
+	// registry
+	SchemaID int32
+
+	// Key returns the message key for an entry, and defaults to the request
+	// ID, spreading entries evenly across partitions
+	Key func(LogEntry) []byte
+
+	// BatchSize and FlushInterval control batching, as per NewBatchLogger,
+	// and default to 100 entries and one second
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// OnError, when set, is called with the entries of any batch which
+	// couldn't be encoded or delivered
+	OnError func([]LogEntry, error)
+}
+
+// KafkaLogger implements middleware.Loggable, publishing entries to a Kafka
+// topic in asynchronous batches, for teams whose access logs feed
+// streaming pipelines.
+type KafkaLogger struct {
+	config  KafkaConfig
+	batcher *BatchLogger
+}
+
+// NewKafkaLogger returns a KafkaLogger configured by c. A missing producer
+// or topic is programmer error, and panics
+func NewKafkaLogger(c KafkaConfig) *KafkaLogger {
+	if c.Producer == nil || c.Topic == "" {
+		panic(fmt.Errorf("kafka logger requires a producer and topic"))
+	}
+
+	switch c.Encoding {
+	case "":
+		c.Encoding = "json"
+	case "json", "avro":
+	default:
+		panic(fmt.Errorf("unknown kafka encoding %q", c.Encoding))
+	}
+
+	if c.Key == nil {
+		c.Key = func(l LogEntry) []byte { return []byte(l.RequestID) }
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+
+	kl := &KafkaLogger{config: c}
+	kl.batcher = NewBatchLogger(kl, c.BatchSize, c.FlushInterval)
+
+	return kl
+}
+
+// Log implements middleware.Loggable
+func (kl *KafkaLogger) Log(l LogEntry) {
+	kl.batcher.Log(l)
+}
+
+// LogBatch implements middleware.BatchLoggable, publishing ls straight away
+func (kl *KafkaLogger) LogBatch(ls []LogEntry) {
+	if err := kl.Publish(ls); err != nil && kl.config.OnError != nil {
+		kl.config.OnError(ls, err)
+	}
+}
+
+// Ship implements middleware.Shipper, so a KafkaLogger can be spooled
+func (kl *KafkaLogger) Ship(l LogEntry) error {
+	return kl.Publish([]LogEntry{l})
+}
+
+// Flush publishes any pending entries immediately
+func (kl *KafkaLogger) Flush() {
+	kl.batcher.Flush()
+}
+
+// Close flushes any pending entries. The producer is left open
+func (kl *KafkaLogger) Close() error {
+	return kl.batcher.Close()
+}
+
+// Publish encodes ls and hands them to the producer as a single batch
+func (kl *KafkaLogger) Publish(ls []LogEntry) error {
+	msgs := make([]KafkaMessage, 0, len(ls))
+
+	for _, l := range ls {
+		value, err := kl.encode(l)
+		if err != nil {
+			return err
+		}
+
+		msgs = append(msgs, KafkaMessage{
+			Topic: kl.config.Topic,
+			Key:   kl.config.Key(l),
+			Value: value,
+		})
+	}
+
+	return kl.config.Producer.Produce(msgs)
+}
+
+func (kl *KafkaLogger) encode(l LogEntry) ([]byte, error) {
+	if kl.config.Encoding == "json" {
+		return json.Marshal(l)
+	}
+
+	b, err := EncodeAvro(l)
+	if err != nil || kl.config.SchemaID == 0 {
+		return b, err
+	}
+
+	framed := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(framed[1:], uint32(kl.config.SchemaID))
+
+	return append(framed, b...), nil
+}
+
+// AvroSchema is the Avro schema EncodeAvro writes. Core fields are typed;
+// everything else, including custom fields and headers, is carried in
+// `extra` as flattened strings, as per LogfmtLogger
+const AvroSchema = `{
+  "type": "record",
+  "name": "LogEntry",
+  "namespace": "middleware",
+  "fields": [
+    {"name": "schema_version", "type": "int"},
+    {"name": "duration_ms", "type": "double"},
+    {"name": "ip_address", "type": "string"},
+    {"name": "request_id", "type": "string"},
+    {"name": "status", "type": "int"},
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "url", "type": "string"},
+    {"name": "useragent", "type": "string"},
+    {"name": "method", "type": "string"},
+    {"name": "proto", "type": "string"},
+    {"name": "response_bytes", "type": "long"},
+    {"name": "extra", "type": {"type": "map", "values": "string"}}
+  ]
+}`
+
+// avroTyped lists the fields AvroSchema types explicitly, along with
+// `duration` which is redundant with duration_ms
+var avroTyped = map[string]bool{
+	"schema_version": true,
+	"duration":       true,
+	"duration_ms":    true,
+	"ip_address":     true,
+	"request_id":     true,
+	"status":         true,
+	"time":           true,
+	"url":            true,
+	"useragent":      true,
+	"method":         true,
+	"proto":          true,
+	"response_bytes": true,
+}
+
+// EncodeAvro encodes l as Avro binary, as per AvroSchema
+func EncodeAvro(l LogEntry) ([]byte, error) {
+	fields, err := entryFields(l)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := make(map[string]string)
+	for _, f := range fields {
+		if avroTyped[f.key] || f.value == nil {
+			continue
+		}
+
+		switch v := f.value.(type) {
+		case string:
+			extra[f.key] = v
+		case []interface{}:
+			b, _ := json.Marshal(v)
+			extra[f.key] = string(b)
+		default:
+			extra[f.key] = fmt.Sprint(v)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	avroLong(buf, int64(l.SchemaVersion))
+	binary.Write(buf, binary.LittleEndian, math.Float64bits(l.DurationMS))
+	avroString(buf, l.IPAddress)
+	avroString(buf, l.RequestID)
+	avroLong(buf, int64(l.Status))
+	avroLong(buf, l.Time.UnixNano()/int64(time.Microsecond))
+	avroString(buf, l.URL)
+	avroString(buf, l.UserAgent)
+	avroString(buf, l.Method)
+	avroString(buf, l.Proto)
+	avroLong(buf, int64(l.ResponseBytes))
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) > 0 {
+		avroLong(buf, int64(len(keys)))
+
+		for _, k := range keys {
+			avroString(buf, k)
+			avroString(buf, extra[k])
+		}
+	}
+
+	// end of map
+	avroLong(buf, 0)
+
+	return buf.Bytes(), nil
+}
+
+// avroLong writes a zigzag encoded variable length integer
+func avroLong(buf *bytes.Buffer, n int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutVarint(b, n)])
+}
+
+func avroString(buf *bytes.Buffer, s string) {
+	avroLong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
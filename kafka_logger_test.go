@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKafkaLogger(t *testing.T) {
+	var published []KafkaMessage
+
+	kl := NewKafkaLogger(KafkaConfig{
+		Producer: KafkaProducerFunc(func(msgs []KafkaMessage) error {
+			published = append(published, msgs...)
+
+			return nil
+		}),
+		Topic:     "access-logs",
+		BatchSize: 2,
+	})
+
+	kl.Log(LogEntry{RequestID: "a", Status: 200})
+
+	if len(published) != 0 {
+		t.Fatalf("expected entries to be batched, received %d", len(published))
+	}
+
+	kl.Log(LogEntry{RequestID: "b", Status: 404})
+
+	if len(published) != 2 {
+		t.Fatalf("expected 2 messages, received %d", len(published))
+	}
+
+	var l LogEntry
+	if err := json.Unmarshal(published[1].Value, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if published[1].Topic != "access-logs" || string(published[1].Key) != "b" || l.Status != 404 {
+		t.Errorf("unexpected message %+v", published[1])
+	}
+}
+
+func TestKafkaLogger_OnError(t *testing.T) {
+	var failed []LogEntry
+
+	kl := NewKafkaLogger(KafkaConfig{
+		Producer: KafkaProducerFunc(func([]KafkaMessage) error {
+			return fmt.Errorf("leader not available")
+		}),
+		Topic:   "access-logs",
+		OnError: func(ls []LogEntry, err error) { failed = ls },
+	})
+
+	kl.Log(LogEntry{RequestID: "a"})
+	kl.Flush()
+
+	if len(failed) != 1 || failed[0].RequestID != "a" {
+		t.Errorf("expected failed entries to be reported, received %+v", failed)
+	}
+}
+
+func TestEncodeAvro(t *testing.T) {
+	var value []byte
+
+	kl := NewKafkaLogger(KafkaConfig{
+		Producer: KafkaProducerFunc(func(msgs []KafkaMessage) error {
+			value = msgs[0].Value
+
+			return nil
+		}),
+		Topic:    "access-logs",
+		Encoding: "avro",
+		SchemaID: 42,
+	})
+
+	err := kl.Publish([]LogEntry{{
+		SchemaVersion: SchemaVersion,
+		RequestID:     "abc",
+		Status:        200,
+		Time:          time.Unix(1, 0),
+		Fields:        map[string]interface{}{"shard": "eu-1"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if value[0] != 0 || binary.BigEndian.Uint32(value[1:5]) != 42 {
+		t.Fatalf("expected confluent framing, received %x", value[:5])
+	}
+
+	r := bytes.NewReader(value[5:])
+
+	if v, _ := binary.ReadVarint(r); v != SchemaVersion {
+		t.Errorf("expected schema version %d, received %d", SchemaVersion, v)
+	}
+
+	// duration_ms, then empty ip_address
+	r.Seek(8, 1)
+	if v, _ := binary.ReadVarint(r); v != 0 {
+		t.Errorf("expected empty ip address, received length %d", v)
+	}
+
+	if v, _ := binary.ReadVarint(r); v != 3 {
+		t.Errorf("expected request id of length 3, received %d", v)
+	}
+
+	r.Seek(3, 1)
+	if v, _ := binary.ReadVarint(r); v != 200 {
+		t.Errorf("expected status 200, received %d", v)
+	}
+
+	if !bytes.Contains(value, []byte("shard")) || !bytes.HasSuffix(value, []byte("eu-1\x00")) {
+		t.Errorf("expected custom fields in extra map, received %q", value)
+	}
+}
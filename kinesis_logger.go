@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// kinesisMaxBatch is the most records PutRecords and PutRecordBatch accept
+	kinesisMaxBatch = 500
+
+	// DefaultKinesisRetryBackoff is how long a KinesisLogger waits before
+	// its first retry; waits double with each attempt
+	DefaultKinesisRetryBackoff = 100 * time.Millisecond
+)
+
+// kinesisRetryable lists error codes which are worth retrying; anything else
+// will fail again
+var kinesisRetryable = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"ServiceUnavailableException":            true,
+	"InternalFailure":                        true,
+	"KMSThrottlingException":                 true,
+}
+
+// KinesisRecord is a single record to be put to a stream
+type KinesisRecord struct {
+	// PartitionKey is ignored by Firehose
+	PartitionKey string
+	Data         []byte
+}
+
+// KinesisClient puts batches of records to a Kinesis Data Stream, or a
+// Firehose delivery stream. It is deliberately small, so that either
+// version of the AWS SDK can be adapted (wrapping PutRecords, or
+// PutRecordBatch) without this package depending on one.
+//
+// PutRecords returns an error code per record, as reported by AWS, with an
+// empty string for records which succeeded. err is reserved for the call
+// failing as a whole.
+type KinesisClient interface {
+	PutRecords(stream string, records []KinesisRecord) (errorCodes []string, err error)
+}
+
+// KinesisConfig configures a KinesisLogger. Client and Stream are required
+type KinesisConfig struct {
+	Client KinesisClient
+	Stream string
+
+	// Firehose newline terminates records, which Firehose needs to write
+	// one entry per line to S3 and the like
+	Firehose bool
+
+	// PartitionKey picks a record's partition key, and defaults to the
+	// request ID; Kinesis hashes keys, so this spreads entries evenly
+	PartitionKey func(LogEntry) string
+
+	// BatchSize defaults to, and can't exceed, 500. FlushInterval defaults
+	// to one second
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Retries is how many times records failing with a retryable error,
+	// such as ProvisionedThroughputExceededException, are resent, and
+	// defaults to 3. A negative value disables retries
+	Retries      int
+	RetryBackoff time.Duration
+
+	// OnError, when set, is called with any entries which couldn't be put
+	OnError func([]LogEntry, error)
+}
+
+// KinesisLogger implements middleware.Loggable, batching entries into
+// PutRecords (or PutRecordBatch) calls. Records which are throttled are
+// retried, with exponential back off, without resending the rest of
+// their batch.
+type KinesisLogger struct {
+	config  KinesisConfig
+	batcher *BatchLogger
+}
+
+// NewKinesisLogger returns a KinesisLogger configured by c. A missing client
+// or stream is programmer error, and panics
+func NewKinesisLogger(c KinesisConfig) *KinesisLogger {
+	if c.Client == nil || c.Stream == "" {
+		panic(fmt.Errorf("kinesis logger requires a client and stream"))
+	}
+
+	if c.PartitionKey == nil {
+		c.PartitionKey = func(l LogEntry) string { return l.RequestID }
+	}
+
+	if c.BatchSize <= 0 || c.BatchSize > kinesisMaxBatch {
+		c.BatchSize = kinesisMaxBatch
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+
+	switch {
+	case c.Retries == 0:
+		c.Retries = 3
+	case c.Retries < 0:
+		c.Retries = 0
+	}
+
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultKinesisRetryBackoff
+	}
+
+	kl := &KinesisLogger{config: c}
+	kl.batcher = NewBatchLogger(kl, c.BatchSize, c.FlushInterval)
+
+	return kl
+}
+
+// Log implements middleware.Loggable
+func (kl *KinesisLogger) Log(l LogEntry) {
+	kl.batcher.Log(l)
+}
+
+// LogBatch implements middleware.BatchLoggable, putting ls straight away
+func (kl *KinesisLogger) LogBatch(ls []LogEntry) {
+	failed, err := kl.put(ls)
+	if err != nil && kl.config.OnError != nil {
+		kl.config.OnError(failed, err)
+	}
+}
+
+// Ship implements middleware.Shipper, so a KinesisLogger can be spooled
+func (kl *KinesisLogger) Ship(l LogEntry) error {
+	_, err := kl.put([]LogEntry{l})
+
+	return err
+}
+
+// Flush puts any pending entries immediately
+func (kl *KinesisLogger) Flush() {
+	kl.batcher.Flush()
+}
+
+// Close flushes any pending entries
+func (kl *KinesisLogger) Close() error {
+	return kl.batcher.Close()
+}
+
+// put sends ls, retrying throttled records, and returns the entries which
+// ultimately failed
+func (kl *KinesisLogger) put(ls []LogEntry) (failed []LogEntry, err error) {
+	records := make([]KinesisRecord, 0, len(ls))
+	pending := make([]LogEntry, 0, len(ls))
+
+	for _, l := range ls {
+		data, mErr := json.Marshal(l)
+		if mErr != nil {
+			failed = append(failed, l)
+			err = mErr
+
+			continue
+		}
+
+		if kl.config.Firehose {
+			data = append(data, '\n')
+		}
+
+		key := kl.config.PartitionKey(l)
+		if key == "" {
+			key = newUUID()
+		}
+
+		records = append(records, KinesisRecord{PartitionKey: key, Data: data})
+		pending = append(pending, l)
+	}
+
+	backoff := kl.config.RetryBackoff
+
+	for attempt := 0; len(records) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		codes, pErr := kl.config.Client.PutRecords(kl.config.Stream, records)
+
+		var (
+			retryRecords []KinesisRecord
+			retryEntries []LogEntry
+		)
+
+		for i := range records {
+			code := ""
+			if pErr != nil {
+				code = pErr.Error()
+			} else if i < len(codes) {
+				code = codes[i]
+			}
+
+			switch {
+			case code == "":
+				continue
+
+			case (pErr != nil || kinesisRetryable[code]) && attempt < kl.config.Retries:
+				retryRecords = append(retryRecords, records[i])
+				retryEntries = append(retryEntries, pending[i])
+
+			default:
+				failed = append(failed, pending[i])
+				err = fmt.Errorf("putting records to %s: %s", kl.config.Stream, code)
+			}
+		}
+
+		records, pending = retryRecords, retryEntries
+	}
+
+	return
+}
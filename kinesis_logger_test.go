@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testKinesisClient struct {
+	calls   [][]KinesisRecord
+	respond func(call int, records []KinesisRecord) ([]string, error)
+}
+
+func (tk *testKinesisClient) PutRecords(stream string, records []KinesisRecord) ([]string, error) {
+	tk.calls = append(tk.calls, records)
+
+	return tk.respond(len(tk.calls), records)
+}
+
+func TestKinesisLogger_RetriesThrottledRecords(t *testing.T) {
+	client := &testKinesisClient{
+		respond: func(call int, records []KinesisRecord) ([]string, error) {
+			codes := make([]string, len(records))
+			if call == 1 {
+				codes[1] = "ProvisionedThroughputExceededException"
+				codes[2] = "AccessDeniedException"
+			}
+
+			return codes, nil
+		},
+	}
+
+	var failed []LogEntry
+
+	kl := NewKinesisLogger(KinesisConfig{
+		Client:       client,
+		Stream:       "access-logs",
+		Firehose:     true,
+		RetryBackoff: time.Millisecond,
+		OnError:      func(ls []LogEntry, err error) { failed = ls },
+	})
+
+	kl.LogBatch([]LogEntry{{RequestID: "a"}, {RequestID: "b"}, {RequestID: "c"}})
+
+	if len(client.calls) != 2 {
+		t.Fatalf("expected 2 calls, received %d", len(client.calls))
+	}
+
+	if retried := client.calls[1]; len(retried) != 1 || retried[0].PartitionKey != "b" {
+		t.Errorf("expected only the throttled record to be retried, received %+v", retried)
+	}
+
+	if len(failed) != 1 || failed[0].RequestID != "c" {
+		t.Errorf("expected the non-retryable record to fail, received %+v", failed)
+	}
+
+	if !bytes.HasSuffix(client.calls[0][0].Data, []byte("}\n")) {
+		t.Errorf("expected firehose records to be newline terminated")
+	}
+}
+
+func TestKinesisLogger_GivesUp(t *testing.T) {
+	client := &testKinesisClient{
+		respond: func(int, []KinesisRecord) ([]string, error) {
+			return nil, fmt.Errorf("connection reset")
+		},
+	}
+
+	kl := NewKinesisLogger(KinesisConfig{Client: client, Stream: "access-logs", Retries: 2, RetryBackoff: time.Millisecond})
+
+	if err := kl.Ship(LogEntry{}); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if len(client.calls) != 3 {
+		t.Errorf("expected 3 attempts, received %d", len(client.calls))
+	}
+
+	if client.calls[0][0].PartitionKey == "" {
+		t.Errorf("expected a partition key to be minted for entries without a request ID")
+	}
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lane classifies requests by priority. A request joins the first lane,
+// in LanePolicy order, which any of Paths, the header, or Tenants match.
+type Lane struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Paths takes patterns of the same form as those passed to
+	// AddRoutePolicy
+	Paths []string `json:"paths" yaml:"paths"`
+
+	// Header matches requests carrying this header with one of
+	// HeaderValues, or with any value when HeaderValues is empty
+	Header       string   `json:"header" yaml:"header"`
+	HeaderValues []string `json:"header_values" yaml:"header_values"`
+
+	// Tenants matches requests whose TenantHeader holds one of these
+	Tenants []string `json:"tenants" yaml:"tenants"`
+
+	// Share is the fraction of MaxConcurrent in use above which requests
+	// in this lane are shed. It defaults to spreading lanes evenly: with
+	// three lanes, the first is shed at 100%, the second at 66%, and the
+	// third at 33%
+	Share float64 `json:"share" yaml:"share"`
+}
+
+// LanePolicy limits the number of requests handled at once, shedding low
+// priority traffic first. Shed requests receive a `503 Service Unavailable`
+// without the wrapped handler being called, and are counted per lane under
+// `shed` in the counters endpoint. The lane of every request is logged.
+type LanePolicy struct {
+	MaxConcurrent int `json:"max_concurrent" yaml:"max_concurrent"`
+
+	// Lanes are ordered highest priority first
+	Lanes []Lane `json:"lanes" yaml:"lanes"`
+
+	// Default names the lane of requests no lane matches, and defaults to
+	// the last lane
+	Default string `json:"default" yaml:"default"`
+}
+
+// SetLanes enables priority lanes as per p, and panics on an invalid policy
+func (m *Middleware) SetLanes(p LanePolicy) {
+	ll, err := newLaneLimiter(p)
+	if err != nil {
+		panic(err)
+	}
+
+	m.lanes = ll
+}
+
+// laneLimiter tracks requests in flight, admitting each against the limit
+// of its lane
+type laneLimiter struct {
+	sync.Mutex
+
+	lanes    []compiledLane
+	fallback int
+	inFlight int
+	shed     counterSet
+}
+
+type compiledLane struct {
+	Lane
+
+	paths   routeMatcher
+	values  map[string]bool
+	tenants map[string]bool
+	limit   int
+}
+
+func newLaneLimiter(p LanePolicy) (ll *laneLimiter, err error) {
+	if p.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("lanes: max concurrent must be positive")
+	}
+
+	if len(p.Lanes) == 0 {
+		return nil, fmt.Errorf("lanes: at least one lane is required")
+	}
+
+	ll = &laneLimiter{fallback: len(p.Lanes) - 1}
+	seen := make(map[string]bool)
+
+	for i, lane := range p.Lanes {
+		if lane.Name == "" || seen[lane.Name] {
+			return nil, fmt.Errorf("lanes: lane %d requires a unique name", i)
+		}
+
+		seen[lane.Name] = true
+
+		if lane.Share <= 0 {
+			lane.Share = float64(len(p.Lanes)-i) / float64(len(p.Lanes))
+		}
+
+		cl := compiledLane{
+			Lane:    lane,
+			values:  stringSet(lane.HeaderValues),
+			tenants: stringSet(lane.Tenants),
+			limit:   int(lane.Share * float64(p.MaxConcurrent)),
+		}
+
+		if cl.limit < 1 {
+			cl.limit = 1
+		}
+
+		for _, path := range lane.Paths {
+			if err = cl.paths.add(path, nil); err != nil {
+				return nil, fmt.Errorf("lanes: %s: %v", lane.Name, err)
+			}
+		}
+
+		if lane.Name == p.Default {
+			ll.fallback = i
+		}
+
+		ll.lanes = append(ll.lanes, cl)
+	}
+
+	if p.Default != "" && !seen[p.Default] {
+		return nil, fmt.Errorf("lanes: unknown default lane %q", p.Default)
+	}
+
+	return
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
+
+// classify returns the index of the lane a request belongs to
+func (ll *laneLimiter) classify(path, tenant string, header func(string) string) int {
+	for i, lane := range ll.lanes {
+		if _, _, ok := lane.paths.match(path); ok {
+			return i
+		}
+
+		if lane.Header != "" {
+			if v := header(lane.Header); v != "" && (len(lane.values) == 0 || lane.values[v]) {
+				return i
+			}
+		}
+
+		if tenant != "" && lane.tenants[tenant] {
+			return i
+		}
+	}
+
+	return ll.fallback
+}
+
+// admit classifies a request, and takes a slot for it unless its lane is
+// full. Admitted requests must be released
+func (ll *laneLimiter) admit(path, tenant string, header func(string) string) (lane string, shed bool) {
+	i := ll.classify(path, tenant, header)
+	lane = ll.lanes[i].Name
+
+	ll.Lock()
+	defer ll.Unlock()
+
+	if ll.inFlight >= ll.lanes[i].limit {
+		ll.shed.add(lane, 1)
+
+		return lane, true
+	}
+
+	ll.inFlight++
+
+	return lane, false
+}
+
+func (ll *laneLimiter) release() {
+	ll.Lock()
+	defer ll.Unlock()
+
+	ll.inFlight--
+}
+
+// admit applies concurrency limits, from warm-up and priority lanes, to a
+// request. Admitted requests must be released
+func (m *Middleware) admit(path string, header func(string) string, now time.Time) (lane string, shed bool) {
+	if m.warmUp != nil && !m.warmUp.admit(now) {
+		return "", true
+	}
+
+	if m.lanes == nil {
+		return
+	}
+
+	var tenant string
+	if m.TenantHeader != "" {
+		tenant = header(m.TenantHeader)
+	}
+
+	if lane, shed = m.lanes.admit(path, tenant, header); shed && m.warmUp != nil {
+		m.warmUp.release()
+	}
+
+	return
+}
+
+// release frees the slots taken by an admitted request
+func (m *Middleware) release() {
+	if m.warmUp != nil {
+		m.warmUp.release()
+	}
+
+	if m.lanes != nil {
+		m.lanes.release()
+	}
+}
+
+// shedCounts returns requests shed per lane, and during warm-up
+func (m *Middleware) shedCounts() (counts map[string]int64) {
+	if m.lanes != nil {
+		counts = m.lanes.shed.snapshot()
+	}
+
+	if m.warmUp == nil {
+		return
+	}
+
+	if n := atomic.LoadInt64(&m.warmUp.shed); n > 0 {
+		if counts == nil {
+			counts = make(map[string]int64)
+		}
+
+		counts[warmingShedKey] = n
+	}
+
+	return
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaneLimiter(t *testing.T) {
+	ll, err := newLaneLimiter(LanePolicy{
+		MaxConcurrent: 4,
+		Default:       "normal",
+		Lanes: []Lane{
+			{Name: "critical", Paths: []string{"/payments/*"}},
+			{Name: "normal"},
+			{Name: "bulk", Header: "X-Priority", HeaderValues: []string{"low"}, Tenants: []string{"batch-co"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	noHeaders := func(string) string { return "" }
+	lowPriority := func(k string) string {
+		if k == "X-Priority" {
+			return "low"
+		}
+
+		return ""
+	}
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		tenant string
+		header func(string) string
+		expect string
+	}{
+		{"path", "/payments/1", "", noHeaders, "critical"},
+		{"header", "/search", "", lowPriority, "bulk"},
+		{"tenant", "/search", "batch-co", noHeaders, "bulk"},
+		{"default", "/search", "", noHeaders, "normal"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ll.lanes[ll.classify(test.path, test.tenant, test.header)].Name; got != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, got)
+			}
+		})
+	}
+
+	// Limits are 4, 2 (rounded down from 2.67), and 1
+	for i, expect := range []bool{false, true} {
+		if _, shed := ll.admit("/search", "", lowPriority); shed != expect {
+			t.Errorf("bulk request %d: expected shed %v", i, expect)
+		}
+	}
+
+	for i, expect := range []bool{false, true} {
+		if _, shed := ll.admit("/search", "", noHeaders); shed != expect {
+			t.Errorf("normal request %d: expected shed %v", i, expect)
+		}
+	}
+
+	for i, expect := range []bool{false, false, true} {
+		if _, shed := ll.admit("/payments/1", "", noHeaders); shed != expect {
+			t.Errorf("critical request %d: expected shed %v", i, expect)
+		}
+	}
+
+	ll.release()
+
+	if _, shed := ll.admit("/payments/1", "", noHeaders); shed {
+		t.Errorf("expected a released slot to be reused")
+	}
+
+	if got := ll.shed.snapshot(); got["bulk"] != 1 || got["normal"] != 1 || got["critical"] != 1 {
+		t.Errorf("unexpected shed counts %+v", got)
+	}
+}
+
+func TestSetLanes_PanicsOnBadPolicy(t *testing.T) {
+	for _, p := range []LanePolicy{
+		{Lanes: []Lane{{Name: "a"}}},
+		{MaxConcurrent: 1},
+		{MaxConcurrent: 1, Lanes: []Lane{{Name: "a"}, {Name: "a"}}},
+		{MaxConcurrent: 1, Lanes: []Lane{{Name: "a"}}, Default: "b"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %+v to panic", p)
+				}
+			}()
+
+			NewMiddleware(TestAPI{}).SetLanes(p)
+		}()
+	}
+}
+
+func TestSetLanes(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	m.SetLanes(LanePolicy{MaxConcurrent: 1, Lanes: []Lane{{Name: "all"}}})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	go m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected request to be shed, received %d", rec.Code)
+	}
+
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"lane":"all"`) {
+		t.Errorf("expected lane to be logged, received %q", logWriter.body)
+	}
+
+	if !strings.Contains(string(m.sectionedCounters()), `"shed":{"all":1}`) {
+		t.Errorf("expected shed request to be counted, received %s", m.sectionedCounters())
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of latency histogram buckets
+// used by TrackLatency when given none
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram is the distribution of a route's request durations, as
+// shown under `latency` by the counters endpoint. Buckets are cumulative,
+// as with Prometheus, so each counts the requests which took at most its
+// LeMS; requests slower than every bucket are only in Count
+type LatencyHistogram struct {
+	Buckets []LatencyBucket `json:"buckets"`
+	Count   int64           `json:"count"`
+	SumMS   float64         `json:"sum_ms"`
+}
+
+// LatencyBucket is a bucket of a LatencyHistogram
+type LatencyBucket struct {
+	LeMS  float64 `json:"le_ms"`
+	Count int64   `json:"count"`
+}
+
+// TrackLatency keeps a histogram of request durations per route, with
+// buckets bounded by buckets, or DefaultLatencyBuckets when none are given.
+// Routes are keyed as per MetricsExporter, and exporters may share the
+// buckets; see LatencyBuckets. Admin and synthetic requests aren't tracked.
+//
+// TrackLatency panics unless buckets are positive and ascending
+func (m *Middleware) TrackLatency(buckets ...time.Duration) {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	for i, b := range buckets {
+		if b <= 0 || (i > 0 && b <= buckets[i-1]) {
+			panic(fmt.Errorf("latency buckets must be positive and ascending, received %v", buckets))
+		}
+	}
+
+	m.latency = &latencyHistograms{
+		buckets: buckets,
+		routes:  make(map[string]*latencyCounts),
+	}
+}
+
+// latencyHistograms holds a histogram per route
+type latencyHistograms struct {
+	sync.Mutex
+
+	buckets []time.Duration
+	routes  map[string]*latencyCounts
+}
+
+// latencyCounts counts durations per bucket, non-cumulatively
+type latencyCounts struct {
+	buckets []int64
+	count   int64
+	sum     time.Duration
+}
+
+// reset forgets every duration observed
+func (lh *latencyHistograms) reset() {
+	if lh == nil {
+		return
+	}
+
+	lh.Lock()
+	defer lh.Unlock()
+
+	lh.routes = make(map[string]*latencyCounts)
+}
+
+// observe records that a request to route took d
+func (lh *latencyHistograms) observe(route string, d time.Duration) {
+	if lh == nil {
+		return
+	}
+
+	lh.Lock()
+	defer lh.Unlock()
+
+	lc, ok := lh.routes[route]
+	if !ok {
+		lc = &latencyCounts{buckets: make([]int64, len(lh.buckets))}
+		lh.routes[route] = lc
+	}
+
+	lc.count++
+	lc.sum += d
+
+	for i, b := range lh.buckets {
+		if d <= b {
+			lc.buckets[i]++
+
+			break
+		}
+	}
+}
+
+// snapshot returns every route's histogram, or nil when latency isn't
+// tracked
+func (lh *latencyHistograms) snapshot() map[string]LatencyHistogram {
+	if lh == nil {
+		return nil
+	}
+
+	lh.Lock()
+	defer lh.Unlock()
+
+	if len(lh.routes) == 0 {
+		return nil
+	}
+
+	out := make(map[string]LatencyHistogram, len(lh.routes))
+	for route, lc := range lh.routes {
+		h := LatencyHistogram{
+			Buckets: make([]LatencyBucket, len(lh.buckets)),
+			Count:   lc.count,
+			SumMS:   durationMS(lc.sum),
+		}
+
+		var cumulative int64
+		for i, b := range lh.buckets {
+			cumulative += lc.buckets[i]
+			h.Buckets[i] = LatencyBucket{LeMS: durationMS(b), Count: cumulative}
+		}
+
+		out[route] = h
+	}
+
+	return out
+}
+
+// LatencyBuckets returns the bucket bounds of the histograms kept by
+// TrackLatency, or nil when it hasn't been called, so that exporters can
+// share them
+func (m *Middleware) LatencyBuckets() []time.Duration {
+	if m.latency == nil {
+		return nil
+	}
+
+	return append([]time.Duration(nil), m.latency.buckets...)
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
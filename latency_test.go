@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistograms(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.TrackLatency(10*time.Millisecond, 100*time.Millisecond)
+
+	for _, d := range []time.Duration{time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, time.Second} {
+		m.latency.observe("/a", d)
+	}
+
+	expect := map[string]LatencyHistogram{
+		"/a": {
+			Buckets: []LatencyBucket{{LeMS: 10, Count: 2}, {LeMS: 100, Count: 3}},
+			Count:   4,
+			SumMS:   1061,
+		},
+	}
+
+	if received := m.latency.snapshot(); !reflect.DeepEqual(expect, received) {
+		t.Errorf("expected %+v, received %+v", expect, received)
+	}
+}
+
+func TestTrackLatency_invalid(t *testing.T) {
+	for _, buckets := range [][]time.Duration{{0}, {time.Second, time.Millisecond}, {time.Second, time.Second}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for %v", buckets)
+				}
+			}()
+
+			NewMiddleware(TestAPI{}).TrackLatency(buckets...)
+		}()
+	}
+}
+
+func TestServeHTTP_Latency(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/users/*", RoutePolicy{})
+	m.TrackLatency(7*time.Millisecond, time.Second)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	var c countersPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	h, ok := c.Latency["/users/*"]
+	if !ok || h.Count != 2 || len(h.Buckets) != 2 || h.Buckets[len(h.Buckets)-1].Count != 2 {
+		t.Errorf("expected a histogram of both requests, received %+v", c.Latency)
+	}
+
+	if _, ok := c.Latency[adminPrefix+"counters"]; ok || len(c.Latency) != 1 {
+		t.Errorf("expected only the /users/* route, received %+v", c.Latency)
+	}
+}
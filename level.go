@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a LogEntry. Loggers may ignore entries below a
+// level with LevelAtLeast, so that a verbose debug sink and a terse
+// production sink can be used together
+type Level int
+
+const (
+	// LevelDebug is for entries of little interest, such as healthchecks,
+	// as set by RoutePolicy.Level, and synthetic traffic which succeeded
+	LevelDebug Level = iota + 1
+
+	// LevelInfo is for successful requests
+	LevelInfo
+
+	// LevelWarn is for 4xx responses, slow or over budget requests, and
+	// requests which DryRun features would have rejected
+	LevelWarn
+
+	// LevelError is for 5xx responses, and failed requests
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// ParseLevel parses a level's name, such as `warn`
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown level %q", s)
+}
+
+// String implements fmt.Stringer
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (l *Level) UnmarshalText(b []byte) (err error) {
+	*l, err = ParseLevel(string(b))
+
+	return
+}
+
+// entryLevel returns the level of l, based on its status and duration.
+// routeLevel, when set, replaces LevelInfo, such as to demote healthchecks
+func entryLevel(l LogEntry, routeLevel Level) Level {
+	switch {
+	case isErrorEntry(l):
+		return LevelError
+	case l.Status >= 400 || l.Slow || l.BudgetExceeded || len(l.WouldReject) > 0:
+		return LevelWarn
+	case l.Synthetic:
+		return LevelDebug
+	case routeLevel != 0:
+		return routeLevel
+	default:
+		return LevelInfo
+	}
+}
+
+// LevelAtLeast matches entries with at least level min. Entries logged
+// without a level, by loggers used outside of a Middleware, have theirs
+// worked out from their status
+func LevelAtLeast(min Level) Predicate {
+	return func(l LogEntry) bool {
+		return EntryLevel(l) >= min
+	}
+}
+
+// EntryLevel returns l's Level, or, for entries logged without one, by
+// loggers used outside of a Middleware, the level worked out from its
+// status
+func EntryLevel(l LogEntry) Level {
+	if l.Level != 0 {
+		return l.Level
+	}
+
+	return entryLevel(l, 0)
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, test := range []struct {
+		input  string
+		expect Level
+		err    bool
+	}{
+		{"debug", LevelDebug, false},
+		{"info", LevelInfo, false},
+		{"WARN", LevelWarn, false},
+		{"error", LevelError, false},
+		{"fatal", 0, true},
+		{"", 0, true},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			l, err := ParseLevel(test.input)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, received %v", test.err, err)
+			}
+
+			if l != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, l)
+			}
+		})
+	}
+}
+
+func TestEntryLevel(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		entry      LogEntry
+		routeLevel Level
+		expect     Level
+	}{
+		{"success", LogEntry{Status: 200}, 0, LevelInfo},
+		{"route level", LogEntry{Status: 200}, LevelDebug, LevelDebug},
+		{"client error", LogEntry{Status: 404}, LevelDebug, LevelWarn},
+		{"slow", LogEntry{Status: 200, Slow: true}, 0, LevelWarn},
+		{"server error", LogEntry{Status: 503}, 0, LevelError},
+		{"failed request", LogEntry{Error: "connection refused"}, 0, LevelError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if l := entryLevel(test.entry, test.routeLevel); l != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, l)
+			}
+		})
+	}
+}
+
+func TestLevelAtLeast(t *testing.T) {
+	p := LevelAtLeast(LevelWarn)
+
+	if p(LogEntry{Status: 200, Level: LevelInfo}) {
+		t.Errorf("expected info entries to be filtered")
+	}
+
+	if !p(LogEntry{Status: 200, Level: LevelError}) {
+		t.Errorf("expected error entries to pass")
+	}
+
+	if !p(LogEntry{Status: 404}) {
+		t.Errorf("expected the level of entries without one to be worked out")
+	}
+}
+
+func TestLevel_JSON(t *testing.T) {
+	b, err := json.Marshal(LogEntry{Level: LevelWarn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), `"level":"warn"`) {
+		t.Errorf("expected a level field, received %s", b)
+	}
+
+	var l LogEntry
+	if err = json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Level != LevelWarn {
+		t.Errorf("expected %v, received %v", LevelWarn, l.Level)
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ListenerPolicy describes how requests received on one of several
+// listeners sharing a Middleware differ, such as a public listener on
+// :443 and an internal one on :8081
+type ListenerPolicy struct {
+	// Name is recorded in each LogEntry's Listener
+	Name string
+
+	// Admin serves admin endpoints on this listener. Listeners without it
+	// pass admin paths through to the wrapped handler
+	Admin bool
+
+	// RateLimit, when it has a Rate, replaces the Middleware's rate limit
+	// for requests on this listener
+	RateLimit RateLimit
+}
+
+// Listener serves a Middleware as per a ListenerPolicy. It implements
+// both http.Handler and FasthttpHandler, and shares everything else, such
+// as loggers, routes, and counters, with its Middleware
+type Listener struct {
+	m       *Middleware
+	policy  ListenerPolicy
+	limiter *rateLimiter
+}
+
+// Listener returns a Listener serving m as per p:
+//
+//	m := middleware.NewMiddleware(API{})
+//	go m.Listener(middleware.ListenerPolicy{Name: "internal", Admin: true}).ListenAndServe(":8081")
+//	panic(m.Listener(middleware.ListenerPolicy{Name: "public", RateLimit: middleware.RateLimit{Rate: 10}}).ListenAndServe(":8080"))
+func (m *Middleware) Listener(p ListenerPolicy) *Listener {
+	return &Listener{
+		m:       m,
+		policy:  p,
+		limiter: newRateLimiter(p.RateLimit),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (ln *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ln.m.serveHTTP(w, r, ln)
+}
+
+// ServeFastHTTP serves fasthttp requests, as per Middleware.ServeFastHTTP
+func (ln *Listener) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	ln.m.serveFastHTTP(ctx, ln)
+}
+
+// Handle implements FasthttpHandler
+func (ln *Listener) Handle(ctx *fasthttp.RequestCtx) {
+	ln.ServeFastHTTP(ctx)
+}
+
+// ListenAndServe serves ln on addr, as per Middleware.ListenAndServe
+func (ln *Listener) ListenAndServe(addr string) error {
+	l, err := ln.m.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return ln.m.serveWith(l, ln, ln.ServeFastHTTP)
+}
+
+// The following methods treat a nil Listener, that is a request passed
+// straight to the Middleware, as having the Middleware's own policy
+
+func (ln *Listener) name() string {
+	if ln == nil {
+		return ""
+	}
+
+	return ln.policy.Name
+}
+
+func (ln *Listener) servesAdmin() bool {
+	return ln == nil || ln.policy.Admin
+}
+
+func (ln *Listener) rateLimiter(fallback *rateLimiter) *rateLimiter {
+	if ln == nil || ln.limiter == nil {
+		return fallback
+	}
+
+	return ln.limiter
+}
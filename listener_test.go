@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestListener(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	public := m.Listener(ListenerPolicy{Name: "public", RateLimit: RateLimit{Rate: 1}})
+	internal := m.Listener(ListenerPolicy{Name: "internal", Admin: true})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, test := range []struct {
+		name   string
+		h      http.Handler
+		path   string
+		status int
+	}{
+		{"internal admin", internal, "/__/counters", http.StatusOK},
+		{"public admin", public, "/__/counters", http.StatusOK},
+		{"public limited", public, "/", http.StatusTooManyRequests},
+		{"internal unlimited", internal, "/", http.StatusOK},
+		{"internal unlimited again", internal, "/", http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+
+			if w.Code != test.status {
+				t.Errorf("expected status %d, received %d", test.status, w.Code)
+			}
+
+			if test.name == "public admin" && w.Body.String() != "hello, world!" {
+				t.Errorf("expected admin paths to reach the wrapped handler, received %q", w.Body.String())
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, expect := range []string{`"listener":"public"`, `"listener":"internal"`} {
+		if !strings.Contains(string(logWriter.body), expect) {
+			t.Errorf("expected %s to be logged, received %q", expect, logWriter.body)
+		}
+	}
+}
+
+func TestListener_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+	public := m.Listener(ListenerPolicy{Name: "public"})
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/__/counters")
+
+	public.Handle(c)
+
+	if string(c.Response.Body()) != "hello, world!" {
+		t.Errorf("expected admin paths to reach the wrapped handler, received %q", c.Response.Body())
+	}
+}
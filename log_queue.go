@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLogQueueSize is the number of entries which may wait to be
+	// logged, counting an entry once per logger
+	DefaultLogQueueSize = 10000
+
+	// DefaultLogQueueWorkers is the number of goroutines logging entries
+	DefaultLogQueueWorkers = 8
+)
+
+// QueuePolicy decides what happens to entries when the log queue is full
+type QueuePolicy int
+
+const (
+	// QueueDrop drops entries while the queue is full
+	QueueDrop QueuePolicy = iota
+
+	// QueueBlock waits for space. Once the queue's workers are all busy
+	// too, requests wait to be logged before finishing, so a slow logger
+	// slows responses rather than piling up goroutines
+	QueueBlock
+
+	// QueueSample drops an increasing share of successful entries once the
+	// queue is half full, keeping room for errors, which are only dropped
+	// once the queue is full
+	QueueSample
+)
+
+// ParseQueuePolicy parses `drop`, `block`, or `sample` into a QueuePolicy
+func ParseQueuePolicy(s string) (p QueuePolicy, err error) {
+	switch s {
+	case "", "drop":
+		p = QueueDrop
+	case "block":
+		p = QueueBlock
+	case "sample":
+		p = QueueSample
+	default:
+		err = fmt.Errorf("unknown queue policy %q", s)
+	}
+
+	return
+}
+
+// LogQueue configures the queue entries wait in before being passed to
+// loggers. A fixed pool of Workers takes entries from the queue, and
+// another finishes requests' entries and counts them, so a slow logger
+// can't exhaust goroutines or memory. Zero values take defaults.
+type LogQueue struct {
+	Size    int
+	Workers int
+	Policy  QueuePolicy
+}
+
+// LogQueueStats describes the state of the log queue
+type LogQueueStats struct {
+	Queued  int   `json:"queued"`
+	Dropped int64 `json:"dropped"`
+}
+
+// SetLogQueue replaces the log queue. It panics once the queue has been
+// used, by serving requests or sending audit events or alerts, since
+// entries queued by then would be lost along with its workers
+func (m *Middleware) SetLogQueue(q LogQueue) {
+	if m.queue.used() {
+		panic("middleware: SetLogQueue called after the log queue was used")
+	}
+
+	m.queue = newLogQueue(q)
+}
+
+// logQueue is a bounded queue of entries for loggers, drained by a pool of
+// workers started on first use. Requests are finished, ahead of their
+// entries being queued, by a pool of their own, so that workers finishing
+// requests under QueueBlock can't wait on themselves
+type logQueue struct {
+	config   LogQueue
+	jobs     chan logJob
+	finishes chan func()
+	start    sync.Once
+	started  int32
+	dropped  int64
+
+	// pending counts jobs and finishes queued or being run, for drain
+	pending int64
+}
+
+// logJob logs entry to logger, or else calls send, which delivers an audit
+// event or alert
+type logJob struct {
+	logger Loggable
+	entry  LogEntry
+	send   func()
+}
+
+func newLogQueue(c LogQueue) *logQueue {
+	if c.Size <= 0 {
+		c.Size = DefaultLogQueueSize
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = DefaultLogQueueWorkers
+	}
+
+	return &logQueue{
+		config:   c,
+		jobs:     make(chan logJob, c.Size),
+		finishes: make(chan func(), c.Size),
+	}
+}
+
+// startWorkers starts the queue's workers, unless they're running
+func (q *logQueue) startWorkers() {
+	q.start.Do(func() {
+		atomic.StoreInt32(&q.started, 1)
+
+		for i := 0; i < q.config.Workers; i++ {
+			go q.work()
+			go q.runFinishes()
+		}
+	})
+}
+
+// used returns whether the queue's workers have been started
+func (q *logQueue) used() bool {
+	return atomic.LoadInt32(&q.started) == 1
+}
+
+// finish runs f, which finishes a request's entry and queues it, on the
+// queue's workers. When they're all busy and queued for, f is run by the
+// caller instead, so requests never leave goroutines behind them
+func (q *logQueue) finish(f func()) {
+	q.startWorkers()
+
+	atomic.AddInt64(&q.pending, 1)
+
+	select {
+	case q.finishes <- f:
+	default:
+		f()
+		atomic.AddInt64(&q.pending, -1)
+	}
+}
+
+// enqueue queues l for logger, as per the queue's policy
+func (q *logQueue) enqueue(logger Loggable, l LogEntry) {
+	q.startWorkers()
+
+	job := logJob{logger: logger, entry: l}
+
+	if q.config.Policy == QueueBlock {
+		atomic.AddInt64(&q.pending, 1)
+		q.jobs <- job
+
+		return
+	}
+
+	if q.config.Policy == QueueSample && !q.keep(l) {
+		atomic.AddInt64(&q.dropped, 1)
+
+		return
+	}
+
+	atomic.AddInt64(&q.pending, 1)
+
+	select {
+	case q.jobs <- job:
+	default:
+		atomic.AddInt64(&q.pending, -1)
+		atomic.AddInt64(&q.dropped, 1)
+	}
+}
+
+// dispatch queues send, which delivers an audit event or alert, to be
+// called by the queue's workers. It's never sampled, but is dropped while
+// the queue is full, unless the policy is QueueBlock
+func (q *logQueue) dispatch(send func()) {
+	q.startWorkers()
+
+	job := logJob{send: send}
+
+	atomic.AddInt64(&q.pending, 1)
+
+	if q.config.Policy == QueueBlock {
+		q.jobs <- job
+
+		return
+	}
+
+	select {
+	case q.jobs <- job:
+	default:
+		atomic.AddInt64(&q.pending, -1)
+		atomic.AddInt64(&q.dropped, 1)
+	}
+}
+
+// keep decides whether to queue l under QueueSample. The chance of keeping
+// a successful entry falls from 1, at half full, to 0 when full
+func (q *logQueue) keep(l LogEntry) bool {
+	if l.Status >= 400 || l.Error != "" {
+		return true
+	}
+
+	fill := float64(len(q.jobs)) / float64(cap(q.jobs))
+	if fill <= 0.5 {
+		return true
+	}
+
+	return rand.Float64() < 2*(1-fill)
+}
+
+func (q *logQueue) work() {
+	for job := range q.jobs {
+		if job.send != nil {
+			job.send()
+		} else {
+			job.logger.Log(job.entry)
+		}
+
+		atomic.AddInt64(&q.pending, -1)
+	}
+}
+
+func (q *logQueue) runFinishes() {
+	for f := range q.finishes {
+		f()
+		atomic.AddInt64(&q.pending, -1)
+	}
+}
+
+// drain waits for queued entries to be logged, or for ctx to be done
+func (q *logQueue) drain(ctx context.Context) error {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+
+	for atomic.LoadInt64(&q.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return nil
+}
+
+func (q *logQueue) stats() LogQueueStats {
+	return LogQueueStats{
+		Queued:  len(q.jobs),
+		Dropped: atomic.LoadInt64(&q.dropped),
+	}
+}
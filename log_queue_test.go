@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingLogger holds every entry until released
+type blockingLogger struct {
+	release chan struct{}
+
+	sync.Mutex
+	logged int
+}
+
+func (bl *blockingLogger) Log(LogEntry) {
+	<-bl.release
+
+	bl.Lock()
+	bl.logged++
+	bl.Unlock()
+}
+
+func TestLogQueue_Drop(t *testing.T) {
+	logger := &blockingLogger{release: make(chan struct{})}
+	q := newLogQueue(LogQueue{Size: 2, Workers: 1})
+
+	// The first entry is held by the worker, two are queued, and two are
+	// dropped
+	q.enqueue(logger, LogEntry{Status: 200})
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		q.enqueue(logger, LogEntry{Status: 200})
+	}
+
+	if stats := q.stats(); stats.Dropped != 2 || stats.Queued != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	close(logger.release)
+	time.Sleep(50 * time.Millisecond)
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.logged != 3 {
+		t.Errorf("expected 3 entries to be logged, received %d", logger.logged)
+	}
+}
+
+func TestLogQueue_BlockBoundsGoroutines(t *testing.T) {
+	logger := &blockingLogger{release: make(chan struct{})}
+
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers(logger)
+	m.SetLogQueue(LogQueue{Size: 1, Workers: 1, Policy: QueueBlock})
+
+	before := runtime.NumGoroutine()
+
+	// Requests wait to be logged once the queue and its workers are busy,
+	// rather than each leaving a goroutine behind
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := runtime.NumGoroutine() - before; n > 10 {
+		t.Errorf("expected a bounded number of goroutines, received %d more", n)
+	}
+
+	close(logger.release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected requests to finish once the logger was released")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.logged != 100 {
+		t.Errorf("expected every entry to be logged, received %d", logger.logged)
+	}
+}
+
+func TestLogQueue_Sample(t *testing.T) {
+	q := newLogQueue(LogQueue{Size: 4, Policy: QueueSample})
+
+	for i := 0; i < 4; i++ {
+		q.jobs <- logJob{}
+	}
+
+	if q.keep(LogEntry{Status: 200}) {
+		t.Errorf("expected successful entries to be dropped from a full queue")
+	}
+
+	if !q.keep(LogEntry{Status: 500}) {
+		t.Errorf("expected errors to be kept")
+	}
+
+	<-q.jobs
+	<-q.jobs
+
+	if !q.keep(LogEntry{Status: 200}) {
+		t.Errorf("expected successful entries to be kept in a half full queue")
+	}
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+	for s, expect := range map[string]QueuePolicy{"": QueueDrop, "drop": QueueDrop, "block": QueueBlock, "sample": QueueSample} {
+		if p, err := ParseQueuePolicy(s); err != nil || p != expect {
+			t.Errorf("%q: expected %v, received %v, %v", s, expect, p, err)
+		}
+	}
+
+	if _, err := ParseQueuePolicy("nope"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestSetLogQueue_PanicsOnceUsed(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.SetLogQueue(LogQueue{Size: 1})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	m.SetLogQueue(LogQueue{Size: 2})
+}
+
+func TestLogQueue_Alerts(t *testing.T) {
+	release := make(chan struct{})
+	sent := make(chan Alert, 10)
+
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.SetLogQueue(LogQueue{Size: 2, Workers: 1})
+	m.AddAlerter(AlerterFunc(func(a Alert) {
+		<-release
+		sent <- a
+	}))
+	m.queue.startWorkers()
+
+	before := runtime.NumGoroutine()
+
+	// Alerts wait for the queue's worker, rather than each taking a
+	// goroutine, and are dropped once the queue is full
+	for i := 0; i < 10; i++ {
+		m.alert(Alert{Kind: "test"})
+	}
+
+	if n := runtime.NumGoroutine() - before; n > 0 {
+		t.Errorf("expected no goroutines per alert, received %d more", n)
+	}
+
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := m.queue.drain(ctx); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(sent) < 1 || len(sent) > 3 {
+		t.Errorf("expected between 1 and 3 alerts to be sent, received %d", len(sent))
+	}
+
+	if s := m.queue.stats(); s.Dropped != int64(10-len(sent)) {
+		t.Errorf("expected %d alerts to be dropped, received %d", 10-len(sent), s.Dropped)
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// LogfmtLogger implements middleware.Loggable, writing entries as logfmt
+// key=value pairs rather than JSON, as per:
+//
+//	schema_version=1 duration=394.823µs duration_ms=0 ip_address=[::1]:62405 request_id=80d1b249-0b43-4adc-9456-e42e0b942ec0 status=200 time=2017-05-27T14:57:48.750350842+01:00 url=/ useragent="curl/7.51.0"
+//
+// Keys match those of the JSON output, with nested data (such as headers)
+// flattened into dotted keys. This suits aggregation pipelines which prefer
+// logfmt, such as Heroku style log drains or Grafana Loki.
+type LogfmtLogger struct {
+	lock   sync.Mutex
+	output io.Writer
+}
+
+// NewLogfmtLogger returns a LogfmtLogger writing to w
+func NewLogfmtLogger(w io.Writer) *LogfmtLogger {
+	return &LogfmtLogger{output: w}
+}
+
+// Log implements middleware.Loggable
+func (ll *LogfmtLogger) Log(l LogEntry) {
+	line, err := FormatLogfmt(l)
+	if err != nil {
+		line = "error=" + logfmtValue("error marshaling log data: "+err.Error())
+	}
+
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	io.WriteString(ll.output, line+"\n")
+}
+
+// FormatLogfmt formats a LogEntry as a single logfmt line, without a trailing newline
+func FormatLogfmt(l LogEntry) (string, error) {
+	fields, err := entryFields(l)
+	if err != nil {
+		return "", err
+	}
+
+	pairs := make([]string, 0, len(fields))
+	for _, f := range fields {
+		pairs = append(pairs, logfmtKey(f.key)+"="+logfmtValue(f.value))
+	}
+
+	return strings.Join(pairs, " "), nil
+}
+
+// logfmtKey strips characters which aren't allowed in logfmt keys
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+
+		return r
+	}, k)
+}
+
+// logfmtValue renders v, quoting it when it contains anything which would
+// otherwise break parsing
+func logfmtValue(v interface{}) string {
+	var s string
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		s = t
+	default:
+		s = fmt.Sprint(t)
+	}
+
+	if s == "" {
+		return `""`
+	}
+
+	if strings.ContainsAny(s, " =\"\\\t\r\n") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatLogfmt(t *testing.T) {
+	l := LogEntry{
+		SchemaVersion:   SchemaVersion,
+		RequestID:       "abc",
+		Status:          200,
+		Time:            time.Date(2017, 5, 27, 14, 57, 48, 0, time.UTC),
+		URL:             "/search?q=a b",
+		UserAgent:       `curl "7"`,
+		ResponseHeaders: map[string]string{"Content-Type": "text/plain"},
+		Fields:          map[string]interface{}{"shard": "eu-1"},
+	}
+
+	out, err := FormatLogfmt(l)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for _, expect := range []string{
+		"schema_version=1 ",
+		"request_id=abc ",
+		"status=200 ",
+		"time=2017-05-27T14:57:48Z ",
+		`url="/search?q=a b" `,
+		`useragent="curl \"7\"" `,
+		"response_headers.Content-Type=text/plain ",
+		"shard=eu-1",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("expected %q in %q", expect, out)
+		}
+	}
+
+	if !strings.HasPrefix(out, "schema_version=1 duration=") {
+		t.Errorf("expected fields in schema order, received %q", out)
+	}
+}
+
+func TestLogfmtLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewLogfmtLogger(buf).Log(LogEntry{Status: 404})
+
+	if !strings.Contains(buf.String(), "status=404") || !strings.HasSuffix(buf.String(), "\n") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
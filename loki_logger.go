@@ -0,0 +1,269 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LokiConfig configures a LokiLogger. Only URL is required, and is usually
+// of the form `http://loki.example.com:3100/loki/api/v1/push`
+type LokiConfig struct {
+	URL string
+
+	// Labels are attached to every stream, for instance `{"app": "search"}`
+	Labels map[string]string
+
+	// DynamicLabels names entry fields to use as labels, as they appear in
+	// JSON output (nested fields use dotted names, such as
+	// `response_headers.X-Cache`). `status_class`, the status as `2xx` and
+	// so on, is also available. Defaults to `status_class` and `method`.
+	//
+	// Every distinct combination of labels is a new stream, so high
+	// cardinality fields such as `url` or `request_id` should be avoided
+	DynamicLabels []string
+
+	// TenantID is sent as X-Scope-OrgID for multi-tenant Loki installations
+	TenantID string
+
+	// Username and Password, when set, are sent as basic auth
+	Username string
+	Password string
+
+	// BatchSize and FlushInterval control batching, as per NewBatchLogger,
+	// and default to 100 entries and one second
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Client defaults to an http.Client with a ten second timeout
+	Client *http.Client
+}
+
+// LokiLogger implements middleware.Loggable, batching entries and pushing
+// them to Grafana Loki. Each line is the JSON form of an entry.
+type LokiLogger struct {
+	config  LokiConfig
+	batcher *BatchLogger
+}
+
+// lokiStream is a set of lines sharing labels, as per the push API
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLokiLogger returns a LokiLogger configured by c
+func NewLokiLogger(c LokiConfig) *LokiLogger {
+	if c.DynamicLabels == nil {
+		c.DynamicLabels = []string{"status_class", "method"}
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	ll := &LokiLogger{config: c}
+	ll.batcher = NewBatchLogger(ll, c.BatchSize, c.FlushInterval)
+
+	return ll
+}
+
+// Log implements middleware.Loggable
+func (ll *LokiLogger) Log(l LogEntry) {
+	ll.batcher.Log(l)
+}
+
+// LogBatch implements middleware.BatchLoggable, pushing ls straight away
+func (ll *LokiLogger) LogBatch(ls []LogEntry) {
+	ll.Push(ls)
+}
+
+// Ship implements middleware.Shipper, so a LokiLogger can be spooled
+func (ll *LokiLogger) Ship(l LogEntry) error {
+	return ll.Push([]LogEntry{l})
+}
+
+// Flush sends any pending entries immediately
+func (ll *LokiLogger) Flush() {
+	ll.batcher.Flush()
+}
+
+// Close flushes any pending entries
+func (ll *LokiLogger) Close() error {
+	return ll.batcher.Close()
+}
+
+// Push sends ls to Loki in a single request
+func (ll *LokiLogger) Push(ls []LogEntry) error {
+	body, err := ll.payload(ls)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ll.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if ll.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", ll.config.TenantID)
+	}
+
+	if ll.config.Username != "" || ll.config.Password != "" {
+		req.SetBasicAuth(ll.config.Username, ll.config.Password)
+	}
+
+	resp, err := ll.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	return fmt.Errorf("loki responded %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// payload groups ls into streams by label set
+func (ll *LokiLogger) payload(ls []LogEntry) ([]byte, error) {
+	streams := make(map[string]*lokiStream)
+	order := make([]string, 0)
+
+	for _, l := range ls {
+		line, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+
+		labels, err := ll.labels(l)
+		if err != nil {
+			return nil, err
+		}
+
+		key := lokiStreamKey(labels)
+
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: labels}
+			streams[key] = s
+			order = append(order, key)
+		}
+
+		t := l.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(t.UnixNano(), 10), string(line)})
+	}
+
+	out := make([]*lokiStream, 0, len(order))
+	for _, key := range order {
+		out = append(out, streams[key])
+	}
+
+	return json.Marshal(map[string]interface{}{"streams": out})
+}
+
+func (ll *LokiLogger) labels(l LogEntry) (map[string]string, error) {
+	labels := make(map[string]string, len(ll.config.Labels)+len(ll.config.DynamicLabels))
+	for k, v := range ll.config.Labels {
+		labels[lokiLabelName(k)] = v
+	}
+
+	fields, err := entryFields(l)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		values[f.key] = f.value
+	}
+
+	for _, name := range ll.config.DynamicLabels {
+		if name == "status_class" {
+			labels[name] = statusClass(l.Status)
+
+			continue
+		}
+
+		if v, ok := values[name]; ok && v != nil {
+			if s := fmt.Sprint(v); s != "" {
+				labels[lokiLabelName(name)] = s
+			}
+		}
+	}
+
+	return labels, nil
+}
+
+// statusClass returns the class of status, such as `2xx`
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// lokiLabelName replaces characters which aren't valid in Prometheus style
+// label names
+func lokiLabelName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+
+		return '_'
+	}, name)
+
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := &strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteByte(',')
+	}
+
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLokiLogger(t *testing.T) {
+	var (
+		tenant  string
+		payload struct {
+			Streams []lokiStream `json:"streams"`
+		}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Scope-OrgID")
+
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ll := NewLokiLogger(LokiConfig{
+		URL:           srv.URL,
+		TenantID:      "team-a",
+		Labels:        map[string]string{"app": "search"},
+		DynamicLabels: []string{"status_class", "method", "response_headers.X-Cache"},
+	})
+
+	err := ll.Push([]LogEntry{
+		{RequestID: "a", Method: "GET", Status: 200, ResponseHeaders: map[string]string{"X-Cache": "HIT"}},
+		{RequestID: "b", Method: "GET", Status: 204, ResponseHeaders: map[string]string{"X-Cache": "HIT"}},
+		{RequestID: "c", Method: "POST", Status: 500},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if tenant != "team-a" {
+		t.Errorf("expected tenant header, received %q", tenant)
+	}
+
+	if len(payload.Streams) != 2 {
+		t.Fatalf("expected 2 streams, received %+v", payload.Streams)
+	}
+
+	first, second := payload.Streams[0], payload.Streams[1]
+
+	for k, v := range map[string]string{"app": "search", "status_class": "2xx", "method": "GET", "response_headers_X_Cache": "HIT"} {
+		if first.Stream[k] != v {
+			t.Errorf("%s: expected %q, received %q", k, v, first.Stream[k])
+		}
+	}
+
+	if len(first.Values) != 2 {
+		t.Errorf("expected 2 lines in first stream, received %d", len(first.Values))
+	}
+
+	if second.Stream["status_class"] != "5xx" || second.Stream["method"] != "POST" {
+		t.Errorf("unexpected labels %+v", second.Stream)
+	}
+
+	if _, ok := second.Stream["response_headers_X_Cache"]; ok {
+		t.Errorf("expected missing fields to be left out of labels")
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, expect := range map[int]string{200: "2xx", 404: "4xx", 503: "5xx", 0: "unknown"} {
+		if c := statusClass(status); c != expect {
+			t.Errorf("%d: expected %q, received %q", status, expect, c)
+		}
+	}
+}
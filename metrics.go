@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// MetricsExporter exports the requests a Middleware handles to a metrics
+// system, kept apart from this package so that it needn't depend on its
+// client. The prom subpackage exports them to Prometheus:
+//
+//	prom.Export(m, prom.Config{Namespace: "payments"})
+//
+// Requests are labelled by route, method, and status class (such as
+// `2xx`). Routes are the request's route template where known (see
+// RouteResolver), or else the pattern of the RoutePolicy it matched, and
+// unknown methods are `other`, so that labels stay bounded
+type MetricsExporter interface {
+	// Begin marks a request as in flight, returning a func to call once
+	// it has been handled
+	Begin(route, method string) (done func())
+
+	// Observe records a handled request, described by l, which took d
+	Observe(route, method, status string, l LogEntry, d time.Duration)
+
+	// Reset forgets every request observed, as per ResetCounters.
+	// Requests in flight are left, so that they're still done once
+	// handled
+	Reset()
+
+	// Metrics returns what's exported, in the format negotiated by an
+	// Accept header, for the `metrics` admin endpoint
+	Metrics(accept string) (body []byte, contentType string, err error)
+}
+
+// MetricsMerger is implemented by MetricsExporters which can serve the
+// metrics of several Middlewares at once, for a Registry. MergeMetrics is
+// passed the exporters of every member of the Registry by name, including
+// the MetricsMerger itself, and skips those it can't merge
+type MetricsMerger interface {
+	MergeMetrics(exporters map[string]MetricsExporter, accept string) (body []byte, contentType string, err error)
+}
+
+// ExportMetrics exports requests through e, as described by
+// MetricsExporter, and serves them from the `metrics` admin endpoint, such
+// as `/__/metrics`. Admin and synthetic requests aren't exported.
+//
+// ExportMetrics panics when e is nil
+func (m *Middleware) ExportMetrics(e MetricsExporter) {
+	if e == nil {
+		panic("middleware: ExportMetrics requires an exporter")
+	}
+
+	m.metrics = e
+	m.addAdminEndpoint("metrics", m.serveMetrics)
+}
+
+// prometheusConfigurer applies the prometheus and pushgateway sections of
+// a Config, as registered by RegisterPrometheus
+var prometheusConfigurer func(m *Middleware, c Config) error
+
+// RegisterPrometheus registers apply as what applies the prometheus and
+// pushgateway sections of a Config. The prom subpackage registers itself
+// when imported, which Apply requires when either section is set
+func RegisterPrometheus(apply func(m *Middleware, c Config) error) {
+	prometheusConfigurer = apply
+}
+
+// promMethods are the methods labelled, and counted, by name; any other is
+// labelled `other`, as clients may send whatever they like
+var promMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+func promMethod(method string) string {
+	if promMethods[method] {
+		return method
+	}
+
+	return "other"
+}
+
+// routeKey returns the key metrics for a request are kept under: its route
+// template where known, or else the pattern of the RoutePolicy it matched
+func routeKey(template, pattern string) string {
+	switch {
+	case template != "":
+		return template
+	case pattern != "":
+		return pattern
+	default:
+		return defaultRouteKey
+	}
+}
+
+// beginMetrics marks a request to route as in flight with m's exporter, if
+// any, returning a func to call once it has been handled
+func (m *Middleware) beginMetrics(route, method string) func() {
+	if m.metrics == nil {
+		return func() {}
+	}
+
+	return m.metrics.Begin(routeKey("", route), promMethod(method))
+}
+
+// observeMetrics exports a handled request to route, as per routeKey,
+// which took d
+func (m *Middleware) observeMetrics(route string, l LogEntry, d time.Duration) {
+	if m.metrics == nil {
+		return
+	}
+
+	m.metrics.Observe(route, promMethod(l.Method), statusClass(l.Status), l, d)
+}
+
+func (m *Middleware) serveMetrics(r adminRequest) adminResponse {
+	return metricsResponse(m.metrics.Metrics(r.header("Accept")))
+}
+
+// metricsResponse responds with metrics rendered by a MetricsExporter
+func metricsResponse(body []byte, contentType string, err error) adminResponse {
+	if err != nil {
+		return adminError(http.StatusInternalServerError, err)
+	}
+
+	return adminResponse{
+		status:      http.StatusOK,
+		contentType: contentType,
+		body:        body,
+	}
+}
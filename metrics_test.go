@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetricsExporter struct {
+	sync.Mutex
+
+	inFlight int
+	requests []string
+	merged   []string
+}
+
+func (e *testMetricsExporter) Begin(route, method string) func() {
+	e.Lock()
+	defer e.Unlock()
+
+	e.inFlight++
+
+	return func() {
+		e.Lock()
+		defer e.Unlock()
+
+		e.inFlight--
+	}
+}
+
+func (e *testMetricsExporter) Observe(route, method, status string, l LogEntry, d time.Duration) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.requests = append(e.requests, method+" "+route+" "+status)
+}
+
+func (e *testMetricsExporter) Reset() {
+	e.Lock()
+	defer e.Unlock()
+
+	e.requests = nil
+}
+
+func (e *testMetricsExporter) Metrics(accept string) ([]byte, string, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	return []byte(strings.Join(e.requests, "\n")), "text/plain", nil
+}
+
+func (e *testMetricsExporter) MergeMetrics(exporters map[string]MetricsExporter, accept string) ([]byte, string, error) {
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+
+	e.Lock()
+	e.merged = names
+	e.Unlock()
+
+	return []byte(strings.Join(names, ",")), "text/plain", nil
+}
+
+func (e *testMetricsExporter) observed() int {
+	e.Lock()
+	defer e.Unlock()
+
+	return len(e.requests)
+}
+
+func TestExportMetrics(t *testing.T) {
+	e := &testMetricsExporter{}
+
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.ExportMetrics(e)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/other", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "GET /users/:id 2xx\nother default 2xx" {
+		t.Errorf("expected bounded labels for each request, received %d %q", rec.Code, rec.Body.String())
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if e.inFlight != 0 {
+		t.Errorf("expected every request to be done, received %d in flight", e.inFlight)
+	}
+}
+
+func TestExportMetrics_PanicsWithoutExporter(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a nil exporter to panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).ExportMetrics(nil)
+}
+
+func TestRegistry_metrics(t *testing.T) {
+	r := NewRegistry()
+	r.Register("plain", NewMiddleware(TestAPI{}))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without exporters, received %d", rec.Code)
+	}
+
+	e := &testMetricsExporter{}
+	for _, name := range []string{"public", "internal"} {
+		m := NewMiddleware(TestAPI{})
+		m.ExportMetrics(e)
+
+		r.Register(name, m)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if rec.Code != http.StatusOK || len(e.merged) != 2 {
+		t.Errorf("expected every exporter to be merged, received %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConfig_PrometheusUnregistered(t *testing.T) {
+	defer func(apply func(*Middleware, Config) error) {
+		prometheusConfigurer = apply
+	}(prometheusConfigurer)
+
+	prometheusConfigurer = nil
+
+	c := Config{Prometheus: PrometheusConfig{Enabled: true}}
+	if err := c.Apply(NewMiddleware(TestAPI{})); err == nil {
+		t.Error("expected an error without the prom package")
+	}
+}
@@ -1,14 +1,21 @@
 package middleware
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"expvar"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/satori/go.uuid"
@@ -27,6 +34,10 @@ const (
 	//
 	// The URL used to seed this UUID is james-is-great.beamly.com. This domain
 	// does not exist and is, thus, safe to use.
+	//
+	// Deprecated: IDs are no longer shared when UUID generation fails;
+	// instead a fallback ID is minted, and counted under `uuid_failures` in
+	// the counters endpoint
 	DefaultBrokenUUID = "cd9bbcae-e076-549f-82bf-a08e8c838dd3"
 )
 
@@ -41,12 +52,106 @@ type FasthttpHandler interface {
 // Middleware handles and stores state for the middleware
 // it's self. It, by and large, wraps our handlers and loggers
 type Middleware struct {
+	// inFlight and generation are first, so that they are 64-bit aligned
+	// for atomic access on 32-bit platforms
+	inFlight   int64
+	generation uint64
+
 	handler interface{}
 	loggers []Loggable
+	routes  routeMatcher
+	skip    routeMatcher
+	admin   map[string]adminHandler
+	limiter *rateLimiter
+	debug   *DebugConfig
+	flags   FlagProvider
+
+	requestIDs *RequestIDPolicy
+	anonymizer *ipAnonymizer
+	bans       *banList
+	lanes      *laneLimiter
+	warmUp     *warmUp
+	queue      *logQueue
+	openAPI    *apiObserver
+	shadow     *shadow
+	statics    []*staticHandler
+	wellKnown  map[string]wellKnownFile
+	serving    serving
+
+	publicAdmin map[string]bool
+	cachedAdmin map[string]bool
+	epoch       string
+
+	budgetExceeded counterSet
+	responseBytes  counterSet
+	requestBytes   counterSet
+	blocklist      blocklist
+	honeypots      routeMatcher
+	rewrites       routeMatcher
+	honeypotHits   counterSet
+	costs          costLedger
+	alerters       []Alerter
+
+	logReferer      bool
+	redactParams    map[string]bool
+	redactPatterns  []string
+	resourceRate    float64
+	profiler        *profiler
+	newRelic        NewRelicApplication
+	runtimeStats    bool
+	extractors      []fieldExtractor
+	staticFields    map[string]interface{}
+	routeResolver   RouteResolver
+	counterKeys     routeTemplates
+	counterLRU      *counterLRU
+	expvars         *expvar.Map
+	syntheticMarker *SyntheticMarker
+	synthetics      int64
+	metrics         MetricsExporter
+	statsd          *statsdClient
+	inFlightRoutes  inFlightRoutes
+	reporters       reporters
+	tails           tails
+	quotas          *quotaSet
+	latency         *latencyHistograms
+	percentiles     *latencySketches
+	rejected        routeCounters
+	failures        routeCounters
+	statuses        routeCounters
+	methods         routeCounters
+	apdex           routeCounters
+	wouldReject     routeCounters
+	dryRun          map[string]bool
+	deadlines       *deadlinePolicy
+	requestHeaders  []string
+	responseHeaders []string
+	redactHeaders   map[string]bool
+	headerHashKey   []byte
+	retention       map[string]string
 
 	// Requests contains a hit counter for each route, minus sensitive data like passwords
 	// it is exported for use in telemetry and monitoring endpoints.
 	Requests map[string]*expvar.Int
+
+	// AdminToken, when set, must be presented by callers of the middleware's
+	// own endpoints (such as /__/counters) in either an `Authorization: Bearer`
+	// header or an `X-Admin-Token` header. Without one, only GET and HEAD
+	// requests are served; those which would change state, such as adding
+	// to the blocklist, are refused
+	AdminToken string
+
+	// TenantHeader names a request header identifying the tenant a request
+	// belongs to, such as `X-Tenant-ID`. Tenants are logged, and attached
+	// to the loggers returned by Logger
+	TenantHeader string
+
+	// AppLogger is the base of the loggers returned by Logger. When nil, JSON
+	// is written to STDOUT
+	AppLogger *slog.Logger
+
+	// StripQuery removes query strings from logged and counted URLs, so that
+	// `/search?q=a` and `/search?q=b` share a counter
+	StripQuery bool
 }
 
 // Loggable is an interface designed to.... log out
@@ -56,6 +161,8 @@ type Loggable interface {
 
 // LogEntry holds a particular requests data, metadata
 type LogEntry struct {
+	SchemaVersion int `json:"schema_version"`
+
 	Duration   string    `json:"duration"`
 	DurationMS float64   `json:"duration_ms"`
 	IPAddress  string    `json:"ip_address"`
@@ -64,6 +171,124 @@ type LogEntry struct {
 	Time       time.Time `json:"time"`
 	URL        string    `json:"url"`
 	UserAgent  string    `json:"useragent"`
+
+	Method        string `json:"method"`
+	Proto         string `json:"proto"`
+	Scheme        string `json:"scheme"`
+	ResponseBytes int    `json:"response_bytes"`
+
+	// RequestBytes is the size of the request body read, and ContentLength
+	// the size the client declared, if it did. They differ for chunked
+	// uploads, and for bodies the handler didn't read in full
+	RequestBytes  int64 `json:"request_bytes,omitempty"`
+	ContentLength int64 `json:"content_length,omitempty"`
+
+	// Referer is only recorded with LogReferer, and is redacted as URL is
+	Referer string `json:"referer,omitempty"`
+
+	// Route is the route template the request matched, such as
+	// `/users/:id`, when known; see SetRoute and RouteResolver
+	Route string `json:"route,omitempty"`
+
+	// SampleRate is set when the route this request matched is sampled,
+	// allowing downstream consumers to re-weight counts
+	SampleRate float64 `json:"sample_rate,omitempty"`
+
+	// Slow is set when a request exceeded its route's SlowThreshold
+	Slow bool `json:"slow,omitempty"`
+
+	// Level is the entry's severity, worked out from its status and
+	// duration; see Level
+	Level Level `json:"level,omitempty"`
+
+	// RequestBody and ResponseBody are only populated for routes
+	// whose RoutePolicy asks for them, and are truncated to MaxBodyBytes
+	RequestBody  string `json:"request_body,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+
+	// RequestHeaders and ResponseHeaders are populated for routes whose
+	// RoutePolicy asks for them, or with allowlisted headers such as those
+	// passed to LogRequestHeaders and LogResponseHeaders. Repeated headers are
+	// comma separated, and the values of headers passed to RedactHeaders are
+	// masked
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+
+	// BudgetExceeded is set when a request took longer than its
+	// route's latency Budget
+	BudgetExceeded bool `json:"budget_exceeded,omitempty"`
+
+	// Apdex is the request's Apdex zone, such as ApdexTolerating, as per
+	// its route's ApdexThreshold
+	Apdex string `json:"apdex,omitempty"`
+
+	// Debug is set when verbose logging was forced by DebugHeader
+	Debug bool `json:"debug,omitempty"`
+
+	// EarlyHintsMS is how long after the request arrived a `103 Early
+	// Hints` response was sent, if one was; see RoutePolicy.EarlyHints
+	EarlyHintsMS float64 `json:"early_hints_ms,omitempty"`
+
+	// Synthetic is set for synthetic traffic, such as uptime checks; see
+	// MarkSynthetic
+	Synthetic bool `json:"synthetic,omitempty"`
+
+	// Tenant is the value of the Middleware's TenantHeader, if any
+	Tenant string `json:"tenant,omitempty"`
+
+	// Retention is the retention class of this entry; see
+	// SetRetentionClasses
+	Retention string `json:"retention,omitempty"`
+
+	// ClientRequestID holds the request ID supplied by the client, when
+	// recorded separately; see RequestIDPolicy
+	ClientRequestID string `json:"client_request_id,omitempty"`
+
+	// Outbound is set for entries logged by a Transport, describing
+	// calls made to downstream services rather than incoming requests
+	Outbound bool `json:"outbound,omitempty"`
+
+	// Error holds the reason a request failed without a response, such
+	// as an outbound connection being refused
+	Error string `json:"error,omitempty"`
+
+	// ShadowDiff lists how the shadow handler's response differed from
+	// this one, when comparing; see ShadowPolicy
+	ShadowDiff []string `json:"shadow_diff,omitempty"`
+
+	// Flags holds the feature flag variants evaluated for this request
+	Flags map[string]string `json:"flags,omitempty"`
+
+	// OutsideWindow is set when a request was refused for arriving outside
+	// of its route's availability windows
+	OutsideWindow bool `json:"outside_window,omitempty"`
+
+	// Lane is the priority lane the request was classified into; see
+	// LanePolicy
+	Lane string `json:"lane,omitempty"`
+
+	// Listener names the Listener the request was received on, if any
+	Listener string `json:"listener,omitempty"`
+
+	// Resources is set for requests attributed as per AttributeResources
+	Resources *Resources `json:"resources,omitempty"`
+
+	// WouldReject lists the features in dry run which would have rejected
+	// the request; see DryRun
+	WouldReject []string `json:"would_reject,omitempty"`
+
+	// Panic and Stack describe a panic recovered from the wrapped handler,
+	// which was responded to with a `500 Internal Server Error`
+	Panic string `json:"panic,omitempty"`
+	Stack string `json:"stack,omitempty"`
+
+	// Cost holds the costs reported by the handler via AddCost
+	Cost map[string]float64 `json:"cost,omitempty"`
+
+	// Fields holds custom data, such as that added by AddField. Fields are
+	// flattened into the top level of JSON output unless logging in
+	// strict mode; see MarshalStrict
+	Fields map[string]interface{} `json:"-"`
 }
 
 // NewMiddleware takes either:
@@ -84,8 +309,28 @@ func NewMiddleware(h interface{}) (m *Middleware) {
 
 	m.handler = h
 	m.loggers = []Loggable{newDefaultLogger()}
+	m.queue = newLogQueue(LogQueue{})
+	m.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	m.RedactHeaders(DefaultSensitiveHeaders...)
+	if format := os.Getenv(LogFormatEnv); format != "" {
+		if l, err := (LoggerConfig{Format: format}).logger(m, new(closers)); err == nil {
+			m.loggers = []Loggable{l}
+		}
+
+		// The combined logger is then the only one, so referers may as
+		// well fill its referer column
+		if format == "combined" {
+			m.LogReferer()
+		}
+	}
+
 	m.Requests = make(map[string]*expvar.Int)
 
+	m.addCachedAdminEndpoint("counters", m.serveCounters)
+	m.addAdminEndpoint("counters/reset", m.serveCountersReset)
+	m.addAdminEndpoint("traffic", m.serveTraffic)
+	m.addCachedAdminEndpoint("blocklist", m.serveBlocklist)
+
 	return
 }
 
@@ -102,54 +347,389 @@ func (m *Middleware) AddLogger(l Loggable) {
 	m.loggers = append(m.loggers, l)
 }
 
+// SetLoggers replaces every logger, including the default STDOUT logger,
+// with ls. This allows, for instance, a LogfmtLogger to be used in place
+// of JSON output
+func (m *Middleware) SetLoggers(ls ...Loggable) {
+	m.loggers = ls
+}
+
 // ServeHTTP wraps our net/http requests and produces useful log lines.
 // This happens by intercepting the response which the default handler
 // responds with and then sending that on outselves. This approach adds
 // latency to a response, but it gives us access to things like status codes -
 // information which we absolutely need.
 //
+// As responses are buffered, streamed responses are only sent once the
+// handler returns, and Flush does nothing, unless the route's policy sets
+// Stream. Streamed responses are sent as they're written, so a handler
+// failing part way through can't change the status already sent; the
+// request ID and final status are sent as trailers instead, in
+// RequestIDHeader and StatusTrailer, and the entry logged has the final
+// status.
+//
 // Log lines are produced as per:
 //   {"duration":"394.823µs","ip_address":"[::1]:62405","request_id":"80d1b249-0b43-4adc-9456-e42e0b942ec0","status":200,"time":"2017-05-27T14:57:48.750350842+01:00","url":"/"}
 // where `sample-app` is the 'app' string passed into NewMiddleware()
 //
 // These logs are written to `STDOUT`
 func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.serveHTTP(w, r, nil)
+}
+
+// serveHTTP serves a net/http request, as received by ln, which is nil for
+// requests passed straight to ServeHTTP
+func (m *Middleware) serveHTTP(w http.ResponseWriter, r *http.Request, ln *Listener) {
 	resp := []byte{}
 	status := 200
 
 	rec := httptest.NewRecorder()
 
-	requestID := newUUID()
+	requestID, clientRequestID := m.requestID(r.Header.Get(RequestIDHeader))
 	t0 := time.Now()
 
-	if strings.HasSuffix(r.URL.String(), "/__/counters") {
-		resp = m.counters()
-	} else {
-		m.handler.(http.Handler).ServeHTTP(rec, r)
+	route, policy := m.policy(r.URL.Path)
 
-		if r.URL.User != nil {
-			_, set := r.URL.User.Password()
-			if set {
-				// ensure passwords aren't leaked
-				r.URL.User = url.User(r.URL.User.Username())
-			}
+	debug := m.debugRequest(r.Header.Get(DebugHeader), t0)
+	if debug {
+		policy = debugPolicy(policy)
+	}
+
+	synthetic := m.synthetic(r.Header.Get)
+
+	var (
+		flags   map[string]string
+		costs   map[string]float64
+		tenant  string
+		lane    string
+		blocked bool
+		known   bool
+		trapped bool
+		banned  bool
+		limited bool
+		spent   bool
+		shed    bool
+		closed  bool
+		would   []string
+		crashed *crash
+		stream  *streamWriter
+		run     *shadowRun
+		used    *Resources
+		expires time.Time
+		hinted  time.Time
+		tmpl    string
+		txn     NewRelicTransaction
+	)
+
+	var reqBody *bodyCapture
+	if policy.Capture.Has(CaptureRequestBody) && r.Body != nil {
+		reqBody = newBodyCapture(r.Body, policy.maxBodyBytes())
+		r.Body = reqBody
+	}
+
+	var reqBytes *byteCounter
+	if r.Body != nil && r.Body != http.NoBody {
+		reqBytes = &byteCounter{ReadCloser: r.Body}
+		r.Body = reqBytes
+	}
+
+	client := m.banClient(r.RemoteAddr, r.Header.Get)
+
+	limiter := ln.rateLimiter(m.limiter)
+
+	endpoint, admin := m.adminEndpoint(r.URL.Path)
+	admin = admin && ln.servesAdmin()
+	if admin && m.streamsTail(endpoint, r.Method, r.Header.Get) {
+		m.serveTail(w, r)
+
+		return
+	}
+
+	if admin {
+		var body []byte
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
 		}
 
-		for k, v := range rec.Header() {
+		ar := m.serveAdmin(adminRequest{
+			method:   r.Method,
+			endpoint: endpoint,
+			query:    r.URL.Query(),
+			header:   r.Header.Get,
+			body:     body,
+		})
+
+		w.Header().Set("Content-Type", ar.contentType)
+		if ar.etag != "" {
+			w.Header().Set("ETag", ar.etag)
+		}
+		status, resp = ar.status, ar.body
+	} else if rule, ok := m.blocked(r.URL.Path); ok && m.enforce(DryRunBlocklist, &would) {
+		blocked = true
+		status = rule.Status
+		resp = []byte(http.StatusText(status))
+	} else if s, h, body, ok := m.wellKnownResponse(r.URL.Path); ok {
+		known = true
+		for k, v := range h {
 			w.Header()[k] = v
 		}
-		resp = rec.Body.Bytes()
-		status = rec.Code
+		status, resp = s, body
+	} else if retryAfter, ok := m.checkBan(client, t0); ok && m.enforce(DryRunBans, &would) {
+		banned = true
+		w.Header().Set("Retry-After", retryAfter)
+		status = http.StatusForbidden
+		resp = []byte(http.StatusText(status))
+	} else if m.honeypot(r.URL.Path, m.loggableURL(r.URL), r.RemoteAddr, client, requestID, t0) && m.enforce(DryRunHoneypots, &would) {
+		trapped = true
+		status = http.StatusNotFound
+		resp = []byte(http.StatusText(status))
+	} else if !policy.available(t0) {
+		closed = true
+
+		var retryAfter string
+		if status, retryAfter = policy.unavailable(t0); retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+
+		resp = []byte(http.StatusText(status))
+	} else if limiter != nil && !limiter.allow(clientIP(r.RemoteAddr), t0) && m.enforce(DryRunRateLimit, &would) {
+		limited = true
+		w.Header().Set("Retry-After", limiter.retryAfter())
+		status = http.StatusTooManyRequests
+		resp = []byte(http.StatusText(status))
+	} else if retryAfter, ok := m.checkQuota(r.Header.Get, w.Header().Set, t0); ok && m.enforce(DryRunQuotas, &would) {
+		spent = true
+		w.Header().Set("Retry-After", retryAfter)
+		status = http.StatusTooManyRequests
+		resp = []byte(http.StatusText(status))
+	} else if lane, shed = m.admit(r.URL.Path, r.Header.Get, t0); shed {
+		w.Header().Set("Retry-After", "1")
+		status = http.StatusServiceUnavailable
+		resp = []byte(http.StatusText(status))
+	} else {
+		st := m.newState(requestID, route, r.URL.Path, r.Header.Get)
+		if m.flags != nil {
+			st.flags = m.flags.Evaluate(r)
+		}
+
+		r = r.WithContext(withState(r.Context(), st))
+		flags, tenant, expires = st.flags, st.tenant, st.deadline
+
+		if !st.deadline.IsZero() {
+			ctx, cancel := context.WithDeadline(r.Context(), st.deadline)
+			defer cancel()
+
+			r = r.WithContext(ctx)
+		}
+
+		rw := m.rewrite(r.URL.Path)
+		hr := rw.rewriteRequest(r)
+
+		run = m.mirror(hr)
+
+		handler := m.handler.(http.Handler)
+		if s := m.static(hr.URL.Path); s != nil {
+			handler = s
+		}
+
+		hinted = policy.sendEarlyHints(w, r)
+
+		similar := profileKey(route, r.URL.Path)
+		m.profiler.begin(similar, time.Now())
+
+		probe := m.probeResources(debug)
+		started := time.Now()
+		txn = m.startTransaction(route, r.Method, r.URL.Path, synthetic)
+		done := m.beginInFlight(route, r.Method)
+		var hw http.ResponseWriter = rec
+		if policy.Stream {
+			stream = newStreamWriter(w, func(h http.Header, code int) int {
+				code = rw.rewriteResponse(code, h)
+				policy.Deprecation.annotateResponse(h)
+				h.Set(RequestIDHeader, requestID)
+
+				return code
+			})
+			hw = stream
+		}
+
+		crashed = protect(func() { handler.ServeHTTP(hw, hr) })
+		done()
+		m.profiler.finish(similar, time.Since(started))
+		used = probe.finish()
+		m.release()
+		costs = st.costSnapshot()
+		tmpl = st.routeTemplate()
+
+		if crashed.aborted() {
+			panic(http.ErrAbortHandler)
+		}
+
+		if crashed != nil {
+			m.alertCrash(crashed, m.loggableURL(r.URL), requestID, t0)
+		}
+
+		switch {
+		case stream != nil && stream.started:
+			// The response has begun, so a failure can only be told of
+			// by its trailers
+			status = stream.code
+			if crashed != nil {
+				status = http.StatusInternalServerError
+			}
+
+		default:
+			// Streams which haven't begun are responded to as though
+			// buffered
+			if stream != nil {
+				for k, v := range stream.header {
+					rec.Header()[k] = v
+				}
+			}
+
+			if crashed != nil {
+				rec = httptest.NewRecorder()
+				rec.Code = http.StatusInternalServerError
+				rec.Body.WriteString(http.StatusText(rec.Code))
+			}
+
+			rec.Code = rw.rewriteResponse(rec.Code, rec.Header())
+			policy.Deprecation.annotateResponse(rec.Header())
+
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			resp = rec.Body.Bytes()
+			status = rec.Code
+		}
+
+		m.observe(r.Method, r.URL.Path, func() (names []string) {
+			for k := range r.URL.Query() {
+				names = append(names, k)
+			}
+
+			return
+		}, status)
 	}
 
-	w.Header().Set("X-Request-ID", requestID)
-	w.WriteHeader(status)
-	w.Write(resp)
+	if !admin && !known && !banned && !trapped && !shed && !closed && !spent {
+		m.recordBan(client, status, limited, t0)
+	}
+
+	m.recordRejection(route, banned, closed, limited, spent, shed)
+	if !admin {
+		m.changed()
+	}
+	m.recordDryRun(route, would)
+
+	var werr error
+	respBytes := len(resp)
+
+	if stream != nil && stream.started {
+		stream.finish(requestID, status)
+		werr, respBytes = stream.err, stream.bytes
+	} else {
+		w.Header().Set(RequestIDHeader, requestID)
+		w.WriteHeader(status)
+		_, werr = w.Write(resp)
+	}
+
+	if !admin && !synthetic {
+		m.recordFailure(route, outcome{
+			status:      status,
+			crashed:     crashed,
+			writeFailed: werr != nil,
+			shed:        shed,
+			deadline:    expires,
+			end:         time.Now(),
+		})
+	}
 
 	// Do the rest asynchronously; there's no point blocking threads/ connections
 	// further
 
-	go m.log(requestID, t0, r.RemoteAddr, rec.Code, r.URL.String(), r.UserAgent())
+	if blocked || known || trapped || m.skipped(r.URL.Path) {
+		return
+	}
+
+	l := LogEntry{
+		IPAddress: r.RemoteAddr,
+		RequestID: requestID,
+		Status:    status,
+		Time:      t0,
+		URL:       m.loggableURL(r.URL),
+		UserAgent: r.UserAgent(),
+
+		Method:        r.Method,
+		Proto:         r.Proto,
+		Scheme:        requestScheme(r),
+		ResponseBytes: respBytes,
+	}
+
+	if reqBody != nil {
+		l.RequestBody = reqBody.String()
+	}
+
+	if m.logReferer {
+		l.Referer = m.loggableReferer(r.Referer())
+	}
+
+	l.Route = m.resolveRoute(tmpl, func() *http.Request { return r })
+
+	l.RequestBytes = reqBytes.count()
+	if r.ContentLength > 0 {
+		l.ContentLength = r.ContentLength
+	}
+
+	if policy.capturesResponseBody(status, w.Header().Get("Content-Type")) {
+		l.ResponseBody = policy.truncate(resp)
+	}
+
+	if policy.Capture.Has(CaptureRequestHeaders) {
+		l.RequestHeaders = flattenHeader(r.Header)
+	} else {
+		l.RequestHeaders = pickHeader(r.Header, m.requestHeaders)
+	}
+
+	if policy.Capture.Has(CaptureResponseHeaders) {
+		l.ResponseHeaders = flattenHeader(w.Header())
+	} else {
+		l.ResponseHeaders = pickHeader(w.Header(), m.responseHeaders)
+	}
+
+	l.RequestHeaders = m.redactHeaderValues(l.RequestHeaders)
+	l.ResponseHeaders = m.redactHeaderValues(l.ResponseHeaders)
+
+	l.Debug = debug
+	l.Synthetic = synthetic
+	l.Flags = flags
+	l.Cost = costs
+	l.Lane = lane
+	l.Listener = ln.name()
+	l.Resources = used
+	l.WouldReject = would
+	crashed.annotate(&l)
+	l.Fields = m.extractFields(r, ResponseInfo{Status: status, Header: w.Header(), Bytes: respBytes, Duration: time.Since(t0)})
+	l.OutsideWindow = closed
+	l.Tenant = tenant
+	l.ClientRequestID = clientRequestID
+
+	if !hinted.IsZero() {
+		l.EarlyHintsMS = durationMS(hinted.Sub(t0))
+	}
+
+	if admin {
+		l.Retention = m.retentionClass(l, true)
+	}
+
+	end := time.Now()
+
+	m.queue.finish(func() {
+		endTransaction(txn, l, route, end)
+
+		l.ShadowDiff = m.diff(run, status, rec.Header(), resp)
+		m.log(l, route, policy, end, admin)
+	})
 }
 
 // ServeFastHTTP wraps our fasthttp requests and produces useful log lines.
@@ -162,85 +742,530 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 //
 // These logs are written to `STDOUT`
 func (m *Middleware) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
-	requestID := newUUID()
-	ctx.Response.Header.Set("X-Request-ID", requestID)
+	m.serveFastHTTP(ctx, nil)
+}
 
-	if strings.HasSuffix(ctx.URI().String(), "/__/counters") {
-		resp := m.counters()
+// serveFastHTTP serves a fasthttp request, as received by ln, which is nil
+// for requests passed straight to ServeFastHTTP
+func (m *Middleware) serveFastHTTP(ctx *fasthttp.RequestCtx, ln *Listener) {
+	requestID, clientRequestID := m.requestID(string(ctx.Request.Header.Peek(RequestIDHeader)))
+	ctx.Response.Header.Set(RequestIDHeader, requestID)
 
-		fmt.Fprintf(ctx, string(resp))
+	// Captured up front, as rewrites change the request in place
+	path, uri := string(ctx.Path()), ctx.URI().String()
+
+	route, policy := m.policy(path)
+
+	var (
+		flags   map[string]string
+		costs   map[string]float64
+		tenant  string
+		lane    string
+		blocked bool
+		known   bool
+		trapped bool
+		banned  bool
+		limited bool
+		spent   bool
+		shed    bool
+		closed  bool
+		would   []string
+		crashed *crash
+		used    *Resources
+		expires time.Time
+		tmpl    string
+		txn     NewRelicTransaction
+	)
+
+	debug := m.debugRequest(string(ctx.Request.Header.Peek(DebugHeader)), time.Now())
+	if debug {
+		policy = debugPolicy(policy)
+	}
+
+	synthetic := m.synthetic(func(k string) string { return string(ctx.Request.Header.Peek(k)) })
+
+	client := m.banClient(ctx.RemoteAddr().String(), func(k string) string { return string(ctx.Request.Header.Peek(k)) })
+
+	limiter := ln.rateLimiter(m.limiter)
+
+	endpoint, admin := m.adminEndpoint(path)
+	admin = admin && ln.servesAdmin()
+	if admin && m.streamsTail(endpoint, string(ctx.Method()), func(k string) string { return string(ctx.Request.Header.Peek(k)) }) {
+		m.serveFastTail(ctx)
+
+		return
+	}
+
+	if admin {
+		query, _ := url.ParseQuery(string(ctx.QueryArgs().QueryString()))
+
+		ar := m.serveAdmin(adminRequest{
+			method:   string(ctx.Method()),
+			endpoint: endpoint,
+			query:    query,
+			header:   func(k string) string { return string(ctx.Request.Header.Peek(k)) },
+			body:     ctx.PostBody(),
+		})
+
+		ctx.SetStatusCode(ar.status)
+		ctx.SetContentType(ar.contentType)
+		if ar.etag != "" {
+			ctx.Response.Header.Set("ETag", ar.etag)
+		}
+		ctx.SetBody(ar.body)
+	} else if rule, ok := m.blocked(path); ok && m.enforce(DryRunBlocklist, &would) {
+		blocked = true
+		ctx.Error(http.StatusText(rule.Status), rule.Status)
+	} else if status, h, body, ok := m.wellKnownResponse(path); ok {
+		known = true
+		for k := range h {
+			ctx.Response.Header.Set(k, h.Get(k))
+		}
+		ctx.SetStatusCode(status)
+		ctx.SetBody(body)
+	} else if retryAfter, ok := m.checkBan(client, time.Now()); ok && m.enforce(DryRunBans, &would) {
+		banned = true
+		ctx.Response.Header.Set("Retry-After", retryAfter)
+		ctx.Error(http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	} else if m.honeypot(path, m.loggableRawURL(uri), ctx.RemoteAddr().String(), client, requestID, time.Now()) && m.enforce(DryRunHoneypots, &would) {
+		trapped = true
+		ctx.Error(http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	} else if !policy.available(time.Now()) {
+		closed = true
+
+		status, retryAfter := policy.unavailable(time.Now())
+		if retryAfter != "" {
+			ctx.Response.Header.Set("Retry-After", retryAfter)
+		}
+
+		ctx.Error(http.StatusText(status), status)
+	} else if limiter != nil && !limiter.allow(clientIP(ctx.RemoteAddr().String()), time.Now()) && m.enforce(DryRunRateLimit, &would) {
+		limited = true
+		ctx.Response.Header.Set("Retry-After", limiter.retryAfter())
+		ctx.Error(http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	} else if retryAfter, ok := m.checkQuota(func(k string) string { return string(ctx.Request.Header.Peek(k)) }, ctx.Response.Header.Set, time.Now()); ok && m.enforce(DryRunQuotas, &would) {
+		// ctx.Error would reset the quota headers already set
+		spent = true
+		ctx.Response.Header.Set("Retry-After", retryAfter)
+		ctx.SetStatusCode(http.StatusTooManyRequests)
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBodyString(http.StatusText(http.StatusTooManyRequests))
+	} else if lane, shed = m.admit(path, func(k string) string { return string(ctx.Request.Header.Peek(k)) }, time.Now()); shed {
+		ctx.Response.Header.Set("Retry-After", "1")
+		ctx.Error(http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 	} else {
-		m.handler.(FasthttpHandler).Handle(ctx)
+		st := m.newState(requestID, route, path, func(k string) string { return string(ctx.Request.Header.Peek(k)) })
+		if m.flags != nil {
+			st.flags = m.flags.Evaluate(httpRequest(ctx))
+		}
+
+		ctx.SetUserValue(stateUserValue, st)
+		flags, tenant, expires = st.flags, st.tenant, st.deadline
+
+		rw := m.rewrite(path)
+		rw.rewriteFasthttpRequest(ctx)
+
+		similar := profileKey(route, path)
+		m.profiler.begin(similar, time.Now())
+
+		probe := m.probeResources(debug)
+		started := time.Now()
+		txn = m.startTransaction(route, string(ctx.Method()), path, synthetic)
+		done := m.beginInFlight(route, string(ctx.Method()))
+		crashed = protect(func() {
+			if s := m.static(string(ctx.Path())); s != nil {
+				s.serveFasthttp(ctx)
+			} else {
+				m.handler.(FasthttpHandler).Handle(ctx)
+			}
+		})
+		done()
+		m.profiler.finish(similar, time.Since(started))
+		used = probe.finish()
+		m.release()
+		costs = st.costSnapshot()
+		tmpl = st.routeTemplate()
+
+		if crashed != nil {
+			m.alertCrash(crashed, m.loggableRawURL(uri), requestID, time.Now())
+
+			ctx.Response.Reset()
+			ctx.Error(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+
+		rw.rewriteFasthttpResponse(ctx)
+		policy.Deprecation.annotateFasthttpResponse(ctx)
+
+		m.observe(string(ctx.Method()), path, func() (names []string) {
+			ctx.QueryArgs().VisitAll(func(k, _ []byte) {
+				names = append(names, string(k))
+			})
+
+			return
+		}, ctx.Response.StatusCode())
+	}
+
+	if !admin && !known && !banned && !trapped && !shed && !closed && !spent {
+		m.recordBan(client, ctx.Response.StatusCode(), limited, time.Now())
+	}
+
+	m.recordRejection(route, banned, closed, limited, spent, shed)
+	if !admin {
+		m.changed()
+	}
+	if !admin && !synthetic {
+		m.recordFailure(route, outcome{
+			status:   ctx.Response.StatusCode(),
+			crashed:  crashed,
+			shed:     shed,
+			deadline: expires,
+			end:      time.Now(),
+		})
+	}
+	m.recordDryRun(route, would)
+
+	if blocked || known || trapped || m.skipped(path) {
+		return
 	}
 
 	// Do the rest asynchronously; there's no point blocking threads/ connections
 	// further
 
-	go m.log(requestID, ctx.ConnTime(), ctx.RemoteAddr().String(), ctx.Response.StatusCode(), ctx.URI().String(), string(ctx.UserAgent()))
+	l := LogEntry{
+		IPAddress: ctx.RemoteAddr().String(),
+		RequestID: requestID,
+		Status:    ctx.Response.StatusCode(),
+		Time:      ctx.Time(),
+		URL:       m.loggableRawURL(uri),
+		UserAgent: string(ctx.UserAgent()),
+
+		Method:        string(ctx.Method()),
+		Proto:         fasthttpProto(ctx),
+		Scheme:        fasthttpScheme(ctx),
+		ResponseBytes: len(ctx.Response.Body()),
+	}
+
+	if policy.Capture.Has(CaptureRequestBody) {
+		l.RequestBody = policy.truncate(ctx.PostBody())
+	}
+
+	if m.logReferer {
+		l.Referer = m.loggableReferer(string(ctx.Referer()))
+	}
+
+	l.Route = m.resolveRoute(tmpl, func() *http.Request { return httpRequest(ctx) })
+
+	// fasthttp reads bodies in full before calling handlers
+	l.RequestBytes = int64(len(ctx.Request.Body()))
+	if n := ctx.Request.Header.ContentLength(); n > 0 {
+		l.ContentLength = int64(n)
+	}
+
+	if policy.capturesResponseBody(ctx.Response.StatusCode(), string(ctx.Response.Header.ContentType())) {
+		l.ResponseBody = policy.truncate(ctx.Response.Body())
+	}
+
+	if policy.Capture.Has(CaptureRequestHeaders) {
+		l.RequestHeaders = make(map[string]string)
+		ctx.Request.Header.VisitAll(visitHeader(l.RequestHeaders))
+	} else {
+		l.RequestHeaders = pickFasthttpRequestHeader(&ctx.Request.Header, m.requestHeaders)
+	}
+
+	if policy.Capture.Has(CaptureResponseHeaders) {
+		l.ResponseHeaders = make(map[string]string)
+		ctx.Response.Header.VisitAll(visitHeader(l.ResponseHeaders))
+	} else {
+		l.ResponseHeaders = pickFasthttpHeader(&ctx.Response.Header, m.responseHeaders)
+	}
+
+	l.RequestHeaders = m.redactHeaderValues(l.RequestHeaders)
+	l.ResponseHeaders = m.redactHeaderValues(l.ResponseHeaders)
+
+	l.Debug = debug
+	l.Synthetic = synthetic
+	l.Flags = flags
+	l.Cost = costs
+	l.Lane = lane
+	l.Listener = ln.name()
+	l.Resources = used
+	l.WouldReject = would
+	crashed.annotate(&l)
+	l.Fields = m.extractFasthttpFields(ctx)
+	l.OutsideWindow = closed
+	l.Tenant = tenant
+	l.ClientRequestID = clientRequestID
+
+	if admin {
+		l.Retention = m.retentionClass(l, true)
+	}
+
+	end := time.Now()
+
+	m.queue.finish(func() {
+		endTransaction(txn, l, route, end)
+		m.log(l, route, policy, end, admin)
+	})
 }
 
-func (m *Middleware) counters() (resp []byte) {
-	rData := make(map[string]int64)
-	for k, v := range m.Requests {
-		rData[k] = v.Value()
+// dispatch hands a finished LogEntry to every logger
+func (m *Middleware) dispatch(l LogEntry) {
+	l = m.finish(l)
+
+	for _, logger := range m.loggers {
+		m.queue.enqueue(logger, l)
 	}
+}
 
-	resp, _ = json.Marshal(rData)
+// finish makes the changes made to every entry before it leaves the
+// middleware, such as anonymizing it
+func (m *Middleware) finish(l LogEntry) LogEntry {
+	if l.Level == 0 {
+		l.Level = entryLevel(l, 0)
+	}
 
-	return
+	if m.anonymizer != nil {
+		now := time.Now()
+
+		l.IPAddress = m.anonymizer.anonymize(l.IPAddress, now)
+		l.RequestHeaders = m.anonymizer.anonymizeHeaders(l.RequestHeaders, now)
+		l.ResponseHeaders = m.anonymizer.anonymizeHeaders(l.ResponseHeaders, now)
+	}
+
+	if l.Retention == "" {
+		l.Retention = m.retentionClass(l, false)
+	}
+
+	l.Fields = m.withStaticFields(l.Fields)
+
+	return l
 }
 
-func (m *Middleware) log(requestID string, t0 time.Time, addr string, status int, url string, ua string) {
-	duration := time.Now().Sub(t0)
+// log finishes and dispatches l, for a request which completed at end, and
+// updates counters. Admin requests don't count as changes to the
+// middleware's state, so that scrapes of cached admin endpoints don't
+// invalidate each other; their own hit counts are left a scrape behind
+func (m *Middleware) log(l LogEntry, route string, p RoutePolicy, end time.Time, admin bool) {
+	duration := end.Sub(l.Time)
 
-	// Log request
-	l := LogEntry{
-		Duration:   duration.String(),
-		DurationMS: float64(duration / time.Millisecond),
-		IPAddress:  addr,
-		RequestID:  requestID,
-		Status:     status,
-		Time:       t0,
-		URL:        url,
-		UserAgent:  ua,
+	l.SchemaVersion = SchemaVersion
+	l.Duration = duration.String()
+	l.DurationMS = float64(duration / time.Millisecond)
+
+	l.Slow = p.SlowThreshold > 0 && duration > p.SlowThreshold
+
+	l.BudgetExceeded = p.Budget > 0 && duration > p.Budget
+	if l.BudgetExceeded && !l.Synthetic {
+		m.budgetExceeded.add(route, 1)
 	}
 
-	for _, logger := range m.loggers {
-		go logger.Log(l)
+	l.Apdex = apdexZone(p.ApdexThreshold, l, duration)
+
+	l.Level = entryLevel(l, p.Level)
+
+	if m.tails.active() {
+		m.tails.publish(m.finish(l))
 	}
 
-	// Counters
-	lock.RLock()
-	_, ok := m.Requests[url]
-	lock.RUnlock()
+	// Costs are billing data, and so are totalled before sampling. Requests
+	// matching no policy are keyed as their counters are, so that IDs and
+	// query strings don't each get a total of their own
+	if len(l.Cost) > 0 {
+		costRoute := route
+		if costRoute == "" {
+			costRoute = m.counterKey(l)
+		}
 
-	if !ok {
-		// On uuids: during development it became obvious that there were possible collisions/ unexpected behaviour
-		// around how we store counters.
-		// Because we don't know all of the routes exposed, and as such we can't preallocate counters, we store them
-		// in a map against route names. This allows us to point to the correct counter. It also means that should multiple
-		// *middleware.Middleware instances match the same route (say: an application listening on two ports exposing '/')
-		// then by not setting the counter as the route (or similarly computed value) we're not going to end up with both
-		// counters being merged into a single one.
-		//
-		// This was found during testing: initially storing counters named for their route, which expvar makes globally available,
-		// in a map, which is stored in an instanced *middleware.Middleware, meant that this function always fired and tried to
-		// redfine a counter that existed that `expvar`, in it's wisdom, bombed out on.
-		lock.Lock()
-		m.Requests[url] = expvar.NewInt(newUUID())
-		lock.Unlock()
+		m.costs.add(costRoute, l.Tenant, l.Cost)
+	}
+
+	// Log request, subject to sampling. Slow requests are always logged;
+	// they're the ones people go looking for. Synthetic requests are too,
+	// being few, and so as not to be re-weighted as real traffic
+	if l.Slow || l.Synthetic || p.sampled() {
+		if rate := p.rate(); rate < 1 && !l.Synthetic {
+			l.SampleRate = rate
+		}
+
+		m.dispatch(l)
 	}
 
+	// Synthetic requests are counted apart, so as not to skew the counts
+	// real traffic is judged on
+	if l.Synthetic {
+		atomic.AddInt64(&m.synthetics, 1)
+		if !admin {
+			m.changed()
+		}
+
+		return
+	}
+
+	if !admin {
+		key := routeKey(l.Route, route)
+
+		m.observeMetrics(key, l, duration)
+		m.statsd.observe(key, l, duration, atomic.LoadInt64(&m.inFlight))
+		m.reporters.observe(key, l, duration)
+		m.latency.observe(key, duration)
+		m.percentiles.observe(key, duration)
+		m.statuses.add(key, strconv.Itoa(l.Status))
+		m.methods.add(key, promMethod(l.Method))
+
+		if l.Apdex != "" {
+			m.apdex.add(key, l.Apdex)
+		}
+	}
+
+	// Requests are counted by route template where known, or else by
+	// normalized URL, so that parameterised routes share a counter
+	url := m.counterKey(l)
+
+	// Counters belong to this Middleware alone, and are only published
+	// to expvar via its own map; see PublishCounters
 	lock.Lock()
-	m.Requests[url].Add(1)
+	counter, ok := m.Requests[url]
+	if !ok {
+		counter = m.newCounter(url)
+		m.Requests[url] = counter
+	}
+
+	counter.Add(1)
+
+	// Bytes are added under the lock too, lest a concurrent eviction of
+	// url be undone by adding to it afterwards
+	m.responseBytes.add(url, int64(l.ResponseBytes))
+	m.requestBytes.add(url, l.RequestBytes)
+
+	if evicted, ok := m.counterLRU.touch(url); ok {
+		m.evictCounter(evicted)
+	}
 	lock.Unlock()
+
+	if !admin {
+		m.changed()
+	}
 }
 
+var (
+	// uuidV4 generates UUIDs, and is swapped out in tests
+	uuidV4 = uuid.NewV4
+
+	// uuidFailures counts UUIDs which couldn't be generated, and
+	// fallbackSeq orders the IDs minted instead
+	uuidFailures int64
+	fallbackSeq  uint64
+
+	// fallbackHost prefixes fallback IDs, so that they're unique across hosts
+	fallbackHost = func() string {
+		h, err := os.Hostname()
+		if err != nil || h == "" {
+			return "unknown"
+		}
+
+		return h
+	}()
+)
+
+// newUUID returns a fresh v4 UUID. Should UUID generation fail, such as when
+// crypto/rand is broken, an ID built from the hostname, time, and a counter
+// is returned instead, which is unique to the request if not to the world
 func newUUID() string {
-	u, err := uuid.NewV4()
-	if err != nil {
-		return DefaultBrokenUUID
+	u, err := uuidV4()
+	if err == nil {
+		return u.String()
 	}
 
-	return u.String()
+	atomic.AddInt64(&uuidFailures, 1)
+
+	return fmt.Sprintf("%s-%x-%x", fallbackHost, time.Now().UnixNano(), atomic.AddUint64(&fallbackSeq, 1))
+}
+
+// bodyCapture wraps a request body, keeping a copy of the first max bytes
+// read from it by the wrapped handler
+type bodyCapture struct {
+	io.ReadCloser
+
+	buf bytes.Buffer
+	max int
+}
+
+func newBodyCapture(rc io.ReadCloser, max int) *bodyCapture {
+	return &bodyCapture{ReadCloser: rc, max: max}
+}
+
+func (bc *bodyCapture) Read(p []byte) (n int, err error) {
+	n, err = bc.ReadCloser.Read(p)
+
+	if remaining := bc.max - bc.buf.Len(); remaining > 0 && n > 0 {
+		if n < remaining {
+			remaining = n
+		}
+
+		bc.buf.Write(p[:remaining])
+	}
+
+	return
+}
+
+func (bc *bodyCapture) String() string {
+	return bc.buf.String()
+}
+
+// byteCounter wraps a request body, counting the bytes read from it by the
+// wrapped handler. Handlers may hand bodies to other goroutines, so the count
+// is atomic
+type byteCounter struct {
+	io.ReadCloser
+
+	n int64
+}
+
+func (bc *byteCounter) Read(p []byte) (n int, err error) {
+	n, err = bc.ReadCloser.Read(p)
+	atomic.AddInt64(&bc.n, int64(n))
+
+	return
+}
+
+// count returns the bytes read so far, or 0 for a nil byteCounter
+func (bc *byteCounter) count() int64 {
+	if bc == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&bc.n)
+}
+
+// requestScheme returns the scheme a request was received over. Schemes
+// claimed by proxies, in X-Forwarded-Proto, aren't trusted
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// flattenHeader turns a net/http header into a LogEntry friendly map
+func flattenHeader(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = strings.Join(v, ", ")
+	}
+
+	return out
+}
+
+// visitHeader returns a fasthttp header visitor which flattens headers into out
+func visitHeader(out map[string]string) func(k, v []byte) {
+	return func(k, v []byte) {
+		if existing, ok := out[string(k)]; ok {
+			out[string(k)] = existing + ", " + string(v)
+
+			return
+		}
+
+		out[string(k)] = string(v)
+	}
 }
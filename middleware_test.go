@@ -3,13 +3,18 @@ package middleware
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 const (
@@ -197,3 +202,191 @@ func TestServeFastHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestServeHTTP_requestBytes(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		contentLength int64
+		read          int64
+		expectLength  int64
+	}{
+		{"read in full", 11, -1, 11},
+		{"chunked", -1, -1, 0},
+		{"partially read", 11, 4, 11},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.read < 0 {
+					ioutil.ReadAll(r.Body)
+				} else {
+					io.CopyN(ioutil.Discard, r.Body, test.read)
+				}
+			}))
+
+			logWriter := &TestWriter{}
+			m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+			r := httptest.NewRequest("POST", "/upload", strings.NewReader("hello world"))
+			r.ContentLength = test.contentLength
+
+			m.ServeHTTP(httptest.NewRecorder(), r)
+
+			time.Sleep(100 * time.Millisecond)
+
+			var l LogEntry
+			if err := json.Unmarshal(logWriter.body, &l); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			expectRead := test.read
+			if expectRead < 0 {
+				expectRead = 11
+			}
+
+			if l.RequestBytes != expectRead || l.ContentLength != test.expectLength {
+				t.Errorf("expected %d bytes of %d, received %d of %d", expectRead, test.expectLength, l.RequestBytes, l.ContentLength)
+			}
+		})
+	}
+}
+
+func TestServeFastHTTP_requestBytes(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/upload")
+	c.Request.Header.SetMethod("POST")
+	c.Request.SetBodyString("hello world")
+	c.Request.Header.SetContentLength(11)
+
+	m.ServeFastHTTP(c)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if l.RequestBytes != 11 || l.ContentLength != 11 {
+		t.Errorf("expected 11 bytes of 11, received %d of %d", l.RequestBytes, l.ContentLength)
+	}
+}
+
+func TestServeHTTP_requestLine(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "https://example.com/users/1", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if l.Method != "DELETE" || l.Proto != "HTTP/1.1" || l.Scheme != "https" {
+		t.Errorf("unexpected request line %q %q %q", l.Method, l.Proto, l.Scheme)
+	}
+}
+
+func TestServeFastHTTP_requestLine(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/users/1")
+	c.Request.Header.SetMethod("DELETE")
+
+	m.ServeFastHTTP(c)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if l.Method != "DELETE" || l.Proto != "HTTP/1.1" || l.Scheme != "http" {
+		t.Errorf("unexpected request line %q %q %q", l.Method, l.Proto, l.Scheme)
+	}
+}
+
+func TestServeFastHTTP_keepAlive(t *testing.T) {
+	logger := &collectingLogger{}
+
+	m := NewMiddleware(FHAPI{})
+	m.SetLoggers(logger)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	go fasthttp.Serve(ln, m.ServeFastHTTP)
+
+	// Requests on a connection are timed from their own start, not the
+	// connection's
+	c := &fasthttp.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if len(logger.entries) != 2 {
+		t.Fatalf("expected 2 entries, received %d", len(logger.entries))
+	}
+
+	first, second := logger.entries[0], logger.entries[1]
+	if second.Time.Sub(first.Time) < 200*time.Millisecond || entryDuration(second) >= 100*time.Millisecond {
+		t.Errorf("expected the second request to be timed from its start, received %s taking %s", second.Time, second.Duration)
+	}
+}
+
+func TestServeHTTP_failedStream(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+
+		panic("stream broke")
+	}))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(RequestIDHeader) == "" {
+		t.Errorf("expected a traceable 500, received %d %v", rec.Code, rec.Header())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if l.Status != http.StatusInternalServerError || l.RequestID != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("expected a terminal entry for the failed stream, received %+v", l)
+	}
+}
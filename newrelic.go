@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// NewRelicApplication starts New Relic transactions. It is deliberately
+// small, so that the Go agent can be adapted in a few lines without this
+// package depending on it:
+//
+//	type nrApp struct{ *newrelic.Application }
+//
+//	func (a nrApp) StartTransaction(name string) middleware.NewRelicTransaction {
+//		return nrTxn{a.Application.StartTransaction(name)}
+//	}
+//
+//	type nrTxn struct{ *newrelic.Transaction }
+//
+//	func (t nrTxn) End(time.Time) { t.Transaction.End() }
+type NewRelicApplication interface {
+	StartTransaction(name string) NewRelicTransaction
+}
+
+// NewRelicTransaction is a transaction started by a NewRelicApplication.
+// Its methods are those of the Go agent's *newrelic.Transaction, but for
+// End, which is passed the time the middleware finished handling the
+// request, for agents which can be told it
+type NewRelicTransaction interface {
+	SetName(name string)
+	AddAttribute(key string, value interface{})
+	NoticeError(err error)
+	End(end time.Time)
+}
+
+// NewRelic reports requests to New Relic as transactions of app, started
+// just before the wrapped handler is called and ended once the request has
+// been handled.
+//
+// Responses aren't instrumented a second time; transactions are given the
+// middleware's own results instead. Each is named for its method and
+// route, as per MetricsExporter, such as `GET /users/:id`, and has
+// attributes:
+//   - http.statusCode, the status sent;
+//   - request.method and request.uri, the latter as logged;
+//   - request_id; and
+//   - tenant and lane, where set
+//
+// 5xx responses are noticed as errors, with panics noticed as such.
+//
+// Admin, synthetic, and skipped requests, and requests refused before
+// reaching the wrapped handler, aren't reported. NewRelic panics when app
+// is nil
+func (m *Middleware) NewRelic(app NewRelicApplication) {
+	if app == nil {
+		panic("middleware: NewRelic requires an application")
+	}
+
+	m.newRelic = app
+}
+
+// startTransaction starts a New Relic transaction for a request to route,
+// as per AddRoutePolicy, unless New Relic isn't configured or the request
+// isn't to be reported
+func (m *Middleware) startTransaction(route, method, path string, synthetic bool) NewRelicTransaction {
+	if m.newRelic == nil || synthetic || m.skipped(path) {
+		return nil
+	}
+
+	return m.newRelic.StartTransaction(promMethod(method) + " " + routeKey("", route))
+}
+
+// endTransaction reports l, a request to route which the middleware
+// finished handling at end, on txn, and ends it
+func endTransaction(txn NewRelicTransaction, l LogEntry, route string, end time.Time) {
+	if txn == nil {
+		return
+	}
+
+	txn.SetName(promMethod(l.Method) + " " + routeKey(l.Route, route))
+
+	txn.AddAttribute("http.statusCode", l.Status)
+	txn.AddAttribute("request.method", l.Method)
+	txn.AddAttribute("request.uri", l.URL)
+	txn.AddAttribute("request_id", l.RequestID)
+
+	for _, a := range []struct {
+		key, value string
+	}{
+		{"tenant", l.Tenant},
+		{"lane", l.Lane},
+	} {
+		if a.value != "" {
+			txn.AddAttribute(a.key, a.value)
+		}
+	}
+
+	switch {
+	case l.Panic != "":
+		txn.NoticeError(fmt.Errorf("panic: %s", l.Panic))
+	case l.Status >= 500:
+		txn.NoticeError(errors.New(http.StatusText(l.Status)))
+	}
+
+	txn.End(end)
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type testNewRelicApp struct {
+	sync.Mutex
+
+	txns []*testNewRelicTxn
+}
+
+func (a *testNewRelicApp) StartTransaction(name string) NewRelicTransaction {
+	a.Lock()
+	defer a.Unlock()
+
+	txn := &testNewRelicTxn{name: name, attributes: make(map[string]interface{})}
+	a.txns = append(a.txns, txn)
+
+	return txn
+}
+
+// ended returns copies of the transactions which have ended, taken under
+// their locks
+func (a *testNewRelicApp) ended() (txns []*testNewRelicTxn) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, txn := range a.txns {
+		txn.Lock()
+		if !txn.end.IsZero() {
+			txns = append(txns, &testNewRelicTxn{name: txn.name, attributes: txn.attributes, errs: txn.errs, end: txn.end})
+		}
+		txn.Unlock()
+	}
+
+	return
+}
+
+type testNewRelicTxn struct {
+	sync.Mutex
+
+	name       string
+	attributes map[string]interface{}
+	errs       []error
+	end        time.Time
+}
+
+func (t *testNewRelicTxn) SetName(name string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.name = name
+}
+
+func (t *testNewRelicTxn) AddAttribute(key string, value interface{}) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.attributes[key] = value
+}
+
+func (t *testNewRelicTxn) NoticeError(err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.errs = append(t.errs, err)
+}
+
+func (t *testNewRelicTxn) End(end time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.end = end
+}
+
+func TestNewRelic(t *testing.T) {
+	app := &testNewRelicApp{}
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.SkipPaths("/healthcheck")
+	m.NewRelic(app)
+
+	for _, p := range []string{"/users/1", "/users/broken", "/healthcheck", "/__/counters"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	txns := app.ended()
+	if len(txns) != 2 {
+		t.Fatalf("expected 2 transactions, received %d", len(txns))
+	}
+
+	for _, txn := range txns {
+		if txn.name != "GET /users/:id" {
+			t.Errorf("expected transactions to be named by route, received %q", txn.name)
+		}
+
+		if txn.attributes["request_id"] == "" || txn.attributes["request.method"] != "GET" {
+			t.Errorf("expected request attributes, received %+v", txn.attributes)
+		}
+	}
+
+	if txns[0].attributes["http.statusCode"] != http.StatusOK || len(txns[0].errs) != 0 {
+		t.Errorf("expected a successful transaction, received %+v", txns[0])
+	}
+
+	if txns[1].attributes["http.statusCode"] != http.StatusBadGateway || len(txns[1].errs) != 1 {
+		t.Errorf("expected a failed transaction, received %+v", txns[1])
+	}
+}
+
+func TestNewRelic_fasthttp(t *testing.T) {
+	app := &testNewRelicApp{}
+
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.SetLoggers()
+	m.NewRelic(app)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users")
+	m.ServeFastHTTP(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+
+	txns := app.ended()
+	if len(txns) != 1 || txns[0].name != "GET default" {
+		t.Fatalf("expected a transaction for the request, received %+v", txns)
+	}
+}
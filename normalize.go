@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalizeURL rewrites u, in place, into a canonical form so that equivalent
+// URLs are logged and counted together. It:
+//   - lowercases the scheme and host;
+//   - converts internationalised hostnames to punycode;
+//   - strips default ports (:80 for http, :443 for https);
+//   - resolves `.` and `..` path segments; and
+//   - optionally strips query strings
+func normalizeURL(u *url.URL, stripQuery bool) {
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	if u.Host != "" {
+		host, port := u.Hostname(), u.Port()
+
+		host = strings.ToLower(host)
+		if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+			host = ascii
+		}
+
+		if strings.Contains(host, ":") {
+			// IPv6 literals need their brackets back
+			host = "[" + host + "]"
+		}
+
+		if port != "" && defaultPorts[u.Scheme] != port {
+			host += ":" + port
+		}
+
+		u.Host = host
+	}
+
+	if ep := u.EscapedPath(); strings.Contains(ep, ".") {
+		resolved := removeDotSegments(ep)
+		if p, err := url.PathUnescape(resolved); err == nil {
+			u.Path, u.RawPath = p, resolved
+		}
+	}
+
+	if stripQuery {
+		u.RawQuery = ""
+		u.ForceQuery = false
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+}
+
+// removeDotSegments implements https://tools.ietf.org/html/rfc3986#section-5.2.4
+// Unlike path.Clean it leaves empty segments and trailing slashes alone, since
+// those can be significant to an application
+func removeDotSegments(p string) string {
+	if p == "" {
+		return p
+	}
+
+	in := strings.Split(p, "/")
+	out := make([]string, 0, len(in))
+
+	for i, seg := range in {
+		last := i == len(in)-1
+
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+
+		case "..":
+			// never pop the leading empty segment of an absolute path
+			if len(out) > 1 || (len(out) == 1 && out[0] != "") {
+				out = out[:len(out)-1]
+			}
+
+			if last {
+				out = append(out, "")
+			}
+
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	return strings.Join(out, "/")
+}
+
+// loggableURL returns the normalised, redacted, form of u which is used
+// in logs and as a counter key. u is left untouched.
+func (m *Middleware) loggableURL(u *url.URL) string {
+	c := *u
+	normalizeURL(&c, m.StripQuery)
+
+	return m.redactURL(&c)
+}
+
+// loggableRawURL is loggableURL for callers which only have a string
+func (m *Middleware) loggableRawURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	return m.loggableURL(u)
+}
+
+// LogReferer records the Referer of each request in LogEntry, for traffic
+// source analysis. Referers are normalised and redacted as URLs are, and are
+// dropped when malformed, as they're supplied by clients
+func (m *Middleware) LogReferer() {
+	m.logReferer = true
+}
+
+// loggableReferer is loggableURL for Referer headers, or an empty string for
+// those which aren't valid URLs
+func (m *Middleware) loggableReferer(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	return m.loggableURL(u)
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultMaxObservedPaths bounds the number of path templates recorded
+	// by ObserveAPI, so that unexpected path cardinality can't exhaust
+	// memory. Paths first seen beyond this are ignored
+	DefaultMaxObservedPaths = 1000
+
+	// openAPIVersion is the version of the OpenAPI specification served
+	openAPIVersion = "3.0.3"
+)
+
+// idSegment matches path segments which are almost certainly identifiers:
+// numbers, UUIDs, and long hex strings
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// ObserveAPI records the methods, paths, query parameters, and statuses of
+// requests reaching the wrapped handler, and serves what it has seen as a
+// skeletal OpenAPI document from the `openapi` admin endpoint. This helps
+// document legacy services; the output is a starting point, not a spec.
+//
+// Path segments which look like identifiers (numbers, UUIDs, and long hex
+// strings) are replaced with path parameters, so that `/users/123` and
+// `/users/456` are documented as `/users/{id}`.
+func (m *Middleware) ObserveAPI(title string) {
+	m.openAPI = &apiObserver{
+		title: title,
+		paths: make(map[string]map[string]*observedOperation),
+	}
+
+	m.addCachedAdminEndpoint("openapi", m.serveOpenAPI)
+}
+
+// apiObserver records operations, keyed by path template and then method
+type apiObserver struct {
+	sync.Mutex
+
+	title string
+	paths map[string]map[string]*observedOperation
+}
+
+type observedOperation struct {
+	pathParams  []string
+	queryParams map[string]bool
+	statuses    map[int]bool
+}
+
+// observe records a request, when ObserveAPI is enabled. query returns the
+// names of the request's query parameters, and is only called when needed
+func (m *Middleware) observe(method, path string, query func() []string, status int) {
+	if m.openAPI != nil {
+		m.openAPI.observe(method, path, query(), status)
+	}
+}
+
+func (ao *apiObserver) observe(method, path string, query []string, status int) {
+	template, params := pathTemplate(path)
+	method = strings.ToLower(method)
+
+	ao.Lock()
+	defer ao.Unlock()
+
+	methods, ok := ao.paths[template]
+	if !ok {
+		if len(ao.paths) >= DefaultMaxObservedPaths {
+			return
+		}
+
+		methods = make(map[string]*observedOperation)
+		ao.paths[template] = methods
+	}
+
+	op, ok := methods[method]
+	if !ok {
+		op = &observedOperation{
+			pathParams:  params,
+			queryParams: make(map[string]bool),
+			statuses:    make(map[int]bool),
+		}
+
+		methods[method] = op
+	}
+
+	for _, q := range query {
+		op.queryParams[q] = true
+	}
+
+	op.statuses[status] = true
+}
+
+// pathTemplate replaces identifier-like segments of p with parameters,
+// named `id`, `id2`, and so on, returning the template and the parameter
+// names
+func pathTemplate(p string) (template string, params []string) {
+	segments := strings.Split(p, "/")
+
+	for i, seg := range segments {
+		if !idSegment.MatchString(seg) {
+			continue
+		}
+
+		name := "id"
+		if len(params) > 0 {
+			name += strconv.Itoa(len(params) + 1)
+		}
+
+		params = append(params, name)
+		segments[i] = "{" + name + "}"
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIParameter is an OpenAPI Parameter Object
+type openAPIParameter struct {
+	Name     string            `json:"name"`
+	In       string            `json:"in"`
+	Required bool              `json:"required,omitempty"`
+	Schema   map[string]string `json:"schema"`
+}
+
+// openAPIOperation is an OpenAPI Operation Object
+type openAPIOperation struct {
+	Parameters []openAPIParameter           `json:"parameters,omitempty"`
+	Responses  map[string]map[string]string `json:"responses"`
+}
+
+// document renders everything observed as an OpenAPI document
+func (ao *apiObserver) document() map[string]interface{} {
+	ao.Lock()
+	defer ao.Unlock()
+
+	paths := make(map[string]map[string]openAPIOperation, len(ao.paths))
+
+	for template, methods := range ao.paths {
+		paths[template] = make(map[string]openAPIOperation, len(methods))
+
+		for method, op := range methods {
+			o := openAPIOperation{Responses: make(map[string]map[string]string)}
+
+			for _, name := range op.pathParams {
+				o.Parameters = append(o.Parameters, openAPIParameter{Name: name, In: "path", Required: true, Schema: map[string]string{"type": "string"}})
+			}
+
+			query := make([]string, 0, len(op.queryParams))
+			for name := range op.queryParams {
+				query = append(query, name)
+			}
+
+			sort.Strings(query)
+
+			for _, name := range query {
+				o.Parameters = append(o.Parameters, openAPIParameter{Name: name, In: "query", Schema: map[string]string{"type": "string"}})
+			}
+
+			for status := range op.statuses {
+				o.Responses[strconv.Itoa(status)] = map[string]string{"description": http.StatusText(status)}
+			}
+
+			paths[template][method] = o
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]string{
+			"title":   ao.title,
+			"version": "observed",
+		},
+		"paths": paths,
+	}
+}
+
+func (m *Middleware) serveOpenAPI(adminRequest) adminResponse {
+	b, err := json.Marshal(m.openAPI.document())
+	if err != nil {
+		return adminError(http.StatusInternalServerError, err)
+	}
+
+	return jsonResponse(http.StatusOK, b)
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPathTemplate(t *testing.T) {
+	for _, test := range []struct {
+		path     string
+		expect   string
+		expectPs []string
+	}{
+		{"/", "/", nil},
+		{"/users", "/users", nil},
+		{"/users/123", "/users/{id}", []string{"id"}},
+		{"/users/123/orders/cd9bbcae-e076-549f-82bf-a08e8c838dd3", "/users/{id}/orders/{id2}", []string{"id", "id2"}},
+		{"/commits/0123456789abcdef01", "/commits/{id}", []string{"id"}},
+		{"/v2/cafe", "/v2/cafe", nil},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			got, ps := pathTemplate(test.path)
+			if got != test.expect || !reflect.DeepEqual(ps, test.expectPs) {
+				t.Errorf("expected %q %v, received %q %v", test.expect, test.expectPs, got, ps)
+			}
+		})
+	}
+}
+
+func TestObserveAPI(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.ObserveAPI("legacy")
+
+	for _, p := range []string{"/users/1?fields=name", "/users/2?expand=true", "/__/counters"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/openapi", nil))
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Paths map[string]map[string]openAPIOperation `json:"paths"`
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if doc.OpenAPI != openAPIVersion || doc.Info.Title != "legacy" {
+		t.Errorf("unexpected document header %+v", doc)
+	}
+
+	if len(doc.Paths) != 1 {
+		t.Fatalf("expected only /users/{id} to be observed, received %+v", doc.Paths)
+	}
+
+	op := doc.Paths["/users/{id}"]["get"]
+
+	var names []string
+	for _, p := range op.Parameters {
+		names = append(names, p.In+":"+p.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"path:id", "query:expand", "query:fields"}) {
+		t.Errorf("unexpected parameters %v", names)
+	}
+
+	if op.Responses["200"]["description"] != "OK" {
+		t.Errorf("unexpected responses %+v", op.Responses)
+	}
+}
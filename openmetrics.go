@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openMetricsContentType is that of OpenMetrics text exposition
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// acceptsOpenMetrics returns whether an Accept header asks for OpenMetrics
+func acceptsOpenMetrics(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text")
+}
+
+// encodeOpenMetricsCounters renders c in the OpenMetrics text format, for
+// scrapers which can't read the counters endpoint's JSON:
+//   - http_requests, http_request_bytes, and http_response_bytes, counters
+//     by URL or route template, as under `requests`;
+//   - http_responses and http_requests_by_method, counters by route and
+//     status or method;
+//   - http_request_duration_seconds, a histogram by route, as per
+//     TrackLatency, and http_request_duration_percentile_seconds, a
+//     summary by route, as per TrackPercentiles;
+//   - http_requests_in_flight, a gauge by route;
+//   - http_apdex_requests, a counter by route and zone, and
+//     http_apdex_score, a gauge by route, as per ApdexThreshold;
+//   - http_budget_exceeded, http_blocked, http_honeypots, http_failures,
+//     http_shed, http_shadow_responses, and http_synthetic_requests,
+//     counters as per their JSON equivalents; and
+//   - go_goroutines, go_memstats_heap_alloc_bytes, and the like, as per
+//     IncludeRuntimeStats
+//
+// Sections the JSON holds which aren't counts, such as costs and the log
+// queue, are left out
+func encodeOpenMetricsCounters(c countersPayload) []byte {
+	var w openMetricsWriter
+
+	w.counters("http_requests", "Requests handled, by URL or route template.", "route", c.Requests)
+	w.counters("http_request_bytes", "Bytes of request bodies read, by URL or route template.", "route", c.RequestBytes)
+	w.counters("http_response_bytes", "Bytes of response bodies written, by URL or route template.", "route", c.ResponseBytes)
+	w.nestedCounters("http_responses", "Responses sent, by route and status.", "route", "status", c.Statuses)
+	w.nestedCounters("http_requests_by_method", "Requests handled, by route and method.", "route", "method", c.Methods)
+
+	if len(c.Latency) > 0 {
+		w.family("http_request_duration_seconds", "histogram", "Time taken to handle requests, by route.")
+
+		routes := make([]string, 0, len(c.Latency))
+		for route := range c.Latency {
+			routes = append(routes, route)
+		}
+
+		sort.Strings(routes)
+
+		for _, route := range routes {
+			h := c.Latency[route]
+
+			for _, b := range h.Buckets {
+				w.sample("http_request_duration_seconds_bucket", float64(b.Count), "route", route, "le", formatOpenMetricsFloat(b.LeMS/1000))
+			}
+
+			w.sample("http_request_duration_seconds_bucket", float64(h.Count), "route", route, "le", "+Inf")
+			w.sample("http_request_duration_seconds_sum", h.SumMS/1000, "route", route)
+			w.sample("http_request_duration_seconds_count", float64(h.Count), "route", route)
+		}
+	}
+
+	if len(c.Percentiles) > 0 {
+		w.family("http_request_duration_percentile_seconds", "summary", "Estimated request durations, by route.")
+
+		routes := make([]string, 0, len(c.Percentiles))
+		for route := range c.Percentiles {
+			routes = append(routes, route)
+		}
+
+		sort.Strings(routes)
+
+		for _, route := range routes {
+			p := c.Percentiles[route]
+
+			w.sample("http_request_duration_percentile_seconds", p.P50MS/1000, "route", route, "quantile", "0.5")
+			w.sample("http_request_duration_percentile_seconds", p.P95MS/1000, "route", route, "quantile", "0.95")
+			w.sample("http_request_duration_percentile_seconds", p.P99MS/1000, "route", route, "quantile", "0.99")
+			w.sample("http_request_duration_percentile_seconds_count", float64(p.Count), "route", route)
+		}
+	}
+
+	if c.InFlight != nil && len(c.InFlight.Routes) > 0 {
+		w.family("http_requests_in_flight", "gauge", "Requests being handled, by route pattern.")
+
+		for _, route := range sortedCountKeys(c.InFlight.Routes) {
+			w.sample("http_requests_in_flight", float64(c.InFlight.Routes[route]), "route", route)
+		}
+	}
+
+	if len(c.Apdex) > 0 {
+		routes := make([]string, 0, len(c.Apdex))
+		for route := range c.Apdex {
+			routes = append(routes, route)
+		}
+
+		sort.Strings(routes)
+
+		w.family("http_apdex_requests", "counter", "Requests to routes with an Apdex threshold, by route and Apdex zone.")
+
+		for _, route := range routes {
+			a := c.Apdex[route]
+
+			w.sample("http_apdex_requests_total", float64(a.Satisfied), "route", route, "zone", ApdexSatisfied)
+			w.sample("http_apdex_requests_total", float64(a.Tolerating), "route", route, "zone", ApdexTolerating)
+			w.sample("http_apdex_requests_total", float64(a.Frustrated), "route", route, "zone", ApdexFrustrated)
+		}
+
+		w.family("http_apdex_score", "gauge", "Apdex score, by route.")
+
+		for _, route := range routes {
+			w.sample("http_apdex_score", c.Apdex[route].Score, "route", route)
+		}
+	}
+
+	w.counters("http_budget_exceeded", "Requests which exceeded their route's latency budget, by route pattern.", "route", c.BudgetExceeded)
+	w.counters("http_blocked", "Requests refused, by blocklist pattern.", "pattern", c.Blocked)
+	w.counters("http_honeypots", "Requests trapped, by honeypot pattern.", "pattern", c.Honeypots)
+	w.nestedCounters("http_failures", "Requests which failed, by route and kind of failure.", "route", "kind", c.Failures)
+	w.counters("http_shed", "Requests shed, by lane.", "lane", c.Shed)
+	w.counters("http_shadow_responses", "Shadow responses compared, those which mismatched, and requests skipped.", "outcome", c.Shadow)
+
+	w.family("http_synthetic_requests", "counter", "Synthetic requests handled.")
+	w.sample("http_synthetic_requests_total", float64(c.Synthetic))
+
+	if rs := c.Runtime; rs != nil {
+		for _, f := range []struct {
+			name, typ, help string
+			value           float64
+		}{
+			{"go_goroutines", "gauge", "Goroutines that currently exist.", float64(rs.Goroutines)},
+			{"go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(rs.HeapAllocBytes)},
+			{"go_memstats_heap_sys_bytes", "gauge", "Bytes of heap obtained from the OS.", float64(rs.HeapSysBytes)},
+			{"go_memstats_heap_objects", "gauge", "Allocated heap objects.", float64(rs.HeapObjects)},
+			{"go_gc_cycles", "counter", "Garbage collections completed.", float64(rs.GCCycles)},
+			{"go_gc_pause_seconds", "counter", "Time the program was paused for garbage collection.", rs.GCPauseTotalMS / 1000},
+			{"go_gc_last_pause_seconds", "gauge", "Length of the most recent garbage collection pause.", rs.LastGCPauseMS / 1000},
+			{"process_uptime_seconds", "gauge", "Time since the process started.", rs.UptimeSeconds},
+		} {
+			name := f.name
+			if f.typ == "counter" {
+				name += "_total"
+			}
+
+			w.family(f.name, f.typ, f.help)
+			w.sample(name, f.value)
+		}
+	}
+
+	w.buf.WriteString("# EOF\n")
+
+	return w.buf.Bytes()
+}
+
+// openMetricsWriter writes families and their samples in the OpenMetrics
+// text format
+type openMetricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *openMetricsWriter) family(name, typ, help string) {
+	w.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+	w.buf.WriteString("# HELP " + name + " " + help + "\n")
+}
+
+// sample writes a sample, with labels given as names and values in turn
+func (w *openMetricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+
+			w.buf.WriteString(labels[i] + `="` + openMetricsEscaper.Replace(labels[i+1]) + `"`)
+		}
+
+		w.buf.WriteByte('}')
+	}
+
+	w.buf.WriteString(" " + formatOpenMetricsFloat(value) + "\n")
+}
+
+// counters writes a counter family with a sample per key of counts, which
+// is left out when empty
+func (w *openMetricsWriter) counters(name, help, label string, counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+
+	w.family(name, "counter", help)
+
+	for _, k := range sortedCountKeys(counts) {
+		w.sample(name+"_total", float64(counts[k]), label, k)
+	}
+}
+
+// nestedCounters is counters for counts keyed by two labels
+func (w *openMetricsWriter) nestedCounters(name, help, outer, inner string, counts map[string]map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+
+	w.family(name, "counter", help)
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, j := range sortedCountKeys(counts[k]) {
+			w.sample(name+"_total", float64(counts[k][j]), outer, k, inner, j)
+		}
+	}
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatOpenMetricsFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedCountKeys(counts map[string]int64) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeOpenMetricsCounters(t *testing.T) {
+	got := string(encodeOpenMetricsCounters(countersPayload{
+		Requests: map[string]int64{"/b": 2, `/a"\`: 1},
+		Statuses: map[string]map[string]int64{"/users": {"200": 3}},
+		Latency: map[string]LatencyHistogram{
+			"/users": {
+				Buckets: []LatencyBucket{{LeMS: 10, Count: 2}, {LeMS: 100, Count: 3}},
+				Count:   4,
+				SumMS:   1061,
+			},
+		},
+	}))
+
+	expect := `# TYPE http_requests counter
+# HELP http_requests Requests handled, by URL or route template.
+http_requests_total{route="/a\"\\"} 1
+http_requests_total{route="/b"} 2
+# TYPE http_responses counter
+# HELP http_responses Responses sent, by route and status.
+http_responses_total{route="/users",status="200"} 3
+# TYPE http_request_duration_seconds histogram
+# HELP http_request_duration_seconds Time taken to handle requests, by route.
+http_request_duration_seconds_bucket{route="/users",le="0.01"} 2
+http_request_duration_seconds_bucket{route="/users",le="0.1"} 3
+http_request_duration_seconds_bucket{route="/users",le="+Inf"} 4
+http_request_duration_seconds_sum{route="/users"} 1.061
+http_request_duration_seconds_count{route="/users"} 4
+# TYPE http_synthetic_requests counter
+# HELP http_synthetic_requests Synthetic requests handled.
+http_synthetic_requests_total 0
+# EOF
+`
+
+	if got != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, got)
+	}
+}
+
+func TestCounters_openMetrics(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.TrackLatency()
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	req := httptest.NewRequest("GET", "/__/counters", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != openMetricsContentType {
+		t.Errorf("expected %q, received %q", openMetricsContentType, ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`http_requests_total{route="/users"} 1`,
+		`http_request_duration_seconds_bucket{route="default",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("expected exposition to end with # EOF, received:\n%s", body)
+	}
+
+	jsonRec := httptest.NewRecorder()
+	m.ServeHTTP(jsonRec, httptest.NewRequest("GET", "/__/counters", nil))
+
+	if rec.Header().Get("ETag") == jsonRec.Header().Get("ETag") {
+		t.Errorf("expected representations to have distinct ETags, both were %q", rec.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// AlertPanic is the Kind of alerts raised when the wrapped handler
+	// panics
+	AlertPanic = "panic"
+)
+
+// crash is a panic recovered from the wrapped handler
+type crash struct {
+	value interface{}
+	stack string
+}
+
+// protect calls fn, recovering and returning any panic. Panics are always
+// recovered, rather than left to the server, so that the response, lane
+// slots, and counters are all seen to; the wrapped handler's response is
+// replaced with a `500 Internal Server Error`, and the request is logged
+// with its panic and stack, and raises an AlertPanic alert.
+//
+// Under net/http, http.ErrAbortHandler is recovered so that everything is
+// seen to, and then re-raised, to abort the response as net/http does
+func protect(fn func()) (c *crash) {
+	defer func() {
+		if v := recover(); v != nil {
+			c = &crash{value: v, stack: string(debug.Stack())}
+		}
+	}()
+
+	fn()
+
+	return
+}
+
+// aborted returns whether c is a deliberate abort, with http.ErrAbortHandler
+func (c *crash) aborted() bool {
+	return c != nil && c.value == http.ErrAbortHandler
+}
+
+// alertCrash raises an alert for c, when set
+func (m *Middleware) alertCrash(c *crash, url, requestID string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	m.alert(Alert{
+		Time:      now,
+		Kind:      AlertPanic,
+		Summary:   fmt.Sprintf("panic serving %s: %v", url, c.value),
+		URL:       url,
+		RequestID: requestID,
+	})
+}
+
+// annotate adds c, when set, to l
+func (c *crash) annotate(l *LogEntry) {
+	if c == nil {
+		return
+	}
+
+	l.Panic = fmt.Sprint(c.value)
+	l.Stack = c.stack
+}
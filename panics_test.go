@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestServeHTTP_Panic(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+	m.SetLanes(LanePolicy{MaxConcurrent: 1, Lanes: []Lane{{Name: "normal"}}})
+
+	alerts := make(chan Alert, 2)
+	m.AddAlerter(AlerterFunc(func(a Alert) { alerts <- a }))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Code != http.StatusInternalServerError || rec.Body.String() != http.StatusText(http.StatusInternalServerError) {
+			t.Errorf("expected a 500, received %d %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	if n := m.InFlight(); n != 0 {
+		t.Errorf("expected nothing in flight, received %d", n)
+	}
+
+	select {
+	case a := <-alerts:
+		if a.Kind != AlertPanic || !strings.Contains(a.Summary, "boom") {
+			t.Errorf("unexpected alert %+v", a)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("expected an alert")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	body := string(logWriter.body)
+	if !strings.Contains(body, `"panic":"boom"`) || !strings.Contains(body, `"stack":"goroutine`) || !strings.Contains(body, `"status":500`) {
+		t.Errorf("expected the panic to be logged, received %q", body)
+	}
+}
+
+func TestServeHTTP_ErrAbortHandler(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if recover() != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to be re-raised")
+		}
+
+		if n := m.InFlight(); n != 0 {
+			t.Errorf("expected nothing in flight, received %d", n)
+		}
+	}()
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestServeFastHTTP_Panic(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("X-Partial", "yes")
+		panic("boom")
+	}))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/")
+
+	m.ServeFastHTTP(c)
+
+	if c.Response.StatusCode() != http.StatusInternalServerError || len(c.Response.Header.Peek("X-Partial")) > 0 {
+		t.Errorf("expected a clean 500, received %d", c.Response.StatusCode())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"panic":"boom"`) {
+		t.Errorf("expected the panic to be logged, received %q", logWriter.body)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+// PartitionLogger implements middleware.Loggable, forwarding each entry to
+// the loggers of the route group its URL's path belongs to, and entries in
+// no group to a fallback, so that each entry is logged to exactly one
+// place. This allows, say, separate access logs per API:
+//
+//	m.SetLoggers(middleware.NewPartitionLogger(accessLog).
+//		Partition("/api/*", apiLog).
+//		Partition("/admin/*", adminLog))
+//
+// Groups take the same form as patterns passed to AddRoutePolicy and, as
+// there, the most specific group which matches wins. Audit events are
+// forwarded to every Auditable logger
+type PartitionLogger struct {
+	groups   routeMatcher
+	loggers  [][]Loggable
+	fallback []Loggable
+}
+
+// NewPartitionLogger returns a PartitionLogger forwarding entries outside
+// every group to fallback, if any
+func NewPartitionLogger(fallback ...Loggable) *PartitionLogger {
+	return &PartitionLogger{fallback: fallback}
+}
+
+// Partition forwards entries whose path matches pattern to ls, rather than
+// to the fallback. Partition panics on a malformed pattern
+func (pl *PartitionLogger) Partition(pattern string, ls ...Loggable) *PartitionLogger {
+	if err := pl.groups.add(pattern, len(pl.loggers)); err != nil {
+		panic(err)
+	}
+
+	pl.loggers = append(pl.loggers, ls)
+
+	return pl
+}
+
+// Log implements middleware.Loggable
+func (pl *PartitionLogger) Log(l LogEntry) {
+	for _, logger := range pl.partition(l) {
+		logger.Log(l)
+	}
+}
+
+// Audit implements middleware.Auditable
+func (pl *PartitionLogger) Audit(e AuditEvent) {
+	for _, ls := range append(pl.loggers, pl.fallback) {
+		for _, logger := range ls {
+			if a, ok := logger.(Auditable); ok {
+				a.Audit(e)
+			}
+		}
+	}
+}
+
+// partition returns the loggers an entry belongs to
+func (pl *PartitionLogger) partition(l LogEntry) []Loggable {
+	p, ok := entryPath(l)
+	if !ok {
+		return pl.fallback
+	}
+
+	if _, i, ok := pl.groups.match(p); ok {
+		return pl.loggers[i.(int)]
+	}
+
+	return pl.fallback
+}
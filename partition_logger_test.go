@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"testing"
+)
+
+type auditingLogger struct {
+	collectingLogger
+
+	events []AuditEvent
+}
+
+func (al *auditingLogger) Audit(e AuditEvent) {
+	al.Lock()
+	defer al.Unlock()
+
+	al.events = append(al.events, e)
+}
+
+func TestPartitionLogger(t *testing.T) {
+	api, admin, refunds, rest := &collectingLogger{}, &collectingLogger{}, &collectingLogger{}, &auditingLogger{}
+
+	pl := NewPartitionLogger(rest).
+		Partition("/api/*", api).
+		Partition("/api/refunds/*", refunds).
+		Partition("/admin/*", admin)
+
+	for _, u := range []string{"/api/users?page=2", "https://example.com/api/users", "/api/refunds/1", "/admin/", "/healthcheck", "%zz"} {
+		pl.Log(LogEntry{URL: u})
+	}
+
+	for _, test := range []struct {
+		name   string
+		logger *collectingLogger
+		expect int
+	}{
+		{"api", api, 2},
+		{"refunds", refunds, 1},
+		{"admin", admin, 1},
+		{"rest", &rest.collectingLogger, 2},
+	} {
+		if len(test.logger.entries) != test.expect {
+			t.Errorf("%s: expected %d entries, received %+v", test.name, test.expect, test.logger.entries)
+		}
+	}
+
+	pl.Audit(AuditEvent{Action: "ban"})
+	if len(rest.events) != 1 {
+		t.Errorf("expected audit events to be forwarded, received %+v", rest.events)
+	}
+}
+
+func TestPartitionLogger_PanicsOnBadPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewPartitionLogger().Partition("/[", &collectingLogger{})
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sketchAccuracy is the relative error of percentiles estimated by a
+	// quantileSketch, such that a p99 of 200ms is within 2ms
+	sketchAccuracy = 0.01
+
+	// sketchMinMS is the shortest duration told apart from zero, below
+	// which durations are counted as zero
+	sketchMinMS = 0.001
+)
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// LatencyPercentiles are estimates of a route's request durations, as
+// shown under `percentiles` by the counters endpoint
+type LatencyPercentiles struct {
+	Count int64   `json:"count"`
+	P50MS float64 `json:"p50_ms"`
+	P95MS float64 `json:"p95_ms"`
+	P99MS float64 `json:"p99_ms"`
+}
+
+// TrackPercentiles keeps p50, p95, and p99 request durations per route,
+// served by the counters endpoint. Routes are keyed as per TrackLatency.
+//
+// Percentiles are estimated by a streaming sketch, in the manner of
+// DDSketch, to within 1% of the true durations, with memory bounded by
+// the range of durations seen rather than by the number of requests.
+// Admin and synthetic requests aren't tracked
+func (m *Middleware) TrackPercentiles() {
+	m.percentiles = &latencySketches{routes: make(map[string]*quantileSketch)}
+}
+
+// latencySketches holds a quantileSketch per route
+type latencySketches struct {
+	sync.Mutex
+
+	routes map[string]*quantileSketch
+}
+
+// observe records that a request to route took d
+func (ls *latencySketches) observe(route string, d time.Duration) {
+	if ls == nil {
+		return
+	}
+
+	ls.Lock()
+	defer ls.Unlock()
+
+	s, ok := ls.routes[route]
+	if !ok {
+		s = &quantileSketch{counts: make(map[int]int64)}
+		ls.routes[route] = s
+	}
+
+	s.add(durationMS(d))
+}
+
+// reset forgets every duration observed
+func (ls *latencySketches) reset() {
+	if ls == nil {
+		return
+	}
+
+	ls.Lock()
+	defer ls.Unlock()
+
+	ls.routes = make(map[string]*quantileSketch)
+}
+
+// snapshot returns every route's percentiles, or nil when percentiles
+// aren't tracked
+func (ls *latencySketches) snapshot() map[string]LatencyPercentiles {
+	if ls == nil {
+		return nil
+	}
+
+	ls.Lock()
+	defer ls.Unlock()
+
+	if len(ls.routes) == 0 {
+		return nil
+	}
+
+	out := make(map[string]LatencyPercentiles, len(ls.routes))
+	for route, s := range ls.routes {
+		out[route] = LatencyPercentiles{
+			Count: s.count,
+			P50MS: s.quantile(0.5),
+			P95MS: s.quantile(0.95),
+			P99MS: s.quantile(0.99),
+		}
+	}
+
+	return out
+}
+
+// quantileSketch counts values in buckets whose bounds grow geometrically,
+// by sketchGamma, so that any value is within sketchAccuracy of its
+// bucket's midpoint
+type quantileSketch struct {
+	counts map[int]int64
+	zeros  int64
+	count  int64
+	max    float64
+}
+
+func (s *quantileSketch) add(v float64) {
+	s.count++
+	if v > s.max {
+		s.max = v
+	}
+
+	if v < sketchMinMS {
+		s.zeros++
+
+		return
+	}
+
+	s.counts[int(math.Ceil(math.Log(v)/sketchLogGamma))]++
+}
+
+// quantile returns the estimated q quantile, or 0 when nothing has been
+// added
+func (s *quantileSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := int64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+
+	keys := make([]int, 0, len(s.counts))
+	for k := range s.counts {
+		keys = append(keys, k)
+	}
+
+	sort.Ints(keys)
+
+	seen := s.zeros
+	for _, k := range keys {
+		if seen += s.counts[k]; seen > rank {
+			return math.Min(2*math.Pow(sketchGamma, float64(k))/(sketchGamma+1), s.max)
+		}
+	}
+
+	return s.max
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"math"
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	s := &quantileSketch{counts: make(map[int]int64)}
+	if q := s.quantile(0.5); q != 0 {
+		t.Errorf("expected 0 from an empty sketch, received %v", q)
+	}
+
+	for _, i := range rand.Perm(10000) {
+		s.add(float64(i+1) / 10)
+	}
+
+	for q, expect := range map[float64]float64{0.5: 500, 0.95: 950, 0.99: 990, 1: 1000} {
+		if v := s.quantile(q); math.Abs(v-expect) > expect*sketchAccuracy {
+			t.Errorf("expected p%v within 1%% of %v, received %v", q*100, expect, v)
+		}
+	}
+
+	if n := len(s.counts); n > 1000 {
+		t.Errorf("expected a compact sketch, received %d buckets", n)
+	}
+}
+
+func TestTrackPercentiles(t *testing.T) {
+	m := NewMiddleware(TestSlowAPI{})
+	m.SetLoggers()
+	m.TrackPercentiles()
+
+	for i := 0; i < 3; i++ {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	}
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	p, ok := getCounters(t, m).Percentiles[defaultRouteKey]
+	if !ok || p.Count != 3 {
+		t.Fatalf("expected percentiles of 3 requests, received %+v", p)
+	}
+
+	if p.P50MS < 10 || p.P95MS < p.P50MS || p.P99MS < p.P95MS {
+		t.Errorf("expected ascending percentiles of at least 10ms, received %+v", p)
+	}
+}
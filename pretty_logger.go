@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatEnv names an environment variable which, when set, picks the
+	// format of the default logger: one of `json`, `logfmt`, `combined`, or
+	// `pretty`. This allows, say, `MIDDLEWARE_LOG_FORMAT=pretty` during local
+	// development without code changes
+	LogFormatEnv = "MIDDLEWARE_LOG_FORMAT"
+
+	// DefaultPrettyBarScale is the duration at which a PrettyLogger's
+	// duration bar is full
+	DefaultPrettyBarScale = time.Second
+
+	prettyBarWidth = 10
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// PrettyLogger implements middleware.Loggable, writing entries as aligned,
+// coloured, single lines for humans to read during development, as per:
+//
+//	14:57:48.750 GET     200    12.3ms ▮▯▯▯▯▯▯▯▯▯ /search?q=a 80d1b249
+//
+// The bar fills as a request approaches BarScale, making slow requests
+// easy to spot. It is not intended for production, where JSON or logfmt
+// output suit log aggregators far better.
+type PrettyLogger struct {
+	// Color enables ANSI colours. NewPrettyLogger turns it on when writing
+	// to a terminal, unless NO_COLOR is set
+	Color    bool
+	BarScale time.Duration
+
+	lock   sync.Mutex
+	output io.Writer
+}
+
+// NewPrettyLogger returns a PrettyLogger writing to w
+func NewPrettyLogger(w io.Writer) *PrettyLogger {
+	return &PrettyLogger{
+		Color:    isTerminal(w) && os.Getenv("NO_COLOR") == "",
+		BarScale: DefaultPrettyBarScale,
+		output:   w,
+	}
+}
+
+// Log implements middleware.Loggable
+func (pl *PrettyLogger) Log(l LogEntry) {
+	line := pl.Format(l) + "\n"
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	io.WriteString(pl.output, line)
+}
+
+// Format formats a LogEntry as a single line, without a trailing newline
+func (pl *PrettyLogger) Format(l LogEntry) string {
+	d := entryDuration(l)
+
+	id := l.RequestID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+
+	status := fmt.Sprintf("%3d", l.Status)
+	if l.Error != "" {
+		status = "ERR"
+	}
+
+	method := fmt.Sprintf("%-7s", l.Method)
+	if l.Outbound {
+		method = fmt.Sprintf("%-7s", "→"+l.Method)
+	}
+
+	parts := []string{
+		pl.paint(ansiDim, l.Time.Format("15:04:05.000")),
+		method,
+		pl.paint(statusColor(l), status),
+		fmt.Sprintf("%9s", prettyDuration(d)),
+		pl.paint(durationColor(d, pl.BarScale), pl.bar(d)),
+		l.URL,
+		pl.paint(ansiDim, id),
+	}
+
+	if l.Error != "" {
+		parts = append(parts, pl.paint(ansiRed, l.Error))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (pl *PrettyLogger) paint(color, s string) string {
+	if !pl.Color || s == "" {
+		return s
+	}
+
+	return color + s + ansiReset
+}
+
+// bar renders d as a proportion of BarScale
+func (pl *PrettyLogger) bar(d time.Duration) string {
+	scale := pl.BarScale
+	if scale <= 0 {
+		scale = DefaultPrettyBarScale
+	}
+
+	filled := int(d * prettyBarWidth / scale)
+	if d > 0 && filled == 0 {
+		filled = 1
+	}
+
+	if filled > prettyBarWidth {
+		filled = prettyBarWidth
+	}
+
+	return strings.Repeat("▮", filled) + strings.Repeat("▯", prettyBarWidth-filled)
+}
+
+// prettyDuration rounds d to something readable at a glance
+func prettyDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%dµs", d/time.Microsecond)
+	}
+}
+
+func statusColor(l LogEntry) string {
+	switch {
+	case l.Status >= 500 || l.Error != "":
+		return ansiRed
+	case l.Status >= 400:
+		return ansiYellow
+	case l.Status >= 300:
+		return ansiCyan
+	default:
+		return ansiGreen
+	}
+}
+
+func durationColor(d, scale time.Duration) string {
+	switch {
+	case d >= scale:
+		return ansiRed
+	case d >= scale/2:
+		return ansiYellow
+	default:
+		return ansiGreen
+	}
+}
+
+// isTerminal returns true when w is a character device, such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
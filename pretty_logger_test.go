@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrettyLogger_Format(t *testing.T) {
+	pl := &PrettyLogger{BarScale: 100 * time.Millisecond}
+
+	line := pl.Format(LogEntry{
+		Time:       time.Date(2017, 5, 27, 14, 57, 48, 750000000, time.UTC),
+		Method:     "GET",
+		Status:     200,
+		DurationMS: 52.5,
+		URL:        "/search?q=a",
+		RequestID:  "80d1b249-0b43-4adc-9456-e42e0b942ec0",
+	})
+
+	expect := "14:57:48.750 GET     200    52.5ms ▮▮▮▮▮▯▯▯▯▯ /search?q=a 80d1b249"
+	if line != expect {
+		t.Errorf("expected %q, received %q", expect, line)
+	}
+
+	pl.Color = true
+	if line = pl.Format(LogEntry{Status: 503, DurationMS: 500}); !strings.Contains(line, ansiRed+"503"+ansiReset) {
+		t.Errorf("expected a red status, received %q", line)
+	}
+}
+
+func TestPrettyLogger_FormatSubMillisecond(t *testing.T) {
+	pl := &PrettyLogger{}
+
+	line := pl.Format(LogEntry{Status: 200, Duration: "394.823µs", DurationMS: 0})
+	if !strings.Contains(line, " 394µs ") {
+		t.Errorf("expected a sub-millisecond duration, received %q", line)
+	}
+}
+
+func TestLogFormatEnv(t *testing.T) {
+	t.Setenv(LogFormatEnv, "pretty")
+
+	m := NewMiddleware(http.NotFoundHandler())
+	if _, ok := m.loggers[0].(*PrettyLogger); !ok {
+		t.Errorf("expected a PrettyLogger, received %T", m.loggers[0])
+	}
+
+	t.Setenv(LogFormatEnv, "nonsense")
+
+	m = NewMiddleware(http.NotFoundHandler())
+	if _, ok := m.loggers[0].(defaultLogger); !ok {
+		t.Errorf("expected unknown formats to fall back to JSON, received %T", m.loggers[0])
+	}
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProfileKind is the kind of profile captured by ProfileSlowRequests
+type ProfileKind string
+
+const (
+	// ProfileCPU captures a CPU profile, for use with `go tool pprof`
+	ProfileCPU ProfileKind = "cpu"
+
+	// ProfileTrace captures an execution trace, for use with `go tool trace`
+	ProfileTrace ProfileKind = "trace"
+)
+
+const (
+	// DefaultProfileDuration is how long each capture runs for
+	DefaultProfileDuration = 5 * time.Second
+
+	// DefaultProfileCooldown is the least time between captures
+	DefaultProfileCooldown = time.Minute
+
+	// DefaultMaxProfiles is the number of captures kept; the oldest are
+	// discarded first
+	DefaultMaxProfiles = 10
+
+	// maxArmedRoutes bounds the routes waiting for a capture
+	maxArmedRoutes = 100
+)
+
+// ProfilePolicy configures ProfileSlowRequests. Zero durations and counts
+// take their defaults
+type ProfilePolicy struct {
+	// Threshold is the duration beyond which a request triggers a capture,
+	// and is required
+	Threshold time.Duration
+
+	// Kind defaults to ProfileCPU
+	Kind ProfileKind
+
+	Duration    time.Duration
+	Cooldown    time.Duration
+	MaxProfiles int
+}
+
+// Profile describes a capture made by ProfileSlowRequests
+type Profile struct {
+	ID       int           `json:"id"`
+	Kind     ProfileKind   `json:"kind"`
+	Route    string        `json:"route"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Bytes    int           `json:"bytes"`
+	Error    string        `json:"error,omitempty"`
+
+	data []byte
+}
+
+// ProfileSlowRequests captures a CPU profile, or execution trace, when a
+// request takes longer than p.Threshold. As the slow request has already
+// finished, the capture starts with the next request to the same route,
+// and runs for p.Duration, covering it and any others in flight.
+//
+// Captures are listed by the `profiles` admin endpoint, and downloaded
+// with `profiles?id=<id>`. Only one capture runs at a time, and none while
+// something else, such as net/http/pprof, is profiling. ProfileSlowRequests
+// panics without a Threshold, or with an unknown Kind
+func (m *Middleware) ProfileSlowRequests(p ProfilePolicy) {
+	if p.Threshold <= 0 {
+		panic(fmt.Errorf("profile threshold must be positive"))
+	}
+
+	switch p.Kind {
+	case "":
+		p.Kind = ProfileCPU
+	case ProfileCPU, ProfileTrace:
+	default:
+		panic(fmt.Errorf("unknown profile kind %q", p.Kind))
+	}
+
+	if p.Duration <= 0 {
+		p.Duration = DefaultProfileDuration
+	}
+
+	if p.Cooldown <= 0 {
+		p.Cooldown = DefaultProfileCooldown
+	}
+
+	if p.MaxProfiles <= 0 {
+		p.MaxProfiles = DefaultMaxProfiles
+	}
+
+	m.profiler = &profiler{policy: p, armed: make(map[string]bool), profiles: []*Profile{}}
+	m.addAdminEndpoint("profiles", m.serveProfiles)
+}
+
+// profiler arms captures on slow routes, and holds finished captures
+type profiler struct {
+	sync.Mutex
+
+	policy   ProfilePolicy
+	armed    map[string]bool
+	running  bool
+	last     time.Time
+	profiles []*Profile
+	nextID   int
+}
+
+// profileKey returns the key by which requests are considered similar: their
+// route pattern, or their path when they have none
+func profileKey(route, p string) string {
+	if route != "" {
+		return route
+	}
+
+	return p
+}
+
+// begin starts a capture, when route is armed and no capture is running.
+// A nil profiler does nothing
+func (p *profiler) begin(route string, now time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.armed[route] || p.running || now.Sub(p.last) < p.policy.Cooldown {
+		return
+	}
+
+	delete(p.armed, route)
+
+	p.nextID++
+	prof := &Profile{ID: p.nextID, Kind: p.policy.Kind, Route: route, Started: now}
+
+	buf := new(bytes.Buffer)
+
+	var err error
+	if prof.Kind == ProfileTrace {
+		err = trace.Start(buf)
+	} else {
+		err = pprof.StartCPUProfile(buf)
+	}
+
+	if err != nil {
+		prof.Error = err.Error()
+		p.store(prof)
+
+		return
+	}
+
+	p.running, p.last = true, now
+
+	time.AfterFunc(p.policy.Duration, func() {
+		if prof.Kind == ProfileTrace {
+			trace.Stop()
+		} else {
+			pprof.StopCPUProfile()
+		}
+
+		prof.Duration = p.policy.Duration
+		prof.data = buf.Bytes()
+		prof.Bytes = len(prof.data)
+
+		p.Lock()
+		defer p.Unlock()
+
+		p.running = false
+		p.store(prof)
+	})
+}
+
+// finish arms a capture for route, when a request to it was slow. A nil
+// profiler does nothing
+func (p *profiler) finish(route string, took time.Duration) {
+	if p == nil || took <= p.policy.Threshold {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.armed) < maxArmedRoutes {
+		p.armed[route] = true
+	}
+}
+
+// store keeps prof, discarding the oldest capture when full. Callers hold
+// the lock
+func (p *profiler) store(prof *Profile) {
+	p.profiles = append(p.profiles, prof)
+	if len(p.profiles) > p.policy.MaxProfiles {
+		p.profiles = p.profiles[1:]
+	}
+}
+
+func (m *Middleware) serveProfiles(r adminRequest) adminResponse {
+	m.profiler.Lock()
+	defer m.profiler.Unlock()
+
+	if id := r.query.Get("id"); id != "" {
+		for _, prof := range m.profiler.profiles {
+			if strconv.Itoa(prof.ID) == id && prof.data != nil {
+				return adminResponse{status: http.StatusOK, contentType: "application/octet-stream", body: prof.data}
+			}
+		}
+
+		return adminError(http.StatusNotFound, fmt.Errorf("no profile %q", id))
+	}
+
+	b, err := json.Marshal(m.profiler.profiles)
+	if err != nil {
+		return adminError(http.StatusInternalServerError, err)
+	}
+
+	return jsonResponse(http.StatusOK, b)
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowAPI takes a little while to respond
+type slowAPI struct{}
+
+func (slowAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(5 * time.Millisecond)
+
+	fmt.Fprint(w, "slow")
+}
+
+func TestProfileSlowRequests(t *testing.T) {
+	for _, kind := range []ProfileKind{ProfileCPU, ProfileTrace} {
+		t.Run(string(kind), func(t *testing.T) {
+			m := NewMiddleware(slowAPI{})
+			m.ProfileSlowRequests(ProfilePolicy{Threshold: time.Millisecond, Kind: kind, Duration: 50 * time.Millisecond})
+
+			// The first slow request arms a capture, which the second runs
+			// around
+			for i := 0; i < 2; i++ {
+				m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/reports", nil))
+			}
+
+			time.Sleep(150 * time.Millisecond)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest("GET", "/__/profiles", nil))
+
+			var profiles []Profile
+			if err := json.Unmarshal(w.Body.Bytes(), &profiles); err != nil {
+				t.Fatalf("unexpected error: %v, %q", err, w.Body.String())
+			}
+
+			if len(profiles) != 1 {
+				t.Fatalf("expected 1 profile, received %+v", profiles)
+			}
+
+			prof := profiles[0]
+			if prof.Error != "" || prof.Kind != kind || prof.Route != "/reports" || prof.Bytes == 0 {
+				t.Errorf("unexpected profile %+v", prof)
+			}
+
+			w = httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/__/profiles?id=%d", prof.ID), nil))
+
+			if w.Code != http.StatusOK || w.Body.Len() != prof.Bytes {
+				t.Errorf("expected a %d byte download, received %d %d bytes", prof.Bytes, w.Code, w.Body.Len())
+			}
+
+			w = httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest("GET", "/__/profiles?id=99", nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status %d, received %d", http.StatusNotFound, w.Code)
+			}
+		})
+	}
+}
+
+func TestProfiler_Cooldown(t *testing.T) {
+	p := &profiler{policy: ProfilePolicy{Threshold: time.Second, Cooldown: time.Minute}, armed: make(map[string]bool)}
+	p.last = time.Now()
+
+	p.finish("/fast", time.Millisecond)
+	p.finish("/slow", 2*time.Second)
+
+	if p.armed["/fast"] || !p.armed["/slow"] {
+		t.Errorf("expected only slow routes to be armed, received %+v", p.armed)
+	}
+
+	p.begin("/slow", time.Now())
+
+	if p.running || !p.armed["/slow"] {
+		t.Errorf("expected no capture during cooldown")
+	}
+}
@@ -0,0 +1,44 @@
+package prom
+
+import (
+	"os"
+	"time"
+
+	"github.com/zeebox/go-http-middleware"
+)
+
+func init() {
+	middleware.RegisterPrometheus(configure)
+}
+
+// configure applies the prometheus and pushgateway sections of c to m
+func configure(m *middleware.Middleware, c middleware.Config) error {
+	var e *Exporter
+	if c.Prometheus.Enabled {
+		e = Export(m, Config{Namespace: c.Prometheus.Namespace, Buckets: c.Prometheus.Buckets})
+	}
+
+	if c.Pushgateway.URL == "" {
+		return nil
+	}
+
+	pc := PushgatewayConfig{
+		URL:      c.Pushgateway.URL,
+		Job:      c.Pushgateway.Job,
+		Grouping: make(map[string]string, len(c.Pushgateway.Grouping)),
+		Interval: time.Duration(c.Pushgateway.Interval),
+	}
+
+	for k, v := range c.Pushgateway.Grouping {
+		pc.Grouping[k] = os.ExpandEnv(v)
+	}
+
+	p, err := newPusher(e, pc)
+	if err != nil {
+		return err
+	}
+
+	m.AddReporter(pc.Interval, p.Push)
+
+	return nil
+}
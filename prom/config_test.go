@@ -0,0 +1,38 @@
+package prom
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeebox/go-http-middleware"
+)
+
+func TestConfigure(t *testing.T) {
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+
+	c := middleware.Config{Prometheus: middleware.PrometheusConfig{Enabled: true, Namespace: "payments"}}
+	if err := c.Apply(m); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), "payments_http_requests_total") {
+		t.Errorf("expected metrics to be exported, received %s", rec.Body.String())
+	}
+}
+
+func TestConfigure_PushgatewayRequiresPrometheus(t *testing.T) {
+	c := middleware.Config{Pushgateway: middleware.PushgatewayReporterConfig{URL: "http://localhost:9091", Job: "import"}}
+
+	if err := c.Apply(middleware.NewMiddleware(okHandler)); err == nil {
+		t.Error("expected an error without Prometheus")
+	}
+}
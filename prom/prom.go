@@ -0,0 +1,205 @@
+// Package prom exports middleware requests to Prometheus, kept apart from
+// the middleware package so that it needn't depend on the Prometheus
+// client. Importing it also lets a middleware.Config enable Prometheus
+// and Pushgateway
+package prom
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/zeebox/go-http-middleware"
+)
+
+// Config configures the metrics exported by Export
+type Config struct {
+	// Namespace prefixes metric names, such as `payments` for
+	// `payments_http_requests_total`
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds, of the request duration
+	// histogram's buckets, defaulting to those of TrackLatency when it was
+	// called first, or else to prometheus.DefBuckets
+	Buckets []float64
+
+	// Registerer is where metrics are registered, and Gatherer where
+	// they're served from. Without a Registerer, a registry of the
+	// exporter's own is used, along with Go runtime and process metrics,
+	// and served alongside Gatherer if given. A Registerer which is also a
+	// Gatherer, such as a *prometheus.Registry, needn't be given twice
+	Registerer prometheus.Registerer
+	Gatherer   prometheus.Gatherer
+}
+
+// Export exports m's requests as per c, served in the Prometheus
+// exposition format from m's `metrics` admin endpoint, such as
+// `/__/metrics`:
+//   - http_requests_total, counting requests;
+//   - http_request_duration_seconds, a histogram of request durations;
+//   - http_request_bytes_total and http_response_bytes_total, the bytes
+//     of request bodies read and of response bodies written;
+//   - http_requests_in_flight, the requests being handled right now; and
+//   - http_apdex_requests_total, counting requests to routes with an
+//     ApdexThreshold by zone, from which scores can be derived
+//
+// Requests are labelled by route, method, and status class, as per
+// middleware.MetricsExporter; bytes and requests in flight by route and
+// method alone, and Apdex zones by route and zone.
+//
+// Export panics when metrics can't be registered, such as when c's
+// Registerer already has them, or when c has no Gatherer and its
+// Registerer isn't one
+func Export(m *middleware.Middleware, c Config) *Exporter {
+	if len(c.Buckets) == 0 {
+		for _, b := range m.LatencyBuckets() {
+			c.Buckets = append(c.Buckets, b.Seconds())
+		}
+	}
+
+	e := New(c)
+	m.ExportMetrics(e)
+
+	return e
+}
+
+// Exporter implements middleware.MetricsExporter for Prometheus. Use
+// Export, rather than New, unless it's to be given to ExportMetrics
+// later
+type Exporter struct {
+	gatherer      prometheus.Gatherer
+	requests      *prometheus.CounterVec
+	durations     *prometheus.HistogramVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+	inFlight      *prometheus.GaugeVec
+	apdex         *prometheus.CounterVec
+}
+
+// New returns an Exporter registered as per c, whose Buckets default to
+// prometheus.DefBuckets. It panics as Export does
+func New(c Config) *Exporter {
+	if c.Registerer == nil {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+		c.Registerer = reg
+		if c.Gatherer != nil {
+			c.Gatherer = prometheus.Gatherers{reg, c.Gatherer}
+		} else {
+			c.Gatherer = reg
+		}
+	}
+
+	if c.Gatherer == nil {
+		g, ok := c.Registerer.(prometheus.Gatherer)
+		if !ok {
+			panic("prom: Config requires a Gatherer")
+		}
+
+		c.Gatherer = g
+	}
+
+	if len(c.Buckets) == 0 {
+		c.Buckets = prometheus.DefBuckets
+	}
+
+	e := &Exporter{
+		gatherer: c.Gatherer,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "http_requests_total",
+			Help:      "Requests handled, by route, method, and status class.",
+		}, []string{"route", "method", "status"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle requests, by route, method, and status class.",
+			Buckets:   c.Buckets,
+		}, []string{"route", "method", "status"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "http_request_bytes_total",
+			Help:      "Bytes of request bodies read, by route and method.",
+		}, []string{"route", "method"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "http_response_bytes_total",
+			Help:      "Bytes of response bodies written, by route and method.",
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: c.Namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Requests being handled, by route and method.",
+		}, []string{"route", "method"}),
+		apdex: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "http_apdex_requests_total",
+			Help:      "Requests to routes with an Apdex threshold, by route and Apdex zone.",
+		}, []string{"route", "zone"}),
+	}
+
+	c.Registerer.MustRegister(e.requests, e.durations, e.requestBytes, e.responseBytes, e.inFlight, e.apdex)
+
+	return e
+}
+
+// Begin implements middleware.MetricsExporter
+func (e *Exporter) Begin(route, method string) func() {
+	g := e.inFlight.WithLabelValues(route, method)
+	g.Inc()
+
+	return g.Dec
+}
+
+// Observe implements middleware.MetricsExporter
+func (e *Exporter) Observe(route, method, status string, l middleware.LogEntry, d time.Duration) {
+	e.requests.WithLabelValues(route, method, status).Inc()
+	e.durations.WithLabelValues(route, method, status).Observe(d.Seconds())
+	e.requestBytes.WithLabelValues(route, method).Add(float64(l.RequestBytes))
+	e.responseBytes.WithLabelValues(route, method).Add(float64(l.ResponseBytes))
+
+	if l.Apdex != "" {
+		e.apdex.WithLabelValues(route, l.Apdex).Inc()
+	}
+}
+
+// Reset implements middleware.MetricsExporter
+func (e *Exporter) Reset() {
+	e.requests.Reset()
+	e.durations.Reset()
+	e.requestBytes.Reset()
+	e.responseBytes.Reset()
+	e.apdex.Reset()
+}
+
+// Metrics implements middleware.MetricsExporter
+func (e *Exporter) Metrics(accept string) ([]byte, string, error) {
+	mfs, err := e.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return nil, "", err
+	}
+
+	return encode(mfs, accept)
+}
+
+// encode renders mfs in the exposition format negotiated by an Accept
+// header
+func encode(mfs []*dto.MetricFamily, accept string) ([]byte, string, error) {
+	format := expfmt.Negotiate(http.Header{"Accept": []string{accept}})
+
+	var buf bytes.Buffer
+
+	enc := expfmt.NewEncoder(&buf, format)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return buf.Bytes(), string(format), nil
+}
@@ -0,0 +1,186 @@
+package prom
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+	"github.com/zeebox/go-http-middleware"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+type fhFunc func(*fasthttp.RequestCtx)
+
+func (f fhFunc) Handle(ctx *fasthttp.RequestCtx) {
+	f(ctx)
+}
+
+func TestExport(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m := middleware.NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(middleware.RouteTemplates("/users/:id"))
+	Export(m, Config{Namespace: "test", Registerer: reg})
+
+	for _, p := range []string{"/users/1", "/users/2", "/users/missing", "/other"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/other", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	expect := `
+# HELP test_http_requests_total Requests handled, by route, method, and status class.
+# TYPE test_http_requests_total counter
+test_http_requests_total{method="GET",route="/users/:id",status="2xx"} 2
+test_http_requests_total{method="GET",route="/users/:id",status="4xx"} 1
+test_http_requests_total{method="GET",route="default",status="2xx"} 1
+test_http_requests_total{method="other",route="default",status="2xx"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expect), "test_http_requests_total"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(reg, "test_http_request_duration_seconds"); n != 4 {
+		t.Errorf("expected 4 duration series, received %d", n)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected text exposition, received %d %v", rec.Code, rec.Header())
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `test_http_requests_in_flight{method="GET",route="default"} 0`) {
+		t.Errorf("expected the in flight gauge, received %s", body)
+	}
+
+	if strings.Contains(body, "go_goroutines") {
+		t.Errorf("expected only the given registry to be served, received %s", body)
+	}
+}
+
+func TestExport_InFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	m := middleware.NewMiddleware(fhFunc(func(ctx *fasthttp.RequestCtx) {
+		close(started)
+		<-release
+	}))
+	m.SetLoggers()
+	e := Export(m, Config{})
+
+	go m.ServeFastHTTP(&fasthttp.RequestCtx{})
+
+	<-started
+
+	if v := testutil.ToFloat64(e.inFlight.WithLabelValues("default", "GET")); v != 1 {
+		t.Errorf("expected 1 request in flight, received %v", v)
+	}
+
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+
+	if v := testutil.ToFloat64(e.inFlight.WithLabelValues("default", "GET")); v != 0 {
+		t.Errorf("expected nothing in flight, received %v", v)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), "go_goroutines") {
+		t.Errorf("expected runtime metrics from the exporter's own registry, received %s", rec.Body.String())
+	}
+}
+
+func TestExport_apdex(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+	m.AddRoutePolicy("/users/*", middleware.RoutePolicy{ApdexThreshold: time.Minute})
+	Export(m, Config{Registerer: reg})
+
+	for _, p := range []string{"/users/1", "/users/2", "/other"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	expect := `
+# HELP http_apdex_requests_total Requests to routes with an Apdex threshold, by route and Apdex zone.
+# TYPE http_apdex_requests_total counter
+http_apdex_requests_total{route="/users/*",zone="satisfied"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expect), "http_apdex_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExport_bytes(t *testing.T) {
+	m := middleware.NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	m.SetLoggers()
+	m.SetRouteResolver(middleware.RouteTemplates("/users/:id"))
+	e := Export(m, Config{})
+
+	for _, p := range []string{"/users/1", "/users/2"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", p, strings.NewReader(`{"name":"bob"}`)))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if v := testutil.ToFloat64(e.requestBytes.WithLabelValues("/users/:id", "POST")); v != 28 {
+		t.Errorf("expected 28 bytes exported, received %v", v)
+	}
+}
+
+func TestExport_LatencyBuckets(t *testing.T) {
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+	m.AddRoutePolicy("/users/*", middleware.RoutePolicy{})
+	m.TrackLatency(7*time.Millisecond, time.Second)
+	Export(m, Config{Registerer: prometheus.NewRegistry()})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), `http_request_duration_seconds_bucket{method="GET",route="/users/*",status="2xx",le="0.007"}`) {
+		t.Errorf("expected the latency buckets to be shared, received %s", rec.Body.String())
+	}
+}
+
+func TestExporter_Reset(t *testing.T) {
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+	e := Export(m, Config{})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	m.ResetCounters()
+
+	if n := testutil.CollectAndCount(e.requests); n != 0 {
+		t.Errorf("expected exported requests to be reset, received %d series", n)
+	}
+}
@@ -0,0 +1,77 @@
+package prom
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/zeebox/go-http-middleware"
+)
+
+// PushgatewayConfig configures the metrics pushed by Pushgateway
+type PushgatewayConfig struct {
+	// URL is the Pushgateway's, such as `http://pushgateway:9091`
+	URL string
+
+	// Job is the job label metrics are grouped under, such as
+	// `nightly-import`
+	Job string
+
+	// Grouping holds further labels metrics are grouped under, such as
+	// `{"instance": "web-1"}`, so that processes sharing a job don't
+	// replace each other's metrics
+	Grouping map[string]string
+
+	// Interval, when set, pushes every interval as well as on Shutdown.
+	// Zero pushes on Shutdown alone, which suits processes living no
+	// longer than a batch run
+	Interval time.Duration
+
+	// Client defaults to an http.Client with a ten second timeout
+	Client *http.Client
+}
+
+// Pushgateway pushes the metrics of e, as exported from m by Export, to a
+// Prometheus Pushgateway, as described by c, for short-lived servers, such
+// as those of batch and cron jobs, which may be gone before they can be
+// scraped.
+//
+// Every metric served by the `metrics` admin endpoint is pushed, replacing
+// those last pushed under the same job and grouping labels. The final push
+// is made by m's Shutdown, which returns its error, if any; errors pushing
+// on the interval are dropped. Nothing is pushed for intervals without
+// requests.
+//
+// Pushgateway panics when e is nil, or when c has no URL or Job
+func Pushgateway(m *middleware.Middleware, e *Exporter, c PushgatewayConfig) {
+	p, err := newPusher(e, c)
+	if err != nil {
+		panic(err)
+	}
+
+	m.AddReporter(c.Interval, p.Push)
+}
+
+// newPusher returns a pusher of e's metrics to c's URL
+func newPusher(e *Exporter, c PushgatewayConfig) (*push.Pusher, error) {
+	switch {
+	case e == nil:
+		return nil, fmt.Errorf("pushgateway requires prometheus")
+	case c.URL == "":
+		return nil, fmt.Errorf("pushgateway requires a url")
+	case c.Job == "":
+		return nil, fmt.Errorf("pushgateway requires a job")
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := push.New(c.URL, c.Job).Gatherer(e.gatherer).Client(c.Client)
+	for k, v := range c.Grouping {
+		p = p.Grouping(k, v)
+	}
+
+	return p, nil
+}
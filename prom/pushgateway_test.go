@@ -0,0 +1,99 @@
+package prom
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zeebox/go-http-middleware"
+)
+
+func TestPushgateway(t *testing.T) {
+	type push struct {
+		method, path, body string
+	}
+
+	pushes := make(chan push, 10)
+
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		pushes <- push{r.Method, r.URL.Path, string(b)}
+	}))
+	defer gw.Close()
+
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+	e := Export(m, Config{Registerer: prometheus.NewRegistry()})
+	Pushgateway(m, e, PushgatewayConfig{URL: gw.URL, Job: "import", Grouping: map[string]string{"instance": "web-1"}})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case p := <-pushes:
+		t.Fatalf("expected no push before Shutdown without an interval, received %+v", p)
+	default:
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	select {
+	case p := <-pushes:
+		if p.method != http.MethodPut || p.path != "/metrics/job/import/instance/web-1" {
+			t.Errorf("expected a PUT to the job's group, received %s %s", p.method, p.path)
+		}
+
+		// Pushes are protobuf encoded, in which metric names are plain
+		if !strings.Contains(p.body, "http_requests_total") {
+			t.Errorf("expected request metrics to be pushed, received %q", p.body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a push on Shutdown")
+	}
+}
+
+func TestPushgateway_interval(t *testing.T) {
+	pushes := make(chan struct{}, 10)
+
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes <- struct{}{}
+	}))
+	defer gw.Close()
+
+	m := middleware.NewMiddleware(okHandler)
+	m.SetLoggers()
+	e := Export(m, Config{Registerer: prometheus.NewRegistry()})
+	Pushgateway(m, e, PushgatewayConfig{URL: gw.URL, Job: "import", Interval: 50 * time.Millisecond})
+
+	defer m.Shutdown(context.Background())
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	select {
+	case <-pushes:
+	case <-time.After(time.Second):
+		t.Fatal("expected a push on the interval")
+	}
+}
+
+func TestPushgateway_errors(t *testing.T) {
+	if _, err := newPusher(nil, PushgatewayConfig{URL: "http://localhost:9091", Job: "import"}); err == nil {
+		t.Error("expected an error without Prometheus")
+	}
+
+	e := New(Config{Registerer: prometheus.NewRegistry()})
+
+	for _, c := range []PushgatewayConfig{{Job: "import"}, {URL: "http://localhost:9091"}} {
+		if _, err := newPusher(e, c); err == nil {
+			t.Errorf("expected an error from %+v", c)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package prom
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/zeebox/go-http-middleware"
+)
+
+// registryLabel is the label added to the metrics of each Middleware in a
+// middleware.Registry, naming the Middleware they came from
+const registryLabel = "middleware"
+
+// MergeMetrics implements middleware.MetricsMerger, so that a
+// middleware.Registry serves the metrics of every member exporting to
+// Prometheus, labelled `middleware` by name.
+//
+// Middlewares sharing a Registerer are told apart by their Namespace
+// alone; their metrics are served unlabelled, and only once. Exporters
+// other than Exporters are skipped
+func (e *Exporter) MergeMetrics(exporters map[string]middleware.MetricsExporter, accept string) ([]byte, string, error) {
+	families, err := gather(exporters)
+	if err != nil && len(families) == 0 {
+		return nil, "", err
+	}
+
+	return encode(families, accept)
+}
+
+// gather collects the metrics of every Exporter, merging families of the
+// same name and labelling each metric with the member it came from.
+// Gatherers shared by members are gathered once, without labels
+func gather(exporters map[string]middleware.MetricsExporter) (families []*dto.MetricFamily, err error) {
+	names := make([]string, 0, len(exporters))
+	members := make(map[string]*Exporter, len(exporters))
+
+	for name, me := range exporters {
+		if e, ok := me.(*Exporter); ok {
+			names = append(names, name)
+			members[name] = e
+		}
+	}
+
+	sort.Strings(names)
+
+	byName := make(map[string]*dto.MetricFamily)
+
+	for i, name := range names {
+		e := members[name]
+
+		if sharesGatherer(e.gatherer, members, names[:i]) {
+			continue
+		}
+
+		shared := sharesGatherer(e.gatherer, members, names[i+1:])
+
+		mfs, gerr := e.gatherer.Gather()
+		if gerr != nil {
+			err = gerr
+		}
+
+		for _, mf := range mfs {
+			if !shared {
+				for _, metric := range mf.Metric {
+					metric.Label = append(metric.Label, registryLabelPair(name))
+				}
+			}
+
+			existing, ok := byName[mf.GetName()]
+			if !ok {
+				byName[mf.GetName()] = mf
+
+				continue
+			}
+
+			existing.Metric = append(existing.Metric, mf.Metric...)
+		}
+	}
+
+	for _, mf := range byName {
+		families = append(families, mf)
+	}
+
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+
+	return
+}
+
+// sharesGatherer returns whether any of the named members gathers from g
+func sharesGatherer(g prometheus.Gatherer, members map[string]*Exporter, names []string) bool {
+	for _, name := range names {
+		if sameGatherer(members[name].gatherer, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameGatherer compares gatherers, treating those which can't be compared,
+// such as prometheus.Gatherers, as distinct
+func sameGatherer(a, b prometheus.Gatherer) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb || !ta.Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+func registryLabelPair(name string) *dto.LabelPair {
+	k := registryLabel
+
+	return &dto.LabelPair{Name: &k, Value: &name}
+}
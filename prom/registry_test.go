@@ -0,0 +1,70 @@
+package prom
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zeebox/go-http-middleware"
+)
+
+func TestExporter_MergeMetrics(t *testing.T) {
+	r := middleware.NewRegistry()
+
+	for name, requests := range map[string]int{"public": 2, "internal": 1} {
+		m := middleware.NewMiddleware(okHandler)
+		m.SetLoggers()
+		Export(m, Config{})
+
+		for i := 0; i < requests; i++ {
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+
+		r.Register(name, m)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	body := rec.Body.String()
+	for _, expect := range []string{
+		`http_requests_total{method="GET",route="default",status="2xx",middleware="public"} 2`,
+		`http_requests_total{method="GET",route="default",status="2xx",middleware="internal"} 1`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("expected %s, received %s", expect, body)
+		}
+	}
+
+	if strings.Count(body, "# TYPE http_requests_total") != 1 {
+		t.Errorf("expected families to be merged, received %s", body)
+	}
+}
+
+func TestExporter_MergeMetricsSharedRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	r := middleware.NewRegistry()
+	for _, name := range []string{"a", "b"} {
+		m := middleware.NewMiddleware(okHandler)
+		m.SetLoggers()
+		Export(m, Config{Namespace: name, Registerer: reg})
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		r.Register(name, m)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/__/metrics", nil))
+
+	body := rec.Body.String()
+	if strings.Count(body, `a_http_requests_total{method="GET",route="default",status="2xx"} 1`) != 1 || strings.Contains(body, "middleware=") {
+		t.Errorf("expected a shared registry to be served once, unlabelled, received %s", body)
+	}
+}
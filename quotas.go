@@ -0,0 +1,422 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQuotaMaxHolders is the number of quota holders tracked at once
+	DefaultQuotaMaxHolders = 100000
+
+	// quotaSaveInterval is how often quota usage is pruned, and written to
+	// QuotaPolicy.Path, at most
+	quotaSaveInterval = 10 * time.Second
+)
+
+// QuotaPolicy configures request quotas per tenant, or per API key, such as
+// for plans of an API product. Tenants exceeding a quota receive a
+// `429 Too Many Requests` without the wrapped handler being called, until
+// the quota's period ends. Periods are whole UTC hours and days.
+//
+// Responses to quota holders carry `X-RateLimit-Limit`,
+// `X-RateLimit-Remaining`, and `X-RateLimit-Reset` headers, describing
+// whichever quota is closest to running out. Requests without a tenant
+// aren't subject to quotas.
+type QuotaPolicy struct {
+	// KeyHeader identifies quota holders by the value of this header, such
+	// as an API key, rather than by the Middleware's TenantHeader. Key
+	// values are hashed before being stored or listed, as per BanPolicy
+	KeyHeader string `json:"key_header" yaml:"key_header"`
+
+	// Hourly and Daily are the number of requests a tenant may make per
+	// hour and per day. Zero is unlimited
+	Hourly int64 `json:"hourly" yaml:"hourly"`
+	Daily  int64 `json:"daily" yaml:"daily"`
+
+	// Weights scale the quotas of individual tenants, such as 10 for a
+	// tenant on a plan with ten times the default quota. Weights are keyed
+	// by tenant, or by the unhashed value of KeyHeader
+	Weights map[string]float64 `json:"weights" yaml:"weights"`
+
+	// MaxHolders caps the number of holders whose usage is tracked, as a
+	// KeyHeader takes whatever values clients send. Holders are forgotten
+	// once their day is over; until then, requests from holders beyond
+	// the cap aren't subject to quotas. Defaults to DefaultQuotaMaxHolders
+	MaxHolders int `json:"max_holders" yaml:"max_holders"`
+
+	// Path, when set, is a file usage is saved to, and restored from, so
+	// that quotas survive restarts
+	Path string `json:"path" yaml:"path"`
+}
+
+// Quota is the usage of a tenant's quota in its current period
+type Quota struct {
+	Limit int64     `json:"limit"`
+	Used  int64     `json:"used"`
+	Reset time.Time `json:"reset"`
+}
+
+// QuotaUsage is the usage of each of a tenant's quotas, as shown by the
+// `quotas` admin endpoint
+type QuotaUsage struct {
+	Tenant string `json:"tenant"`
+	Hourly *Quota `json:"hourly,omitempty"`
+	Daily  *Quota `json:"daily,omitempty"`
+}
+
+// EnforceQuotas turns on per-tenant quotas, as per p, and the `quotas` admin
+// endpoint, which lists usage and resets a tenant's usage on `DELETE
+// ?tenant=`. Quotas are keyed by KeyHeader, or else by the TenantHeader
+// set when requests arrive.
+//
+// Policies without any quota, or with usage which can't be restored from
+// Path, are programmer error, and panic.
+func (m *Middleware) EnforceQuotas(p QuotaPolicy) {
+	qs, err := newQuotaSet(p)
+	if err != nil {
+		panic(err)
+	}
+
+	m.quotas = qs
+	m.addAdminEndpoint("quotas", m.serveQuotas)
+}
+
+// newQuotaSet returns a quotaSet as per p, with usage restored from p's Path
+func newQuotaSet(p QuotaPolicy) (qs *quotaSet, err error) {
+	if p.Hourly <= 0 && p.Daily <= 0 {
+		return nil, fmt.Errorf("quota policy requires an hourly or daily quota")
+	}
+
+	if p.MaxHolders <= 0 {
+		p.MaxHolders = DefaultQuotaMaxHolders
+	}
+
+	qs = &quotaSet{
+		policy: p,
+		usage:  make(map[string]*quotaCounts),
+	}
+
+	if err = qs.load(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// quotaSet tracks the usage of every tenant's quotas
+type quotaSet struct {
+	sync.Mutex
+
+	policy   QuotaPolicy
+	usage    map[string]*quotaCounts
+	lastSave time.Time
+
+	// saveLock serialises writes to the policy's Path
+	saveLock sync.Mutex
+}
+
+// quotaCounts is a tenant's usage in its current hour and day
+type quotaCounts struct {
+	Hour      time.Time `json:"hour"`
+	HourCount int64     `json:"hour_count"`
+	Day       time.Time `json:"day"`
+	DayCount  int64     `json:"day_count"`
+
+	// Weight is kept alongside usage so that admin listings needn't
+	// know raw keys
+	Weight float64 `json:"weight"`
+}
+
+// quotaHolder returns the key a request's quotas are held under, hashed when
+// taken from KeyHeader, and its weight. key is empty for requests without
+// a tenant
+func (m *Middleware) quotaHolder(header func(string) string) (key string, weight float64) {
+	qs := m.quotas
+
+	raw := ""
+	switch {
+	case qs.policy.KeyHeader != "":
+		if raw = header(qs.policy.KeyHeader); raw != "" {
+			sum := sha256.Sum256([]byte(raw))
+			key = "key:" + hex.EncodeToString(sum[:8])
+		}
+
+	case m.TenantHeader != "":
+		raw = header(m.TenantHeader)
+		key = raw
+	}
+
+	weight = 1
+	if w, ok := qs.policy.Weights[raw]; ok && w > 0 {
+		weight = w
+	}
+
+	return
+}
+
+// checkQuota takes a request from the quotas of the tenant making it,
+// setting quota headers via set. exhausted is true, without a request being
+// taken, when a quota has run out, along with the seconds until it resets
+func (m *Middleware) checkQuota(header func(string) string, set func(k, v string), now time.Time) (retryAfter string, exhausted bool) {
+	if m.quotas == nil {
+		return
+	}
+
+	key, weight := m.quotaHolder(header)
+	if key == "" {
+		return
+	}
+
+	ok, tightest := m.quotas.take(key, weight, now)
+	m.quotas.maybeSave(now)
+
+	// Holders beyond MaxHolders aren't tracked, so have no quota to describe
+	if tightest.Reset.IsZero() {
+		return
+	}
+
+	remaining := tightest.Limit - tightest.Used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	set("X-RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+	set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	set("X-RateLimit-Reset", strconv.FormatInt(tightest.Reset.Unix(), 10))
+
+	if ok {
+		return
+	}
+
+	return strconv.Itoa(int(math.Ceil(tightest.Reset.Sub(now).Seconds()))), true
+}
+
+// take counts a request against key's quotas, unless one is exhausted,
+// returning the quota with the fewest requests remaining. Requests from new
+// holders are allowed, with a zero Quota, while MaxHolders are tracked
+func (qs *quotaSet) take(key string, weight float64, now time.Time) (ok bool, tightest Quota) {
+	qs.Lock()
+	defer qs.Unlock()
+
+	c, found := qs.usage[key]
+	if !found {
+		if len(qs.usage) >= qs.policy.MaxHolders {
+			qs.prune(now)
+		}
+
+		if len(qs.usage) >= qs.policy.MaxHolders {
+			return true, Quota{}
+		}
+
+		c = &quotaCounts{}
+		qs.usage[key] = c
+	}
+
+	c.Weight = weight
+	c.roll(now)
+
+	hourly, daily := c.usage(qs.policy)
+
+	ok = true
+	for i, q := range []*Quota{hourly, daily} {
+		if q == nil {
+			continue
+		}
+
+		if q.Used >= q.Limit {
+			ok = false
+		}
+
+		if i == 0 || hourly == nil || q.Limit-q.Used < tightest.Limit-tightest.Used {
+			tightest = *q
+		}
+	}
+
+	if !ok {
+		return
+	}
+
+	c.HourCount++
+	c.DayCount++
+	tightest.Used++
+
+	return
+}
+
+// roll starts new periods for counts whose periods have ended
+func (c *quotaCounts) roll(now time.Time) {
+	now = now.UTC()
+
+	if hour := now.Truncate(time.Hour); !c.Hour.Equal(hour) {
+		c.Hour, c.HourCount = hour, 0
+	}
+
+	if day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC); !c.Day.Equal(day) {
+		c.Day, c.DayCount = day, 0
+	}
+}
+
+// usage returns c's usage of each quota p sets, or nil for those it doesn't
+func (c *quotaCounts) usage(p QuotaPolicy) (hourly, daily *Quota) {
+	if p.Hourly > 0 {
+		hourly = &Quota{Limit: weighted(p.Hourly, c.Weight), Used: c.HourCount, Reset: c.Hour.Add(time.Hour)}
+	}
+
+	if p.Daily > 0 {
+		daily = &Quota{Limit: weighted(p.Daily, c.Weight), Used: c.DayCount, Reset: c.Day.AddDate(0, 0, 1)}
+	}
+
+	return
+}
+
+// prune forgets holders whose day has ended, as their usage has rolled
+// over. The caller must hold qs's lock
+func (qs *quotaSet) prune(now time.Time) {
+	for key, c := range qs.usage {
+		if !now.Before(c.Day.AddDate(0, 0, 1)) {
+			delete(qs.usage, key)
+		}
+	}
+}
+
+func weighted(limit int64, weight float64) int64 {
+	if weight <= 0 {
+		return limit
+	}
+
+	return int64(math.Round(float64(limit) * weight))
+}
+
+// snapshot lists every tenant's usage as of now, ordered by tenant
+func (qs *quotaSet) snapshot(now time.Time) []QuotaUsage {
+	qs.Lock()
+	defer qs.Unlock()
+
+	qs.prune(now)
+
+	out := make([]QuotaUsage, 0, len(qs.usage))
+	for key, c := range qs.usage {
+		c.roll(now)
+
+		u := QuotaUsage{Tenant: key}
+		u.Hourly, u.Daily = c.usage(qs.policy)
+
+		out = append(out, u)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Tenant < out[j].Tenant })
+
+	return out
+}
+
+// reset forgets tenant's usage, returning false when it had none
+func (qs *quotaSet) reset(tenant string) bool {
+	qs.Lock()
+	_, ok := qs.usage[tenant]
+	delete(qs.usage, tenant)
+	qs.Unlock()
+
+	if ok && qs.policy.Path != "" {
+		go qs.save()
+	}
+
+	return ok
+}
+
+// load restores usage saved to the policy's Path, if any
+func (qs *quotaSet) load() error {
+	if qs.policy.Path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(qs.policy.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(b, &qs.usage); err != nil {
+		return fmt.Errorf("quotas %s: %v", qs.policy.Path, err)
+	}
+
+	if qs.usage == nil {
+		qs.usage = make(map[string]*quotaCounts)
+	}
+
+	return nil
+}
+
+// maybeSave prunes usage, and saves it in the background when the policy
+// has a Path, unless that was last done within quotaSaveInterval
+func (qs *quotaSet) maybeSave(now time.Time) {
+	qs.Lock()
+	due := now.Sub(qs.lastSave) >= quotaSaveInterval
+	if due {
+		qs.lastSave = now
+		qs.prune(now)
+	}
+	qs.Unlock()
+
+	if due && qs.policy.Path != "" {
+		go qs.save()
+	}
+}
+
+// save writes usage to the policy's Path, via a temporary file so that a
+// crash mid-write can't lose it
+func (qs *quotaSet) save() error {
+	qs.Lock()
+	qs.prune(time.Now())
+	b, err := json.Marshal(qs.usage)
+	qs.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	qs.saveLock.Lock()
+	defer qs.saveLock.Unlock()
+
+	tmp := qs.policy.Path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, qs.policy.Path)
+}
+
+// serveQuotas lists quota usage, or resets a tenant's usage on DELETE
+func (m *Middleware) serveQuotas(r adminRequest) adminResponse {
+	switch r.method {
+	case http.MethodGet:
+		b, _ := json.Marshal(map[string][]QuotaUsage{"quotas": m.quotas.snapshot(time.Now())})
+
+		return jsonResponse(http.StatusOK, b)
+
+	case http.MethodDelete:
+		tenant := r.query.Get("tenant")
+		if !m.quotas.reset(tenant) {
+			return adminError(http.StatusNotFound, fmt.Errorf("tenant %q has no usage", tenant))
+		}
+
+		m.audit(AuditEvent{Action: "reset_quota", Subject: tenant, Reason: "reset via admin endpoint"})
+
+		return jsonResponse(http.StatusOK, []byte(`{"status":"reset"}`))
+	}
+
+	return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.method))
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestQuotaSet_take(t *testing.T) {
+	qs, err := newQuotaSet(QuotaPolicy{Hourly: 2, Daily: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	now := time.Date(2017, 5, 27, 22, 10, 0, 0, time.UTC)
+
+	for i, test := range []struct {
+		at        time.Time
+		weight    float64
+		expect    bool
+		remaining int64
+		reset     time.Time
+	}{
+		{now, 1, true, 1, now.Truncate(time.Hour).Add(time.Hour)},
+		{now, 1, true, 0, now.Truncate(time.Hour).Add(time.Hour)},
+		{now, 1, false, 0, now.Truncate(time.Hour).Add(time.Hour)},
+
+		// A new hour leaves one request of the day's quota
+		{now.Add(time.Hour), 1, true, 0, time.Date(2017, 5, 28, 0, 0, 0, 0, time.UTC)},
+
+		// A new day starts afresh
+		{now.Add(2 * time.Hour), 1, true, 1, time.Date(2017, 5, 28, 1, 0, 0, 0, time.UTC)},
+
+		// Weights scale every quota
+		{now.Add(2 * time.Hour), 10, true, 18, time.Date(2017, 5, 28, 1, 0, 0, 0, time.UTC)},
+	} {
+		ok, q := qs.take("acme", test.weight, test.at)
+		if ok != test.expect || q.Limit-q.Used != test.remaining || !q.Reset.Equal(test.reset) {
+			t.Errorf("%d: expected %v with %d remaining until %s, received %v with %+v", i, test.expect, test.remaining, test.reset, ok, q)
+		}
+	}
+}
+
+func TestQuotaSet_prune(t *testing.T) {
+	qs, err := newQuotaSet(QuotaPolicy{Daily: 1, MaxHolders: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	now := time.Date(2017, 5, 27, 22, 10, 0, 0, time.UTC)
+
+	qs.take("acme", 1, now)
+	qs.take("initech", 1, now)
+
+	// Holders beyond the cap are allowed, untracked
+	for i := 0; i < 2; i++ {
+		if ok, q := qs.take("hooli", 1, now); !ok || !q.Reset.IsZero() {
+			t.Errorf("expected an untracked holder to be allowed, received %v with %+v", ok, q)
+		}
+	}
+
+	if n := len(qs.usage); n != 2 {
+		t.Errorf("expected 2 holders to be tracked, received %d", n)
+	}
+
+	// Once the day is over, its holders make room
+	if _, q := qs.take("hooli", 1, now.Add(2*time.Hour)); q.Reset.IsZero() {
+		t.Errorf("expected a holder to be tracked once others were pruned")
+	}
+
+	if usage := qs.snapshot(now.Add(26 * time.Hour)); len(usage) != 0 {
+		t.Errorf("expected usage for ended days to be pruned, received %+v", usage)
+	}
+}
+
+func TestEnforceQuotas(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.TenantHeader = "X-Tenant"
+	m.EnforceQuotas(QuotaPolicy{Hourly: 1, Weights: map[string]float64{"big": 2}})
+
+	request := func(tenant string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		return rec
+	}
+
+	if rec := request("small"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "1" || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected a 200 with quota headers, received %d %v", rec.Code, rec.Header())
+	}
+
+	if rec := request("small"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a 429 with Retry-After, received %d %v", rec.Code, rec.Header())
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request("big"); rec.Code != http.StatusOK {
+			t.Errorf("expected a weighted tenant to be allowed twice, received %d", rec.Code)
+		}
+	}
+
+	if rec := request(""); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("expected requests without a tenant to be left alone, received %d %v", rec.Code, rec.Header())
+	}
+
+	var body struct {
+		Quotas []QuotaUsage `json:"quotas"`
+	}
+
+	list := m.serveQuotas(adminRequest{method: "GET"})
+	if err := json.Unmarshal(list.body, &body); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(body.Quotas) != 2 || body.Quotas[0].Tenant != "big" || body.Quotas[0].Hourly.Limit != 2 || body.Quotas[1].Hourly.Used != 1 {
+		t.Errorf("unexpected usage %s", list.body)
+	}
+
+	if ar := m.serveQuotas(adminRequest{method: "DELETE", query: map[string][]string{"tenant": {"small"}}}); ar.status != http.StatusOK {
+		t.Errorf("expected a reset, received %d %s", ar.status, ar.body)
+	}
+
+	if rec := request("small"); rec.Code != http.StatusOK {
+		t.Errorf("expected the reset tenant to be allowed, received %d", rec.Code)
+	}
+
+	if ar := m.serveQuotas(adminRequest{method: "DELETE", query: map[string][]string{"tenant": {"nonsuch"}}}); ar.status != http.StatusNotFound {
+		t.Errorf("expected a 404, received %d", ar.status)
+	}
+}
+
+func TestEnforceQuotas_DryRun(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.EnforceQuotas(QuotaPolicy{KeyHeader: "X-API-Key", Daily: 1})
+
+	for _, dryRun := range []bool{false, true} {
+		if dryRun {
+			m.DryRun(DryRunQuotas)
+		}
+
+		for i := 0; i < 2; i++ {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.Set("X-API-Key", "secret")
+			m.ServeFastHTTP(ctx)
+
+			if i == 1 && !dryRun && (ctx.Response.StatusCode() != http.StatusTooManyRequests || len(ctx.Response.Header.Peek("X-RateLimit-Reset")) == 0) {
+				t.Errorf("expected a 429 with quota headers, received %s", ctx.Response.Header.String())
+			}
+
+			if dryRun && ctx.Response.StatusCode() != http.StatusOK {
+				t.Errorf("expected quotas in dry run to let requests through, received %d", ctx.Response.StatusCode())
+			}
+		}
+	}
+
+	for _, u := range m.quotas.snapshot(time.Now()) {
+		if u.Tenant == "secret" {
+			t.Errorf("expected API keys to be hashed")
+		}
+	}
+}
+
+func TestEnforceQuotas_Path(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quotas")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := QuotaPolicy{Hourly: 5, Path: filepath.Join(dir, "quotas.json")}
+
+	qs, err := newQuotaSet(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	qs.take("acme", 1, time.Now())
+	qs.take("acme", 1, time.Now())
+
+	if err = qs.save(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if qs, err = newQuotaSet(p); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if ok, q := qs.take("acme", 1, time.Now()); !ok || q.Used != 3 {
+		t.Errorf("expected usage to be restored, received %+v", q)
+	}
+
+	ioutil.WriteFile(p.Path, []byte("{"), 0600)
+	if _, err = newQuotaSet(p); err == nil {
+		t.Errorf("expected an error restoring corrupt usage")
+	}
+}
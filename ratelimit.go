@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitSweepInterval is how often idle client buckets are forgotten
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimit configures per-client rate limiting, where clients are
+// identified by IP address. Clients exceeding the limit receive a
+// `429 Too Many Requests` without the wrapped handler being called.
+type RateLimit struct {
+	// Rate is the sustained number of requests per second a client may make
+	Rate float64
+
+	// Burst is the number of requests a client may make in excess of Rate
+	// before being limited. Values below 1 are treated as 1
+	Burst int
+}
+
+// SetRateLimit enables per-client rate limiting. A zero Rate disables it.
+func (m *Middleware) SetRateLimit(rl RateLimit) {
+	m.limiter = newRateLimiter(rl)
+}
+
+// newRateLimiter returns a rateLimiter as per rl, or nil when rl has no Rate
+func newRateLimiter(rl RateLimit) *rateLimiter {
+	if rl.Rate <= 0 {
+		return nil
+	}
+
+	if rl.Burst < 1 {
+		rl.Burst = 1
+	}
+
+	return &rateLimiter{
+		limit:   rl,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// rateLimiter is a token bucket per client
+type rateLimiter struct {
+	sync.Mutex
+
+	limit     RateLimit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from a client's bucket, returning false when it is empty
+func (rl *rateLimiter) allow(client string, now time.Time) bool {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.sweep(now)
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(rl.limit.Burst), last: now}
+		rl.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.limit.Rate
+	if max := float64(rl.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// sweep forgets about clients whose buckets have refilled; they're
+// indistinguishable from clients we've never seen
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	rl.lastSweep = now
+
+	refill := time.Duration(float64(rl.limit.Burst) / rl.limit.Rate * float64(time.Second))
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) > refill {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// clientIP strips the port from a remote address, where there is one
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// retryAfter is the number of whole seconds, as a string, until a limited
+// client will have a token again
+func (rl *rateLimiter) retryAfter() string {
+	return strconv.Itoa(int(math.Ceil(1 / rl.limit.Rate)))
+}
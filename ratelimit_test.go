@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetRateLimit(RateLimit{Rate: 1, Burst: 2})
+
+	now := time.Now()
+	for i, expect := range []bool{true, true, false} {
+		if m.limiter.allow("127.0.0.1", now) != expect {
+			t.Errorf("request %d: expected %v", i, expect)
+		}
+	}
+
+	if !m.limiter.allow("127.0.0.1", now.Add(time.Second)) {
+		t.Errorf("expected bucket to refill")
+	}
+
+	if !m.limiter.allow("127.0.0.2", now) {
+		t.Errorf("expected clients to be limited independently")
+	}
+}
+
+func TestServeHTTP_rateLimited(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetRateLimit(RateLimit{Rate: 0.5})
+
+	for _, expect := range []int{200, 429} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Code != expect {
+			t.Errorf("expected %d, received %d", expect, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// RedactedValue replaces the values of redacted query parameters
+	RedactedValue = "REDACTED"
+)
+
+// RedactQueryParams registers query parameter names, such as `token` or
+// `api_key`, whose values should never appear in logs or counters. Names
+// are case insensitive, and may be globs, as understood by path.Match,
+// such as `*_token`. RedactQueryParams panics on a malformed glob
+func (m *Middleware) RedactQueryParams(names ...string) {
+	if m.redactParams == nil {
+		m.redactParams = make(map[string]bool)
+	}
+
+	for _, n := range names {
+		n = strings.ToLower(n)
+
+		if !strings.ContainsAny(n, "*?[") {
+			m.redactParams[n] = true
+
+			continue
+		}
+
+		if _, err := path.Match(n, ""); err != nil {
+			panic(fmt.Errorf("redact query param %q: %v", n, err))
+		}
+
+		m.redactPatterns = append(m.redactPatterns, n)
+	}
+}
+
+// redactedParam returns true when the values of query parameter name
+// should be masked
+func (m *Middleware) redactedParam(name string) bool {
+	name = strings.ToLower(name)
+	if m.redactParams[name] {
+		return true
+	}
+
+	for _, p := range m.redactPatterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactURL returns a loggable form of u; that is, without passwords or
+// the values of any redacted query parameters. u is left untouched.
+func (m *Middleware) redactURL(u *url.URL) string {
+	c := *u
+
+	if c.User != nil {
+		if _, set := c.User.Password(); set {
+			c.User = url.User(c.User.Username())
+		}
+	}
+
+	if len(m.redactParams)+len(m.redactPatterns) > 0 && c.RawQuery != "" {
+		c.RawQuery = m.redactQuery(c.RawQuery)
+	}
+
+	return c.String()
+}
+
+// redactQuery masks redacted parameters in a raw query string, preserving the
+// order (and encoding) of everything else
+func (m *Middleware) redactQuery(q string) string {
+	pairs := strings.Split(q, "&")
+	for i, pair := range pairs {
+		k := pair
+		if idx := strings.Index(pair, "="); idx >= 0 {
+			k = pair[:idx]
+		}
+
+		if name, err := url.QueryUnescape(k); err == nil && m.redactedParam(name) {
+			pairs[i] = k + "=" + RedactedValue
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRedactURL(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.RedactQueryParams("token", "API_KEY", "*_secret")
+
+	for _, test := range []struct {
+		url    string
+		expect string
+	}{
+		{"/?token=abc&page=2", "/?token=REDACTED&page=2"},
+		{"/?Api_Key=abc", "/?Api_Key=REDACTED"},
+		{"/?client_secret=abc&secretive=1", "/?client_secret=REDACTED&secretive=1"},
+		{"//user:pass@example.com/", "//user@example.com/"},
+		{"/?page=2", "/?page=2"},
+	} {
+		u, _ := url.Parse(test.url)
+		if received := m.redactURL(u); received != test.expect {
+			t.Errorf("%s: expected %q, received %q", test.url, test.expect, received)
+		}
+	}
+}
+
+func TestRedactQueryParams_BadGlob(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).RedactQueryParams("[")
+}
+
+func TestRedactQueryParams_Counters(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		handler interface{}
+		serve   func(m *Middleware)
+	}{
+		{"net/http", TestAPI{}, func(m *Middleware) {
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login?code=secret", nil))
+		}},
+		{"fasthttp", FHAPI{}, func(m *Middleware) {
+			c := &fasthttp.RequestCtx{}
+			c.Request.SetRequestURI("/login?code=secret")
+
+			m.ServeFastHTTP(c)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMiddleware(test.handler)
+			m.RedactQueryParams("code")
+
+			logWriter := &TestWriter{}
+			m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+			test.serve(m)
+
+			time.Sleep(100 * time.Millisecond)
+
+			if strings.Contains(string(logWriter.body), "secret") {
+				t.Errorf("expected the code to be redacted, received %q", logWriter.body)
+			}
+
+			lock.RLock()
+			defer lock.RUnlock()
+
+			for k := range m.Requests {
+				if strings.Contains(k, "secret") {
+					t.Errorf("expected the counter key to be redacted, received %q", k)
+				}
+			}
+		})
+	}
+}
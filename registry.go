@@ -0,0 +1,267 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Registry aggregates the admin endpoints of several Middlewares in one
+// process, such as a public API and an internal one, into a single admin
+// surface, rather than one per Middleware:
+//
+//	r := middleware.NewRegistry()
+//	r.Register("public", public)
+//	r.Register("internal", internal)
+//
+//	go http.ListenAndServe("localhost:9090", r)
+//
+// A Registry serves, under `/__/`:
+//   - counters, with requests and response bytes summed across every
+//     Middleware, and each Middleware's own counters, with every section,
+//     under `instances`;
+//   - ready, which is `503 Service Unavailable` until every Middleware
+//     that is warming up is ready; and
+//   - metrics, the metrics of every Middleware exporting them, as merged
+//     by a MetricsMerger, such as the prom subpackage's Exporter, which
+//     labels them `middleware` by name.
+type Registry struct {
+	// AdminToken, when set, is required by every endpoint but ready, in
+	// the same way as Middleware.AdminToken. Members' own tokens don't
+	// apply
+	AdminToken string
+
+	lock    sync.RWMutex
+	names   []string
+	members map[string]*Middleware
+}
+
+// registryCounters is the response body of a Registry's counters endpoint
+type registryCounters struct {
+	Requests      map[string]int64           `json:"requests"`
+	ResponseBytes map[string]int64           `json:"response_bytes,omitempty"`
+	RequestBytes  map[string]int64           `json:"request_bytes,omitempty"`
+	Instances     map[string]json.RawMessage `json:"instances"`
+}
+
+// registryReady is the response body of a Registry's ready endpoint
+type registryReady struct {
+	Status    string                `json:"status"`
+	Instances map[string]readyState `json:"instances"`
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{members: make(map[string]*Middleware)}
+}
+
+// Register adds m to the registry as name. Registering an empty or taken
+// name is programmer error, and panics
+func (r *Registry) Register(name string, m *Middleware) {
+	if name == "" {
+		panic(fmt.Errorf("registry requires a name for each middleware"))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.members[name]; ok {
+		panic(fmt.Errorf("registry already has a middleware named %q", name))
+	}
+
+	r.members[name] = m
+	r.names = append(r.names, name)
+	sort.Strings(r.names)
+}
+
+// ServeHTTP serves the registry's admin endpoints to net/http clients
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ar := r.serve(req.URL.Path, adminRequest{
+		method: req.Method,
+		query:  req.URL.Query(),
+		header: req.Header.Get,
+	})
+
+	if ar.etag != "" {
+		w.Header().Set("ETag", ar.etag)
+	}
+
+	w.Header().Set("Content-Type", ar.contentType)
+	w.WriteHeader(ar.status)
+	w.Write(ar.body)
+}
+
+// ServeFastHTTP serves the registry's admin endpoints to fasthttp clients
+func (r *Registry) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	query, _ := url.ParseQuery(string(ctx.QueryArgs().QueryString()))
+
+	ar := r.serve(string(ctx.Path()), adminRequest{
+		method: string(ctx.Method()),
+		query:  query,
+		header: func(k string) string { return string(ctx.Request.Header.Peek(k)) },
+	})
+
+	if ar.etag != "" {
+		ctx.Response.Header.Set("ETag", ar.etag)
+	}
+
+	ctx.SetStatusCode(ar.status)
+	ctx.SetContentType(ar.contentType)
+	ctx.SetBody(ar.body)
+}
+
+// serve authorises and then dispatches a request to the endpoint in p
+func (r *Registry) serve(p string, req adminRequest) adminResponse {
+	i := strings.Index(p, adminPrefix)
+	if i < 0 {
+		return adminError(http.StatusNotFound, fmt.Errorf("no such endpoint %q", p))
+	}
+
+	req.endpoint = p[i+len(adminPrefix):]
+
+	if req.endpoint != "ready" && !tokenAuthorised(r.AdminToken, req.header) {
+		return jsonResponse(http.StatusUnauthorized, []byte(`{"error":"unauthorised"}`))
+	}
+
+	if req.method != http.MethodGet && req.method != http.MethodHead {
+		return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.method))
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	switch req.endpoint {
+	case "counters":
+		etag := r.etag()
+		if etagMatches(req.header("If-None-Match"), etag) {
+			ar := jsonResponse(http.StatusNotModified, nil)
+			ar.etag = etag
+
+			return ar
+		}
+
+		ar := r.serveCounters()
+		ar.etag = etag
+
+		return ar
+
+	case "ready":
+		return r.serveReady()
+
+	case "metrics":
+		return r.serveMetrics(req)
+	}
+
+	return adminError(http.StatusNotFound, fmt.Errorf("no such endpoint %q", req.endpoint))
+}
+
+// etag combines the ETags of every member, so that it changes along with
+// any of them
+func (r *Registry) etag() string {
+	h := fnv.New64a()
+	for _, name := range r.names {
+		fmt.Fprintf(h, "%s=%s;", name, r.members[name].etag())
+	}
+
+	return `"` + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+func (r *Registry) serveCounters() adminResponse {
+	out := registryCounters{
+		Requests:      make(map[string]int64),
+		ResponseBytes: make(map[string]int64),
+		RequestBytes:  make(map[string]int64),
+		Instances:     make(map[string]json.RawMessage, len(r.names)),
+	}
+
+	for _, name := range r.names {
+		m := r.members[name]
+
+		lock.RLock()
+		for k, v := range m.Requests {
+			out.Requests[k] += v.Value()
+		}
+		lock.RUnlock()
+
+		for k, v := range m.responseBytes.snapshot() {
+			out.ResponseBytes[k] += v
+		}
+
+		for k, v := range m.requestBytes.snapshot() {
+			out.RequestBytes[k] += v
+		}
+
+		out.Instances[name] = m.sectionedCounters()
+	}
+
+	b, _ := json.Marshal(out)
+
+	return jsonResponse(http.StatusOK, b)
+}
+
+// serveReady reports the warm-up state of every member which is warming
+// up. Members which aren't are always ready, and left out
+func (r *Registry) serveReady() adminResponse {
+	out := registryReady{
+		Status:    "ready",
+		Instances: make(map[string]readyState),
+	}
+
+	status := http.StatusOK
+	for _, name := range r.names {
+		m := r.members[name]
+		if m.warmUp == nil {
+			continue
+		}
+
+		ar := m.serveReady(adminRequest{})
+
+		var state readyState
+		json.Unmarshal(ar.body, &state)
+
+		out.Instances[name] = state
+
+		if ar.status != http.StatusOK {
+			out.Status = state.Status
+			status = ar.status
+		}
+	}
+
+	b, _ := json.Marshal(out)
+
+	return jsonResponse(status, b)
+}
+
+// serveMetrics serves the metrics of every member exporting them, merged
+// by the first exporter, in name order, which is a MetricsMerger
+func (r *Registry) serveMetrics(req adminRequest) adminResponse {
+	exporters := make(map[string]MetricsExporter, len(r.names))
+
+	var merger MetricsMerger
+	for _, name := range r.names {
+		e := r.members[name].metrics
+		if e == nil {
+			continue
+		}
+
+		exporters[name] = e
+
+		if mm, ok := e.(MetricsMerger); ok && merger == nil {
+			merger = mm
+		}
+	}
+
+	if merger == nil {
+		return adminError(http.StatusNotFound, fmt.Errorf("no middleware exports mergeable metrics"))
+	}
+
+	return metricsResponse(merger.MergeMetrics(exporters, req.header("Accept")))
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRegistry(t *testing.T) {
+	public := NewMiddleware(TestAPI{})
+	internal := NewMiddleware(TestAPI{})
+	internal.WarmUp(WarmUp{Duration: time.Hour, MaxConcurrency: 10, ReadyAt: 0.5})
+
+	r := NewRegistry()
+	r.AdminToken = "sekrit"
+	r.Register("public", public)
+	r.Register("internal", internal)
+
+	public.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	public.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	internal.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	request := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer sekrit")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("counters", func(t *testing.T) {
+		rec := request("/__/counters", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, received %d %s", rec.Code, rec.Body)
+		}
+
+		var body struct {
+			Requests  map[string]int64           `json:"requests"`
+			Instances map[string]countersPayload `json:"instances"`
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if body.Requests["/"] != 3 || body.Instances["public"].Requests["/"] != 2 || body.Instances["internal"].Requests["/"] != 1 {
+			t.Errorf("expected combined and per instance requests, received %s", rec.Body)
+		}
+
+		etag := rec.Header().Get("ETag")
+		if rec = request("/__/counters", etag); rec.Code != http.StatusNotModified {
+			t.Errorf("expected 304, received %d", rec.Code)
+		}
+
+		internal.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		time.Sleep(100 * time.Millisecond)
+
+		if rec = request("/__/counters", etag); rec.Code != http.StatusOK {
+			t.Errorf("expected a member's change to change the ETag, received %d", rec.Code)
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/__/ready", nil))
+
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"internal":{"status":"warming"`) {
+			t.Errorf("expected a warming member to make the registry unready, received %d %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unauthorised", func(t *testing.T) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/__/counters")
+		r.ServeFastHTTP(ctx)
+
+		if ctx.Response.StatusCode() != http.StatusUnauthorized {
+			t.Errorf("expected 401, received %d", ctx.Response.StatusCode())
+		}
+	})
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	r.Register("api", NewMiddleware(TestAPI{}))
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a taken name to panic")
+		}
+	}()
+
+	r.Register("api", NewMiddleware(TestAPI{}))
+}
@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reportReservoir is the number of durations kept per reportPoint, from
+// which percentiles are estimated
+const reportReservoir = 1024
+
+// reportKey identifies the requests a reportPoint rolls up: those to a
+// route, as per routeKey, with a method, as per promMethod, and a status
+// class
+type reportKey struct {
+	Route  string
+	Method string
+	Class  string
+}
+
+// reportPoint rolls up the requests matching its key over an interval
+type reportPoint struct {
+	reportKey
+
+	Requests      int64
+	Errors        int64
+	DurationSumMS float64
+	DurationMaxMS float64
+	RequestBytes  int64
+	ResponseBytes int64
+
+	// Satisfied, Tolerating, and Frustrated count requests by Apdex zone,
+	// for routes with an ApdexThreshold
+	Satisfied  int64
+	Tolerating int64
+	Frustrated int64
+
+	// durations are a sample of the interval's, in milliseconds, sorted
+	// once the interval is over
+	durations []float64
+	seen      int
+}
+
+// percentile returns the estimated q percentile duration, in milliseconds
+func (p reportPoint) percentile(q float64) float64 {
+	return percentile(p.durations, q)
+}
+
+// apdex returns whether any of p's requests had an Apdex zone, and if so
+// their score
+func (p reportPoint) apdex() (score float64, ok bool) {
+	if p.Satisfied+p.Tolerating+p.Frustrated == 0 {
+		return
+	}
+
+	return apdexScore(p.Satisfied, p.Tolerating, p.Frustrated), true
+}
+
+// report is what a reporter sends at the end of each interval
+type report struct {
+	at     time.Time
+	points []reportPoint
+
+	// inFlight is as of the end of the interval
+	inFlight InFlightStats
+}
+
+// reporter rolls up request metrics, and sends them every interval. It
+// leaves wire formats to send, so that periodic sinks needn't each keep
+// their own totals
+type reporter struct {
+	send     func(report) error
+	interval time.Duration
+	inFlight func() InFlightStats
+	stop     chan struct{}
+	once     sync.Once
+
+	lock   sync.Mutex
+	points map[reportKey]*reportPoint
+}
+
+// newReporter returns a reporter which, once added to a Middleware by
+// addReporter, calls send every interval with the points observed since
+// the last call, or only on close for an interval of zero. Call close to
+// stop it, which sends any final points
+func newReporter(interval time.Duration, send func(report) error) *reporter {
+	return &reporter{
+		send:     send,
+		interval: interval,
+		stop:     make(chan struct{}),
+		points:   make(map[reportKey]*reportPoint),
+	}
+}
+
+// AddReporter calls send every interval, or only on Shutdown for an
+// interval of zero, for sinks which keep their own totals, such as the
+// prom subpackage's Pushgateway. Nothing is sent for intervals without
+// requests. Shutdown sends a final time, and returns its error, if any;
+// errors sending on the interval are dropped
+func (m *Middleware) AddReporter(interval time.Duration, send func() error) {
+	m.addReporter(newReporter(interval, func(report) error {
+		return send()
+	}))
+}
+
+// addReporter starts r, reporting m's requests
+func (m *Middleware) addReporter(r *reporter) {
+	r.inFlight = m.InFlightStats
+	m.reporters.add(r)
+
+	go r.run()
+}
+
+// observe rolls a handled request to route, which took d, into the
+// current interval
+func (r *reporter) observe(route string, l LogEntry, d time.Duration) {
+	key := reportKey{Route: route, Method: promMethod(l.Method), Class: statusClass(l.Status)}
+	ms := float64(d) / float64(time.Millisecond)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.points[key]
+	if !ok {
+		p = &reportPoint{reportKey: key}
+		r.points[key] = p
+	}
+
+	p.Requests++
+	if isErrorEntry(l) {
+		p.Errors++
+	}
+
+	p.DurationSumMS += ms
+	if ms > p.DurationMaxMS {
+		p.DurationMaxMS = ms
+	}
+
+	p.RequestBytes += l.RequestBytes
+	p.ResponseBytes += int64(l.ResponseBytes)
+
+	switch l.Apdex {
+	case ApdexSatisfied:
+		p.Satisfied++
+	case ApdexTolerating:
+		p.Tolerating++
+	case ApdexFrustrated:
+		p.Frustrated++
+	}
+
+	p.seen++
+	if len(p.durations) < reportReservoir {
+		p.durations = append(p.durations, ms)
+	} else if i := rand.Intn(p.seen); i < reportReservoir {
+		p.durations[i] = ms
+	}
+}
+
+// flush sends the points observed since the last flush, ordered by key,
+// and starts a new interval. Nothing is sent for an idle interval
+func (r *reporter) flush() error {
+	rep := report{at: time.Now()}
+	if r.inFlight != nil {
+		rep.inFlight = r.inFlight()
+	}
+
+	r.lock.Lock()
+	points := make([]reportPoint, 0, len(r.points))
+	for _, p := range r.points {
+		points = append(points, *p)
+	}
+
+	r.points = make(map[reportKey]*reportPoint)
+	r.lock.Unlock()
+
+	if len(points) == 0 && rep.inFlight.Total == 0 {
+		return nil
+	}
+
+	for _, p := range points {
+		sort.Float64s(p.durations)
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		a, b := points[i].reportKey, points[j].reportKey
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+
+		return a.Class < b.Class
+	})
+
+	rep.points = points
+
+	return r.send(rep)
+}
+
+// close stops periodic reports, and sends a final one
+func (r *reporter) close() (err error) {
+	r.once.Do(func() {
+		close(r.stop)
+		err = r.flush()
+	})
+
+	return
+}
+
+func (r *reporter) run() {
+	if r.interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			// Failed reports are dropped, rather than growing without
+			// bound while a sink is down
+			r.flush()
+		}
+	}
+}
+
+// reporters are the Middleware's periodic sinks
+type reporters struct {
+	sync.RWMutex
+
+	all []*reporter
+}
+
+func (rs *reporters) add(r *reporter) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.all = append(rs.all, r)
+}
+
+func (rs *reporters) observe(route string, l LogEntry, d time.Duration) {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	for _, r := range rs.all {
+		r.observe(route, l, d)
+	}
+}
+
+// count returns the number of reporters added
+func (rs *reporters) count() int {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return len(rs.all)
+}
+
+// closeFrom stops, and removes, reporters added after the first n
+func (rs *reporters) closeFrom(n int) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	for _, r := range rs.all[n:] {
+		r.close()
+	}
+
+	rs.all = rs.all[:n]
+}
+
+// close stops every reporter, returning the first error sending final
+// reports
+func (rs *reporters) close() (err error) {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	for _, r := range rs.all {
+		if e := r.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// DefaultRequestIDMaxLength is the longest client supplied request ID
+	// honoured by default
+	DefaultRequestIDMaxLength = 128
+)
+
+// DefaultRequestIDPattern matches request IDs safe to write to logs: UUIDs,
+// hex strings, and similar tokens, but nothing containing whitespace,
+// quotes, or control characters
+var DefaultRequestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:+=/-]+$`)
+
+// RequestIDPolicy controls how request IDs supplied by clients, in
+// RequestIDHeader, are treated. By default these are ignored and every
+// request is given a fresh ID.
+//
+// Client supplied IDs are only honoured when no longer than MaxLength and
+// matching Pattern; anything else is discarded, so clients can't inject
+// values which break log parsing.
+type RequestIDPolicy struct {
+	// Pattern defaults to DefaultRequestIDPattern
+	Pattern *regexp.Regexp
+
+	// MaxLength defaults to DefaultRequestIDMaxLength
+	MaxLength int
+
+	// RecordClientID keeps the internally minted ID as the request ID, and
+	// records a valid client supplied ID alongside it as ClientRequestID.
+	// This suits services which can't trust their callers to supply
+	// unique IDs, but still want to correlate with them
+	RecordClientID bool
+}
+
+// HonourRequestIDs turns on the use of client supplied request IDs, as
+// per p. Invalid patterns or lengths are programmer error, and panic.
+func (m *Middleware) HonourRequestIDs(p RequestIDPolicy) {
+	if p.Pattern == nil {
+		p.Pattern = DefaultRequestIDPattern
+	}
+
+	if p.MaxLength < 0 {
+		panic(fmt.Errorf("request ID max length must not be negative, received %d", p.MaxLength))
+	}
+
+	if p.MaxLength == 0 {
+		p.MaxLength = DefaultRequestIDMaxLength
+	}
+
+	m.requestIDs = &p
+}
+
+// requestID returns the ID to use for a request, given the value of its
+// RequestIDHeader, along with the client supplied ID when it is to be
+// recorded separately
+func (m *Middleware) requestID(supplied string) (id, clientID string) {
+	if m.requestIDs == nil || !m.requestIDs.valid(supplied) {
+		return newUUID(), ""
+	}
+
+	if m.requestIDs.RecordClientID {
+		return newUUID(), supplied
+	}
+
+	return supplied, ""
+}
+
+func (p RequestIDPolicy) valid(id string) bool {
+	return id != "" && len(id) <= p.MaxLength && p.Pattern.MatchString(id)
+}
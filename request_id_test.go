@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestMiddleware_requestID(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		policy     *RequestIDPolicy
+		supplied   string
+		expectID   string
+		expectFrom string
+	}{
+		{"ignored by default", nil, "abc-123", "", ""},
+		{"honoured", &RequestIDPolicy{}, "abc-123", "abc-123", ""},
+		{"too long", &RequestIDPolicy{MaxLength: 4}, "abc-123", "", ""},
+		{"log breaking", &RequestIDPolicy{}, "abc\" status=500", "", ""},
+		{"newline", &RequestIDPolicy{}, "abc\nfake entry", "", ""},
+		{"recorded separately", &RequestIDPolicy{RecordClientID: true}, "abc-123", "", "abc-123"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMiddleware(TestAPI{})
+			if test.policy != nil {
+				m.HonourRequestIDs(*test.policy)
+			}
+
+			id, clientID := m.requestID(test.supplied)
+
+			if test.expectID != "" && id != test.expectID {
+				t.Errorf("expected %q, received %q", test.expectID, id)
+			}
+
+			if test.expectID == "" && (id == test.supplied || id == "") {
+				t.Errorf("expected a freshly minted ID, received %q", id)
+			}
+
+			if clientID != test.expectFrom {
+				t.Errorf("expected client ID %q, received %q", test.expectFrom, clientID)
+			}
+		})
+	}
+}
+
+func TestHonourRequestIDs_RecordClientID(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.HonourRequestIDs(RequestIDPolicy{RecordClientID: true})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "client-supplied")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(logWriter.body))), &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if l.ClientRequestID != "client-supplied" {
+		t.Errorf("expected client ID to be recorded, received %q", l.ClientRequestID)
+	}
+
+	if l.RequestID == "client-supplied" || l.RequestID != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("expected minted ID to be used and returned, received %q", l.RequestID)
+	}
+}
+
+func TestNewUUID_Fallback(t *testing.T) {
+	uuidV4 = func() (uuid.UUID, error) {
+		return uuid.UUID{}, fmt.Errorf("entropy exhausted")
+	}
+	defer func() { uuidV4 = uuid.NewV4 }()
+
+	before := atomic.LoadInt64(&uuidFailures)
+
+	a, b := newUUID(), newUUID()
+	if a == b || a == DefaultBrokenUUID {
+		t.Errorf("expected unique fallback IDs, received %q and %q", a, b)
+	}
+
+	if !strings.HasPrefix(a, fallbackHost+"-") {
+		t.Errorf("expected fallback IDs to name the host, received %q", a)
+	}
+
+	if n := atomic.LoadInt64(&uuidFailures) - before; n != 2 {
+		t.Errorf("expected 2 failures, received %d", n)
+	}
+
+	// expvar panics on duplicate names, which counters used to risk
+	m := NewMiddleware(TestAPI{})
+	m.SetLogOutput(&TestWriter{})
+
+	for _, p := range []string{"/a", "/b"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if getCounters(t, m).UUIDFailures == 0 {
+		t.Errorf("expected uuid_failures to be reported")
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// leakyAPI allocates, and leaves a goroutine running until release is closed
+type leakyAPI struct {
+	release chan struct{}
+}
+
+var leaked []byte
+
+func (la leakyAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	leaked = make([]byte, 1<<20)
+
+	go func() { <-la.release }()
+
+	fmt.Fprint(w, "leaky")
+}
+
+func TestAttributeResources(t *testing.T) {
+	api := leakyAPI{release: make(chan struct{})}
+	defer close(api.release)
+
+	m := NewMiddleware(api)
+	m.AttributeResources(1)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Resources == nil {
+		t.Fatalf("expected resources, received %q", logWriter.body)
+	}
+
+	if l.Resources.AllocBytes < 1<<20 || l.Resources.Mallocs == 0 {
+		t.Errorf("expected at least 1MiB allocated, received %+v", l.Resources)
+	}
+
+	if l.Resources.Goroutines < 1 {
+		t.Errorf("expected a leaked goroutine, received %+v", l.Resources)
+	}
+}
+
+func TestAttributeResources_Disabled(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	if m.probeResources(false) != nil {
+		t.Errorf("expected no probe when disabled")
+	}
+
+	if m.probeResources(true) == nil {
+		t.Errorf("expected debug requests to be probed")
+	}
+}
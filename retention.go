@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"strconv"
+)
+
+const (
+	// RetentionAdmin is the SetRetentionClasses key for requests to admin
+	// endpoints, which are usually kept as an audit trail
+	RetentionAdmin = "admin"
+
+	// RetentionError is the SetRetentionClasses key for requests which
+	// failed without a response at all
+	RetentionError = "error"
+
+	// RetentionDefault is the SetRetentionClasses key for entries matching
+	// no other key
+	RetentionDefault = "*"
+)
+
+// SetRetentionClasses tags each entry with a retention class, emitted as
+// `retention`, so that downstream log storage can apply tiered retention.
+// classes maps keys to class names, where keys are, in order of precedence:
+//   - RetentionAdmin, for requests to admin endpoints;
+//   - RetentionError, for requests which failed without a response;
+//   - an exact status, such as `404`;
+//   - a status class, such as `2xx`; or
+//   - RetentionDefault
+//
+// For instance:
+//
+//	m.SetRetentionClasses(map[string]string{
+//		"2xx":                       "short",
+//		"5xx":                       "long",
+//		middleware.RetentionAdmin:   "long",
+//		middleware.RetentionDefault: "standard",
+//	})
+//
+// Entries matching no key have no retention class.
+func (m *Middleware) SetRetentionClasses(classes map[string]string) {
+	m.retention = make(map[string]string, len(classes))
+	for k, v := range classes {
+		m.retention[k] = v
+	}
+}
+
+// retentionClass returns the retention class for l, if any
+func (m *Middleware) retentionClass(l LogEntry, admin bool) string {
+	if len(m.retention) == 0 {
+		return ""
+	}
+
+	keys := []string{strconv.Itoa(l.Status), statusClass(l.Status), RetentionDefault}
+
+	switch {
+	case admin:
+		keys = append([]string{RetentionAdmin}, keys...)
+	case l.Error != "":
+		keys = append([]string{RetentionError}, keys...)
+	}
+
+	for _, k := range keys {
+		if class, ok := m.retention[k]; ok {
+			return class
+		}
+	}
+
+	return ""
+}
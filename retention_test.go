@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware_retentionClass(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+
+	if class := m.retentionClass(LogEntry{Status: 500}, false); class != "" {
+		t.Errorf("expected no class by default, received %q", class)
+	}
+
+	m.SetRetentionClasses(map[string]string{
+		"2xx":            "short",
+		"404":            "medium",
+		"5xx":            "long",
+		RetentionAdmin:   "audit",
+		RetentionError:   "long",
+		RetentionDefault: "standard",
+	})
+
+	for _, test := range []struct {
+		name   string
+		l      LogEntry
+		admin  bool
+		expect string
+	}{
+		{"status class", LogEntry{Status: 204}, false, "short"},
+		{"exact status", LogEntry{Status: 404}, false, "medium"},
+		{"fallback", LogEntry{Status: 401}, false, "standard"},
+		{"server error", LogEntry{Status: 503}, false, "long"},
+		{"failed request", LogEntry{Error: "timeout"}, false, "long"},
+		{"admin", LogEntry{Status: 200}, true, "audit"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if class := m.retentionClass(test.l, test.admin); class != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, class)
+			}
+		})
+	}
+}
+
+func TestSetRetentionClasses(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetRetentionClasses(map[string]string{"2xx": "short", RetentionAdmin: "audit"})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+
+	time.Sleep(100 * time.Millisecond)
+
+	classes := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(logWriter.body)), "\n") {
+		var l LogEntry
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		classes[l.Retention] = true
+	}
+
+	if !classes["short"] || !classes["audit"] {
+		t.Errorf("expected short and audit entries, received %v", classes)
+	}
+}
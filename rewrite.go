@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Rewrite holds small, gateway style, transformations for requests to a
+// route, such as stripping internal headers, rewriting legacy paths, or
+// injecting default headers. Hooks are written against net/http types,
+// and adapted for fasthttp handlers.
+//
+// Requests are logged and counted as the client made them, before
+// rewriting.
+type Rewrite struct {
+	// Request may change r's URL and headers before it reaches the wrapped
+	// handler. r is a copy, so changes don't leak into logs
+	Request func(r *http.Request)
+
+	// Response may change the response's headers, and returns the status
+	// to respond with, which is usually status
+	Response func(status int, h http.Header) int
+}
+
+// AddRewrite applies rw to any request path matching pattern, which takes
+// the same form as those passed to AddRoutePolicy. AddRewrite panics on a
+// malformed pattern
+func (m *Middleware) AddRewrite(pattern string, rw Rewrite) {
+	if err := m.rewrites.add(pattern, rw); err != nil {
+		panic(err)
+	}
+}
+
+// rewrite returns the Rewrite for a request path, if any
+func (m *Middleware) rewrite(p string) (rw Rewrite) {
+	if _, v, ok := m.rewrites.match(p); ok {
+		rw = v.(Rewrite)
+	}
+
+	return
+}
+
+// rewriteRequest returns the request to pass to the wrapped handler, which
+// is a rewritten copy of r when rw has a Request hook
+func (rw Rewrite) rewriteRequest(r *http.Request) *http.Request {
+	if rw.Request == nil {
+		return r
+	}
+
+	r = r.Clone(r.Context())
+	rw.Request(r)
+
+	return r
+}
+
+// rewriteResponse applies rw's Response hook, if any, to h
+func (rw Rewrite) rewriteResponse(status int, h http.Header) int {
+	if rw.Response == nil {
+		return status
+	}
+
+	return rw.Response(status, h)
+}
+
+// rewriteFasthttpRequest applies rw's Request hook to a fasthttp request
+func (rw Rewrite) rewriteFasthttpRequest(ctx *fasthttp.RequestCtx) {
+	if rw.Request == nil {
+		return
+	}
+
+	r := httpRequest(ctx)
+	before := r.Header.Clone()
+
+	rw.Request(r)
+
+	if uri := r.URL.RequestURI(); uri != string(ctx.RequestURI()) {
+		ctx.Request.SetRequestURI(uri)
+	}
+
+	applyHeaderChanges(before, r.Header, ctx.Request.Header.Del, ctx.Request.Header.Add)
+}
+
+// rewriteFasthttpResponse applies rw's Response hook to a fasthttp response
+func (rw Rewrite) rewriteFasthttpResponse(ctx *fasthttp.RequestCtx) {
+	if rw.Response == nil {
+		return
+	}
+
+	h := make(http.Header)
+	ctx.Response.Header.VisitAll(func(k, v []byte) {
+		h.Add(string(k), string(v))
+	})
+
+	before := h.Clone()
+
+	ctx.SetStatusCode(rw.Response(ctx.Response.StatusCode(), h))
+
+	applyHeaderChanges(before, h, ctx.Response.Header.Del, ctx.Response.Header.Add)
+}
+
+// applyHeaderChanges replays the differences between before and after via
+// del and add, leaving untouched headers (some of which fasthttp treats
+// specially, such as Content-Length) alone
+func applyHeaderChanges(before, after http.Header, del func(string), add func(string, string)) {
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			del(k)
+		}
+	}
+
+	for k, vs := range after {
+		if equalValues(before[k], vs) {
+			continue
+		}
+
+		del(k)
+
+		for _, v := range vs {
+			add(k, v)
+		}
+	}
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var testRewrite = Rewrite{
+	Request: func(r *http.Request) {
+		r.URL.Path = strings.Replace(r.URL.Path, "/v1/", "/v2/", 1)
+		r.Header.Del("X-Internal")
+
+		if r.Header.Get("Accept") == "" {
+			r.Header.Set("Accept", "application/json")
+		}
+	},
+	Response: func(status int, h http.Header) int {
+		h.Del("X-Backend")
+
+		if status == http.StatusNotFound {
+			return http.StatusGone
+		}
+
+		return status
+	},
+}
+
+func TestAddRewrite(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "10.0.0.1")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%s %q %s", r.URL.Path, r.Header.Get("X-Internal"), r.Header.Get("Accept"))
+	}))
+	m.AddRewrite("/v1/*", testRewrite)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/v1/users", nil)
+	r.Header.Set("X-Internal", "secret")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	if rec.Body.String() != `/v2/users "" application/json` {
+		t.Errorf("expected a rewritten request, received %q", rec.Body.String())
+	}
+
+	if rec.Code != http.StatusGone || rec.Header().Get("X-Backend") != "" {
+		t.Errorf("expected a rewritten response, received %d %v", rec.Code, rec.Header())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"url":"/v1/users"`) {
+		t.Errorf("expected the original URL to be logged, received %q", logWriter.body)
+	}
+}
+
+func TestAddRewrite_Fasthttp(t *testing.T) {
+	var received string
+
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {
+		received = fmt.Sprintf("%s %q %s", ctx.Path(), ctx.Request.Header.Peek("X-Internal"), ctx.Request.Header.Peek("Accept"))
+
+		ctx.Response.Header.Set("X-Backend", "10.0.0.1")
+		ctx.SetStatusCode(http.StatusNotFound)
+	}))
+	m.AddRewrite("/v1/*", testRewrite)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/v1/users")
+	c.Request.Header.Set("X-Internal", "secret")
+
+	m.ServeFastHTTP(c)
+
+	if received != `/v2/users "" application/json` {
+		t.Errorf("expected a rewritten request, received %q", received)
+	}
+
+	if c.Response.StatusCode() != http.StatusGone || len(c.Response.Header.Peek("X-Backend")) > 0 {
+		t.Errorf("expected a rewritten response, received %d", c.Response.StatusCode())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `/v1/users"`) {
+		t.Errorf("expected the original URL to be logged, received %q", logWriter.body)
+	}
+}
+
+type FHFunc func(*fasthttp.RequestCtx)
+
+func (f FHFunc) Handle(ctx *fasthttp.RequestCtx) {
+	f(ctx)
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxBodyBytes is the number of bytes of a request or response body
+	// captured into a LogEntry when a RoutePolicy doesn't specify its own limit
+	DefaultMaxBodyBytes = 4096
+)
+
+// Capture is a set of optional, and potentially expensive, pieces of
+// data which may be recorded against a LogEntry
+type Capture uint
+
+const (
+	// CaptureRequestBody records the (truncated) request body
+	CaptureRequestBody Capture = 1 << iota
+
+	// CaptureResponseBody records the (truncated) response body
+	CaptureResponseBody
+
+	// CaptureRequestHeaders records every request header
+	CaptureRequestHeaders
+
+	// CaptureResponseHeaders records every response header
+	CaptureResponseHeaders
+
+	// CaptureAll records everything there is to record
+	CaptureAll = CaptureRequestBody | CaptureResponseBody | CaptureRequestHeaders | CaptureResponseHeaders
+)
+
+// Has returns true when c contains every flag in flags
+func (c Capture) Has(flags Capture) bool {
+	return c&flags == flags
+}
+
+// RoutePolicy configures how requests to a particular route are logged.
+//
+// High volume, low value routes (such as health checks) can be sampled
+// down heavily, while sensitive routes (such as payments) can be logged in
+// full with their bodies attached.
+type RoutePolicy struct {
+	// SampleRate is the fraction, between 0 and 1, of requests which are
+	// passed on to loggers. Counters are always incremented regardless of
+	// sampling. A SampleRate of zero is treated as 1; log everything.
+	SampleRate float64
+
+	// SlowThreshold forces requests which take longer than this to be logged,
+	// regardless of sampling, and flags them as slow. Zero disables this.
+	SlowThreshold time.Duration
+
+	// Budget is the latency this route is expected to respond within.
+	// Requests which blow their budget are flagged in logs and counted,
+	// per route, under `budget_exceeded` in the counters endpoint. Zero
+	// disables this.
+	Budget time.Duration
+
+	// ApdexThreshold is the duration, T, within which this route's users
+	// are satisfied. Requests taking up to 4T are tolerated, and slower
+	// ones, along with errors, frustrate. Requests are flagged in logs with
+	// their zone, and counted, per route, under `apdex` in the counters
+	// endpoint, along with the route's Apdex score. Zero disables this.
+	ApdexThreshold time.Duration
+
+	// Capture lists the optional fields to record for this route
+	Capture Capture
+
+	// Level, when set, is the level of successful requests to this route,
+	// such as LevelDebug for healthchecks. Failed and slow requests keep
+	// their own levels
+	Level Level
+
+	// MaxBodyBytes limits how much of a body is captured. Zero means
+	// DefaultMaxBodyBytes
+	MaxBodyBytes int
+
+	// CaptureMinStatus and CaptureContentTypes, when set, limit response
+	// body capture to responses with at least this status, such as 500,
+	// and whose Content-Type begins with one of these, such as
+	// `application/json` or `text/`. This keeps post-mortem detail without
+	// logging every successful, or binary, body
+	CaptureMinStatus    int
+	CaptureContentTypes []string
+
+	// Availability, when set, limits the route to these daily windows,
+	// such as batch triggers which shouldn't be called at peak. Requests
+	// outside every window receive UnavailableStatus, which defaults to
+	// 503, without the wrapped handler being called, and are logged as
+	// `outside_window`
+	Availability      []Window
+	UnavailableStatus int
+
+	// EarlyHints lists Link header values, such as
+	// `</app.css>; rel=preload; as=style`, to send in a `103 Early Hints`
+	// response before the wrapped handler is called, so that browsers can
+	// preload assets while the response is prepared. Hints are only sent
+	// by net/http handlers, as fasthttp can't send interim responses, and
+	// only to HTTP/1.1 clients and later. ResponseWriters which don't
+	// support interim responses, such as httptest.ResponseRecorder, take
+	// the 103 as the final status
+	EarlyHints []string
+
+	// Deprecation, when set, annotates the route's responses with warnings
+	// of contract changes, such as deprecated fields
+	Deprecation Deprecation
+
+	// Stream passes net/http responses to clients as they're written and
+	// flushed, such as server-sent events, rather than once the handler
+	// returns. The request ID and final status are sent as trailers too,
+	// so that streams cut short by a failure are traceable. Response
+	// bodies of streamed routes aren't captured. fasthttp handlers stream
+	// with SetBodyStreamWriter, and aren't affected
+	Stream bool
+}
+
+// sampled decides whether a request should be logged, based on the policy's
+// sample rate
+func (p RoutePolicy) sampled() bool {
+	if p.SampleRate <= 0 || p.SampleRate >= 1 {
+		return true
+	}
+
+	return rand.Float64() < p.SampleRate
+}
+
+// rate returns the effective sample rate of a policy
+func (p RoutePolicy) rate() float64 {
+	if p.SampleRate <= 0 || p.SampleRate >= 1 {
+		return 1
+	}
+
+	return p.SampleRate
+}
+
+func (p RoutePolicy) maxBodyBytes() int {
+	if p.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+
+	return p.MaxBodyBytes
+}
+
+// capturesResponseBody returns true when a response's body should be
+// captured as per the policy
+func (p RoutePolicy) capturesResponseBody(status int, contentType string) bool {
+	if !p.Capture.Has(CaptureResponseBody) || status < p.CaptureMinStatus {
+		return false
+	}
+
+	if len(p.CaptureContentTypes) == 0 {
+		return true
+	}
+
+	for _, ct := range p.CaptureContentTypes {
+		if strings.HasPrefix(contentType, ct) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncate returns, at most, the policy's MaxBodyBytes worth of b as a string
+func (p RoutePolicy) truncate(b []byte) string {
+	if max := p.maxBodyBytes(); len(b) > max {
+		b = b[:max]
+	}
+
+	return string(b)
+}
+
+// AddRoutePolicy configures logging for any request path matching pattern.
+//
+// Patterns are matched against the request path, and come in three flavours:
+//   - exact paths, such as `/healthcheck`;
+//   - prefixes, ending in a `*`, such as `/payments/*`; and
+//   - globs, as understood by path.Match, such as `/users/*/avatar`
+//
+// Exact matches win over prefixes, longer prefixes win over shorter ones, and
+// globs are tried last in the order they were added.
+//
+// AddRoutePolicy panics on a malformed pattern, in much the same way
+// NewMiddleware panics on a handler it doesn't understand.
+func (m *Middleware) AddRoutePolicy(pattern string, p RoutePolicy) {
+	if err := m.routes.add(pattern, p); err != nil {
+		panic(err)
+	}
+}
+
+// policy returns the RoutePolicy for a request path, and the pattern which
+// matched it. Unmatched paths receive the zero RoutePolicy
+func (m *Middleware) policy(p string) (pattern string, rp RoutePolicy) {
+	pattern, v, ok := m.routes.match(p)
+	if ok {
+		rp = v.(RoutePolicy)
+	}
+
+	return
+}
+
+// routeMatcher is a compiled set of route patterns, each holding an arbitrary value.
+// It is built up front so that matching, which happens on every request, is cheap
+type routeMatcher struct {
+	exact    map[string]routeValue
+	prefixes []routeValue
+	globs    []routeValue
+}
+
+type routeValue struct {
+	pattern string
+	prefix  string
+	value   interface{}
+}
+
+func (rm *routeMatcher) add(pattern string, v interface{}) (err error) {
+	if pattern == "" {
+		return fmt.Errorf("empty route pattern")
+	}
+
+	if rm.exact == nil {
+		rm.exact = make(map[string]routeValue)
+	}
+
+	rv := routeValue{pattern: pattern, value: v}
+
+	switch {
+	case !strings.ContainsAny(pattern, `*?[\`):
+		rm.exact[pattern] = rv
+
+	case strings.HasSuffix(pattern, "*") && !strings.ContainsAny(pattern[:len(pattern)-1], `*?[\`):
+		rv.prefix = pattern[:len(pattern)-1]
+
+		rm.prefixes = append(rm.prefixes, rv)
+		sort.SliceStable(rm.prefixes, func(i, j int) bool {
+			return len(rm.prefixes[i].prefix) > len(rm.prefixes[j].prefix)
+		})
+
+	default:
+		if _, err = path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid route pattern %q: %v", pattern, err)
+		}
+
+		rm.globs = append(rm.globs, rv)
+	}
+
+	return
+}
+
+func (rm *routeMatcher) match(p string) (pattern string, v interface{}, ok bool) {
+	if rv, found := rm.exact[p]; found {
+		return rv.pattern, rv.value, true
+	}
+
+	for _, rv := range rm.prefixes {
+		if strings.HasPrefix(p, rv.prefix) {
+			return rv.pattern, rv.value, true
+		}
+	}
+
+	for _, rv := range rm.globs {
+		if matched, _ := path.Match(rv.pattern, p); matched {
+			return rv.pattern, rv.value, true
+		}
+	}
+
+	return
+}
+
+// SkipPaths stops requests matching any of patterns from being logged or
+// counted, which is useful for noisy endpoints such as load balancer health
+// checks. Matching requests are still passed to the wrapped handler.
+//
+// Patterns take the same form as those passed to AddRoutePolicy.
+func (m *Middleware) SkipPaths(patterns ...string) {
+	for _, p := range patterns {
+		if err := m.skip.add(p, nil); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (m *Middleware) skipped(p string) bool {
+	_, _, ok := m.skip.match(p)
+
+	return ok
+}
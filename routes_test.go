@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	rm := routeMatcher{}
+	for _, p := range []string{"/users/*/avatar", "/payments/*", "/payments/refunds/*", "/healthcheck", "/*"} {
+		if err := rm.add(p, p); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		path   string
+		expect string
+	}{
+		{"/healthcheck", "/healthcheck"},
+		{"/payments/123", "/payments/*"},
+		{"/payments/refunds/123", "/payments/refunds/*"},
+		{"/users/jim/avatar", "/*"},
+		{"/elsewhere", "/*"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			pattern, _, ok := rm.match(test.path)
+			if !ok {
+				t.Fatalf("expected %q to match", test.path)
+			}
+
+			if pattern != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, pattern)
+			}
+		})
+	}
+
+	t.Run("globs", func(t *testing.T) {
+		rm := routeMatcher{}
+		rm.add("/users/*/avatar", "glob")
+
+		if _, _, ok := rm.match("/users/jim/avatar"); !ok {
+			t.Errorf("expected glob to match")
+		}
+
+		if _, _, ok := rm.match("/users/jim/profile"); ok {
+			t.Errorf("unexpected match")
+		}
+	})
+
+	t.Run("bad patterns", func(t *testing.T) {
+		rm := routeMatcher{}
+		if err := rm.add("/users/[", nil); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestRoutePolicy(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/payments/*", RoutePolicy{Capture: CaptureRequestBody | CaptureResponseBody, MaxBodyBytes: 5})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("POST", "/payments/1", strings.NewReader("amount=100"))
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// TestAPI never reads the body it receives
+	if l.RequestBody != "" {
+		t.Errorf("expected empty request body, received %q", l.RequestBody)
+	}
+
+	if l.ResponseBody != TestResponseBody[:5] {
+		t.Errorf("expected %q, received %q", TestResponseBody[:5], l.ResponseBody)
+	}
+}
+
+func TestRoutePolicy_capturesResponseBody(t *testing.T) {
+	p := RoutePolicy{
+		Capture:             CaptureResponseBody,
+		CaptureMinStatus:    500,
+		CaptureContentTypes: []string{"application/json", "text/"},
+	}
+
+	for _, test := range []struct {
+		status      int
+		contentType string
+		expect      bool
+	}{
+		{200, "application/json", false},
+		{500, "application/json", true},
+		{503, "text/html; charset=utf-8", true},
+		{500, "image/png", false},
+		{500, "", false},
+	} {
+		if received := p.capturesResponseBody(test.status, test.contentType); received != test.expect {
+			t.Errorf("%d %q: expected %v, received %v", test.status, test.contentType, test.expect, received)
+		}
+	}
+
+	if (RoutePolicy{CaptureMinStatus: 500}).capturesResponseBody(500, "") {
+		t.Errorf("expected no capture without CaptureResponseBody")
+	}
+
+	if !debugPolicy(p).capturesResponseBody(200, "image/png") {
+		t.Errorf("expected debug requests to capture everything")
+	}
+}
+
+func TestRoutePolicy_sampled(t *testing.T) {
+	for _, test := range []struct {
+		rate   float64
+		expect float64
+	}{
+		{0, 1},
+		{1, 1},
+		{0.01, 0.01},
+	} {
+		p := RoutePolicy{SampleRate: test.rate}
+		if p.rate() != test.expect {
+			t.Errorf("%v: expected %v, received %v", test.rate, test.expect, p.rate())
+		}
+	}
+
+	if !(RoutePolicy{}).sampled() {
+		t.Errorf("the zero policy should always log")
+	}
+}
+
+type TestEchoAPI struct{}
+
+func (ta TestEchoAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 64)
+	n, _ := r.Body.Read(buf)
+
+	w.Write(buf[:n])
+}
+
+func TestBodyCapture(t *testing.T) {
+	m := NewMiddleware(TestEchoAPI{})
+	m.AddRoutePolicy("/payments/*", RoutePolicy{Capture: CaptureRequestBody, MaxBodyBytes: 6})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("POST", "/payments/1", strings.NewReader("amount=100")))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if rec.Body.String() != "amount=100" {
+		t.Errorf("body capture must not affect the handler, received %q", rec.Body.String())
+	}
+
+	var l LogEntry
+	json.Unmarshal(logWriter.body, &l)
+
+	if l.RequestBody != "amount" {
+		t.Errorf("expected %q, received %q", "amount", l.RequestBody)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"runtime"
+	"time"
+)
+
+// processStart approximates when the process started, for uptime
+var processStart = time.Now()
+
+// RuntimeStats describes the health of the process, as shown under
+// `runtime` by the counters endpoint
+type RuntimeStats struct {
+	Goroutines int `json:"goroutines"`
+
+	// HeapAllocBytes is the size of live, and not yet swept, heap objects,
+	// and HeapSysBytes that of the heap obtained from the OS
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapSysBytes   uint64 `json:"heap_sys_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+
+	// GCCycles is the number of garbage collections completed, which
+	// paused the program for GCPauseTotalMS in all, and LastGCPauseMS in
+	// the most recent
+	GCCycles       uint32  `json:"gc_cycles"`
+	GCPauseTotalMS float64 `json:"gc_pause_total_ms"`
+	LastGCPauseMS  float64 `json:"last_gc_pause_ms"`
+
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// IncludeRuntimeStats adds the process's RuntimeStats to the counters
+// endpoint, so that a single scrape gives both traffic and process
+// health.
+//
+// Reading them briefly stops the world, as runtime.ReadMemStats does, so
+// they're only read when the counters endpoint is called. They change
+// whether or not requests are counted, so counters which include them,
+// with `?sections` or as OpenMetrics, are served without an ETag
+func (m *Middleware) IncludeRuntimeStats() {
+	m.runtimeStats = true
+}
+
+// readRuntimeStats returns the process's RuntimeStats, or nil when they
+// aren't included in the counters endpoint
+func (m *Middleware) readRuntimeStats() *RuntimeStats {
+	if !m.runtimeStats {
+		return nil
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	rs := &RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapSysBytes:   mem.HeapSys,
+		HeapObjects:    mem.HeapObjects,
+		GCCycles:       mem.NumGC,
+		GCPauseTotalMS: durationMS(time.Duration(mem.PauseTotalNs)),
+		UptimeSeconds:  time.Since(processStart).Seconds(),
+	}
+
+	// PauseNs is a circular buffer of recent pauses
+	if mem.NumGC > 0 {
+		rs.LastGCPauseMS = durationMS(time.Duration(mem.PauseNs[(mem.NumGC+255)%256]))
+	}
+
+	return rs
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIncludeRuntimeStats(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+
+	if c := getCounters(t, m); c.Runtime != nil {
+		t.Errorf("expected no runtime stats unless included, received %+v", c.Runtime)
+	}
+
+	m.IncludeRuntimeStats()
+
+	rs := getCounters(t, m).Runtime
+	if rs == nil {
+		t.Fatal("expected runtime stats")
+	}
+
+	if rs.Goroutines < 1 || rs.HeapAllocBytes == 0 || rs.HeapSysBytes < rs.HeapAllocBytes || rs.UptimeSeconds <= 0 {
+		t.Errorf("expected plausible runtime stats, received %+v", rs)
+	}
+
+	req := httptest.NewRequest("GET", "/__/counters", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+
+	for _, line := range []string{"# TYPE go_goroutines gauge\n", "\ngo_gc_cycles_total ", "\nprocess_uptime_seconds "} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("expected %q in:\n%s", line, rec.Body.String())
+		}
+	}
+
+	sections := httptest.NewRecorder()
+	m.ServeHTTP(sections, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	for _, rec := range []*httptest.ResponseRecorder{rec, sections} {
+		if etag := rec.Header().Get("ETag"); etag != "" {
+			t.Errorf("expected counters with runtime stats to have no ETag, received %q", etag)
+		}
+	}
+}
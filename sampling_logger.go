@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// SamplingLogger implements middleware.Loggable, passing only a fraction
+// of successful entries on to another logger, while always passing on
+// errors (4xx and 5xx responses, and failed requests). This cuts log volume
+// on high traffic services without losing the entries people go looking for.
+//
+// Sampled entries have their SampleRate set, or multiplied when a route's
+// RoutePolicy has already sampled them, so that downstream consumers can
+// re-weight counts.
+type SamplingLogger struct {
+	target Loggable
+	rate   float64
+
+	// every, when set, keeps exactly one in every entries rather than
+	// sampling randomly
+	every uint64
+	seen  uint64
+}
+
+// NewSamplingLogger returns a SamplingLogger passing on each successful
+// entry with probability rate, between 0 and 1
+func NewSamplingLogger(target Loggable, rate float64) *SamplingLogger {
+	return &SamplingLogger{target: target, rate: rate}
+}
+
+// NewOneInNLogger returns a SamplingLogger passing on exactly one in every
+// n successful entries, which gives steadier volumes than NewSamplingLogger
+// on low traffic routes
+func NewOneInNLogger(target Loggable, n int) *SamplingLogger {
+	if n < 1 {
+		n = 1
+	}
+
+	return &SamplingLogger{target: target, rate: 1 / float64(n), every: uint64(n)}
+}
+
+// Log implements middleware.Loggable
+func (sl *SamplingLogger) Log(l LogEntry) {
+	if l.Status >= 400 || l.Error != "" || sl.rate >= 1 {
+		sl.target.Log(l)
+
+		return
+	}
+
+	if !sl.sampled() {
+		return
+	}
+
+	if l.SampleRate > 0 {
+		l.SampleRate *= sl.rate
+	} else {
+		l.SampleRate = sl.rate
+	}
+
+	sl.target.Log(l)
+}
+
+func (sl *SamplingLogger) sampled() bool {
+	if sl.every > 0 {
+		return atomic.AddUint64(&sl.seen, 1)%sl.every == 1%sl.every
+	}
+
+	return rand.Float64() < sl.rate
+}
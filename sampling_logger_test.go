@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"sync"
+	"testing"
+)
+
+type collectingLogger struct {
+	sync.Mutex
+	entries []LogEntry
+}
+
+func (cl *collectingLogger) Log(l LogEntry) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	cl.entries = append(cl.entries, l)
+}
+
+func TestOneInNLogger(t *testing.T) {
+	target := &collectingLogger{}
+	sl := NewOneInNLogger(target, 4)
+
+	for i := 0; i < 8; i++ {
+		sl.Log(LogEntry{Status: 200})
+	}
+
+	sl.Log(LogEntry{Status: 500})
+	sl.Log(LogEntry{Status: 404})
+
+	if len(target.entries) != 4 {
+		t.Fatalf("expected 2 successful entries and 2 errors, received %d", len(target.entries))
+	}
+
+	for _, l := range target.entries[:2] {
+		if l.SampleRate != 0.25 {
+			t.Errorf("expected sample rate 0.25, received %v", l.SampleRate)
+		}
+	}
+
+	for _, l := range target.entries[2:] {
+		if l.SampleRate != 0 {
+			t.Errorf("expected errors to be unsampled, received %v", l.SampleRate)
+		}
+	}
+}
+
+func TestSamplingLogger(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		rate       float64
+		entry      LogEntry
+		expect     int
+		expectRate float64
+	}{
+		{"none", 0, LogEntry{Status: 200}, 0, 0},
+		{"all", 1, LogEntry{Status: 200}, 100, 0},
+		{"errors", 0, LogEntry{Error: "connection refused"}, 100, 0},
+		{"route sampled", 0.999999, LogEntry{Status: 200, SampleRate: 0.5}, -1, 0.4999995},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target := &collectingLogger{}
+			sl := NewSamplingLogger(target, test.rate)
+
+			for i := 0; i < 100; i++ {
+				sl.Log(test.entry)
+			}
+
+			if test.expect >= 0 && len(target.entries) != test.expect {
+				t.Errorf("expected %d entries, received %d", test.expect, len(target.entries))
+			}
+
+			if test.expectRate > 0 && len(target.entries) > 0 && target.entries[0].SampleRate != test.expectRate {
+				t.Errorf("expected sample rate %v, received %v", test.expectRate, target.entries[0].SampleRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// SchemaVersion is the version of the LogEntry JSON schema. It is bumped
+	// whenever a field is removed or changes meaning; adding fields does not
+	// bump it
+	SchemaVersion = 1
+
+	// strictFieldsKey holds custom fields when marshaling in strict mode
+	strictFieldsKey = "fields"
+)
+
+// coreFields holds the JSON names of every field LogEntry defines itself.
+// Custom fields never override these
+var coreFields = jsonFieldNames(reflect.TypeOf(LogEntry{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{strictFieldsKey: true}
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// logEntry prevents MarshalJSON and UnmarshalJSON from recursing
+type logEntry LogEntry
+
+// MarshalJSON implements json.Marshaler. Custom Fields are flattened into
+// the top level object, alongside core fields, which makes them easy to
+// query in most log aggregators. Fields which clash with core fields
+// are dropped.
+func (l LogEntry) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(logEntry(l))
+	if err != nil || len(l.Fields) == 0 {
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	for _, k := range sortedFieldKeys(l.Fields) {
+		if coreFields[k] {
+			continue
+		}
+
+		v, err := json.Marshal(l.Fields[k])
+		if err != nil {
+			return nil, err
+		}
+
+		kb, _ := json.Marshal(k)
+
+		buf.WriteByte(',')
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// MarshalStrict marshals l such that every top level key is part of the
+// versioned schema, nesting custom Fields under a `fields` key. This suits
+// consumers which validate entries against a schema and reject unknown keys.
+func MarshalStrict(l LogEntry) ([]byte, error) {
+	b, err := json.Marshal(logEntry(l))
+	if err != nil || len(l.Fields) == 0 {
+		return b, err
+	}
+
+	fields, err := json.Marshal(l.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	buf.WriteString(`,"` + strictFieldsKey + `":`)
+	buf.Write(fields)
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting both flattened and
+// strict output. Unknown keys are collected into Fields.
+func (l *LogEntry) UnmarshalJSON(b []byte) (err error) {
+	var le logEntry
+	if err = json.Unmarshal(b, &le); err != nil {
+		return
+	}
+
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(b, &raw); err != nil {
+		return
+	}
+
+	if nested, ok := raw[strictFieldsKey]; ok {
+		if err = json.Unmarshal(nested, &le.Fields); err != nil {
+			return
+		}
+	}
+
+	for k, v := range raw {
+		if coreFields[k] {
+			continue
+		}
+
+		if le.Fields == nil {
+			le.Fields = make(map[string]interface{})
+		}
+
+		var val interface{}
+		if err = json.Unmarshal(v, &val); err != nil {
+			return
+		}
+
+		le.Fields[k] = val
+	}
+
+	*l = LogEntry(le)
+
+	return
+}
+
+// CustomFieldKeys returns the keys of l's custom Fields which don't clash
+// with core fields, in order, as flattened by MarshalJSON. Loggers of
+// other formats can use it to do the same
+func CustomFieldKeys(l LogEntry) []string {
+	keys := make([]string, 0, len(l.Fields))
+	for _, k := range sortedFieldKeys(l.Fields) {
+		if !coreFields[k] {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// StrictSchema switches the default logger to strict output, as per MarshalStrict
+func (m *Middleware) StrictSchema() {
+	m.defaultLoggers(func(dl *defaultLogger) {
+		dl.strict = true
+	})
+}
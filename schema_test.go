@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogEntry_MarshalJSON(t *testing.T) {
+	l := LogEntry{
+		SchemaVersion: SchemaVersion,
+		Status:        200,
+		Fields: map[string]interface{}{
+			"shard":  "eu-1",
+			"status": "clobbered",
+		},
+	}
+
+	t.Run("flattened", func(t *testing.T) {
+		b, err := json.Marshal(l)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		var raw map[string]interface{}
+		json.Unmarshal(b, &raw)
+
+		if raw["shard"] != "eu-1" {
+			t.Errorf("expected custom field to be flattened, received %s", b)
+		}
+
+		if raw["status"] != float64(200) {
+			t.Errorf("custom fields must not override core fields, received %s", b)
+		}
+
+		if raw["schema_version"] != float64(SchemaVersion) {
+			t.Errorf("expected schema version, received %s", b)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		b, err := MarshalStrict(l)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if !strings.Contains(string(b), `"fields":{"shard":"eu-1","status":"clobbered"}`) {
+			t.Errorf("expected custom fields to be nested, received %s", b)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, marshal := range []func(LogEntry) ([]byte, error){
+			func(l LogEntry) ([]byte, error) { return json.Marshal(l) },
+			MarshalStrict,
+		} {
+			b, _ := marshal(l)
+
+			var out LogEntry
+			if err := json.Unmarshal(b, &out); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if out.Status != 200 || out.Fields["shard"] != "eu-1" {
+				t.Errorf("unexpected entry %+v from %s", out, b)
+			}
+		}
+	})
+}
+
+func TestCustomFieldKeys(t *testing.T) {
+	l := LogEntry{Fields: map[string]interface{}{"shard": "eu-1", "status": "clobbered", "az": "b"}}
+
+	if keys := strings.Join(CustomFieldKeys(l), ","); keys != "az,shard" {
+		t.Errorf("expected custom fields in order, without core fields, received %q", keys)
+	}
+}
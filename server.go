@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultReadHeaderTimeout bounds how long servers started by the
+// middleware wait for request headers, to limit slow clients
+const DefaultReadHeaderTimeout = 10 * time.Second
+
+// CertManager obtains TLS certificates for ListenAndServeTLS, answering
+// challenges over plain HTTP as it needs to. *autocert.Manager, from
+// golang.org/x/crypto/acme/autocert, is one, and the autotls subpackage
+// sets one up for Let's Encrypt
+type CertManager interface {
+	TLSConfig() *tls.Config
+
+	// HTTPHandler answers challenges, passing other requests to fallback,
+	// or redirecting them to HTTPS when fallback is nil
+	HTTPHandler(fallback http.Handler) http.Handler
+}
+
+// ListenAndServe serves m on addr, for both net/http and fasthttp
+// handlers:
+//
+//	m := middleware.NewMiddleware(API{})
+//	panic(m.ListenAndServe(":8008"))
+//
+// When m was started by Handoff, the listener for addr is inherited from
+// the previous process rather than opened afresh. ListenAndServe returns
+// nil once Shutdown is called
+func (m *Middleware) ListenAndServe(addr string) error {
+	ln, err := m.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return m.serve(ln)
+}
+
+// ListenAndServeTLS serves m over TLS on addr, using certificates
+// obtained by cm, and answers cm's challenges on httpAddr, redirecting all
+// other plain HTTP requests to HTTPS:
+//
+//	m := middleware.NewMiddleware(API{})
+//	panic(m.ListenAndServeTLS(manager, ":443", ":80"))
+//
+// addr and httpAddr default to `:443` and `:80`. Challenges never reach
+// the wrapped handler, nor are they logged
+func (m *Middleware) ListenAndServeTLS(cm CertManager, addr, httpAddr string) error {
+	if addr == "" {
+		addr = ":443"
+	}
+
+	if httpAddr == "" {
+		httpAddr = ":80"
+	}
+
+	ln, err := m.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	httpLn, err := m.listen(httpAddr)
+	if err != nil {
+		ln.Close()
+
+		return err
+	}
+
+	challenges := &http.Server{
+		Handler:           cm.HTTPHandler(nil),
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+	}
+
+	errs := make(chan error, 2)
+
+	go func() { errs <- m.serveHTTPServer(challenges, httpLn) }()
+	go func() { errs <- m.serve(tls.NewListener(ln, cm.TLSConfig())) }()
+
+	err = <-errs
+
+	ln.Close()
+	challenges.Close()
+
+	return err
+}
+
+// serve serves m on ln with whichever server suits the wrapped handler
+func (m *Middleware) serve(ln net.Listener) error {
+	return m.serveWith(ln, m, m.ServeFastHTTP)
+}
+
+// serveWith serves ln with h or fh, whichever suits the wrapped handler,
+// preferring net/http for handlers which support both
+func (m *Middleware) serveWith(ln net.Listener, h http.Handler, fh fasthttp.RequestHandler) error {
+	if _, ok := m.handler.(http.Handler); ok {
+		return m.serveHTTPServer(&http.Server{Handler: h, ReadHeaderTimeout: DefaultReadHeaderTimeout}, ln)
+	}
+
+	s := &fasthttp.Server{Handler: fh}
+
+	// fasthttp's Shutdown has no deadline of its own
+	m.track(func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() { done <- s.Shutdown() }()
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	return s.Serve(ln)
+}
+
+// serveHTTPServer serves ln with s, so that it may be stopped by Shutdown
+func (m *Middleware) serveHTTPServer(s *http.Server, ln net.Listener) error {
+	m.track(s.Shutdown)
+
+	if err := s.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
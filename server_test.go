@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		handler interface{}
+	}{
+		{"net/http", TestAPI{}},
+		{"fasthttp", FHAPI{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer ln.Close()
+
+			go NewMiddleware(test.handler).serve(ln)
+
+			resp, err := http.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if string(body) != "hello, world!" || resp.Header.Get(RequestIDHeader) == "" {
+				t.Errorf("expected a response through the middleware, received %d %q", resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+const (
+	// DefaultShadowTimeout is how long a shadow handler has to respond
+	DefaultShadowTimeout = time.Second
+
+	// DefaultShadowMaxBodyBytes is the largest request body mirrored
+	DefaultShadowMaxBodyBytes = 1 << 20
+)
+
+// ShadowPolicy mirrors requests to a second, shadow, handler, such as a
+// rewrite being dark launched. Shadow responses are never sent to clients.
+//
+// With Compare set, each shadow response is compared with the primary's
+// status, CompareHeaders, and a hash of its body. Differences are logged
+// against the primary request under `shadow_diff`, and counted under
+// `shadow` in the counters endpoint, making dark launches measurable. A
+// shadow handler which panics is recovered, and counted as mismatched.
+//
+// Shadowing is only supported for net/http handlers.
+type ShadowPolicy struct {
+	Handler http.Handler
+
+	// SampleRate is the fraction of requests mirrored. Zero mirrors them all
+	SampleRate float64
+
+	Compare        bool
+	CompareHeaders []string
+
+	// Timeout bounds how long comparison waits for the shadow handler.
+	// Zero means DefaultShadowTimeout
+	Timeout time.Duration
+
+	// MaxBodyBytes is the largest request body mirrored, as bodies are
+	// buffered for both handlers. Larger requests aren't mirrored, and are
+	// counted as skipped. Zero means DefaultShadowMaxBodyBytes
+	MaxBodyBytes int
+}
+
+// SetShadow mirrors requests as per p. A policy without a Handler disables
+// shadowing
+func (m *Middleware) SetShadow(p ShadowPolicy) {
+	if p.Handler == nil {
+		m.shadow = nil
+
+		return
+	}
+
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultShadowTimeout
+	}
+
+	if p.MaxBodyBytes <= 0 {
+		p.MaxBodyBytes = DefaultShadowMaxBodyBytes
+	}
+
+	m.shadow = &shadow{policy: p}
+}
+
+// shadow holds the shadow policy, and comparison counts
+type shadow struct {
+	policy ShadowPolicy
+	counts counterSet
+}
+
+// shadowRun is a request in flight to the shadow handler
+type shadowRun struct {
+	policy ShadowPolicy
+	done   chan shadowResult
+}
+
+// shadowResult is the shadow handler's response, or how it crashed
+type shadowResult struct {
+	rec     *httptest.ResponseRecorder
+	crashed *crash
+}
+
+// mirror sends a copy of r to the shadow handler, when shadowing is
+// enabled and r is sampled. r's body is read, up to the policy's
+// MaxBodyBytes, and replaced, so that both handlers see it
+func (m *Middleware) mirror(r *http.Request) *shadowRun {
+	if m.shadow == nil {
+		return nil
+	}
+
+	p := m.shadow.policy
+	if p.SampleRate > 0 && p.SampleRate < 1 && rand.Float64() >= p.SampleRate {
+		return nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(io.LimitReader(r.Body, int64(p.MaxBodyBytes)+1))
+
+		// Whatever wasn't read is left for the primary handler alone
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		if len(body) > p.MaxBodyBytes {
+			m.shadow.counts.add("skipped", 1)
+
+			return nil
+		}
+	}
+
+	// The shadow mustn't be cancelled along with the primary request
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+
+	sr := r.Clone(ctx)
+	sr.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	run := &shadowRun{policy: p, done: make(chan shadowResult, 1)}
+
+	go func() {
+		defer cancel()
+
+		// The shadow is the code under validation, so mustn't be able to
+		// take the process down with it
+		rec := httptest.NewRecorder()
+		crashed := protect(func() { p.Handler.ServeHTTP(rec, sr) })
+
+		run.done <- shadowResult{rec: rec, crashed: crashed}
+	}()
+
+	return run
+}
+
+// diff waits for the shadow response, and lists how it differs from the
+// primary response, when comparing. A nil run, or one which isn't being
+// compared, has no differences
+func (m *Middleware) diff(run *shadowRun, status int, header http.Header, body []byte) (diff []string) {
+	if run == nil || !run.policy.Compare {
+		return nil
+	}
+
+	defer func() {
+		m.shadow.counts.add("compared", 1)
+		if len(diff) > 0 {
+			m.shadow.counts.add("mismatched", 1)
+		}
+	}()
+
+	var res shadowResult
+
+	select {
+	case res = <-run.done:
+	case <-time.After(run.policy.Timeout):
+		return []string{"shadow timed out"}
+	}
+
+	if res.crashed != nil {
+		return []string{fmt.Sprintf("shadow panicked: %v", res.crashed.value)}
+	}
+
+	rec := res.rec
+
+	if rec.Code != status {
+		diff = append(diff, fmt.Sprintf("status: %d != %d", status, rec.Code))
+	}
+
+	for _, h := range run.policy.CompareHeaders {
+		if p, s := header.Get(h), rec.Header().Get(h); p != s {
+			diff = append(diff, fmt.Sprintf("header %s: %q != %q", h, p, s))
+		}
+	}
+
+	if sha256.Sum256(body) != sha256.Sum256(rec.Body.Bytes()) {
+		diff = append(diff, fmt.Sprintf("body: %d bytes != %d bytes, hashes differ", len(body), rec.Body.Len()))
+	}
+
+	return
+}
+
+// shadowCounts returns comparison counts, when shadowing is enabled
+func (m *Middleware) shadowCounts() map[string]int64 {
+	if m.shadow == nil {
+		return nil
+	}
+
+	return m.shadow.counts.snapshot()
+}
+
+// readCloser reads from a Reader, but closes a Closer, such as the body it
+// reads from in part
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetShadow(t *testing.T) {
+	bodies := make(chan string, 1)
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		w.Header().Set("X-Version", "1")
+		fmt.Fprintf(w, "primary %s", b)
+	}))
+
+	m.SetShadow(ShadowPolicy{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			bodies <- string(b)
+
+			w.Header().Set("X-Version", "2")
+			w.WriteHeader(http.StatusAccepted)
+		}),
+		Compare:        true,
+		CompareHeaders: []string{"X-Version", "Content-Type"},
+	})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+
+	if rec.Body.String() != "primary hello" {
+		t.Errorf("expected the primary response, received %q", rec.Body.String())
+	}
+
+	select {
+	case b := <-bodies:
+		if b != "hello" {
+			t.Errorf("expected the shadow to receive the body, received %q", b)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("expected the shadow to be called")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, expect := range []string{`status: 200 != 202`, `header X-Version: \"1\" != \"2\"`, `hashes differ`} {
+		if !strings.Contains(string(logWriter.body), expect) {
+			t.Errorf("expected %q in %q", expect, logWriter.body)
+		}
+	}
+
+	if got := m.shadowCounts(); got["compared"] != 1 || got["mismatched"] != 1 {
+		t.Errorf("unexpected counts %+v", got)
+	}
+}
+
+func TestSetShadow_Timeout(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetShadow(ShadowPolicy{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}),
+		Compare: true,
+		Timeout: 10 * time.Millisecond,
+	})
+
+	run := m.mirror(httptest.NewRequest("GET", "/", nil))
+
+	if diff := m.diff(run, 200, http.Header{}, nil); len(diff) != 1 || diff[0] != "shadow timed out" {
+		t.Errorf("expected a timeout, received %v", diff)
+	}
+}
+
+func TestSetShadow_Match(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetShadow(ShadowPolicy{Handler: TestAPI{}, Compare: true})
+
+	run := m.mirror(httptest.NewRequest("GET", "/", nil))
+
+	if diff := m.diff(run, 200, http.Header{}, []byte(TestResponseBody)); diff != nil {
+		t.Errorf("expected no differences, received %v", diff)
+	}
+}
+
+func TestSetShadow_Panic(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.SetShadow(ShadowPolicy{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("shadow broke")
+		}),
+		Compare: true,
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Body.String() != TestResponseBody {
+		t.Errorf("expected the primary response, received %q", rec.Body.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if got := m.shadowCounts(); got["compared"] != 1 || got["mismatched"] != 1 {
+		t.Errorf("expected a panicked shadow to count as a mismatch, received %+v", got)
+	}
+}
+
+func TestSetShadow_MaxBodyBytes(t *testing.T) {
+	called := make(chan struct{}, 1)
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	m.SetShadow(ShadowPolicy{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called <- struct{}{}
+		}),
+		MaxBodyBytes: 4,
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+
+	if rec.Body.String() != "hello" {
+		t.Errorf("expected the primary handler to read the whole body, received %q", rec.Body.String())
+	}
+
+	select {
+	case <-called:
+		t.Error("expected a body over MaxBodyBytes not to be mirrored")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := m.shadowCounts(); got["skipped"] != 1 {
+		t.Errorf("expected the request to be counted as skipped, received %+v", got)
+	}
+}
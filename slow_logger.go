@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// SlowLogger implements middleware.Loggable, forwarding only entries for
+// requests which took longer than a threshold to another logger, which
+// surfaces latency outliers without wading through every entry.
+//
+// With Diagnose, slow entries gain `goroutines` and `in_flight` fields,
+// captured as they're logged, to help tell a slow dependency from an
+// overloaded process.
+type SlowLogger struct {
+	target    Loggable
+	threshold time.Duration
+	m         *Middleware
+}
+
+// NewSlowLogger returns a SlowLogger forwarding entries slower than
+// threshold to target
+func NewSlowLogger(target Loggable, threshold time.Duration) *SlowLogger {
+	return &SlowLogger{target: target, threshold: threshold}
+}
+
+// Diagnose adds diagnostic fields to slow entries, reading requests in
+// flight from m, and returns sl
+func (sl *SlowLogger) Diagnose(m *Middleware) *SlowLogger {
+	sl.m = m
+
+	return sl
+}
+
+// Log implements middleware.Loggable
+func (sl *SlowLogger) Log(l LogEntry) {
+	if entryDuration(l) <= sl.threshold {
+		return
+	}
+
+	if sl.m != nil {
+		// Fields is shared with other loggers, so is copied rather than
+		// added to
+		fields := make(map[string]interface{}, len(l.Fields)+2)
+		for k, v := range l.Fields {
+			fields[k] = v
+		}
+
+		fields["goroutines"] = runtime.NumGoroutine()
+		fields["in_flight"] = sl.m.InFlight()
+
+		l.Fields = fields
+	}
+
+	sl.target.Log(l)
+}
+
+// entryDuration returns the duration of l, preferring the precision of its
+// Duration string to the whole milliseconds of DurationMS
+func entryDuration(l LogEntry) time.Duration {
+	if d, err := time.ParseDuration(l.Duration); err == nil {
+		return d
+	}
+
+	return time.Duration(l.DurationMS * float64(time.Millisecond))
+}
+
+// InFlight returns the number of requests currently being handled by the
+// wrapped handler
+func (m *Middleware) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowLogger(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		entry  LogEntry
+		expect bool
+	}{
+		{"fast", LogEntry{Duration: "10ms", DurationMS: 10}, false},
+		{"at threshold", LogEntry{Duration: "100ms", DurationMS: 100}, false},
+		{"slow", LogEntry{Duration: "100.5ms", DurationMS: 100}, true},
+		{"ms only", LogEntry{DurationMS: 250}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target := &collectingLogger{}
+			NewSlowLogger(target, 100*time.Millisecond).Log(test.entry)
+
+			if received := len(target.entries) == 1; received != test.expect {
+				t.Errorf("expected logged %v, received %v", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestSlowLogger_Diagnose(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	target := &collectingLogger{}
+
+	fields := map[string]interface{}{"user": "jo"}
+	NewSlowLogger(target, time.Millisecond).Diagnose(m).Log(LogEntry{Duration: "1s", Fields: fields})
+
+	if len(target.entries) != 1 {
+		t.Fatalf("expected 1 entry, received %d", len(target.entries))
+	}
+
+	l := target.entries[0]
+	if l.Fields["goroutines"].(int) < 1 {
+		t.Errorf("expected a goroutine count, received %v", l.Fields["goroutines"])
+	}
+
+	if l.Fields["in_flight"] != int64(0) {
+		t.Errorf("expected 0 requests in flight, received %v", l.Fields["in_flight"])
+	}
+
+	if l.Fields["user"] != "jo" {
+		t.Errorf("expected existing fields to be kept, received %v", l.Fields)
+	}
+
+	if len(fields) != 1 {
+		t.Errorf("expected shared fields to be left alone, received %v", fields)
+	}
+}
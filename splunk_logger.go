@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultSplunkBatchSize is the most entries a SplunkHECLogger sends per request
+	DefaultSplunkBatchSize = 100
+
+	// DefaultSplunkFlushInterval is the longest a SplunkHECLogger holds onto
+	// an entry before sending it
+	DefaultSplunkFlushInterval = 5 * time.Second
+
+	// DefaultSplunkRetries is how many times a failed batch is retried
+	DefaultSplunkRetries = 3
+
+	// DefaultSplunkRetryBackoff is how long a SplunkHECLogger waits
+	// before its first retry
+	DefaultSplunkRetryBackoff = time.Second
+)
+
+// SplunkHECConfig configures a SplunkHECLogger. Only URL and Token are
+// required; URL is usually of the form
+// `https://splunk.example.com:8088/services/collector/event`
+type SplunkHECConfig struct {
+	URL   string
+	Token string
+
+	// Index, Source, SourceType, and Host are sent as event metadata when
+	// set. Otherwise the token's defaults apply
+	Index      string
+	Source     string
+	SourceType string
+	Host       string
+
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Retries is how many times a batch is resent after a network error,
+	// or a 429 or 5xx response, waiting RetryBackoff times the attempt
+	// number between each. A negative value disables retries
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Client defaults to an http.Client with a ten second timeout
+	Client *http.Client
+}
+
+// SplunkHECLogger implements middleware.Loggable, batching entries and
+// POSTing them to a Splunk HTTP Event Collector. Batches are sent when full,
+// and by a background flusher every FlushInterval.
+type SplunkHECLogger struct {
+	config  SplunkHECConfig
+	batcher *BatchLogger
+}
+
+// hecEvent is a single event, as per the HEC /services/collector/event API
+type hecEvent struct {
+	Time       float64  `json:"time"`
+	Host       string   `json:"host,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	SourceType string   `json:"sourcetype,omitempty"`
+	Index      string   `json:"index,omitempty"`
+	Event      LogEntry `json:"event"`
+}
+
+// NewSplunkHECLogger returns a SplunkHECLogger configured by c
+func NewSplunkHECLogger(c SplunkHECConfig) *SplunkHECLogger {
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultSplunkBatchSize
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = DefaultSplunkFlushInterval
+	}
+
+	switch {
+	case c.Retries == 0:
+		c.Retries = DefaultSplunkRetries
+	case c.Retries < 0:
+		c.Retries = 0
+	}
+
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultSplunkRetryBackoff
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	sl := &SplunkHECLogger{config: c}
+	sl.batcher = NewBatchLogger(sl, c.BatchSize, c.FlushInterval)
+
+	return sl
+}
+
+// Log implements middleware.Loggable
+func (sl *SplunkHECLogger) Log(l LogEntry) {
+	sl.batcher.Log(l)
+}
+
+// LogBatch implements middleware.BatchLoggable, sending ls straight away
+func (sl *SplunkHECLogger) LogBatch(ls []LogEntry) {
+	sl.Post(ls)
+}
+
+// Flush sends any pending entries immediately
+func (sl *SplunkHECLogger) Flush() {
+	sl.batcher.Flush()
+}
+
+// Close flushes any pending entries
+func (sl *SplunkHECLogger) Close() error {
+	return sl.batcher.Close()
+}
+
+// Post sends ls to Splunk in a single request, retrying as configured
+func (sl *SplunkHECLogger) Post(ls []LogEntry) (err error) {
+	body := &bytes.Buffer{}
+	enc := json.NewEncoder(body)
+
+	for _, l := range ls {
+		t := l.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+
+		err = enc.Encode(hecEvent{
+			Time:       float64(t.UnixNano()) / float64(time.Second),
+			Host:       sl.config.Host,
+			Source:     sl.config.Source,
+			SourceType: sl.config.SourceType,
+			Index:      sl.config.Index,
+			Event:      l,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for attempt := 0; attempt <= sl.config.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * sl.config.RetryBackoff)
+		}
+
+		var retry bool
+		if retry, err = sl.post(body.Bytes()); err == nil || !retry {
+			return
+		}
+	}
+
+	return
+}
+
+// post makes a single request, returning whether a failure is worth retrying
+func (sl *SplunkHECLogger) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, sl.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Authorization", "Splunk "+sl.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sl.config.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+
+		return
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		fmt.Errorf("splunk HEC responded %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
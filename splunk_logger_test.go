@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplunkHECLogger(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		attempts int
+		events   []hecEvent
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if r.Header.Get("Authorization") != "Splunk s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		s := bufio.NewScanner(r.Body)
+		for s.Scan() {
+			var e hecEvent
+			if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+
+			events = append(events, e)
+		}
+	}))
+	defer srv.Close()
+
+	sl := NewSplunkHECLogger(SplunkHECConfig{
+		URL:        srv.URL,
+		Token:      "s3cr3t",
+		Index:      "web",
+		SourceType: "_json",
+		BatchSize:  2,
+
+		RetryBackoff: time.Millisecond,
+	})
+
+	sl.Log(LogEntry{RequestID: "a", Status: 200})
+	sl.Log(LogEntry{RequestID: "b", Status: 404})
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if attempts != 2 {
+		t.Errorf("expected a retry after a 503, received %d attempts", attempts)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, received %d", len(events))
+	}
+
+	if events[0].Index != "web" || events[0].SourceType != "_json" || events[1].Event.RequestID != "b" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestSplunkHECLogger_NoRetryOnClientError(t *testing.T) {
+	var attempts int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	sl := NewSplunkHECLogger(SplunkHECConfig{URL: srv.URL, Token: "wrong"})
+
+	if err := sl.Post([]LogEntry{{}}); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, received %d", attempts)
+	}
+}
@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSpoolRetryInterval is how often a SpoolLogger retries a
+	// failing sink
+	DefaultSpoolRetryInterval = 5 * time.Second
+)
+
+// Shipper is implemented by network-backed loggers, which, unlike plain
+// Loggables, can report a failure to deliver an entry
+type Shipper interface {
+	Ship(LogEntry) error
+}
+
+// SpoolStats describes the contents of a spool
+type SpoolStats struct {
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Dropped int64 `json:"dropped"`
+}
+
+// SpoolLogger implements middleware.Loggable, wrapping a Shipper with an on
+// disk spool. Entries which can't be shipped, because the sink is down,
+// are appended to the spool and replayed, in order, once the sink recovers.
+//
+// While the spool holds entries, new entries are spooled behind them and the
+// sink is retried at most once per RetryInterval. Replays are triggered by
+// logging, or by calling Replay. Should the spool grow beyond MaxBytes, new
+// entries are dropped and counted.
+//
+// The spool survives restarts: entries left over by a previous process are
+// replayed by the next.
+type SpoolLogger struct {
+	MaxBytes      int64
+	RetryInterval time.Duration
+
+	lock sync.Mutex
+	sink Shipper
+	path string
+
+	stats     SpoolStats
+	lastRetry time.Time
+}
+
+// NewSpoolLogger returns a SpoolLogger shipping to sink, spooling to the
+// file at path. A maxBytes of zero means the spool is unbounded
+func NewSpoolLogger(sink Shipper, path string, maxBytes int64) (sl *SpoolLogger, err error) {
+	sl = &SpoolLogger{
+		MaxBytes:      maxBytes,
+		RetryInterval: DefaultSpoolRetryInterval,
+		sink:          sink,
+		path:          path,
+	}
+
+	lines, err := sl.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		sl.stats.Entries++
+		sl.stats.Bytes += int64(len(line)) + 1
+	}
+
+	return
+}
+
+// Log implements middleware.Loggable
+func (sl *SpoolLogger) Log(l LogEntry) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.stats.Entries > 0 && time.Since(sl.lastRetry) >= sl.RetryInterval {
+		sl.replay()
+	}
+
+	if sl.stats.Entries == 0 {
+		if err := sl.sink.Ship(l); err == nil {
+			return
+		}
+
+		sl.lastRetry = time.Now()
+	}
+
+	sl.spool(l)
+}
+
+// Replay attempts to ship every spooled entry, stopping at the first
+// failure. It returns the number of entries which remain spooled
+func (sl *SpoolLogger) Replay() (remaining int64, err error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	err = sl.replay()
+
+	return sl.stats.Entries, err
+}
+
+// Stats returns the current size of the spool, and the number of entries
+// dropped because it was full
+func (sl *SpoolLogger) Stats() SpoolStats {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	return sl.stats
+}
+
+// spoolStats identifies this spool in the counters endpoint
+func (sl *SpoolLogger) spoolStats() (string, SpoolStats) {
+	return sl.path, sl.Stats()
+}
+
+func (sl *SpoolLogger) spool(l LogEntry) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return
+	}
+
+	size := int64(len(b)) + 1
+	if sl.MaxBytes > 0 && sl.stats.Bytes+size > sl.MaxBytes {
+		sl.stats.Dropped++
+
+		return
+	}
+
+	f, err := os.OpenFile(sl.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		sl.stats.Dropped++
+
+		return
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		sl.stats.Dropped++
+
+		return
+	}
+
+	sl.stats.Entries++
+	sl.stats.Bytes += size
+}
+
+// replay must be called with sl.lock held
+func (sl *SpoolLogger) replay() (err error) {
+	sl.lastRetry = time.Now()
+
+	lines, err := sl.read()
+	if err != nil {
+		return
+	}
+
+	var shipped int
+	for _, line := range lines {
+		var l LogEntry
+		if json.Unmarshal(line, &l) == nil {
+			if err = sl.sink.Ship(l); err != nil {
+				break
+			}
+		}
+
+		shipped++
+	}
+
+	if shipped == 0 {
+		return
+	}
+
+	remaining := lines[shipped:]
+
+	sl.stats.Entries, sl.stats.Bytes = 0, 0
+	for _, line := range remaining {
+		sl.stats.Entries++
+		sl.stats.Bytes += int64(len(line)) + 1
+	}
+
+	if len(remaining) == 0 {
+		if rmErr := os.Remove(sl.path); rmErr != nil && !os.IsNotExist(rmErr) {
+			return rmErr
+		}
+
+		return
+	}
+
+	// write the remainder alongside the spool, then swap, so a crash
+	// mid-rewrite can't lose entries
+	tmp := sl.path + ".tmp"
+	if wErr := ioutil.WriteFile(tmp, append(bytes.Join(remaining, []byte{'\n'}), '\n'), 0600); wErr != nil {
+		return wErr
+	}
+
+	if rnErr := os.Rename(tmp, sl.path); rnErr != nil {
+		return rnErr
+	}
+
+	return
+}
+
+// read returns every entry in the spool, as raw JSON lines
+func (sl *SpoolLogger) read() (lines [][]byte, err error) {
+	f, err := os.Open(sl.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		lines = append(lines, append([]byte(nil), s.Bytes()...))
+	}
+
+	return lines, s.Err()
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+type testShipper struct {
+	down    bool
+	shipped []string
+}
+
+func (ts *testShipper) Ship(l LogEntry) error {
+	if ts.down {
+		return fmt.Errorf("sink unavailable")
+	}
+
+	ts.shipped = append(ts.shipped, l.RequestID)
+
+	return nil
+}
+
+func TestSpoolLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	sink := &testShipper{down: true}
+
+	sl, err := NewSpoolLogger(sink, path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	sl.RetryInterval = 0
+
+	sl.Log(LogEntry{RequestID: "a"})
+	sl.Log(LogEntry{RequestID: "b"})
+
+	if s := sl.Stats(); s.Entries != 2 || s.Bytes == 0 {
+		t.Fatalf("expected 2 spooled entries, received %+v", s)
+	}
+
+	// a restarted process picks up where the last left off
+	sl, err = NewSpoolLogger(sink, path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	sl.RetryInterval = 0
+	sink.down = false
+
+	sl.Log(LogEntry{RequestID: "c"})
+
+	if fmt.Sprint(sink.shipped) != "[a b c]" {
+		t.Errorf("expected entries shipped in order, received %v", sink.shipped)
+	}
+
+	if s := sl.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("expected an empty spool, received %+v", s)
+	}
+}
+
+func TestSpoolLogger_MaxBytes(t *testing.T) {
+	sl, err := NewSpoolLogger(&testShipper{down: true}, filepath.Join(t.TempDir(), "spool"), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	sl.Log(LogEntry{RequestID: "a"})
+
+	if s := sl.Stats(); s.Entries != 0 || s.Dropped != 1 {
+		t.Errorf("expected entry to be dropped, received %+v", s)
+	}
+
+	remaining, err := sl.Replay()
+	if remaining != 0 || err != nil {
+		t.Errorf("expected nothing to replay, received %d, %v", remaining, err)
+	}
+}
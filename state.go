@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// stateUserValue is the fasthttp user value holding request state.
+	// fasthttp only exposes string keyed user values through context.Context
+	stateUserValue = "github.com/beamly/go-http-middleware.state"
+)
+
+type stateKey struct{}
+
+// requestState holds everything the middleware knows about an in-flight
+// request which handlers may want to read, or add to, via its context
+type requestState struct {
+	id     string
+	route  string
+	tenant string
+	trace  map[string]string
+	flags  map[string]string
+
+	// deadline is when the caller stops waiting, if it said; see
+	// PropagateDeadlines
+	deadline time.Time
+
+	costLock sync.Mutex
+	costs    map[string]float64
+
+	// template is the route template reported via SetRoute
+	templateLock sync.Mutex
+	template     string
+
+	appLogger  *slog.Logger
+	logger     *slog.Logger
+	loggerOnce sync.Once
+}
+
+func withState(ctx context.Context, st *requestState) context.Context {
+	return context.WithValue(ctx, stateKey{}, st)
+}
+
+// stateFrom returns the request state held in ctx, which may be either a
+// net/http request's context or a *fasthttp.RequestCtx
+func stateFrom(ctx context.Context) *requestState {
+	if ctx == nil {
+		return nil
+	}
+
+	if st, ok := ctx.Value(stateKey{}).(*requestState); ok {
+		return st
+	}
+
+	if st, ok := ctx.Value(stateUserValue).(*requestState); ok {
+		return st
+	}
+
+	return nil
+}
+
+// RequestID returns the ID minted for the request ctx belongs to, or an
+// empty string outside of the middleware. ctx is either a net/http
+// request's context, or a *fasthttp.RequestCtx
+func RequestID(ctx context.Context) string {
+	if st := stateFrom(ctx); st != nil {
+		return st.id
+	}
+
+	return ""
+}
+
+// newState builds the state for a request about to be passed to the wrapped
+// handler. Requests which didn't match a route pattern use their path as
+// their route. header reads request headers
+func (m *Middleware) newState(id, route, path string, header func(string) string) *requestState {
+	if route == "" {
+		route = path
+	}
+
+	st := &requestState{
+		id:        id,
+		route:     route,
+		trace:     traceHeaders(header),
+		deadline:  m.deadline(header, time.Now()),
+		appLogger: m.appLogger(),
+	}
+
+	if m.TenantHeader != "" {
+		st.tenant = header(m.TenantHeader)
+	}
+
+	return st
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultStaticMaxAge is how long clients may cache assets served by
+	// Static without revalidating
+	DefaultStaticMaxAge = time.Hour
+)
+
+// Static serves assets from fsys, such as an embed.FS or os.DirFS, for
+// requests whose path begins with prefix, in place of the wrapped handler.
+// Asset requests are logged and counted like any other.
+//
+// Assets are served with a Cache-Control max-age of DefaultStaticMaxAge and
+// a content based ETag, and support conditional and range requests. Where
+// prefixes overlap, the longest wins. Static panics when prefix doesn't
+// begin with `/`
+func (m *Middleware) Static(prefix string, fsys fs.FS) {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Errorf("static prefix %q must begin with /", prefix))
+	}
+
+	m.statics = append(m.statics, &staticHandler{
+		prefix: prefix,
+		fsys:   fsys,
+		files:  http.FileServer(http.FS(fsys)),
+		maxAge: DefaultStaticMaxAge,
+	})
+}
+
+// static returns the handler for the longest static prefix matching p, if
+// any
+func (m *Middleware) static(p string) (s *staticHandler) {
+	for _, candidate := range m.statics {
+		if strings.HasPrefix(p, candidate.prefix) && (s == nil || len(candidate.prefix) > len(s.prefix)) {
+			s = candidate
+		}
+	}
+
+	return
+}
+
+// staticHandler serves assets under a prefix
+type staticHandler struct {
+	prefix string
+	fsys   fs.FS
+	files  http.Handler
+	maxAge time.Duration
+
+	// etags caches staticETags by asset name; assets in an embed.FS have
+	// no modification time to validate against, so a hash of their
+	// content is used instead
+	etags sync.Map
+}
+
+// staticETag is an asset's ETag, as of the modification time and size it
+// was hashed at, so that assets changed on disk are hashed afresh
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func (s *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, s.prefix))
+	if strings.HasSuffix(r.URL.Path, "/") && name != "/" {
+		name += "/"
+	}
+
+	// Directories without an index.html would otherwise be listed
+	if fi, err := fs.Stat(s.fsys, s.fsName(name)); err == nil && fi.IsDir() {
+		if _, err := fs.Stat(s.fsys, path.Join(s.fsName(name), "index.html")); err != nil {
+			http.NotFound(w, r)
+
+			return
+		}
+	}
+
+	if etag := s.etag(name); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.maxAge/time.Second)))
+
+	r2 := new(http.Request)
+	*r2 = *r
+
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = name
+	r2.URL.RawPath = ""
+
+	s.files.ServeHTTP(w, r2)
+}
+
+// serveFasthttp serves an asset to a fasthttp request
+func (s *staticHandler) serveFasthttp(ctx *fasthttp.RequestCtx) {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httpRequest(ctx))
+
+	// Set, rather than Add, for the first value, as fasthttp treats some
+	// headers, such as Content-Type, specially
+	for k, vs := range rec.Header() {
+		for i, v := range vs {
+			if i == 0 {
+				ctx.Response.Header.Set(k, v)
+			} else {
+				ctx.Response.Header.Add(k, v)
+			}
+		}
+	}
+
+	ctx.SetStatusCode(rec.Code)
+	ctx.SetBody(rec.Body.Bytes())
+}
+
+// fsName converts a request path to the form used by fs.FS
+func (s *staticHandler) fsName(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// etag returns a quoted hash of the named asset, or an empty string when it
+// can't be read, such as for directories and missing files
+func (s *staticHandler) etag(name string) string {
+	f, err := s.fsys.Open(s.fsName(name))
+	if err != nil {
+		return ""
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return ""
+	}
+
+	if cached, ok := s.etags.Load(name); ok {
+		if c := cached.(staticETag); c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+			return c.etag
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, staticETag{modTime: fi.ModTime(), size: fi.Size(), etag: etag})
+
+	return etag
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var testAssets = fstest.MapFS{
+	"app.js":          {Data: []byte("console.log('hello')")},
+	"css/site.css":    {Data: []byte("body { margin: 0 }")},
+	"docs/index.html": {Data: []byte("<h1>docs</h1>")},
+}
+
+func TestStatic(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.Static("/assets/", testAssets)
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		header http.Header
+		status int
+		body   string
+	}{
+		{"asset", "/assets/app.js", nil, http.StatusOK, "console.log('hello')"},
+		{"nested", "/assets/css/site.css", nil, http.StatusOK, "body { margin: 0 }"},
+		{"range", "/assets/app.js", http.Header{"Range": {"bytes=0-6"}}, http.StatusPartialContent, "console"},
+		{"index", "/assets/docs/", nil, http.StatusOK, "<h1>docs</h1>"},
+		{"no listing", "/assets/css/", nil, http.StatusNotFound, ""},
+		{"missing", "/assets/missing.js", nil, http.StatusNotFound, ""},
+		{"traversal", "/assets/../static_test.go", nil, http.StatusNotFound, ""},
+		{"handler", "/", nil, http.StatusOK, "hello, world!"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = test.path
+			for k, v := range test.header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %d, received %d", test.status, w.Code)
+			}
+
+			if test.body != "" && w.Body.String() != test.body {
+				t.Errorf("expected body %q, received %q", test.body, w.Body.String())
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"url":"/assets/app.js"`) {
+		t.Errorf("expected asset requests to be logged, received %q", logWriter.body)
+	}
+}
+
+func TestStatic_Caching(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.Static("/assets/", testAssets)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/assets/app.js", nil))
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag")
+	}
+
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("expected Cache-Control %q, received %q", "public, max-age=3600", cc)
+	}
+
+	r := httptest.NewRequest("GET", "/assets/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %d, received %d", http.StatusNotModified, w.Code)
+	}
+}
+
+func TestStatic_CachingChangedAssets(t *testing.T) {
+	assets := fstest.MapFS{"app.js": {Data: []byte("console.log('hello')"), ModTime: time.Unix(1, 0)}}
+
+	m := NewMiddleware(TestAPI{})
+	m.Static("/assets/", assets)
+
+	etag := func() string {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/assets/app.js", nil))
+
+		return w.Header().Get("ETag")
+	}
+
+	before := etag()
+
+	assets["app.js"] = &fstest.MapFile{Data: []byte("console.log('bye')"), ModTime: time.Unix(2, 0)}
+
+	if after := etag(); after == before {
+		t.Errorf("expected a changed asset to have a new ETag, both were %q", after)
+	}
+}
+
+func TestStatic_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHAPI{})
+	m.Static("/assets/", testAssets)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI("/assets/css/site.css")
+
+	m.ServeFastHTTP(c)
+
+	if c.Response.StatusCode() != http.StatusOK {
+		t.Errorf("expected status %d, received %d", http.StatusOK, c.Response.StatusCode())
+	}
+
+	if string(c.Response.Body()) != "body { margin: 0 }" {
+		t.Errorf("expected asset body, received %q", c.Response.Body())
+	}
+
+	if ct := string(c.Response.Header.ContentType()); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("expected a css content type, received %q", ct)
+	}
+}
+
+func TestStatic_BadPrefix(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).Static("assets", testAssets)
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsdConfig configures the metrics sent by Statsd
+type StatsdConfig struct {
+	// Address is the statsd server's, such as `localhost:8125`
+	Address string `json:"address" yaml:"address"`
+
+	// Prefix is prepended to every metric name, such as `payments.`
+	Prefix string `json:"prefix" yaml:"prefix"`
+
+	// SampleRate is the fraction, between 0 and 1, of requests to send
+	// metrics for. The rate is sent along with counts and timings, so that
+	// statsd scales them back up. A SampleRate of zero is treated as 1
+	SampleRate float64 `json:"sample_rate" yaml:"sample_rate"`
+}
+
+// Statsd sends metrics for each request over UDP to the statsd server
+// described by c, alongside the counters endpoint:
+//   - http.requests.<route>.<method>.<status class>, a count;
+//   - http.request_duration.<route>.<method>, a timing in milliseconds;
+//   - http.request_bytes.<route>.<method> and http.response_bytes.<route>.<method>,
+//     counts of the bytes of request bodies read and response bodies
+//     written, sent when non-zero;
+//   - http.apdex.<route>.<method>.<zone>, a count, for routes with an
+//     ApdexThreshold; and
+//   - http.requests_in_flight, a gauge of the requests being handled
+//
+// Routes are as per MetricsExporter, with slashes turned into dots and other
+// punctuation into underscores, so that `/users/:id` becomes `users._id`.
+// Admin and synthetic requests aren't sent.
+//
+// Statsd panics when c has no Address, or one which can't be resolved
+func (m *Middleware) Statsd(c StatsdConfig) {
+	s, err := newStatsdClient(c)
+	if err != nil {
+		panic(err)
+	}
+
+	m.statsd = s
+}
+
+// statsdClient sends request metrics to a statsd server
+type statsdClient struct {
+	conn   net.Conn
+	config StatsdConfig
+}
+
+// newStatsdClient returns a statsdClient sending to c's Address. As UDP is
+// connectionless, the server needn't be up yet
+func newStatsdClient(c StatsdConfig) (s *statsdClient, err error) {
+	if c.Address == "" {
+		return nil, fmt.Errorf("statsd requires an address")
+	}
+
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		c.SampleRate = 1
+	}
+
+	s = &statsdClient{config: c}
+	if s.conn, err = net.Dial("udp", c.Address); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// observe sends metrics for a handled request to route, as per routeKey,
+// which took d, with inFlight requests still being handled
+func (s *statsdClient) observe(route string, l LogEntry, d time.Duration, inFlight int64) {
+	if s == nil {
+		return
+	}
+
+	rate := s.config.SampleRate
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	suffix := ""
+	if rate < 1 {
+		suffix = "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+
+	name := statsdName(route) + "." + strings.ToLower(promMethod(l.Method))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%shttp.requests.%s.%s:1|c%s\n", s.config.Prefix, name, statusClass(l.Status), suffix)
+	fmt.Fprintf(&buf, "%shttp.request_duration.%s:%s|ms%s\n", s.config.Prefix, name, strconv.FormatFloat(durationMS(d), 'f', 3, 64), suffix)
+
+	if l.RequestBytes > 0 {
+		fmt.Fprintf(&buf, "%shttp.request_bytes.%s:%d|c%s\n", s.config.Prefix, name, l.RequestBytes, suffix)
+	}
+
+	if l.ResponseBytes > 0 {
+		fmt.Fprintf(&buf, "%shttp.response_bytes.%s:%d|c%s\n", s.config.Prefix, name, l.ResponseBytes, suffix)
+	}
+
+	if l.Apdex != "" {
+		fmt.Fprintf(&buf, "%shttp.apdex.%s.%s:1|c%s\n", s.config.Prefix, name, l.Apdex, suffix)
+	}
+
+	fmt.Fprintf(&buf, "%shttp.requests_in_flight:%d|g", s.config.Prefix, inFlight)
+
+	// Metrics are best effort; a missing server mustn't affect requests
+	s.conn.Write(buf.Bytes())
+}
+
+// statsdName makes a route safe to use in a statsd metric name, in which
+// dots separate segments and colons, pipes and at signs are reserved
+func statsdName(route string) string {
+	route = strings.Trim(route, "/")
+	if route == "" {
+		return "root"
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/':
+			return '.'
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+
+		return '_'
+	}, route)
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsd(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer pc.Close()
+
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	m.SetRouteResolver(RouteTemplates("/users/:id"))
+	m.Statsd(StatsdConfig{Address: pc.LocalAddr().String(), Prefix: "test."})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/__/counters", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users/1", nil))
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	lines := strings.Split(string(buf[:n]), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 metrics, received %q", buf[:n])
+	}
+
+	for i, prefix := range []string{
+		"test.http.requests.users._id.post.4xx:1|c",
+		"test.http.request_duration.users._id.post:",
+		"test.http.requests_in_flight:0|g",
+	} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected %q, received %q", prefix, lines[i])
+		}
+	}
+
+	if !strings.HasSuffix(lines[1], "|ms") {
+		t.Errorf("expected a timing, received %q", lines[1])
+	}
+}
+
+func TestStatsd_SampleRate(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer pc.Close()
+
+	s, err := newStatsdClient(StatsdConfig{Address: pc.LocalAddr().String(), SampleRate: 0.999999})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	s.observe("/", LogEntry{Method: "GET", Status: 200}, time.Millisecond, 1)
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if !strings.HasPrefix(string(buf[:n]), "http.requests.root.get.2xx:1|c|@0.999999\n") || !strings.HasSuffix(string(buf[:n]), "in_flight:1|g") {
+		t.Errorf("expected counts with their sample rate, and gauges without, received %q", buf[:n])
+	}
+
+	if _, err = newStatsdClient(StatsdConfig{}); err == nil {
+		t.Errorf("expected an error without an address")
+	}
+}
+
+func TestStatsdName(t *testing.T) {
+	for route, expect := range map[string]string{
+		"/users/:id":      "users._id",
+		"/files/{path}/*": "files._path_._",
+		"/":               "root",
+		"default":         "default",
+		"/a.b|c@d":        "a_b_c_d",
+	} {
+		if received := statsdName(route); received != expect {
+			t.Errorf("%s: expected %q, received %q", route, expect, received)
+		}
+	}
+}
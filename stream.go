@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// StatusTrailer is the trailer the final status of a streamed response
+	// is sent in, alongside RequestIDHeader. It differs from the status
+	// line when the handler fails after the response has begun
+	StatusTrailer = "X-Final-Status"
+)
+
+// streamWriter passes a handler's response straight to the client, for
+// routes whose policy streams, rather than buffering it. The response is
+// begun, and its status fixed, by the handler's first write or flush,
+// after which the request ID and final status can only be sent as
+// trailers.
+//
+// Until then, headers and status are held, so that a handler which writes
+// nothing, or fails before writing, is responded to as though buffered
+type streamWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	code   int
+
+	// begin is called with the response's headers and status as the
+	// response begins, and may change either
+	begin func(http.Header, int) int
+
+	started  bool
+	hijacked bool
+	bytes    int
+	err      error
+}
+
+func newStreamWriter(w http.ResponseWriter, begin func(http.Header, int) int) *streamWriter {
+	return &streamWriter{
+		w:      w,
+		header: make(http.Header),
+		code:   http.StatusOK,
+		begin:  begin,
+	}
+}
+
+// Header implements http.ResponseWriter
+func (sw *streamWriter) Header() http.Header {
+	if sw.started {
+		return sw.w.Header()
+	}
+
+	return sw.header
+}
+
+// WriteHeader implements http.ResponseWriter. Informational statuses are
+// passed on without beginning the response
+func (sw *streamWriter) WriteHeader(code int) {
+	if sw.started {
+		return
+	}
+
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		for k, v := range sw.header {
+			sw.w.Header()[k] = v
+		}
+
+		sw.w.WriteHeader(code)
+
+		return
+	}
+
+	sw.code = code
+	sw.start()
+}
+
+// Write implements http.ResponseWriter
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	sw.start()
+
+	n, err := sw.w.Write(b)
+	sw.bytes += n
+
+	if err != nil && sw.err == nil {
+		sw.err = err
+	}
+
+	return n, err
+}
+
+// Flush implements http.Flusher, beginning the response if need be
+func (sw *streamWriter) Flush() {
+	sw.start()
+
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, where the underlying ResponseWriter
+// does. Hijacked connections are logged with the status they were
+// switched with, or 101, and get no trailers
+func (sw *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking isn't supported by %T", sw.w)
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		if !sw.started {
+			sw.code = http.StatusSwitchingProtocols
+		}
+
+		sw.started = true
+		sw.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+// start begins the response, declaring its trailers, unless it's begun
+func (sw *streamWriter) start() {
+	if sw.started {
+		return
+	}
+
+	sw.started = true
+	sw.code = sw.begin(sw.header, sw.code)
+
+	h := sw.w.Header()
+	for k, v := range sw.header {
+		h[k] = v
+	}
+
+	h.Set("Trailer", RequestIDHeader+", "+StatusTrailer)
+
+	sw.w.WriteHeader(sw.code)
+}
+
+// finish sends the request ID and final status of a begun response as
+// trailers
+func (sw *streamWriter) finish(requestID string, status int) {
+	if sw.hijacked {
+		return
+	}
+
+	h := sw.w.Header()
+	h.Set(RequestIDHeader, requestID)
+	h.Set(StatusTrailer, strconv.Itoa(status))
+}
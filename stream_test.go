@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		final   int
+		body    string
+	}{
+		{"complete stream", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "data: 2\n\n")
+		}, http.StatusOK, http.StatusOK, "data: 1\n\ndata: 2\n\n"},
+
+		{"failed stream", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+
+			panic("stream broke")
+		}, http.StatusAccepted, http.StatusInternalServerError, "data: 1\n\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMiddleware(test.handler)
+			m.AddRoutePolicy("/events", RoutePolicy{Stream: true})
+
+			logWriter := &TestWriter{}
+			m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+			s := httptest.NewServer(m)
+			defer s.Close()
+
+			resp, err := http.Get(s.URL + "/events")
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status || string(body) != test.body {
+				t.Errorf("expected %d %q, received %d %q", test.status, test.body, resp.StatusCode, body)
+			}
+
+			requestID := resp.Header.Get(RequestIDHeader)
+			if requestID == "" || resp.Trailer.Get(RequestIDHeader) != requestID {
+				t.Errorf("expected the request ID in headers and trailers, received %v %v", resp.Header, resp.Trailer)
+			}
+
+			if final := resp.Trailer.Get(StatusTrailer); final != strconv.Itoa(test.final) {
+				t.Errorf("expected final status %d, received %q", test.final, final)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if err := m.queue.drain(ctx); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			var l LogEntry
+			if err := json.Unmarshal(logWriter.body, &l); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if l.Status != test.final || l.RequestID != requestID || l.ResponseBytes != len(test.body) {
+				t.Errorf("expected a terminal entry for the stream, received %+v", l)
+			}
+		})
+	}
+}
+
+func TestStream_NotBegun(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Before", "failing")
+
+		panic("failed before streaming")
+	}))
+	m.SetLoggers()
+	m.AddRoutePolicy("/events", RoutePolicy{Stream: true})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Trailer") != "" || rec.Header().Get(RequestIDHeader) == "" {
+		t.Errorf("expected a buffered 500, received %d %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSummaryInterval is how often a SummaryLogger writes a summary
+	DefaultSummaryInterval = time.Minute
+
+	// summaryReservoir is the number of durations kept per interval, from
+	// which percentiles are estimated
+	summaryReservoir = 4096
+)
+
+// Summary rolls up the entries logged over an interval
+type Summary struct {
+	Type      string           `json:"type"`
+	Time      time.Time        `json:"time"`
+	Interval  string           `json:"interval"`
+	Requests  int64            `json:"requests"`
+	Errors    int64            `json:"errors"`
+	ErrorRate float64          `json:"error_rate"`
+	Statuses  map[string]int64 `json:"statuses"`
+	P50MS     float64          `json:"p50_ms"`
+	P90MS     float64          `json:"p90_ms"`
+	P99MS     float64          `json:"p99_ms"`
+}
+
+// SummaryLogger implements middleware.Loggable, writing one JSON line per
+// interval in place of a line per request, such as:
+//
+//	{"type":"summary","time":"2017-05-27T14:58:00Z","interval":"1m0s","requests":1204,"errors":3,"error_rate":0.0025,"statuses":{"2xx":1180,"4xx":21,"5xx":3},"p50_ms":12.4,"p90_ms":48.1,"p99_ms":212.9}
+//
+// This gives environments which can't ship per-request logs useful telemetry
+// from stdout alone. Errors are counted as per the error stream: 5xx
+// responses and failed requests. Sampled entries count 1/SampleRate times,
+// and percentiles are estimated from a sample of each interval's durations.
+// Synthetic entries, as per MarkSynthetic, are left out.
+type SummaryLogger struct {
+	output   io.Writer
+	interval time.Duration
+	stop     chan struct{}
+	once     sync.Once
+
+	lock      sync.Mutex
+	start     time.Time
+	requests  float64
+	errors    float64
+	statuses  map[string]float64
+	durations []float64
+	seen      int
+}
+
+// NewSummaryLogger returns a SummaryLogger writing to w every interval, or
+// every DefaultSummaryInterval when interval is zero. Call Close to stop it,
+// which writes a final summary
+func NewSummaryLogger(w io.Writer, interval time.Duration) *SummaryLogger {
+	if interval <= 0 {
+		interval = DefaultSummaryInterval
+	}
+
+	sl := &SummaryLogger{
+		output:   w,
+		interval: interval,
+		stop:     make(chan struct{}),
+		start:    time.Now(),
+		statuses: make(map[string]float64),
+	}
+
+	go sl.run()
+
+	return sl
+}
+
+// Log implements middleware.Loggable
+func (sl *SummaryLogger) Log(l LogEntry) {
+	if l.Synthetic {
+		return
+	}
+
+	weight := 1.0
+	if l.SampleRate > 0 && l.SampleRate < 1 {
+		weight = 1 / l.SampleRate
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	sl.requests += weight
+	if isErrorEntry(l) {
+		sl.errors += weight
+	}
+
+	sl.statuses[statusClass(l.Status)] += weight
+
+	// Reservoir sampling keeps memory flat however busy the interval
+	ms := float64(entryDuration(l)) / float64(time.Millisecond)
+
+	sl.seen++
+	if len(sl.durations) < summaryReservoir {
+		sl.durations = append(sl.durations, ms)
+	} else if i := rand.Intn(sl.seen); i < summaryReservoir {
+		sl.durations[i] = ms
+	}
+}
+
+// Flush writes a summary of the entries logged since the last one, and
+// starts a new interval
+func (sl *SummaryLogger) Flush() {
+	s := sl.summarise(time.Now())
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"type":"summary","error":%q}`, err.Error()))
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	sl.output.Write(append(b, '\n'))
+}
+
+// Close stops periodic summaries, and writes a final one
+func (sl *SummaryLogger) Close() error {
+	sl.once.Do(func() {
+		close(sl.stop)
+		sl.Flush()
+	})
+
+	return nil
+}
+
+func (sl *SummaryLogger) run() {
+	t := time.NewTicker(sl.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-sl.stop:
+			return
+		case <-t.C:
+			sl.Flush()
+		}
+	}
+}
+
+// summarise returns the summary of the interval ending at now, and resets
+// for the next
+func (sl *SummaryLogger) summarise(now time.Time) Summary {
+	sl.lock.Lock()
+
+	s := Summary{
+		Type:     "summary",
+		Time:     now,
+		Interval: now.Sub(sl.start).Round(time.Millisecond).String(),
+		Requests: int64(math.Round(sl.requests)),
+		Errors:   int64(math.Round(sl.errors)),
+		Statuses: make(map[string]int64, len(sl.statuses)),
+	}
+
+	if sl.requests > 0 {
+		s.ErrorRate = sl.errors / sl.requests
+	}
+
+	for class, n := range sl.statuses {
+		s.Statuses[class] = int64(math.Round(n))
+	}
+
+	durations := sl.durations
+
+	sl.start = now
+	sl.requests = 0
+	sl.errors = 0
+	sl.statuses = make(map[string]float64)
+	sl.durations = nil
+	sl.seen = 0
+
+	sl.lock.Unlock()
+
+	sort.Float64s(durations)
+
+	s.P50MS = percentile(durations, 0.5)
+	s.P90MS = percentile(durations, 0.9)
+	s.P99MS = percentile(durations, 0.99)
+
+	return s
+}
+
+// percentile returns the nearest rank p percentile of sorted, or 0 when
+// sorted is empty
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i]
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) lines() [][]byte {
+	sb.Lock()
+	defer sb.Unlock()
+
+	return bytes.Split(bytes.TrimSpace(sb.buf.Bytes()), []byte("\n"))
+}
+
+func TestSummaryLogger(t *testing.T) {
+	w := &syncBuffer{}
+	sl := NewSummaryLogger(w, time.Hour)
+
+	for i := 1; i <= 100; i++ {
+		sl.Log(LogEntry{Status: 200, Duration: (time.Duration(i) * time.Millisecond).String()})
+	}
+
+	sl.Log(LogEntry{Status: 404, Duration: "1ms"})
+	sl.Log(LogEntry{Status: 503, Duration: "1ms"})
+	sl.Log(LogEntry{Status: 200, Duration: "1ms", SampleRate: 0.5})
+
+	sl.Close()
+
+	lines := w.lines()
+	if len(lines) != 1 {
+		t.Fatalf("expected a single summary, received %q", lines)
+	}
+
+	var s Summary
+	if err := json.Unmarshal(lines[0], &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Type != "summary" || s.Requests != 104 || s.Errors != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+
+	if s.Statuses["2xx"] != 102 || s.Statuses["4xx"] != 1 || s.Statuses["5xx"] != 1 {
+		t.Errorf("unexpected statuses %v", s.Statuses)
+	}
+
+	if s.P50MS != 49 || s.P99MS != 99 {
+		t.Errorf("unexpected percentiles %+v", s)
+	}
+}
+
+func TestSummaryLogger_Interval(t *testing.T) {
+	w := &syncBuffer{}
+	sl := NewSummaryLogger(w, 20*time.Millisecond)
+	defer sl.Close()
+
+	sl.Log(LogEntry{Status: 200, Duration: "1ms"})
+
+	time.Sleep(50 * time.Millisecond)
+
+	var s Summary
+	if err := json.Unmarshal(w.lines()[0], &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Requests != 1 {
+		t.Errorf("expected a periodic summary of one request, received %+v", s)
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"strings"
+)
+
+// SyntheticMarker identifies synthetic traffic, such as uptime checks and
+// canaries, by a request header or a member of the W3C `baggage` header.
+// When Values is set, the marker's value must be one of them; otherwise
+// any value will do
+type SyntheticMarker struct {
+	Header     string   `json:"header" yaml:"header"`
+	BaggageKey string   `json:"baggage_key" yaml:"baggage_key"`
+	Values     []string `json:"values" yaml:"values"`
+}
+
+// MarkSynthetic treats requests carrying sm's marker as synthetic, so that
+// uptime checks don't skew the numbers SLOs are built on. Synthetic
+// requests are:
+//   - logged at LevelDebug, unless they would be warnings or errors, and
+//     flagged `synthetic`;
+//   - always logged, rather than taking part in sampling;
+//   - left out of request, response byte, budget, and failure counters,
+//     and counted under `synthetic` instead; and
+//   - ignored by SummaryLogger
+//
+// MarkSynthetic panics when sm sets neither Header nor BaggageKey
+func (m *Middleware) MarkSynthetic(sm SyntheticMarker) {
+	if sm.Header == "" && sm.BaggageKey == "" {
+		panic("middleware: SyntheticMarker requires a Header or BaggageKey")
+	}
+
+	m.syntheticMarker = &sm
+}
+
+// synthetic returns whether a request is synthetic, as per MarkSynthetic.
+// header reads request headers
+func (m *Middleware) synthetic(header func(string) string) bool {
+	sm := m.syntheticMarker
+	if sm == nil {
+		return false
+	}
+
+	if sm.Header != "" {
+		if v := header(sm.Header); v != "" && sm.matches(v) {
+			return true
+		}
+	}
+
+	if sm.BaggageKey != "" {
+		if v, ok := baggageValue(header("Baggage"), sm.BaggageKey); ok && sm.matches(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sm *SyntheticMarker) matches(v string) bool {
+	if len(sm.Values) == 0 {
+		return true
+	}
+
+	for _, want := range sm.Values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// baggageValue returns the value of key in a W3C baggage header, of the
+// form `key1=value1;property,key2=value2`
+func baggageValue(baggage, key string) (string, bool) {
+	for _, member := range strings.Split(baggage, ",") {
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+
+		parts := strings.SplitN(member, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			return strings.TrimSpace(parts[1]), true
+		}
+	}
+
+	return "", false
+}
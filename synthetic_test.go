@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSynthetic(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.MarkSynthetic(SyntheticMarker{Header: "X-Synthetic", BaggageKey: "synthetic", Values: []string{"true"}})
+
+	for _, test := range []struct {
+		name   string
+		header map[string]string
+		expect bool
+	}{
+		{"unmarked", nil, false},
+		{"header", map[string]string{"X-Synthetic": "TRUE"}, true},
+		{"header with other value", map[string]string{"X-Synthetic": "false"}, false},
+		{"baggage", map[string]string{"Baggage": "user=1, synthetic=true;ttl=5"}, true},
+		{"baggage with other value", map[string]string{"Baggage": "synthetic=no"}, false},
+		{"other baggage", map[string]string{"Baggage": "not_synthetic=true"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if received := m.synthetic(func(k string) string { return test.header[k] }); received != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestMarkSynthetic_invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).MarkSynthetic(SyntheticMarker{Values: []string{"true"}})
+}
+
+func TestServeHTTP_Synthetic(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	m.MarkSynthetic(SyntheticMarker{Header: "X-Synthetic"})
+	m.AddRoutePolicy("/*", RoutePolicy{SampleRate: 0.000001, Budget: time.Nanosecond})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/uptime", nil)
+	r.Header.Set("X-Synthetic", "pingdom")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var l LogEntry
+	if err := json.Unmarshal(logWriter.body, &l); err != nil {
+		t.Fatalf("expected the synthetic request to be logged despite sampling: %+v", err)
+	}
+
+	if !l.Synthetic || l.Level != LevelWarn || l.SampleRate != 0 {
+		t.Errorf("expected an unsampled synthetic entry, received %+v", l)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	var c countersPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if c.Synthetic != 1 || c.Requests["/uptime"] != 0 || c.Failures != nil || c.BudgetExceeded != nil {
+		t.Errorf("expected the synthetic request to be counted apart, received %s", rec.Body.String())
+	}
+}
+
+func TestServeFastHTTP_Synthetic(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.MarkSynthetic(SyntheticMarker{BaggageKey: "synthetic"})
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/uptime")
+	ctx.Request.Header.Set("Baggage", "synthetic=1")
+	m.ServeFastHTTP(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+
+	body := string(logWriter.body)
+	if !strings.Contains(body, `"synthetic":true`) || !strings.Contains(body, `"level":"debug"`) {
+		t.Errorf("expected a debug level synthetic entry, received %q", body)
+	}
+}
+
+func TestSummaryLogger_Synthetic(t *testing.T) {
+	var buf bytes.Buffer
+
+	sl := NewSummaryLogger(&buf, time.Hour)
+	defer sl.Close()
+
+	sl.Log(LogEntry{Status: 500, Synthetic: true})
+	sl.Log(LogEntry{Status: 200})
+	sl.Flush()
+
+	var s Summary
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if s.Requests != 1 || s.Errors != 0 {
+		t.Errorf("expected synthetic entries to be ignored, received %+v", s)
+	}
+}
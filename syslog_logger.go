@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Facility is a syslog facility, as per RFC 5424 section 6.2.1
+type Facility int
+
+// Syslog facilities. Applications usually log to FacilityUser, which is
+// the default, or one of the local facilities
+const (
+	FacilityKern Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+
+	FacilityLocal0 Facility = iota + 4
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// Severity is a syslog severity, as per RFC 5424 section 6.2.1
+type Severity int
+
+// Syslog severities
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// localSyslogSockets are tried, in order, when no address is configured
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogConfig configures where, and how, a SyslogLogger ships entries
+type SyslogConfig struct {
+	// Network is one of `udp`, `tcp`, `unix`, or `unixgram`. When both Network
+	// and Address are empty the local syslog daemon's socket is used
+	Network string
+	Address string
+
+	// Facility defaults to FacilityUser. FacilityKern, being zero, can't
+	// be chosen; it's reserved for the kernel, and daemons rewrite it to
+	// FacilityUser for processes anyway
+	Facility Facility
+
+	// EnterpriseID, when set, is the IANA private enterprise number of the
+	// organisation running the service, such as `32473`, and adds the
+	// request ID and status as structured data, under the SD-ID
+	// `request@EnterpriseID`. Structured data IDs of one's own need one, so
+	// without it messages have no structured data
+	EnterpriseID string
+
+	// AppName and Hostname default to the running binary's name and the
+	// machine's hostname respectively
+	AppName  string
+	Hostname string
+}
+
+// SyslogLogger implements middleware.Loggable, shipping entries to a local
+// or remote syslog daemon as RFC 5424 messages. Severity is derived from
+// the response status: 5xx responses (and requests which failed without
+// a response) are errors, 4xx responses are warnings, and everything
+// else is informational.
+//
+// The message body is the JSON form of the entry, with the request ID and
+// status also available as structured data, given an EnterpriseID.
+type SyslogLogger struct {
+	lock sync.Mutex
+	conn net.Conn
+
+	config SyslogConfig
+	procID string
+}
+
+// NewSyslogLogger connects to the syslog daemon described by c
+func NewSyslogLogger(c SyslogConfig) (sl *SyslogLogger, err error) {
+	if c.AppName == "" {
+		c.AppName = filepath.Base(os.Args[0])
+	}
+
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	if c.Facility == FacilityKern {
+		c.Facility = FacilityUser
+	}
+
+	sl = &SyslogLogger{
+		config: c,
+		procID: strconv.Itoa(os.Getpid()),
+	}
+
+	sl.conn, err = sl.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (sl *SyslogLogger) dial() (net.Conn, error) {
+	if sl.config.Network != "" || sl.config.Address != "" {
+		return net.Dial(sl.config.Network, sl.config.Address)
+	}
+
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range localSyslogSockets {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unable to connect to a local syslog daemon")
+}
+
+// Log implements middleware.Loggable
+func (sl *SyslogLogger) Log(l LogEntry) {
+	sl.Ship(l)
+}
+
+// Ship implements middleware.Shipper. Should a write fail, the logger
+// reconnects and retries once before giving up on the entry
+func (sl *SyslogLogger) Ship(l LogEntry) error {
+	msg, err := sl.format(l, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if sl.stream() {
+		// RFC 6587 octet counting, so messages can contain newlines
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.conn != nil {
+		if _, err = sl.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+
+		sl.conn.Close()
+		sl.conn = nil
+	}
+
+	if sl.conn, err = sl.dial(); err != nil {
+		return err
+	}
+
+	_, err = sl.conn.Write([]byte(msg))
+
+	return err
+}
+
+// Close closes the connection to the syslog daemon
+func (sl *SyslogLogger) Close() error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.conn == nil {
+		return nil
+	}
+
+	return sl.conn.Close()
+}
+
+func (sl *SyslogLogger) stream() bool {
+	return sl.config.Network == "tcp" || sl.config.Network == "unix"
+}
+
+func (sl *SyslogLogger) format(l LogEntry, now time.Time) (string, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+
+	pri := int(sl.config.Facility)*8 + int(StatusSeverity(l.Status))
+
+	sd := "-"
+	if sl.config.EnterpriseID != "" {
+		sd = fmt.Sprintf(`[request@%s request_id="%s" status="%d"]`, sl.config.EnterpriseID, sdEscape(l.RequestID), l.Status)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s access %s %s",
+		pri,
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(sl.config.Hostname),
+		syslogHeaderField(sl.config.AppName),
+		sl.procID,
+		sd,
+		body,
+	), nil
+}
+
+// StatusSeverity maps an HTTP status to a syslog severity
+func StatusSeverity(status int) Severity {
+	switch {
+	case status >= 500, status == 0:
+		return SeverityError
+	case status >= 400:
+		return SeverityWarning
+	default:
+		return SeverityInfo
+	}
+}
+
+// syslogHeaderField replaces empty header fields with the NILVALUE, and
+// strips spaces, which delimit header fields
+func syslogHeaderField(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return strings.Replace(s, " ", "_", -1)
+}
+
+// sdEscape escapes structured data parameter values, as per RFC 5424 section 6.3.3
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusSeverity(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		expect Severity
+	}{
+		{200, SeverityInfo},
+		{302, SeverityInfo},
+		{404, SeverityWarning},
+		{503, SeverityError},
+		{0, SeverityError},
+	} {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			if s := StatusSeverity(test.status); s != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, s)
+			}
+		})
+	}
+}
+
+func TestSyslogLogger_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer pc.Close()
+
+	sl, err := NewSyslogLogger(SyslogConfig{
+		Network:  "udp",
+		Address:  pc.LocalAddr().String(),
+		Facility: FacilityLocal0,
+		AppName:  "my app",
+		Hostname: "web-1",
+
+		EnterpriseID: "32473",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer sl.Close()
+
+	sl.Log(LogEntry{RequestID: `a"b`, Status: 404})
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	msg := string(buf[:n])
+
+	// local0 (16) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("unexpected priority in %q", msg)
+	}
+
+	for _, expect := range []string{
+		" web-1 my_app ",
+		` access [request@32473 request_id="a\"b" status="404"] {`,
+		`"status":404`,
+	} {
+		if !strings.Contains(msg, expect) {
+			t.Errorf("expected %q in %q", expect, msg)
+		}
+	}
+}
+
+func TestSyslogLogger_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+
+		size, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(size))
+
+		msg := make([]byte, n)
+		r.Read(msg)
+
+		received <- string(msg)
+	}()
+
+	sl, err := NewSyslogLogger(SyslogConfig{Network: "tcp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer sl.Close()
+
+	sl.Log(LogEntry{Status: 200})
+
+	select {
+	case msg := <-received:
+		// user (1), by default, * 8 + info (6)
+		if !strings.HasPrefix(msg, "<14>1 ") || !strings.HasSuffix(msg, "}") {
+			t.Errorf("unexpected message %q", msg)
+		}
+
+		if !strings.Contains(msg, " access - {") {
+			t.Errorf("expected no structured data without an enterprise ID, received %q", msg)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultTailBuffer is how many entries a tail holds for its reader
+	// before dropping new ones
+	DefaultTailBuffer = 64
+
+	// tailKeepAlive is how often an idle tail endpoint sends a comment, so
+	// that proxies keep the stream open and disconnected clients are noticed
+	tailKeepAlive = 15 * time.Second
+)
+
+// Tail returns a channel receiving live entries matching every one of
+// predicates, as they happen, until stop is called. Entries are redacted
+// and anonymized as they are for loggers, but are tailed before sampling,
+// so that a request can be found by its ID however its route is sampled.
+//
+// Tailing never slows requests down: entries arriving while DefaultTailBuffer
+// entries are waiting to be read are dropped
+func (m *Middleware) Tail(predicates ...Predicate) (entries <-chan LogEntry, stop func()) {
+	t := &tail{
+		predicates: predicates,
+		entries:    make(chan LogEntry, DefaultTailBuffer),
+	}
+
+	m.tails.add(t)
+
+	var once sync.Once
+
+	return t.entries, func() {
+		once.Do(func() { m.tails.remove(t) })
+	}
+}
+
+// EnableTail registers the `tail` admin endpoint, which streams entries as
+// per Tail to GET requests as server-sent events, each event's data being
+// an entry as JSON. Entries may be filtered with query parameters:
+//   - path, a pattern as per MatchingPaths, such as `/payments/*`;
+//   - request_id, as per MatchingRequestID; and
+//   - min_status, as per StatusAtLeast
+//
+// For instance, `curl -N -H 'X-Admin-Token: ...' 'localhost:8080/__/tail?min_status=500'`.
+// Requests to the endpoint aren't themselves logged, lest tails see
+// themselves.
+//
+// Entries hold other clients' requests, headers and all, so EnableTail
+// panics when AdminToken hasn't been set first
+func (m *Middleware) EnableTail() {
+	if m.AdminToken == "" {
+		panic("middleware: EnableTail requires an AdminToken")
+	}
+
+	m.addAdminEndpoint("tail", m.serveTailMethod)
+}
+
+// tail is a reader of live entries
+type tail struct {
+	predicates []Predicate
+	entries    chan LogEntry
+}
+
+// tails holds the Middleware's tails. Its zero value is ready to use
+type tails struct {
+	sync.RWMutex
+
+	readers map[*tail]bool
+}
+
+func (ts *tails) add(t *tail) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.readers == nil {
+		ts.readers = make(map[*tail]bool)
+	}
+
+	ts.readers[t] = true
+}
+
+func (ts *tails) remove(t *tail) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	delete(ts.readers, t)
+}
+
+// active returns whether anything is tailing, so that entries needn't be
+// prepared for tails otherwise
+func (ts *tails) active() bool {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	return len(ts.readers) > 0
+}
+
+// publish sends l to every tail it matches, without waiting on any
+func (ts *tails) publish(l LogEntry) {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	for t := range ts.readers {
+		if !t.matches(l) {
+			continue
+		}
+
+		select {
+		case t.entries <- l:
+		default:
+		}
+	}
+}
+
+func (t *tail) matches(l LogEntry) bool {
+	for _, p := range t.predicates {
+		if !p(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tailPredicates parses the query parameters of a request to the tail
+// endpoint
+func tailPredicates(q url.Values) (predicates []Predicate, err error) {
+	if p := q.Get("path"); p != "" {
+		// MatchingPaths panics on bad patterns, so check it first
+		var rm routeMatcher
+		if err = rm.add(p, nil); err != nil {
+			return
+		}
+
+		predicates = append(predicates, MatchingPaths(p))
+	}
+
+	if id := q.Get("request_id"); id != "" {
+		predicates = append(predicates, MatchingRequestID(id))
+	}
+
+	if s := q.Get("min_status"); s != "" {
+		var status int
+		if status, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid min_status %q", s)
+		}
+
+		predicates = append(predicates, StatusAtLeast(status))
+	}
+
+	return
+}
+
+// serveTailMethod is the tail endpoint as far as serveAdmin is concerned.
+// GET requests are streamed by serveTail and serveFastTail instead, so
+// this only sees other methods, and GETs made after AdminToken is unset
+func (m *Middleware) serveTailMethod(r adminRequest) adminResponse {
+	if r.method == http.MethodGet {
+		return jsonResponse(http.StatusUnauthorized, []byte(`{"error":"unauthorised"}`))
+	}
+
+	return adminError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.method))
+}
+
+// streamsTail returns whether a request to an admin endpoint is one to
+// stream via serveTail or serveFastTail
+func (m *Middleware) streamsTail(endpoint, method string, header func(string) string) bool {
+	return endpoint == "tail" && method == http.MethodGet && m.AdminToken != "" && m.adminAuthorised(header)
+}
+
+// serveTail streams entries to a net/http client, until it disconnects
+func (m *Middleware) serveTail(w http.ResponseWriter, r *http.Request) {
+	predicates, err := tailPredicates(r.URL.Query())
+	if err != nil {
+		ar := adminError(http.StatusBadRequest, err)
+
+		w.Header().Set("Content-Type", ar.contentType)
+		w.WriteHeader(ar.status)
+		w.Write(ar.body)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flush := func() error { return nil }
+	if f, ok := w.(http.Flusher); ok {
+		flush = func() error {
+			f.Flush()
+
+			return nil
+		}
+	}
+
+	m.streamTail(w, flush, r.Context().Done(), predicates)
+}
+
+// serveFastTail streams entries to a fasthttp client, until it disconnects
+func (m *Middleware) serveFastTail(ctx *fasthttp.RequestCtx) {
+	query, _ := url.ParseQuery(string(ctx.QueryArgs().QueryString()))
+
+	predicates, err := tailPredicates(query)
+	if err != nil {
+		ar := adminError(http.StatusBadRequest, err)
+
+		ctx.SetStatusCode(ar.status)
+		ctx.SetContentType(ar.contentType)
+		ctx.SetBody(ar.body)
+
+		return
+	}
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		m.streamTail(w, w.Flush, nil, predicates)
+	})
+}
+
+// streamTail writes entries matching predicates to w as server-sent
+// events, until writing fails or done is closed
+func (m *Middleware) streamTail(w io.Writer, flush func() error, done <-chan struct{}, predicates []Predicate) {
+	entries, stop := m.Tail(predicates...)
+	defer stop()
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+
+	// An opening comment lets clients know the stream is up
+	event := []byte(": tailing\n\n")
+
+	for {
+		if event != nil {
+			if _, err := w.Write(event); err != nil {
+				return
+			}
+
+			if err := flush(); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-done:
+			return
+
+		case <-keepAlive.C:
+			event = []byte(": keepalive\n\n")
+
+		case l := <-entries:
+			event = nil
+
+			b, err := json.Marshal(l)
+			if err == nil {
+				event = append(append([]byte("data: "), b...), '\n', '\n')
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestTail(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.AddRoutePolicy("/api/*", RoutePolicy{SampleRate: 0.000001})
+	m.LogRequestHeaders("Authorization")
+
+	entries, stop := m.Tail(MatchingPaths("/api/*"))
+
+	for _, p := range []string{"/other", "/api/users"} {
+		r := httptest.NewRequest("GET", p, nil)
+		r.Header.Set("Authorization", "Bearer sekrit")
+
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	select {
+	case l := <-entries:
+		if l.URL != "/api/users" || l.RequestHeaders["Authorization"] != RedactedValue {
+			t.Errorf("expected a redacted entry for /api/users, received %+v", l)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("expected an entry, however its route is sampled")
+	}
+
+	stop()
+	stop()
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users", nil))
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case l := <-entries:
+		t.Errorf("expected nothing once stopped, received %+v", l)
+	default:
+	}
+}
+
+func TestEnableTail(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	m.SetLoggers()
+	m.AdminToken = "sekrit"
+	m.EnableTail()
+
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	for _, test := range []struct {
+		method string
+		query  string
+		token  string
+		expect int
+	}{
+		{"GET", "", "", http.StatusUnauthorized},
+		{"POST", "", "sekrit", http.StatusMethodNotAllowed},
+		{"GET", "?min_status=lots", "sekrit", http.StatusBadRequest},
+		{"GET", "?path=/[", "sekrit", http.StatusBadRequest},
+	} {
+		r, _ := http.NewRequest(test.method, s.URL+"/__/tail"+test.query, nil)
+		r.Header.Set("X-Admin-Token", test.token)
+
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.expect {
+			t.Errorf("%s %s: expected %d, received %d", test.method, test.query, test.expect, resp.StatusCode)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", s.URL+"/__/tail?min_status=500", nil)
+	r.Header.Set("X-Admin-Token", "sekrit")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, received %v", resp.Header)
+	}
+
+	lines := bufio.NewReader(resp.Body)
+	if line, _ := lines.ReadString('\n'); line != ": tailing\n" {
+		t.Fatalf("expected the stream to open, received %q", line)
+	}
+
+	http.Get(s.URL + "/ok")
+	http.Get(s.URL + "/fail")
+
+	line := "\n"
+	for line == "\n" {
+		if line, err = lines.ReadString('\n'); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	var l LogEntry
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &l); err != nil || l.URL != "/fail" || l.Status != http.StatusInternalServerError {
+		t.Errorf("expected the failed request, received %q", line)
+	}
+}
+
+func TestEnableTail_PanicsWithoutAdminToken(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	NewMiddleware(TestAPI{}).EnableTail()
+}
+
+func TestEnableTail_AdminTokenUnset(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLoggers()
+	m.AdminToken = "sekrit"
+	m.EnableTail()
+	m.AdminToken = ""
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/tail", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, received %d", rec.Code)
+	}
+}
+
+func TestEnableTail_Fasthttp(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.SetLoggers()
+	m.AdminToken = "sekrit"
+	m.EnableTail()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	go fasthttp.Serve(ln, m.ServeFastHTTP)
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /__/tail?path=/api/* HTTP/1.1\r\nHost: example.com\r\nX-Admin-Token: sekrit\r\n\r\n")
+
+	body := bufio.NewReader(conn)
+
+	// Skip past headers and chunk sizes to the opening comment
+	for line := ""; line != ": tailing\n"; {
+		if line, err = body.ReadString('\n'); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/api/users")
+	m.ServeFastHTTP(ctx)
+
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if strings.HasPrefix(line, "data: ") {
+			if !strings.Contains(line, `/api/users"`) {
+				t.Errorf("expected the tailed request, received %q", line)
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RouteResolver returns the route template a request matched, such as
+// `/users/:id`, or an empty string when it matched none. Templates are
+// logged under `route`, and count hits in place of URLs, so that logs and
+// counters group by endpoint rather than by every unique URL.
+//
+// Resolvers see requests after the wrapped handler has returned; under
+// fasthttp they see a copy, as per FlagProvider.
+type RouteResolver interface {
+	Resolve(*http.Request) string
+}
+
+// RouteResolverFunc allows ordinary functions to be used as RouteResolvers
+type RouteResolverFunc func(*http.Request) string
+
+// Resolve implements RouteResolver
+func (f RouteResolverFunc) Resolve(r *http.Request) string {
+	return f(r)
+}
+
+// SetRouteResolver resolves the route template of every logged request with
+// rr, unless the handler reported one with SetRoute
+func (m *Middleware) SetRouteResolver(rr RouteResolver) {
+	m.routeResolver = rr
+}
+
+// SetRoute reports the route template the request ctx belongs to matched,
+// and takes precedence over any RouteResolver. It is intended for routers
+// which know which of their routes matched, such as from a chi middleware:
+//
+//	next.ServeHTTP(w, r)
+//	middleware.SetRoute(r.Context(), chi.RouteContext(r.Context()).RoutePattern())
+//
+// ctx is either a net/http request's context, or a *fasthttp.RequestCtx
+func SetRoute(ctx context.Context, template string) {
+	if st := stateFrom(ctx); st != nil {
+		st.templateLock.Lock()
+		st.template = template
+		st.templateLock.Unlock()
+	}
+}
+
+// routeTemplate returns the template reported via SetRoute, if any
+func (st *requestState) routeTemplate() string {
+	st.templateLock.Lock()
+	defer st.templateLock.Unlock()
+
+	return st.template
+}
+
+// resolveRoute returns the route template of a request: template, when the
+// handler reported one, or else that of the RouteResolver. r is only called
+// when the resolver is needed
+func (m *Middleware) resolveRoute(template string, r func() *http.Request) string {
+	if template != "" || m.routeResolver == nil {
+		return template
+	}
+
+	return m.routeResolver.Resolve(r())
+}
+
+// RouteTemplates returns a RouteResolver matching request paths against
+// templates, for applications whose routers can't report their own. Each
+// segment of a template is either:
+//   - literal, such as `users`;
+//   - a parameter, matching any one segment, such as `:id` or `{id}`; or
+//   - a final `*`, matching the remainder of a path
+//
+// When several templates match, the one with the most literal segments
+// wins, and then the first added.
+//
+// RouteTemplates panics on a malformed template, as AddRoutePolicy does
+func RouteTemplates(templates ...string) RouteResolver {
+	rt, err := newRouteTemplates(templates)
+	if err != nil {
+		panic(err)
+	}
+
+	return rt
+}
+
+type routeTemplates []routeTemplate
+
+type routeTemplate struct {
+	template string
+	segments []string
+	literals int
+	rest     bool
+}
+
+func newRouteTemplates(templates []string) (routeTemplates, error) {
+	rt := make(routeTemplates, 0, len(templates))
+
+	for _, t := range templates {
+		if !strings.HasPrefix(t, "/") {
+			return nil, fmt.Errorf("route template %q must start with /", t)
+		}
+
+		tmpl := routeTemplate{template: t, segments: strings.Split(t[1:], "/")}
+		for i, s := range tmpl.segments {
+			switch {
+			case s == "*":
+				if i != len(tmpl.segments)-1 {
+					return nil, fmt.Errorf("route template %q: * must be the last segment", t)
+				}
+
+				tmpl.segments, tmpl.rest = tmpl.segments[:i], true
+
+			case isTemplateParam(s):
+
+			case strings.ContainsAny(s, "{}*") || strings.HasPrefix(s, ":"):
+				return nil, fmt.Errorf("route template %q: malformed segment %q", t, s)
+
+			default:
+				tmpl.literals++
+			}
+		}
+
+		rt = append(rt, tmpl)
+	}
+
+	return rt, nil
+}
+
+// isTemplateParam returns whether a template segment is a parameter
+func isTemplateParam(s string) bool {
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		return len(s) > 2
+	}
+
+	return len(s) > 1 && s[0] == ':'
+}
+
+// Resolve implements RouteResolver
+func (rt routeTemplates) Resolve(r *http.Request) string {
+	return rt.resolvePath(r.URL.Path)
+}
+
+// resolvePath returns the template path matches, or an empty string
+func (rt routeTemplates) resolvePath(path string) string {
+	if len(rt) == 0 {
+		return ""
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	best := -1
+	for i, tmpl := range rt {
+		if tmpl.matches(segments) && (best < 0 || tmpl.literals > rt[best].literals) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return ""
+	}
+
+	return rt[best].template
+}
+
+func (tmpl routeTemplate) matches(segments []string) bool {
+	if len(segments) < len(tmpl.segments) || (!tmpl.rest && len(segments) != len(tmpl.segments)) {
+		return false
+	}
+
+	for i, s := range tmpl.segments {
+		if isTemplateParam(s) {
+			if segments[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if s != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
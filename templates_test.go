@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouteTemplates(t *testing.T) {
+	rr := RouteTemplates("/users/:id", "/users/me", "/users/{id}/orders/{order}", "/docs/*", "/")
+
+	for _, test := range []struct {
+		path   string
+		expect string
+	}{
+		{"/users/123", "/users/:id"},
+		{"/users/me", "/users/me"},
+		{"/users/123/orders/abc", "/users/{id}/orders/{order}"},
+		{"/users/123/orders", ""},
+		{"/users/", ""},
+		{"/docs/", "/docs/*"},
+		{"/docs/a/b/c", "/docs/*"},
+		{"/", "/"},
+		{"/nonsuch", ""},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			if received := rr.Resolve(httptest.NewRequest("GET", test.path, nil)); received != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestRouteTemplates_malformed(t *testing.T) {
+	for _, template := range []string{"users/:id", "/docs/*/edit", "/users/{}", "/users/{id", "/users/:"} {
+		t.Run(template, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic")
+				}
+			}()
+
+			RouteTemplates(template)
+		})
+	}
+}
+
+func TestServeHTTP_Route(t *testing.T) {
+	m := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/reported/") {
+			SetRoute(r.Context(), "/reported/:id")
+		}
+	}))
+	m.SetRouteResolver(RouteTemplates("/users/:id", "/reported/*"))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, p := range []string{"/users/1", "/users/2", "/reported/3", "/other"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, expect := range []string{`"route":"/users/:id"`, `"route":"/reported/:id"`} {
+		if !strings.Contains(string(logWriter.body), expect) {
+			t.Errorf("expected %s to be logged, received %q", expect, logWriter.body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters?sections", nil))
+
+	var c countersPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for k, v := range map[string]int64{"/users/:id": 2, "/reported/:id": 1, "/other": 1} {
+		if c.Requests[k] != v {
+			t.Errorf("expected %d requests to %s, received %v", v, k, c.Requests)
+		}
+	}
+
+	if _, ok := c.Requests["/users/1"]; ok {
+		t.Errorf("expected requests to be counted by route, received %v", c.Requests)
+	}
+}
+
+func TestServeFastHTTP_Route(t *testing.T) {
+	m := NewMiddleware(FHFunc(func(ctx *fasthttp.RequestCtx) {}))
+	m.SetRouteResolver(RouteResolverFunc(func(r *http.Request) string {
+		return "/resolved" + r.URL.Path
+	}))
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/things/1")
+	m.ServeFastHTTP(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !strings.Contains(string(logWriter.body), `"route":"/resolved/things/1"`) {
+		t.Errorf("expected the route to be logged, received %q", logWriter.body)
+	}
+}
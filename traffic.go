@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultRouteKey is the key under which requests matching no route
+	// pattern are reported by the traffic endpoint
+	defaultRouteKey = "default"
+)
+
+// trafficState is the response body of the traffic endpoint
+type trafficState struct {
+	InFlight  int64                       `json:"in_flight"`
+	RateLimit *rateLimitState             `json:"rate_limit,omitempty"`
+	Lanes     *laneState                  `json:"lanes,omitempty"`
+	WarmUp    *warmUpState                `json:"warm_up,omitempty"`
+	Rejected  map[string]map[string]int64 `json:"rejected,omitempty"`
+
+	// WouldReject counts requests features in dry run would have rejected
+	WouldReject map[string]map[string]int64 `json:"would_reject,omitempty"`
+}
+
+type rateLimitState struct {
+	Rate    float64 `json:"rate"`
+	Burst   int     `json:"burst"`
+	Clients int     `json:"clients"`
+	Limited int     `json:"limited_clients"`
+
+	// Fill is the mean fraction of their burst clients have left
+	Fill float64 `json:"fill"`
+}
+
+type laneState struct {
+	InFlight int             `json:"in_flight"`
+	Lanes    []laneLimitInfo `json:"lanes"`
+}
+
+type laneLimitInfo struct {
+	Name  string `json:"name"`
+	Limit int    `json:"limit"`
+	Shed  int64  `json:"shed"`
+}
+
+type warmUpState struct {
+	Progress         float64 `json:"progress"`
+	ConcurrencyLimit int     `json:"concurrency_limit,omitempty"`
+	InFlight         int64   `json:"in_flight"`
+	Shed             int64   `json:"shed"`
+}
+
+// routeCounters counts requests per route and reason, such as those refused
+// before reaching the wrapped handler
+type routeCounters struct {
+	sync.Mutex
+
+	routes map[string]*counterSet
+}
+
+func (r *routeCounters) add(route, reason string) {
+	if route == "" {
+		route = defaultRouteKey
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.routes == nil {
+		r.routes = make(map[string]*counterSet)
+	}
+
+	cs, ok := r.routes[route]
+	if !ok {
+		cs = &counterSet{}
+		r.routes[route] = cs
+	}
+
+	cs.add(reason, 1)
+}
+
+func (r *routeCounters) reset() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.routes = nil
+}
+
+func (r *routeCounters) snapshot() map[string]map[string]int64 {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.routes) == 0 {
+		return nil
+	}
+
+	out := make(map[string]map[string]int64, len(r.routes))
+	for route, cs := range r.routes {
+		out[route] = cs.snapshot()
+	}
+
+	return out
+}
+
+// recordRejection counts a request refused for any of the given reasons
+func (m *Middleware) recordRejection(route string, banned, closed, limited, spent, shed bool) {
+	switch {
+	case banned:
+		m.rejected.add(route, "banned")
+	case closed:
+		m.rejected.add(route, "outside_window")
+	case limited:
+		m.rejected.add(route, "rate_limited")
+	case spent:
+		m.rejected.add(route, "quota_exceeded")
+	case shed:
+		m.rejected.add(route, "shed")
+	}
+}
+
+// stats summarises the rate limiter's buckets as of now
+func (rl *rateLimiter) stats(now time.Time) *rateLimitState {
+	rl.Lock()
+	defer rl.Unlock()
+
+	s := &rateLimitState{Rate: rl.limit.Rate, Burst: rl.limit.Burst, Clients: len(rl.buckets)}
+
+	var fill float64
+	for _, b := range rl.buckets {
+		tokens := b.tokens + now.Sub(b.last).Seconds()*rl.limit.Rate
+		if max := float64(rl.limit.Burst); tokens > max {
+			tokens = max
+		}
+
+		if tokens < 1 {
+			s.Limited++
+		}
+
+		fill += tokens / float64(rl.limit.Burst)
+	}
+
+	if s.Clients > 0 {
+		s.Fill = fill / float64(s.Clients)
+	}
+
+	return s
+}
+
+// state summarises the lanes' usage
+func (ll *laneLimiter) state() *laneState {
+	shed := ll.shed.snapshot()
+
+	ll.Lock()
+	defer ll.Unlock()
+
+	s := &laneState{InFlight: ll.inFlight}
+	for _, lane := range ll.lanes {
+		s.Lanes = append(s.Lanes, laneLimitInfo{Name: lane.Name, Limit: lane.limit, Shed: shed[lane.Name]})
+	}
+
+	return s
+}
+
+func (m *Middleware) traffic(now time.Time) trafficState {
+	s := trafficState{
+		InFlight:    m.InFlight(),
+		Rejected:    m.rejected.snapshot(),
+		WouldReject: m.wouldReject.snapshot(),
+	}
+
+	if m.limiter != nil {
+		s.RateLimit = m.limiter.stats(now)
+	}
+
+	if m.lanes != nil {
+		s.Lanes = m.lanes.state()
+	}
+
+	if m.warmUp != nil {
+		s.WarmUp = &warmUpState{
+			Progress:         m.warmUp.progress(now),
+			ConcurrencyLimit: m.warmUp.limit(now),
+			InFlight:         atomic.LoadInt64(&m.warmUp.inFlight),
+			Shed:             atomic.LoadInt64(&m.warmUp.shed),
+		}
+	}
+
+	return s
+}
+
+// serveTraffic shows why requests are being rejected, as they are: rate
+// limiter fill, concurrency usage, and rejections per route
+func (m *Middleware) serveTraffic(adminRequest) adminResponse {
+	b, err := json.Marshal(m.traffic(time.Now()))
+	if err != nil {
+		return adminError(http.StatusInternalServerError, err)
+	}
+
+	return jsonResponse(http.StatusOK, b)
+}
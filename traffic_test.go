@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraffic(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AddRoutePolicy("/batch/*", RoutePolicy{Availability: []Window{{Start: time.Hour, End: time.Hour}}})
+	m.SetRateLimit(RateLimit{Rate: 0.001, Burst: 2})
+
+	for _, p := range []string{"/", "/", "/", "/batch/run"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/__/traffic", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, received %d", http.StatusOK, w.Code)
+	}
+
+	var s trafficState
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Rejected[defaultRouteKey]["rate_limited"] != 1 {
+		t.Errorf("expected 1 rate limited request, received %+v", s.Rejected)
+	}
+
+	if s.Rejected["/batch/*"]["outside_window"] != 1 {
+		t.Errorf("expected 1 request outside its window, received %+v", s.Rejected)
+	}
+
+	if s.RateLimit == nil || s.RateLimit.Clients != 1 || s.RateLimit.Limited != 1 || s.RateLimit.Fill >= 0.5 {
+		t.Errorf("expected one limited client, received %+v", s.RateLimit)
+	}
+
+	if s.Lanes != nil || s.WarmUp != nil {
+		t.Errorf("expected no lanes or warm-up, received %+v", s)
+	}
+}
+
+func TestTraffic_Lanes(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.SetLanes(LanePolicy{MaxConcurrent: 10, Lanes: []Lane{{Name: "critical"}, {Name: "bulk"}}})
+
+	s := m.traffic(time.Now())
+	if s.Lanes == nil || len(s.Lanes.Lanes) != 2 || s.Lanes.Lanes[0].Limit != 10 || s.Lanes.Lanes[1].Limit != 5 {
+		t.Errorf("unexpected lanes %+v", s.Lanes)
+	}
+}
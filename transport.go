@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// RequestIDHeader is the header request IDs are returned to clients in,
+	// and propagated to downstream services with
+	RequestIDHeader = "X-Request-ID"
+)
+
+// propagatedHeaders are the tracing headers, covering W3C Trace Context and
+// Zipkin's B3, which are passed from incoming requests to outbound calls
+var propagatedHeaders = []string{
+	"Traceparent",
+	"Tracestate",
+	"Baggage",
+	"B3",
+	"X-B3-Traceid",
+	"X-B3-Spanid",
+	"X-B3-Parentspanid",
+	"X-B3-Sampled",
+	"X-B3-Flags",
+}
+
+// traceHeaders pulls any tracing headers from an incoming request
+func traceHeaders(get func(string) string) (h map[string]string) {
+	for _, k := range propagatedHeaders {
+		if v := get(k); v != "" {
+			if h == nil {
+				h = make(map[string]string)
+			}
+
+			h[k] = v
+		}
+	}
+
+	return
+}
+
+// Transport is an http.RoundTripper which closes the correlation loop between
+// incoming requests and the calls a handler makes to downstream services.
+//
+// When used with a request whose context came from the middleware, Transport
+// propagates the request ID (as X-Request-ID), any incoming tracing
+// headers, and the remaining deadline as per PropagateDeadlines. Every call
+// is timed, and logged, through the same loggers as the Middleware which
+// created it, with Outbound set.
+//
+//	client := &http.Client{Transport: m.NewTransport(nil)}
+//
+//	func (a API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+//	        req, _ := http.NewRequestWithContext(r.Context(), "GET", "http://users.internal/me", nil)
+//	        resp, err := client.Do(req)
+//	        ...
+//	}
+type Transport struct {
+	// Base makes the actual requests. When nil, http.DefaultTransport is used
+	Base http.RoundTripper
+
+	m *Middleware
+}
+
+// NewTransport returns a Transport wrapping base, logging through m's loggers
+func (m *Middleware) NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base, m: m}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	st := stateFrom(req.Context())
+	if st != nil {
+		// RoundTrippers must not modify the request they're given
+		req = req.Clone(req.Context())
+
+		if req.Header.Get(RequestIDHeader) == "" {
+			req.Header.Set(RequestIDHeader, st.id)
+		}
+
+		for k, v := range st.trace {
+			if req.Header.Get(k) == "" {
+				req.Header.Set(k, v)
+			}
+		}
+
+		if !st.deadline.IsZero() && req.Header.Get(DeadlineHeader) == "" {
+			req.Header.Set(DeadlineHeader, formatTimeout(time.Until(st.deadline)))
+		}
+	}
+
+	t0 := time.Now()
+	resp, err = base.RoundTrip(req)
+
+	if t.m != nil {
+		t.m.logOutbound(req, resp, err, t0)
+	}
+
+	return
+}
+
+func (m *Middleware) logOutbound(req *http.Request, resp *http.Response, err error, t0 time.Time) {
+	duration := time.Now().Sub(t0)
+
+	l := LogEntry{
+		SchemaVersion: SchemaVersion,
+		Duration:      duration.String(),
+		DurationMS:    float64(duration / time.Millisecond),
+		RequestID:     req.Header.Get(RequestIDHeader),
+		Time:          t0,
+		URL:           m.loggableURL(req.URL),
+		UserAgent:     req.UserAgent(),
+		Method:        req.Method,
+		Scheme:        req.URL.Scheme,
+		Outbound:      true,
+	}
+
+	if resp != nil {
+		l.Status = resp.StatusCode
+		l.Proto = resp.Proto
+
+		if resp.ContentLength > 0 {
+			l.ResponseBytes = int(resp.ContentLength)
+		}
+	}
+
+	if err != nil {
+		l.Error = err.Error()
+	}
+
+	m.dispatch(l)
+}
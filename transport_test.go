@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	var received http.Header
+
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer downstream.Close()
+
+	var m *Middleware
+
+	m = NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := &http.Client{Transport: m.NewTransport(nil)}
+
+		req, _ := http.NewRequest("GET", downstream.URL+"/users?token=abc", nil)
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		resp.Body.Close()
+	}))
+	m.RedactQueryParams("token")
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, r)
+
+	requestID := rec.Header().Get(RequestIDHeader)
+
+	t.Run("propagates request ID", func(t *testing.T) {
+		if received.Get(RequestIDHeader) != requestID {
+			t.Errorf("expected %q, received %q", requestID, received.Get(RequestIDHeader))
+		}
+	})
+
+	t.Run("propagates trace headers", func(t *testing.T) {
+		if received.Get("Traceparent") != r.Header.Get("Traceparent") {
+			t.Errorf("expected traceparent to be propagated, received %+v", received)
+		}
+	})
+
+	t.Run("logs outbound requests", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		// Draining, rather than sleeping, waits for the queue's workers to
+		// finish writing to logWriter before it's read
+		if err := m.queue.drain(ctx); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		var outbound LogEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(logWriter.body)), "\n") {
+			var l LogEntry
+			json.Unmarshal([]byte(line), &l)
+
+			if l.Outbound {
+				outbound = l
+			}
+		}
+
+		if outbound.Status != http.StatusAccepted || outbound.RequestID != requestID {
+			t.Errorf("unexpected outbound entry %+v", outbound)
+		}
+
+		if !strings.HasSuffix(outbound.URL, "/users?token=REDACTED") {
+			t.Errorf("expected redacted URL, received %q", outbound.URL)
+		}
+	})
+}
+
+func TestTransport_outsideMiddleware(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(RequestIDHeader) != "" {
+			t.Errorf("unexpected request ID")
+		}
+	}))
+	defer downstream.Close()
+
+	resp, err := (&http.Client{Transport: &Transport{}}).Get(downstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	resp.Body.Close()
+}
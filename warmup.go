@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// warmingShedKey counts requests shed during warm-up under `shed` in
+	// the counters endpoint
+	warmingShedKey = "warming"
+)
+
+// WarmUp ramps up the number of requests handled at once after startup,
+// preventing a cold process (empty caches, unfilled connection pools, an
+// unwarmed JIT in a sidecar) from being swamped straight after a deploy.
+//
+// The concurrency limit grows linearly from InitialConcurrency to
+// MaxConcurrency over Duration, after which it is lifted. Requests over the
+// limit receive a `503 Service Unavailable` without the wrapped handler
+// being called.
+type WarmUp struct {
+	Duration time.Duration
+
+	// InitialConcurrency defaults to 1
+	InitialConcurrency int
+	MaxConcurrency     int
+
+	// ReadyAt is the fraction of warm-up after which `/__/ready` reports
+	// ready. The default of zero reports ready straight away, which lets
+	// traffic in to do the warming
+	ReadyAt float64
+}
+
+// warmUp tracks progress through a WarmUp
+type warmUp struct {
+	policy   WarmUp
+	start    time.Time
+	inFlight int64
+	shed     int64
+}
+
+// readyState is the response body of the ready endpoint
+type readyState struct {
+	Status           string  `json:"status"`
+	Progress         float64 `json:"progress"`
+	ConcurrencyLimit int     `json:"concurrency_limit,omitempty"`
+}
+
+// WarmUp starts warming up, as per w, from now. It registers the `ready`
+// endpoint which, unlike other admin endpoints, doesn't require the admin
+// token so that orchestrators' readiness probes can call it. Policies
+// without a Duration or MaxConcurrency are programmer error, and panic.
+func (m *Middleware) WarmUp(w WarmUp) {
+	if w.Duration <= 0 || w.MaxConcurrency <= 0 {
+		panic(fmt.Errorf("warm-up requires a duration and max concurrency"))
+	}
+
+	if w.InitialConcurrency <= 0 {
+		w.InitialConcurrency = 1
+	}
+
+	if w.InitialConcurrency > w.MaxConcurrency {
+		panic(fmt.Errorf("warm-up initial concurrency %d exceeds max concurrency %d", w.InitialConcurrency, w.MaxConcurrency))
+	}
+
+	m.warmUp = &warmUp{policy: w, start: time.Now()}
+
+	m.addPublicAdminEndpoint("ready", m.serveReady)
+}
+
+// progress returns how far through warm-up now is, from 0 to 1
+func (w *warmUp) progress(now time.Time) float64 {
+	p := float64(now.Sub(w.start)) / float64(w.policy.Duration)
+
+	switch {
+	case p < 0:
+		return 0
+	case p > 1:
+		return 1
+	}
+
+	return p
+}
+
+// limit returns the concurrency limit at now, or zero once warm-up is over
+func (w *warmUp) limit(now time.Time) int {
+	p := w.progress(now)
+	if p >= 1 {
+		return 0
+	}
+
+	return w.policy.InitialConcurrency + int(p*float64(w.policy.MaxConcurrency-w.policy.InitialConcurrency))
+}
+
+// admit takes a slot for a request unless the limit has been reached.
+// Admitted requests must be released
+func (w *warmUp) admit(now time.Time) bool {
+	n := atomic.AddInt64(&w.inFlight, 1)
+
+	if limit := w.limit(now); limit > 0 && n > int64(limit) {
+		atomic.AddInt64(&w.inFlight, -1)
+		atomic.AddInt64(&w.shed, 1)
+
+		return false
+	}
+
+	return true
+}
+
+func (w *warmUp) release() {
+	atomic.AddInt64(&w.inFlight, -1)
+}
+
+// serveReady reports warm-up state, responding `503 Service Unavailable`
+// until warm-up has progressed past ReadyAt
+func (m *Middleware) serveReady(adminRequest) adminResponse {
+	now := time.Now()
+
+	state := readyState{
+		Status:           "ready",
+		Progress:         m.warmUp.progress(now),
+		ConcurrencyLimit: m.warmUp.limit(now),
+	}
+
+	status := http.StatusOK
+
+	switch {
+	case state.Progress < m.warmUp.policy.ReadyAt:
+		state.Status = "warming"
+		status = http.StatusServiceUnavailable
+
+	case state.Progress < 1:
+		state.Status = "warming"
+	}
+
+	b, _ := json.Marshal(state)
+
+	return jsonResponse(status, b)
+}
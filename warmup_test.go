@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWarmUp_limit(t *testing.T) {
+	now := time.Now()
+	w := &warmUp{policy: WarmUp{Duration: 10 * time.Second, InitialConcurrency: 2, MaxConcurrency: 12}, start: now}
+
+	for _, test := range []struct {
+		elapsed time.Duration
+		expect  int
+	}{
+		{-time.Second, 2},
+		{0, 2},
+		{5 * time.Second, 7},
+		{9 * time.Second, 11},
+		{10 * time.Second, 0},
+	} {
+		if got := w.limit(now.Add(test.elapsed)); got != test.expect {
+			t.Errorf("%s: expected %d, received %d", test.elapsed, test.expect, got)
+		}
+	}
+
+	if !w.admit(now) || !w.admit(now) || w.admit(now) {
+		t.Errorf("expected only 2 requests to be admitted at start")
+	}
+
+	w.release()
+
+	if !w.admit(now) {
+		t.Errorf("expected a released slot to be reused")
+	}
+
+	if !w.admit(now.Add(10 * time.Second)) {
+		t.Errorf("expected the limit to be lifted after warm-up")
+	}
+}
+
+func TestWarmUp_PanicsOnBadPolicy(t *testing.T) {
+	for _, w := range []WarmUp{
+		{MaxConcurrency: 1},
+		{Duration: time.Second},
+		{Duration: time.Second, InitialConcurrency: 2, MaxConcurrency: 1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %+v to panic", w)
+				}
+			}()
+
+			NewMiddleware(TestAPI{}).WarmUp(w)
+		}()
+	}
+}
+
+func TestServeReady(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.AdminToken = "secret"
+	m.WarmUp(WarmUp{Duration: time.Hour, MaxConcurrency: 10, ReadyAt: 0.5})
+
+	ready := func() (int, readyState) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/ready", nil))
+
+		var state readyState
+		json.Unmarshal(rec.Body.Bytes(), &state)
+
+		return rec.Code, state
+	}
+
+	if status, state := ready(); status != http.StatusServiceUnavailable || state.Status != "warming" || state.ConcurrencyLimit != 1 {
+		t.Errorf("expected unready warm-up without the admin token, received %d %+v", status, state)
+	}
+
+	m.warmUp.start = time.Now().Add(-45 * time.Minute)
+
+	if status, state := ready(); status != http.StatusOK || state.Status != "warming" {
+		t.Errorf("expected ready warm-up, received %d %+v", status, state)
+	}
+
+	m.warmUp.start = time.Now().Add(-time.Hour)
+
+	if status, state := ready(); status != http.StatusOK || state.Status != "ready" || state.Progress != 1 {
+		t.Errorf("expected warm-up to be complete, received %d %+v", status, state)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/__/counters", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected other admin endpoints to still require the token, received %d", rec.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// FaviconPath is the path browsers request favicons from
+	FaviconPath = "/favicon.ico"
+
+	// RobotsPath is the path crawlers request robots.txt from
+	RobotsPath = "/robots.txt"
+)
+
+// wellKnownFile is a response served directly by the middleware
+type wellKnownFile struct {
+	body        []byte
+	contentType string
+}
+
+// Favicon serves icon from FaviconPath, in place of the wrapped handler. A
+// nil icon responds 204 No Content, which stops browsers asking again.
+//
+// Like blocked requests, favicon requests are neither logged nor counted,
+// as browsers make them on every public service whether there's an icon or
+// not, polluting metrics.
+func (m *Middleware) Favicon(icon []byte) {
+	m.serveWellKnown(FaviconPath, wellKnownFile{body: icon, contentType: http.DetectContentType(icon)})
+}
+
+// RobotsTxt serves robots from RobotsPath, in place of the wrapped handler.
+// An empty robots responds 204 No Content. As with Favicon, robots.txt
+// requests are neither logged nor counted
+func (m *Middleware) RobotsTxt(robots string) {
+	m.serveWellKnown(RobotsPath, wellKnownFile{body: []byte(robots), contentType: "text/plain; charset=utf-8"})
+}
+
+func (m *Middleware) serveWellKnown(p string, f wellKnownFile) {
+	if m.wellKnown == nil {
+		m.wellKnown = make(map[string]wellKnownFile)
+	}
+
+	m.wellKnown[p] = f
+}
+
+// wellKnownResponse returns the status, headers, and body to respond to p
+// with, when it's served by the middleware
+func (m *Middleware) wellKnownResponse(p string) (status int, header http.Header, body []byte, ok bool) {
+	f, ok := m.wellKnown[p]
+	if !ok {
+		return
+	}
+
+	header = http.Header{"Cache-Control": {fmt.Sprintf("public, max-age=%d", int(DefaultStaticMaxAge/time.Second))}}
+
+	if len(f.body) == 0 {
+		return http.StatusNoContent, header, nil, true
+	}
+
+	header.Set("Content-Type", f.contentType)
+
+	return http.StatusOK, header, f.body, true
+}
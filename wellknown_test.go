@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestFaviconAndRobotsTxt(t *testing.T) {
+	m := NewMiddleware(TestAPI{})
+	m.Favicon(nil)
+	m.RobotsTxt("User-agent: *\nDisallow: /\n")
+
+	logWriter := &TestWriter{}
+	m.loggers[0].(defaultLogger).output.SetOutput(logWriter)
+
+	for _, test := range []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{FaviconPath, http.StatusNoContent, "", ""},
+		{RobotsPath, http.StatusOK, "text/plain; charset=utf-8", "User-agent: *\nDisallow: /\n"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+
+			if w.Code != test.status {
+				t.Errorf("expected status %d, received %d", test.status, w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+				t.Errorf("expected content type %q, received %q", test.contentType, ct)
+			}
+
+			if w.Body.String() != test.body {
+				t.Errorf("expected body %q, received %q", test.body, w.Body.String())
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if len(logWriter.body) > 0 {
+		t.Errorf("expected no logs, received %q", logWriter.body)
+	}
+
+	if _, ok := m.Requests[FaviconPath]; ok {
+		t.Errorf("expected favicon requests not to be counted")
+	}
+}
+
+func TestFavicon_Fasthttp(t *testing.T) {
+	icon := []byte("\x00\x00\x01\x00\x01\x00\x10\x10")
+
+	m := NewMiddleware(FHAPI{})
+	m.Favicon(icon)
+
+	c := &fasthttp.RequestCtx{}
+	c.Request.SetRequestURI(FaviconPath)
+
+	m.ServeFastHTTP(c)
+
+	if c.Response.StatusCode() != http.StatusOK {
+		t.Errorf("expected status %d, received %d", http.StatusOK, c.Response.StatusCode())
+	}
+
+	if ct := string(c.Response.Header.ContentType()); ct != "image/x-icon" {
+		t.Errorf("expected content type %q, received %q", "image/x-icon", ct)
+	}
+
+	if !strings.HasPrefix(string(c.Response.Header.Peek("Cache-Control")), "public") {
+		t.Errorf("expected a Cache-Control header, received %q", c.Response.Header.Peek("Cache-Control"))
+	}
+}
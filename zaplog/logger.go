@@ -0,0 +1,211 @@
+// Package zaplog logs middleware entries through zap, kept apart from the
+// middleware package so that it needn't depend on zap
+package zaplog
+
+import (
+	"sort"
+
+	"github.com/zeebox/go-http-middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger implements middleware.Loggable, logging entries through an
+// existing zap.Logger, so that services keep their zap pipelines (encoders,
+// sinks, sampling) for access logs too:
+//
+//	m.SetLoggers(zaplog.New(logger))
+//
+// Entries are logged as typed zap fields, named as per the JSON schema,
+// without going via reflection or JSON. Only custom Fields, whose types
+// aren't known up front, use zap.Any. Entries are logged at their Level:
+// usually error for 5xx responses and failed requests, warn for 4xx and
+// slow requests, and info for everything else.
+type Logger struct {
+	logger *zap.Logger
+}
+
+// New returns a Logger writing to l
+func New(l *zap.Logger) *Logger {
+	return &Logger{logger: l}
+}
+
+// Log implements middleware.Loggable
+func (zl *Logger) Log(l middleware.LogEntry) {
+	msg := "request"
+	if l.Outbound {
+		msg = "outbound request"
+	}
+
+	if ce := zl.logger.Check(zapLevels[middleware.EntryLevel(l)], msg); ce != nil {
+		ce.Write(zapFields(l)...)
+	}
+}
+
+var zapLevels = map[middleware.Level]zapcore.Level{
+	middleware.LevelDebug: zapcore.DebugLevel,
+	middleware.LevelInfo:  zapcore.InfoLevel,
+	middleware.LevelWarn:  zapcore.WarnLevel,
+	middleware.LevelError: zapcore.ErrorLevel,
+}
+
+// zapFields returns l as zap fields, skipping empty optional fields as
+// JSON output does
+func zapFields(l middleware.LogEntry) []zap.Field {
+	fields := make([]zap.Field, 0, 16+len(l.Fields))
+
+	fields = append(fields,
+		zap.Int("schema_version", l.SchemaVersion),
+		zap.String("duration", l.Duration),
+		zap.Float64("duration_ms", l.DurationMS),
+		zap.String("ip_address", l.IPAddress),
+		zap.String("request_id", l.RequestID),
+		zap.Int("status", l.Status),
+		zap.Time("time", l.Time),
+		zap.String("url", l.URL),
+		zap.String("useragent", l.UserAgent),
+		zap.String("method", l.Method),
+		zap.String("proto", l.Proto),
+		zap.String("scheme", l.Scheme),
+		zap.Int("response_bytes", l.ResponseBytes),
+	)
+
+	if l.RequestBytes != 0 {
+		fields = append(fields, zap.Int64("request_bytes", l.RequestBytes))
+	}
+
+	if l.ContentLength != 0 {
+		fields = append(fields, zap.Int64("content_length", l.ContentLength))
+	}
+
+	if l.SampleRate != 0 {
+		fields = append(fields, zap.Float64("sample_rate", l.SampleRate))
+	}
+
+	if l.EarlyHintsMS != 0 {
+		fields = append(fields, zap.Float64("early_hints_ms", l.EarlyHintsMS))
+	}
+
+	for _, f := range []struct {
+		key string
+		set bool
+	}{
+		{"slow", l.Slow},
+		{"budget_exceeded", l.BudgetExceeded},
+		{"debug", l.Debug},
+		{"synthetic", l.Synthetic},
+		{"outside_window", l.OutsideWindow},
+		{"outbound", l.Outbound},
+	} {
+		if f.set {
+			fields = append(fields, zap.Bool(f.key, true))
+		}
+	}
+
+	for _, f := range []struct {
+		key, value string
+	}{
+		{"request_body", l.RequestBody},
+		{"response_body", l.ResponseBody},
+		{"referer", l.Referer},
+		{"apdex", l.Apdex},
+		{"route", l.Route},
+		{"tenant", l.Tenant},
+		{"retention", l.Retention},
+		{"client_request_id", l.ClientRequestID},
+		{"lane", l.Lane},
+		{"listener", l.Listener},
+		{"error", l.Error},
+		{"panic", l.Panic},
+		{"stack", l.Stack},
+	} {
+		if f.value != "" {
+			fields = append(fields, zap.String(f.key, f.value))
+		}
+	}
+
+	for _, f := range []struct {
+		key   string
+		value map[string]string
+	}{
+		{"request_headers", l.RequestHeaders},
+		{"response_headers", l.ResponseHeaders},
+		{"flags", l.Flags},
+	} {
+		if len(f.value) > 0 {
+			fields = append(fields, zap.Object(f.key, zapStringMap(f.value)))
+		}
+	}
+
+	if len(l.ShadowDiff) > 0 {
+		fields = append(fields, zap.Strings("shadow_diff", l.ShadowDiff))
+	}
+
+	if len(l.WouldReject) > 0 {
+		fields = append(fields, zap.Strings("would_reject", l.WouldReject))
+	}
+
+	if len(l.Cost) > 0 {
+		fields = append(fields, zap.Object("cost", zapFloatMap(l.Cost)))
+	}
+
+	if l.Resources != nil {
+		fields = append(fields, zap.Object("resources", zapResources(*l.Resources)))
+	}
+
+	for _, k := range middleware.CustomFieldKeys(l) {
+		fields = append(fields, zap.Any(k, l.Fields[k]))
+	}
+
+	return fields
+}
+
+// zapResources marshals Resources as a zap object
+type zapResources middleware.Resources
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (r zapResources) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddUint64("alloc_bytes", r.AllocBytes)
+	enc.AddUint64("mallocs", r.Mallocs)
+	enc.AddInt("goroutines", r.Goroutines)
+
+	return nil
+}
+
+// zapStringMap marshals a map[string]string as a zap object, in key order
+type zapStringMap map[string]string
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (m zapStringMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		enc.AddString(k, m[k])
+	}
+
+	return nil
+}
+
+// zapFloatMap marshals a map[string]float64 as a zap object, in key order
+type zapFloatMap map[string]float64
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (m zapFloatMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		enc.AddFloat64(k, m[k])
+	}
+
+	return nil
+}
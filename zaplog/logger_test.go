@@ -0,0 +1,76 @@
+package zaplog
+
+import (
+	"testing"
+
+	"github.com/zeebox/go-http-middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	New(zap.New(core)).Log(middleware.LogEntry{
+		RequestID:       "abc",
+		Status:          503,
+		Slow:            true,
+		Tenant:          "acme",
+		ResponseHeaders: map[string]string{"Content-Type": "text/plain"},
+		Fields:          map[string]interface{}{"shard": "eu-1", "status": "clobbered"},
+	})
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, received %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.Level != zapcore.ErrorLevel || e.Message != "request" {
+		t.Errorf("unexpected level or message %s %q", e.Level, e.Message)
+	}
+
+	ctx := e.ContextMap()
+
+	for k, expect := range map[string]interface{}{
+		"request_id": "abc",
+		"status":     int64(503),
+		"slow":       true,
+		"tenant":     "acme",
+		"shard":      "eu-1",
+	} {
+		if ctx[k] != expect {
+			t.Errorf("%s: expected %#v, received %#v", k, expect, ctx[k])
+		}
+	}
+
+	if headers, ok := ctx["response_headers"].(map[string]interface{}); !ok || headers["Content-Type"] != "text/plain" {
+		t.Errorf("expected response headers object, received %#v", ctx["response_headers"])
+	}
+
+	for _, k := range []string{"debug", "error", "request_body"} {
+		if _, ok := ctx[k]; ok {
+			t.Errorf("expected empty field %q to be omitted", k)
+		}
+	}
+}
+
+func TestLogger_Levels(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	zl := New(zap.New(core))
+
+	zl.Log(middleware.LogEntry{Status: 200})
+	zl.Log(middleware.LogEntry{Status: 404})
+	zl.Log(middleware.LogEntry{Outbound: true, Error: "connection refused"})
+
+	for i, expect := range []zapcore.Level{zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel} {
+		if l := logs.All()[i].Level; l != expect {
+			t.Errorf("%d: expected %s, received %s", i, expect, l)
+		}
+	}
+
+	if logs.All()[2].Message != "outbound request" {
+		t.Errorf("unexpected message %q", logs.All()[2].Message)
+	}
+}